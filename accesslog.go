@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// COS访问日志每行一个请求，字段以空格分隔（值已URL编码），前几个字段依次为：
+// eventVersion bucketName qcsRegion eventTime eventSource eventName remoteIp userSecretKeyId
+// reservedFiled reqBytesSent deltaDataSize reqPath reqMethod userAgent resHttpCode resErrorCode
+// resErrorMsg resBytesSent resTotalTime logSourceType storageClass accountId resTurnAroundTime
+// requester requestId ...
+const (
+	logFieldEventTime = 3
+	logFieldEventName = 5
+	logFieldRemoteIP  = 6
+	logFieldReqPath   = 11
+	logFieldUserAgent = 13
+	logFieldHTTPCode  = 14
+	logFieldRequester = 23
+	logFieldCount     = 24
+)
+
+// accessLogEvents 需要报告的操作：下载和删除
+var accessLogEvents = map[string]string{
+	"GetObject":             "下载",
+	"DeleteObject":          "删除",
+	"DeleteMultipleObjects": "批量删除",
+}
+
+// accessLogEntry 一条与备份相关的访问记录
+type accessLogEntry struct {
+	Time      string
+	Operation string
+	Key       string
+	RemoteIP  string
+	Requester string
+	UserAgent string
+	Status    string
+}
+
+// isOwnTraffic 检查请求是否由vcpsave自身发出
+func (e accessLogEntry) isOwnTraffic() bool {
+	return strings.HasPrefix(e.UserAgent, "vcpsave/")
+}
+
+// unescapeLogField 解码URL编码的日志字段
+func unescapeLogField(value string) string {
+	if value == "-" {
+		return ""
+	}
+	if decoded, err := url.QueryUnescape(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+// parseAccessLogLine 解析一行访问日志，只返回备份目录下的下载和删除记录
+func parseAccessLogLine(line string, prefixes []string) (accessLogEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < logFieldCount || strings.HasPrefix(line, "#") {
+		return accessLogEntry{}, false
+	}
+
+	operation, ok := accessLogEvents[fields[logFieldEventName]]
+	if !ok {
+		return accessLogEntry{}, false
+	}
+
+	key := strings.TrimPrefix(unescapeLogField(fields[logFieldReqPath]), "/")
+	// 批量删除的请求路径是存储桶根目录，无法判断删除了哪些对象，全部报告
+	if fields[logFieldEventName] != "DeleteMultipleObjects" {
+		matched := false
+		for _, prefix := range prefixes {
+			if prefix == "" || strings.HasPrefix(key, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return accessLogEntry{}, false
+		}
+	}
+
+	return accessLogEntry{
+		Time:      fields[logFieldEventTime],
+		Operation: operation,
+		Key:       key,
+		RemoteIP:  fields[logFieldRemoteIP],
+		Requester: unescapeLogField(fields[logFieldRequester]),
+		UserAgent: unescapeLogField(fields[logFieldUserAgent]),
+		Status:    fields[logFieldHTTPCode],
+	}, true
+}
+
+// readAccessLog 读取一个日志文件，.gz 结尾时先解压
+func readAccessLog(client *cos.Client, key string, prefixes []string) ([]accessLogEntry, error) {
+	resp, err := client.Object.Get(context.Background(), key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("下载日志文件失败: %s, 错误: %v", key, err)
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("解压日志文件失败: %s, 错误: %v", key, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var entries []accessLogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if entry, ok := parseAccessLogLine(scanner.Text(), prefixes); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取日志文件失败: %s, 错误: %v", key, err)
+	}
+	return entries, nil
+}
+
+// runAccessLog 分析存储桶访问日志，报告谁下载或删除了备份文件
+func runAccessLog(projects []*project, args []string) error {
+	fs := flag.NewFlagSet("accesslog", flag.ExitOnError)
+	days := fs.Int("days", 7, "分析最近多少天的日志")
+	projectName := fs.String("project", "", "只分析指定项目的目标目录，默认分析全部项目")
+	all := fs.Bool("all", false, "同时显示vcpsave自身的请求")
+	fs.Parse(args)
+
+	if getStorageProvider() != "cos" {
+		return fmt.Errorf("访问日志分析只支持COS的日志格式")
+	}
+	logPrefix := os.Getenv("ACCESS_LOG_PREFIX")
+	if logPrefix == "" {
+		return fmt.Errorf("未配置ACCESS_LOG_PREFIX（访问日志在日志存储桶中的路径前缀）")
+	}
+	logBucket := os.Getenv("ACCESS_LOG_BUCKET")
+	if logBucket == "" {
+		logBucket = os.Getenv("COS_BUCKET_NAME")
+	}
+	logRegion := os.Getenv("ACCESS_LOG_REGION")
+	if logRegion == "" {
+		logRegion = os.Getenv("COS_REGION")
+	}
+
+	var prefixes []string
+	for _, proj := range projects {
+		if *projectName != "" && proj.Name != *projectName {
+			continue
+		}
+		prefix := strings.Trim(proj.TargetDir, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if len(prefixes) == 0 {
+		return fmt.Errorf("未找到项目: %s", *projectName)
+	}
+
+	client := newCOSClient(logBucket, logRegion,
+		os.Getenv("TENCENTCLOUD_SECRET_ID"), os.Getenv("TENCENTCLOUD_SECRET_KEY"))
+
+	fmt.Printf("\n=== 开始分析访问日志 ===\n")
+	fmt.Printf("日志位置: %s/%s, 最近 %d 天\n", logBucket, logPrefix, *days)
+
+	objects, err := listCOSObjects(client, logPrefix)
+	if err != nil {
+		return err
+	}
+
+	since := time.Now().AddDate(0, 0, -*days)
+	var entries []accessLogEntry
+	logCount := 0
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		if modTime, err := time.Parse(time.RFC3339, object.LastModified); err == nil && modTime.Before(since) {
+			continue
+		}
+
+		fileEntries, err := readAccessLog(client, object.Key, prefixes)
+		if err != nil {
+			fmt.Printf("警告: %v\n", err)
+			continue
+		}
+		logCount++
+		for _, entry := range fileEntries {
+			if *all || !entry.isOwnTraffic() {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time < entries[j].Time
+	})
+
+	fmt.Printf("分析了 %d 个日志文件，发现 %d 条下载/删除记录\n", logCount, len(entries))
+	requesters := make(map[string]int)
+	for _, entry := range entries {
+		fmt.Printf("  %s %s %s 状态:%s 来源:%s 请求者:%s UA:%s\n",
+			entry.Time, entry.Operation, entry.Key, entry.Status, entry.RemoteIP, entry.Requester, entry.UserAgent)
+		requesters[fmt.Sprintf("%s (%s)", entry.Requester, entry.RemoteIP)]++
+	}
+
+	if len(requesters) > 0 {
+		fmt.Printf("\n按请求者汇总:\n")
+		for _, requester := range sortedKeys(requesters) {
+			fmt.Printf("  %s: %d 次\n", requester, requesters[requester])
+		}
+	}
+	return nil
+}
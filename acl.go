@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// allUsersURI COS ACL中表示所有人（匿名用户）的被授权者
+const allUsersURI = "http://cam.qcloud.com/groups/global/AllUsers"
+
+// uploadACLHeader 配置了ENFORCE_PRIVATE_ACL时返回强制私有读写的ACL请求头，
+// 避免对象继承到存储桶的公开ACL
+func uploadACLHeader() *cos.ACLHeaderOptions {
+	if os.Getenv("ENFORCE_PRIVATE_ACL") != "true" {
+		return nil
+	}
+	return &cos.ACLHeaderOptions{XCosACL: "private"}
+}
+
+// getPublicBucketPolicy 获取存储桶允许公开访问时的处理方式：warn（默认）、fail、ignore
+func getPublicBucketPolicy() string {
+	policy := os.Getenv("PUBLIC_BUCKET_POLICY")
+	switch policy {
+	case "warn", "fail", "ignore":
+		return policy
+	case "":
+		return "warn"
+	default:
+		fmt.Printf("警告: PUBLIC_BUCKET_POLICY格式错误: %s，使用默认值 warn\n", policy)
+		return "warn"
+	}
+}
+
+// checkBucketPublicAccess 检查存储桶ACL是否允许所有人读取或写入
+func checkBucketPublicAccess(client *cos.Client) error {
+	policy := getPublicBucketPolicy()
+	if policy == "ignore" {
+		return nil
+	}
+	// 其他存储的ACL实现差异较大，只检查COS
+	if getStorageProvider() != "cos" {
+		return nil
+	}
+
+	acl, _, err := client.Bucket.GetACL(context.Background())
+	if err != nil {
+		// 没有读取ACL的权限不影响备份
+		fmt.Printf("警告: 读取存储桶ACL失败，无法检查公开访问: %v\n", err)
+		return nil
+	}
+
+	var permissions []string
+	for _, grant := range acl.AccessControlList {
+		if grant.Grantee != nil && grant.Grantee.URI == allUsersURI {
+			permissions = append(permissions, grant.Permission)
+		}
+	}
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("存储桶 %s 允许所有人访问 (%s)，备份文件可能被公开下载",
+		os.Getenv("COS_BUCKET_NAME"), strings.Join(permissions, ", "))
+	if policy == "fail" {
+		return fmt.Errorf("%s，请将存储桶ACL改为私有", message)
+	}
+	fmt.Printf("警告: %s\n", message)
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// benchFileSampleSize 每个文件最多采样的字节数，让样本覆盖更多文件
+const benchFileSampleSize = 4 * 1024 * 1024
+
+// benchCandidate 一种候选压缩设置
+type benchCandidate struct {
+	Name     string // 显示名称
+	Pipeline string // 对应的SOURCE_PIPELINE
+	Level    int    // gzip级别，0表示不使用gzip阶段
+	compress func(w io.Writer, data []byte) error
+}
+
+// benchResult 一种压缩设置的测试结果
+type benchResult struct {
+	benchCandidate
+	Output   int64
+	Duration time.Duration
+}
+
+// Ratio 压缩后大小与原始大小之比
+func (r benchResult) Ratio(input int64) float64 {
+	return float64(r.Output) / float64(input)
+}
+
+// Speed 压缩速度（字节/秒）
+func (r benchResult) Speed(input int64) float64 {
+	return float64(input) / r.Duration.Seconds()
+}
+
+// countingWriter 只统计写入的字节数
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// benchCandidates 本机可用的压缩设置
+func benchCandidates() []benchCandidate {
+	candidates := []benchCandidate{
+		{Name: "不压缩", Pipeline: "tar", compress: func(w io.Writer, data []byte) error {
+			_, err := w.Write(data)
+			return err
+		}},
+		{Name: "zip (deflate)", Pipeline: "zip", compress: func(w io.Writer, data []byte) error {
+			zw := zip.NewWriter(w)
+			fw, err := zw.Create("sample")
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(data); err != nil {
+				return err
+			}
+			return zw.Close()
+		}},
+	}
+
+	for _, level := range []int{gzip.BestSpeed, 3, gzip.DefaultCompression, gzip.BestCompression} {
+		display := level
+		if level == gzip.DefaultCompression {
+			display = 6
+		}
+		level := level
+		candidates = append(candidates, benchCandidate{
+			Name:     fmt.Sprintf("gzip -%d", display),
+			Pipeline: "tar+gzip",
+			Level:    display,
+			compress: func(w io.Writer, data []byte) error {
+				gw, err := gzip.NewWriterLevel(w, level)
+				if err != nil {
+					return err
+				}
+				if _, err := gw.Write(data); err != nil {
+					return err
+				}
+				return gw.Close()
+			},
+		})
+	}
+	return candidates
+}
+
+// sampleSource 从源路径采样最多maxSize字节，返回样本和源的总大小
+func sampleSource(sourcePath string, maxSize int64) ([]byte, int64, error) {
+	var sample bytes.Buffer
+	var total int64
+
+	err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		total += info.Size()
+
+		remaining := maxSize - int64(sample.Len())
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > benchFileSampleSize {
+			remaining = benchFileSampleSize
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Printf("警告: 无法读取文件: %s: %v\n", path, err)
+			return nil
+		}
+		defer file.Close()
+		_, err = io.CopyN(&sample, file, remaining)
+		if err != nil && err != io.EOF {
+			fmt.Printf("警告: 无法读取文件: %s: %v\n", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("遍历源路径失败: %v", err)
+	}
+	return sample.Bytes(), total, nil
+}
+
+// recommendBenchResult 选择推荐的设置：样本几乎无法压缩时不压缩，
+// 否则在速度不低于最快gzip级别一半的设置中选择压缩率最高的
+func recommendBenchResult(results []benchResult, input int64) benchResult {
+	var gzipResults []benchResult
+	for _, r := range results {
+		if r.Level > 0 {
+			gzipResults = append(gzipResults, r)
+		}
+	}
+
+	best := gzipResults[len(gzipResults)-1]
+	if best.Ratio(input) > 0.95 {
+		return results[0]
+	}
+
+	minSpeed := gzipResults[0].Speed(input) / 2
+	recommended := gzipResults[0]
+	for _, r := range gzipResults[1:] {
+		if r.Speed(input) >= minSpeed && r.Output < recommended.Output {
+			recommended = r
+		}
+	}
+	return recommended
+}
+
+// runBench 对源路径采样，测试本机各压缩设置的速度和压缩率并给出推荐配置
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	sampleMB := fs.Int("sample", 64, "最多采样多少MB数据")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *sampleMB < 1 {
+		return fmt.Errorf("用法: vcpsave bench [-sample MB] <路径>")
+	}
+	sourcePath := fs.Arg(0)
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("无法访问源路径: %v", err)
+	}
+
+	sample, total, err := sampleSource(sourcePath, int64(*sampleMB)*1024*1024)
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return fmt.Errorf("源路径中没有可读取的数据: %s", sourcePath)
+	}
+	input := int64(len(sample))
+
+	fmt.Printf("\n=== 压缩测试: %s ===\n", sourcePath)
+	fmt.Printf("源大小: %.1f MB, 采样: %.1f MB\n\n", float64(total)/1024/1024, float64(input)/1024/1024)
+	fmt.Printf("%-16s %10s %12s %14s\n", "设置", "压缩率", "速度(MB/s)", "预计全量耗时")
+
+	var results []benchResult
+	for _, candidate := range benchCandidates() {
+		counter := &countingWriter{}
+		start := time.Now()
+		if err := candidate.compress(counter, sample); err != nil {
+			return fmt.Errorf("%s 测试失败: %v", candidate.Name, err)
+		}
+		result := benchResult{benchCandidate: candidate, Output: counter.n, Duration: time.Since(start)}
+		results = append(results, result)
+
+		estimate := time.Duration(float64(total) / result.Speed(input) * float64(time.Second))
+		fmt.Printf("%-16s %9.1f%% %12.1f %14v\n", candidate.Name,
+			result.Ratio(input)*100, result.Speed(input)/1024/1024, estimate.Round(time.Second))
+	}
+
+	recommended := recommendBenchResult(results, input)
+	pipelineSpec := recommended.Pipeline
+	if !info.IsDir() {
+		// 单个文件只能使用raw归档器
+		pipelineSpec = "raw"
+		if recommended.Level > 0 {
+			pipelineSpec = "raw+gzip"
+		}
+	}
+
+	fmt.Printf("\n推荐设置: %s\n", recommended.Name)
+	if recommended.Level == 0 {
+		fmt.Println("样本几乎无法压缩（可能已是压缩格式），压缩只会增加耗时")
+	}
+	fmt.Printf("SOURCE_PIPELINE=%s:%s\n", filepath.Base(sourcePath), pipelineSpec)
+	if recommended.Level > 0 {
+		fmt.Printf("GZIP_LEVEL=%d\n", recommended.Level)
+	}
+	fmt.Println("（测试只在本机内存中进行，不包括读取磁盘和上传的耗时）")
+	return nil
+}
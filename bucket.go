@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// bucketACLs COS支持的存储桶预设ACL
+var bucketACLs = map[string]bool{
+	"private":           true,
+	"public-read":       true,
+	"public-read-write": true,
+}
+
+// ensureBucket 检查存储桶是否存在，配置了CREATE_BUCKET_IF_MISSING时自动创建
+// 存储桶在COS_REGION指定的地域创建，ACL由CREATE_BUCKET_ACL指定，默认private
+func ensureBucket(client *cos.Client) error {
+	exists, err := client.Bucket.IsExist(context.Background())
+	if err != nil {
+		return fmt.Errorf("检查存储桶失败: %v", err)
+	}
+	if exists {
+		return nil
+	}
+
+	if getStorageProvider() != "cos" {
+		return fmt.Errorf("存储桶不存在，使用%s时请先手动创建存储桶", getStorageProvider())
+	}
+
+	bucketName := os.Getenv("COS_BUCKET_NAME")
+	if os.Getenv("CREATE_BUCKET_IF_MISSING") != "true" {
+		return fmt.Errorf("存储桶不存在: %s，可设置 CREATE_BUCKET_IF_MISSING=true 自动创建", bucketName)
+	}
+
+	acl := os.Getenv("CREATE_BUCKET_ACL")
+	if acl == "" {
+		acl = "private"
+	}
+	if !bucketACLs[acl] {
+		return fmt.Errorf("CREATE_BUCKET_ACL格式错误: %s，可选值: private, public-read, public-read-write", acl)
+	}
+	if acl != "private" {
+		fmt.Printf("警告: 将以公开ACL %s 创建存储桶，备份文件可能被公开访问\n", acl)
+	}
+
+	fmt.Printf("存储桶不存在，正在创建: %s (地域: %s, ACL: %s)\n", bucketName, os.Getenv("COS_REGION"), acl)
+	_, err = client.Bucket.Put(context.Background(), &cos.BucketPutOptions{XCosACL: acl})
+	if err != nil {
+		return fmt.Errorf("创建存储桶失败: %v", err)
+	}
+
+	fmt.Printf("存储桶创建成功: %s\n", bucketName)
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// metaDirName 目标目录下存放程序元数据（索引等）的子目录
+const metaDirName = ".vcpsave"
+
+// catalogEntry 远程索引中的一条备份记录
+type catalogEntry struct {
+	Key       string `json:"key"`       // COS对象键
+	Prefix    string `json:"prefix"`    // 文件名前缀（源名称）
+	TimeStamp string `json:"timestamp"` // 备份时间戳：YYYYMMDD_HHMMSS
+	Size      int64  `json:"size"`      // 对象大小
+	Source    string `json:"source,omitempty"`
+	KeyID     string `json:"key_id,omitempty"` // 加密使用的密钥ID
+	SHA256    string `json:"sha256,omitempty"` // 对象内容的SHA-256
+	Parts     int    `json:"parts,omitempty"`  // 分卷数，Key不带卷号，各卷为 Key.001、Key.002……
+}
+
+// catalogIndex 远程索引文件 index.json 的内容
+type catalogIndex struct {
+	UpdatedAt string          `json:"updated_at"`
+	Backups   []catalogEntry  `json:"backups"`
+	Cleanup   *cleanupJournal `json:"cleanup,omitempty"` // 进行中的清理，清理完成后清空
+
+	CleanupPause *cleanupPause `json:"cleanup_pause,omitempty"` // 检测到疑似勒索软件后暂停清理
+}
+
+// cleanupJournal 清理的删除决定和进度，清理中断后下次从剩余的文件继续
+type cleanupJournal struct {
+	StartedAt string   `json:"started_at"`
+	Pending   []string `json:"pending"` // 已决定删除但尚未确认删除的文件名
+}
+
+// historyRecord 本地历史中的一条备份记录
+type historyRecord struct {
+	StartTime string  `json:"start_time"`
+	Project   string  `json:"project,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Prefix    string  `json:"prefix"`
+	Key       string  `json:"key,omitempty"`
+	Size      int64   `json:"size,omitempty"`
+	KeyID     string  `json:"key_id,omitempty"`
+	Status    string  `json:"status"` // success、failed、skipped（与上一次重叠被跳过）、cancelled（被新的备份取消）或 disabled（Error为禁用原因）
+	Duration  float64 `json:"duration_seconds,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Recovered bool    `json:"recovered,omitempty"` // 是否由 repair 从存储桶恢复
+
+	Resources *resourceUsage  `json:"resources,omitempty"`       // 备份该源的资源使用
+	Replicas  []replicaResult `json:"replicas,omitempty"`        // 复制到每个副本存储的结果
+	Sensitive []string        `json:"sensitive_files,omitempty"` // 可能包含密钥的文件（SENSITIVE_SCAN）
+}
+
+// cosObjectKey 拼接目标目录和文件名得到COS对象键
+func cosObjectKey(dirPath, fileName string) string {
+	cleanDir := strings.Trim(dirPath, "/")
+	if cleanDir == "" {
+		return fileName
+	}
+	return fmt.Sprintf("%s/%s", cleanDir, strings.TrimLeft(fileName, "/"))
+}
+
+// isMetaFile 检查文件名（相对目标目录）是否为程序元数据、状态标记或逐个上传的文件，这些都不是备份
+func isMetaFile(fileName string) bool {
+	return strings.HasPrefix(fileName, metaDirName+"/") || isStatusStampKey(fileName) || isFileUploadKey(fileName)
+}
+
+// getIndexKey 获取远程索引文件的对象键
+func getIndexKey(targetDir string) string {
+	return cosObjectKey(targetDir, metaDirName+"/index.json")
+}
+
+// getDataDir 获取本地数据目录，用于保存历史记录等状态
+func getDataDir() string {
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	return dataDir
+}
+
+// getHistoryPath 获取本地历史文件路径。本地历史使用JSON文件而不是SQLite，程序不引入cgo或数据库依赖，
+// 单个二进制即可在各平台运行；记录按时间顺序追加，导入其他存储时直接读取该数组即可
+func getHistoryPath() string {
+	return filepath.Join(getDataDir(), "history.json")
+}
+
+// loadCatalogIndex 从COS读取远程索引，不存在时返回空索引
+func loadCatalogIndex(client *cos.Client, targetDir string) (*catalogIndex, error) {
+	index := &catalogIndex{}
+
+	resp, err := client.Object.Get(context.Background(), getIndexKey(targetDir), nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("读取远程索引失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(index); err != nil {
+		return nil, &indexDecodeError{err: err}
+	}
+	return index, nil
+}
+
+// indexDecodeError 远程索引存在但无法解析，repair 据此判断可以覆盖索引
+type indexDecodeError struct {
+	err error
+}
+
+func (e *indexDecodeError) Error() string {
+	return fmt.Sprintf("解析远程索引失败: %v", e.err)
+}
+
+// saveCatalogIndex 将索引写回COS
+func saveCatalogIndex(client *cos.Client, targetDir string, index *catalogIndex) error {
+	sort.Slice(index.Backups, func(i, j int) bool {
+		return index.Backups[i].Key < index.Backups[j].Key
+	})
+	index.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化远程索引失败: %v", err)
+	}
+
+	_, err = client.Object.Put(context.Background(), getIndexKey(targetDir), bytes.NewReader(data), nil)
+	if err != nil {
+		return fmt.Errorf("写入远程索引失败: %v", err)
+	}
+	return nil
+}
+
+var (
+	// indexLocks 每个目标目录一把锁，保证同一进程内对远程索引的读-改-写不会互相覆盖
+	indexLocks sync.Map
+	// historyMu 保护本地历史文件的读-改-写
+	historyMu sync.Mutex
+)
+
+// lockCatalogIndex 获取目标目录的索引锁，返回解锁函数
+func lockCatalogIndex(targetDir string) func() {
+	lock, _ := indexLocks.LoadOrStore(strings.Trim(targetDir, "/"), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// updateCatalogIndex 在目标目录的锁内读取远程索引、修改并写回
+func updateCatalogIndex(client *cos.Client, targetDir string, update func(index *catalogIndex)) error {
+	defer lockCatalogIndex(targetDir)()
+
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil {
+		return err
+	}
+	update(index)
+	return saveCatalogIndex(client, targetDir, index)
+}
+
+// replaceCatalogBackups 在索引锁内用重建的记录替换远程索引中的备份记录，保留进行中的清理记录和清理暂停。
+// 远程索引已损坏时这两项无法读取，输出警告后写入只包含备份记录的新索引
+func replaceCatalogBackups(client *cos.Client, targetDir string, backups []catalogEntry) error {
+	defer lockCatalogIndex(targetDir)()
+
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil {
+		var decodeErr *indexDecodeError
+		if !errors.As(err, &decodeErr) {
+			return err
+		}
+		fmt.Printf("警告: %v，清理记录和清理暂停状态无法保留\n", err)
+		index = &catalogIndex{}
+	}
+	index.Backups = backups
+	return saveCatalogIndex(client, targetDir, index)
+}
+
+// addEntry 添加或更新一条索引记录
+func (index *catalogIndex) addEntry(entry catalogEntry) {
+	for i := range index.Backups {
+		if index.Backups[i].Key == entry.Key {
+			index.Backups[i] = entry
+			return
+		}
+	}
+	index.Backups = append(index.Backups, entry)
+}
+
+// removeEntry 删除一条索引记录
+func (index *catalogIndex) removeEntry(key string) {
+	for i := range index.Backups {
+		if index.Backups[i].Key == key {
+			index.Backups = append(index.Backups[:i], index.Backups[i+1:]...)
+			return
+		}
+	}
+}
+
+// loadHistory 读取本地历史记录，文件不存在时返回空列表
+func loadHistory() ([]historyRecord, error) {
+	data, err := os.ReadFile(getHistoryPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取本地历史失败: %v", err)
+	}
+
+	var records []historyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("解析本地历史失败: %v", err)
+	}
+	return records, nil
+}
+
+// saveHistory 写入本地历史记录（先写临时文件再替换，避免写入中断损坏文件）
+func saveHistory(records []historyRecord) error {
+	if err := os.MkdirAll(getDataDir(), 0755); err != nil {
+		return fmt.Errorf("创建数据目录失败: %v", err)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化本地历史失败: %v", err)
+	}
+
+	tmpPath := getHistoryPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("写入本地历史失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, getHistoryPath()); err != nil {
+		return fmt.Errorf("替换本地历史失败: %v", err)
+	}
+	return nil
+}
+
+// appendHistory 追加本地历史记录
+func appendHistory(newRecords ...historyRecord) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	records, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	return saveHistory(append(records, newRecords...))
+}
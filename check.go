@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// Nagios插件的退出码，Zabbix、Icinga等也使用相同的约定
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// sourceCheck 一个源的检查结果
+type sourceCheck struct {
+	name   string
+	state  int
+	latest time.Time // 零值表示没有任何备份
+}
+
+// runCheck 按远程索引中每个源最新备份的时间检查新鲜度，以Nagios插件的格式输出，返回退出码。
+// 第一行为状态摘要和性能数据，之后每行一个源
+func runCheck(client *cos.Client, projects []*project, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	warnStr := fs.String("warn", "26h", "最新备份超过此时长时为WARNING，支持 26h、2d 等格式")
+	critStr := fs.String("crit", "50h", "最新备份超过此时长时为CRITICAL")
+	projectName := fs.String("project", "", "只检查指定的项目，默认检查全部项目")
+	sourceNames := fs.String("source", "", "只检查指定的源（逗号分隔），默认检查SOURCEFOLDER中的全部源")
+	fs.Parse(args)
+
+	warn, err := parseAge(*warnStr)
+	if err != nil {
+		fmt.Printf("VCPSAVE UNKNOWN - -warn %v\n", err)
+		return checkUnknown
+	}
+	crit, err := parseAge(*critStr)
+	if err != nil {
+		fmt.Printf("VCPSAVE UNKNOWN - -crit %v\n", err)
+		return checkUnknown
+	}
+	if crit < warn {
+		fmt.Printf("VCPSAVE UNKNOWN - -crit (%v) 不能小于 -warn (%v)\n", crit, warn)
+		return checkUnknown
+	}
+
+	var checks []sourceCheck
+	var disabled []string
+	found := false
+	for _, proj := range projects {
+		if *projectName != "" && proj.Name != *projectName {
+			continue
+		}
+		found = true
+
+		var names []string
+		disabledReasons := parseKeyValueList(proj.Getenv("SOURCE_DISABLED"))
+		if *sourceNames != "" {
+			for _, name := range strings.Split(*sourceNames, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+		} else {
+			for _, sourcePath := range parseSourcePaths(proj.Getenv("SOURCEFOLDER")) {
+				names = append(names, sourceName(sourcePath))
+			}
+		}
+
+		latest, err := latestBackupTimes(client, proj.TargetDir)
+		if err != nil {
+			fmt.Printf("VCPSAVE UNKNOWN - %v\n", err)
+			return checkUnknown
+		}
+
+		for _, name := range names {
+			c := sourceCheck{name: name, state: checkOK, latest: latest[name]}
+			if proj.Name != "" {
+				c.name = proj.Name + "/" + name
+			}
+			// 禁用的源不参与检查，只在详情中列出
+			if reason := disabledReasons[name]; reason != "" {
+				disabled = append(disabled, fmt.Sprintf("%s (%s)", c.name, reason))
+				continue
+			}
+			switch {
+			case c.latest.IsZero(), time.Since(c.latest) > crit:
+				c.state = checkCritical
+			case time.Since(c.latest) > warn:
+				c.state = checkWarning
+			}
+			checks = append(checks, c)
+		}
+	}
+	if !found {
+		fmt.Printf("VCPSAVE UNKNOWN - 未找到项目: %s\n", *projectName)
+		return checkUnknown
+	}
+	if len(checks) == 0 {
+		fmt.Printf("VCPSAVE UNKNOWN - 没有需要检查的源\n")
+		return checkUnknown
+	}
+
+	// 状态严重的源排在前面
+	sort.SliceStable(checks, func(i, j int) bool {
+		return checks[i].state > checks[j].state
+	})
+
+	state := checkOK
+	counts := make([]int, 3)
+	var perfData []string
+	for _, c := range checks {
+		counts[c.state]++
+		if c.state > state {
+			state = c.state
+		}
+		if !c.latest.IsZero() {
+			perfData = append(perfData, fmt.Sprintf("'%s'=%ds;%d;%d;0",
+				c.name, int64(time.Since(c.latest).Seconds()), int64(warn.Seconds()), int64(crit.Seconds())))
+		}
+	}
+
+	fmt.Printf("VCPSAVE %s - %d 个源: %d CRITICAL, %d WARNING, %d OK | %s\n", checkStateNames[state],
+		len(checks), counts[checkCritical], counts[checkWarning], counts[checkOK], strings.Join(perfData, " "))
+	for _, c := range checks {
+		if c.latest.IsZero() {
+			fmt.Printf("%s: %s - 没有任何备份\n", checkStateNames[c.state], c.name)
+			continue
+		}
+		fmt.Printf("%s: %s - 最新备份 %s (%v 前)\n", checkStateNames[c.state], c.name,
+			c.latest.Format("2006-01-02 15:04:05"), time.Since(c.latest).Round(time.Minute))
+	}
+	for _, d := range disabled {
+		fmt.Printf("DISABLED: %s\n", d)
+	}
+	return state
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// cleanupMu 保证同一时间只有一次清理运行，与备份的runMu互相独立
+var cleanupMu sync.Mutex
+
+// getRetention 获取备份保留期：CLEANUP_MAX_AGE（如 36h）优先，否则使用CLEANUP_DAYS（默认7天）
+func getRetention(proj *project) time.Duration {
+	if maxAgeStr := proj.Getenv("CLEANUP_MAX_AGE"); maxAgeStr != "" {
+		if d, err := time.ParseDuration(maxAgeStr); err == nil && d > 0 {
+			return d
+		}
+		fmt.Printf("警告: CLEANUP_MAX_AGE格式错误: %s，改用CLEANUP_DAYS\n", maxAgeStr)
+	}
+
+	cleanupDays := 7 // 默认7天
+	if cleanupDaysStr := proj.Getenv("CLEANUP_DAYS"); cleanupDaysStr != "" {
+		if days, err := strconv.Atoi(cleanupDaysStr); err == nil {
+			cleanupDays = days
+		}
+	}
+	return time.Duration(cleanupDays) * 24 * time.Hour
+}
+
+// getCleanupConcurrency 获取清理时并发删除的数量
+func getCleanupConcurrency(proj *project) int {
+	concurrency := 8
+	if concurrencyStr := proj.Getenv("CLEANUP_CONCURRENCY"); concurrencyStr != "" {
+		if n, err := strconv.Atoi(concurrencyStr); err == nil && n >= 1 {
+			concurrency = n
+		} else {
+			fmt.Printf("警告: CLEANUP_CONCURRENCY格式错误: %s，使用默认值 %d\n", concurrencyStr, concurrency)
+		}
+	}
+	return concurrency
+}
+
+// getMaxClockSkew 获取允许的本地时间与COS服务器时间的最大偏差
+func getMaxClockSkew(proj *project) time.Duration {
+	maxSkew := 5 * time.Minute
+	if skewStr := proj.Getenv("CLOCK_SKEW_MAX"); skewStr != "" {
+		if d, err := time.ParseDuration(skewStr); err == nil && d > 0 {
+			maxSkew = d
+		} else {
+			fmt.Printf("警告: CLOCK_SKEW_MAX格式错误: %s，使用默认值 %v\n", skewStr, maxSkew)
+		}
+	}
+	return maxSkew
+}
+
+// getClockSkew 根据COS响应的Date头计算本地时间与服务器时间的偏差（本地减服务器）
+func getClockSkew(client *cos.Client) (time.Duration, error) {
+	before := time.Now()
+	resp, err := client.Bucket.Head(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("获取COS服务器时间失败: %v", err)
+	}
+	after := time.Now()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("解析COS服务器时间失败: %v", err)
+	}
+
+	// 以请求往返的中点作为本地时间，Date头只精确到秒
+	local := before.Add(after.Sub(before) / 2)
+	return local.Sub(serverTime), nil
+}
+
+// checkClockSkew 检查本地时钟是否可信，按时间删除备份之前调用，
+// 系统时间错误可能导致所有备份看起来都已过期
+func checkClockSkew(client *cos.Client, proj *project) error {
+	skew, err := getClockSkew(client)
+	if err != nil {
+		return err
+	}
+
+	maxSkew := getMaxClockSkew(proj)
+	if skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("本地时间与COS服务器时间相差 %v，超过 CLOCK_SKEW_MAX=%v", skew.Round(time.Second), maxSkew)
+	}
+	return nil
+}
+
+// checkDeleteThreshold 检查一次清理要删除的数量是否超过安全阈值，
+// 防止系统时间错误或时间戳解析错误导致删除全部备份。
+// totals 为每个前缀的备份总数，expired 为要删除的文件名，同一备份的分卷按一个备份计算
+func checkDeleteThreshold(proj *project, expired []string, totals map[string]int) error {
+	if proj.Getenv("CLEANUP_CONFIRM_MASS_DELETE") == "true" {
+		return nil
+	}
+	expired = backupUnits(expired)
+
+	if maxStr := proj.Getenv("CLEANUP_MAX_DELETE"); maxStr != "" {
+		maxDelete, err := strconv.Atoi(maxStr)
+		if err != nil || maxDelete < 0 {
+			fmt.Printf("警告: CLEANUP_MAX_DELETE格式错误: %s\n", maxStr)
+		} else if len(expired) > maxDelete {
+			return fmt.Errorf("本次清理将删除 %d 个备份，超过 CLEANUP_MAX_DELETE=%d", len(expired), maxDelete)
+		}
+	}
+
+	if percentStr := proj.Getenv("CLEANUP_MAX_DELETE_PERCENT"); percentStr != "" {
+		maxPercent, err := strconv.ParseFloat(percentStr, 64)
+		if err != nil || maxPercent < 0 {
+			fmt.Printf("警告: CLEANUP_MAX_DELETE_PERCENT格式错误: %s\n", percentStr)
+			return nil
+		}
+
+		counts := make(map[string]int)
+		for _, fileName := range expired {
+			prefix, _, _ := parseFileName(fileName)
+			counts[prefix]++
+		}
+		for _, prefix := range sortedKeys(counts) {
+			percent := float64(counts[prefix]) * 100 / float64(totals[prefix])
+			if percent > maxPercent {
+				return fmt.Errorf("本次清理将删除前缀 %s 的 %d/%d 个备份 (%.0f%%)，超过 CLEANUP_MAX_DELETE_PERCENT=%s",
+					prefix, counts[prefix], totals[prefix], percent, percentStr)
+			}
+		}
+	}
+	return nil
+}
+
+// lockExpiredPrefixes 为要删除的文件所属前缀加锁，正在备份（锁被占用）的前缀本次不删除，
+// 返回可删除的文件和释放锁的函数
+func lockExpiredPrefixes(targetDir string, expired []string, skipped *skipSummary) ([]string, func()) {
+	byPrefix := make(map[string][]string)
+	for _, fileName := range expired {
+		prefix, _, _ := parseFileName(fileName)
+		byPrefix[prefix] = append(byPrefix[prefix], fileName)
+	}
+
+	var locked []*sync.Mutex
+	var result []string
+	for _, prefix := range sortedKeys(byPrefix) {
+		lock := prefixLock(targetDir, prefix)
+		if !lock.TryLock() {
+			fmt.Printf("前缀 %s 正在备份，本次跳过其 %d 个过期文件\n", prefix, len(byPrefix[prefix]))
+			for _, fileName := range byPrefix[prefix] {
+				skipped.add("前缀正在备份", fileName)
+			}
+			continue
+		}
+		locked = append(locked, lock)
+		result = append(result, byPrefix[prefix]...)
+	}
+
+	return result, func() {
+		for _, lock := range locked {
+			lock.Unlock()
+		}
+	}
+}
+
+// deleteExpiredFiles 以有限的并发删除文件及其清单，返回删除成功的文件名
+// checkpoint返回false时不再开始新的删除
+func deleteExpiredFiles(client *cos.Client, targetDir string, fileNames []string, concurrency int, checkpoint func(batch []string) bool) []string {
+	jobs := make(chan string)
+	results := make(chan string)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileName := range jobs {
+				if err := deleteCOSFile(client, targetDir, fileName); err != nil {
+					fmt.Printf("删除失败: %v\n", err)
+					continue
+				}
+				for _, name := range backupNames(fileName) {
+					if err := deleteManifest(client, targetDir, name); err != nil {
+						fmt.Printf("警告: %v\n", err)
+					}
+				}
+				results <- fileName
+			}
+		}()
+	}
+
+	go func() {
+	feed:
+		for _, fileName := range fileNames {
+			select {
+			case jobs <- fileName:
+			case <-stop:
+				break feed
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var deleted []string
+	var batch []string
+	stopped := false
+	for fileName := range results {
+		deleted = append(deleted, fileName)
+		batch = append(batch, fileName)
+		if len(batch) >= cleanupCheckpointSize {
+			if !checkpoint(batch) && !stopped {
+				close(stop)
+				stopped = true
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		checkpoint(batch)
+	}
+	return deleted
+}
+
+// cleanupCheckpointSize 每删除多少个文件记录一次清理进度
+const cleanupCheckpointSize = 50
+
+// loadCleanupJournal 读取远程索引中上次未完成的清理，没有时返回nil
+func loadCleanupJournal(client *cos.Client, targetDir string) *cleanupJournal {
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil {
+		fmt.Printf("警告: 读取清理进度失败，将重新判断过期文件: %v\n", err)
+		return nil
+	}
+	if index.Cleanup == nil || len(index.Cleanup.Pending) == 0 {
+		return nil
+	}
+	return index.Cleanup
+}
+
+// saveCleanupJournal 在删除前将本次清理决定删除的文件记录到远程索引
+func saveCleanupJournal(client *cos.Client, targetDir string, fileNames []string) error {
+	if len(fileNames) == 0 {
+		return nil
+	}
+	err := updateCatalogIndex(client, targetDir, func(index *catalogIndex) {
+		index.Cleanup = &cleanupJournal{
+			StartedAt: time.Now().Format(time.RFC3339),
+			Pending:   fileNames,
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("记录清理进度失败: %v", err)
+	}
+	return nil
+}
+
+// checkpointCleanup 从远程索引中移除已删除文件的记录和清理进度中的待删除项，finished为true时清空清理进度
+func checkpointCleanup(client *cos.Client, targetDir string, deleted []string, finished bool) error {
+	done := make(map[string]bool, len(deleted))
+	for _, fileName := range deleted {
+		done[fileName] = true
+	}
+
+	err := updateCatalogIndex(client, targetDir, func(index *catalogIndex) {
+		for _, fileName := range deleted {
+			for _, name := range backupNames(fileName) {
+				index.removeEntry(cosObjectKey(targetDir, name))
+			}
+		}
+		if index.Cleanup == nil {
+			return
+		}
+		if finished {
+			index.Cleanup = nil
+			return
+		}
+		var pending []string
+		for _, fileName := range index.Cleanup.Pending {
+			if !done[fileName] {
+				pending = append(pending, fileName)
+			}
+		}
+		index.Cleanup.Pending = pending
+	})
+	if err != nil {
+		return fmt.Errorf("更新远程索引失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// 旧编码文件名：从旧版Windows复制或经Samba写入的文件，文件名可能是GBK等本地编码而不是UTF-8，
+// 原样写入归档后在其他系统上解压为乱码。设置 FILENAME_LEGACY_ENCODING 后，不是有效UTF-8的文件名
+// 按该编码转换为UTF-8再写入归档；解压时不是有效UTF-8的条目名同样按该编码转换。
+
+// getLegacyEncoding 获取旧文件名的编码（FILENAME_LEGACY_ENCODING），例如 gbk、big5、shift_jis
+func getLegacyEncoding() string {
+	return strings.ToLower(os.Getenv("FILENAME_LEGACY_ENCODING"))
+}
+
+// nameDecoder 把旧编码的文件名转换为UTF-8，按路径的每一级缓存转换结果
+type nameDecoder struct {
+	encoding string
+	cache    map[string]string
+}
+
+// newNameDecoder 创建文件名转换器，encoding为空时返回nil
+func newNameDecoder(encoding string) *nameDecoder {
+	if encoding == "" {
+		return nil
+	}
+	return &nameDecoder{encoding: encoding, cache: make(map[string]string)}
+}
+
+// decode 转换斜杠分隔路径中不是有效UTF-8的部分，无法转换时保留原名
+func (d *nameDecoder) decode(name string) string {
+	if d == nil || utf8.ValidString(name) {
+		return name
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if utf8.ValidString(part) {
+			continue
+		}
+		decoded, ok := d.cache[part]
+		if !ok {
+			var err error
+			decoded, err = decodeCodepage(part, d.encoding)
+			if err != nil || !utf8.ValidString(decoded) {
+				fmt.Printf("警告: 文件名无法按%s转换，保留原名: %q: %v\n", d.encoding, part, err)
+				decoded = part
+			}
+			d.cache[part] = decoded
+		}
+		parts[i] = decoded
+	}
+	return strings.Join(parts, "/")
+}
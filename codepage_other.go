@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// decodeCodepage 调用系统的iconv程序把指定编码的文件名转换为UTF-8
+func decodeCodepage(name, encoding string) (string, error) {
+	path := os.Getenv("ICONV_PATH")
+	if path == "" {
+		path = "iconv"
+	}
+	cmd := exec.Command(path, "-f", encoding, "-t", "UTF-8")
+	cmd.Stdin = strings.NewReader(name)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("iconv转换失败: %v", err)
+	}
+	return string(output), nil
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procMultiByteToWideChar = kernel32.NewProc("MultiByteToWideChar")
+)
+
+// mbErrInvalidChars 遇到无效字符时失败，而不是替换为默认字符
+const mbErrInvalidChars = 0x8
+
+// windowsCodepages 编码名称对应的Windows代码页
+var windowsCodepages = map[string]uint32{
+	"gbk":       936,
+	"gb2312":    936,
+	"cp936":     936,
+	"gb18030":   54936,
+	"big5":      950,
+	"cp950":     950,
+	"shift_jis": 932,
+	"sjis":      932,
+	"cp932":     932,
+	"euc-kr":    949,
+	"cp949":     949,
+	"cp437":     437,
+}
+
+// decodeCodepage 使用Windows的代码页转换把指定编码的文件名转换为UTF-8
+func decodeCodepage(name, encoding string) (string, error) {
+	codepage, ok := windowsCodepages[encoding]
+	if !ok {
+		return "", fmt.Errorf("不支持的编码: %s", encoding)
+	}
+	if name == "" {
+		return "", nil
+	}
+	src := []byte(name)
+	n, _, err := procMultiByteToWideChar.Call(uintptr(codepage), mbErrInvalidChars,
+		uintptr(unsafe.Pointer(&src[0])), uintptr(len(src)), 0, 0)
+	if n == 0 {
+		return "", fmt.Errorf("代码页转换失败: %v", err)
+	}
+	buf := make([]uint16, n)
+	n, _, err = procMultiByteToWideChar.Call(uintptr(codepage), mbErrInvalidChars,
+		uintptr(unsafe.Pointer(&src[0])), uintptr(len(src)), uintptr(unsafe.Pointer(&buf[0])), n)
+	if n == 0 {
+		return "", fmt.Errorf("代码页转换失败: %v", err)
+	}
+	return syscall.UTF16ToString(buf[:n]), nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 完成钩子：每次备份运行结束后按顺序执行COMPLETION_HOOKS中配置的命令，例如更新CMDB、轮转本地暂存目录或触发下游同步。
+// 运行报告（JSON）写入命令的标准输入，运行的结果同时通过环境变量提供。每个钩子有单独的超时时间，
+// 失败时告警并记录在运行报告中，不影响备份本身的结果。与事件插件（PLUGIN_HOOKS）不同，钩子不需要返回JSON响应。
+
+// completionHook 一个完成钩子
+type completionHook struct {
+	name    string
+	command []string
+	timeout time.Duration
+	on      string // always、success、failure
+}
+
+// hookResult 一个完成钩子的执行结果，写入运行报告
+type hookResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // success、failed 或 timeout
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// completionHookKeys 返回钩子的配置项名称，供严格配置模式识别
+func completionHookKeys(proj *project) []string {
+	var keys []string
+	for _, name := range parseSourcePaths(proj.Getenv("COMPLETION_HOOKS")) {
+		prefix := "COMPLETION_HOOK_" + strings.ToUpper(name) + "_"
+		keys = append(keys, prefix+"COMMAND", prefix+"TIMEOUT", prefix+"ON")
+	}
+	return keys
+}
+
+// getCompletionHooks 获取项目的完成钩子，COMPLETION_HOOKS为钩子名称列表（逗号分隔），每个钩子的配置：
+// COMPLETION_HOOK_<名称>_COMMAND 命令，COMPLETION_HOOK_<名称>_TIMEOUT 超时时间（默认5m），
+// COMPLETION_HOOK_<名称>_ON 执行条件（always、success、failure，默认always）。配置错误的钩子输出警告后跳过
+func getCompletionHooks(proj *project) []*completionHook {
+	var hooks []*completionHook
+	for _, name := range parseSourcePaths(proj.Getenv("COMPLETION_HOOKS")) {
+		if !transformNamePattern.MatchString(name) {
+			fmt.Printf("警告: 完成钩子名称只能包含字母、数字和下划线: %s\n", name)
+			continue
+		}
+		prefix := "COMPLETION_HOOK_" + strings.ToUpper(name) + "_"
+
+		command := strings.TrimSpace(proj.Getenv(prefix + "COMMAND"))
+		if command == "" {
+			fmt.Printf("警告: 完成钩子 %s 未配置%sCOMMAND\n", name, prefix)
+			continue
+		}
+		hook := &completionHook{name: name, command: []string{command}, timeout: 5 * time.Minute, on: "always"}
+		// 与插件相同：命令是已存在的文件时整体作为路径，否则按空格拆分为路径和参数
+		if _, err := os.Stat(command); err != nil {
+			hook.command = strings.Fields(command)
+		}
+
+		if timeoutStr := proj.Getenv(prefix + "TIMEOUT"); timeoutStr != "" {
+			if d, err := time.ParseDuration(timeoutStr); err == nil && d > 0 {
+				hook.timeout = d
+			} else {
+				fmt.Printf("警告: %sTIMEOUT格式错误: %s，使用默认值 %v\n", prefix, timeoutStr, hook.timeout)
+			}
+		}
+		switch on := proj.Getenv(prefix + "ON"); on {
+		case "":
+		case "always", "success", "failure":
+			hook.on = on
+		default:
+			fmt.Printf("警告: %sON只能为 always、success 或 failure: %s，使用默认值 always\n", prefix, on)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// runCompletionHooks 按配置顺序执行项目的完成钩子，返回每个钩子的结果，失败的钩子汇总后告警
+func runCompletionHooks(proj *project, report *runReport, runStart time.Time) []hookResult {
+	hooks := getCompletionHooks(proj)
+	if len(hooks) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		fmt.Printf("警告: 序列化运行报告失败，跳过完成钩子: %v\n", err)
+		return nil
+	}
+
+	status := "success"
+	if report.Failed > 0 {
+		status = "failed"
+	}
+	env := append(os.Environ(),
+		"VCPSAVE_PROJECT="+proj.Name,
+		"VCPSAVE_STATUS="+status,
+		"VCPSAVE_SUCCEEDED="+strconv.Itoa(report.Succeeded),
+		"VCPSAVE_FAILED="+strconv.Itoa(report.Failed),
+		"VCPSAVE_SKIPPED="+strconv.Itoa(report.Skipped),
+		"VCPSAVE_START_TIME="+report.StartTime,
+		"VCPSAVE_END_TIME="+report.EndTime,
+		"VCPSAVE_TARGET_DIR="+proj.TargetDir,
+		"VCPSAVE_REPORT_KEY="+getReportKey(proj.TargetDir, runStart),
+	)
+
+	var results []hookResult
+	var failed []string
+	for _, hook := range hooks {
+		if (hook.on == "success" && status != "success") || (hook.on == "failure" && status == "success") {
+			continue
+		}
+		result := hook.run(payload, env)
+		results = append(results, result)
+		if result.Status != "success" {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
+	}
+	if len(failed) > 0 {
+		sendAlert(proj, "完成钩子执行失败", strings.Join(failed, "; "))
+	}
+	return results
+}
+
+// run 执行钩子，标准输入为运行报告，标准输出和标准错误输出转发到日志
+func (h *completionHook) run(payload []byte, env []string) hookResult {
+	fmt.Printf("执行完成钩子: %s\n", h.name)
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(append(payload, '\n'))
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = env
+	// 超时后钩子启动的子进程可能仍占用输出，不再等待
+	cmd.WaitDelay = 10 * time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	result := hookResult{Name: h.name, Status: "success", Duration: time.Since(start).Seconds()}
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line != "" {
+			fmt.Printf("[%s] %s\n", h.name, line)
+		}
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Status = "timeout"
+		result.Error = fmt.Sprintf("超过 %v 未完成", h.timeout)
+	case err != nil:
+		result.Status = "failed"
+		result.Error = err.Error()
+		// 附上最后一行输出，通常是错误原因
+		if lines := strings.Split(strings.TrimSpace(output.String()), "\n"); lines[len(lines)-1] != "" {
+			result.Error += ": " + strings.TrimSpace(lines[len(lines)-1])
+		}
+	}
+	if result.Status == "success" {
+		fmt.Printf("完成钩子 %s 执行成功，耗时 %.1fs\n", h.name, result.Duration)
+	} else {
+		fmt.Printf("警告: 完成钩子 %s 执行失败: %s\n", h.name, result.Error)
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// 外部压缩程序：Go标准库的gzip只能使用单核，多核主机上压缩往往是瓶颈。
+// 可用时调用pigz并行压缩gzip、使用zstd的多线程模式，不可用时回退到Go实现的并行gzip（见pgzip.go）。
+// 外部程序的输出与Go实现的格式相同，恢复时不需要区分。
+
+// getCompressThreads 获取压缩使用的线程数，默认使用全部CPU核心
+func getCompressThreads(proj *project) int {
+	threads := runtime.NumCPU()
+	if threadsStr := proj.Getenv("COMPRESS_THREADS"); threadsStr != "" {
+		if n, err := strconv.Atoi(threadsStr); err == nil && n > 0 {
+			threads = n
+		} else {
+			fmt.Printf("警告: COMPRESS_THREADS格式错误: %s，使用默认值 %d\n", threadsStr, threads)
+		}
+	}
+	return threads
+}
+
+// getPigzPath 获取pigz程序路径
+func getPigzPath() string {
+	if path := os.Getenv("PIGZ_PATH"); path != "" {
+		return path
+	}
+	return "pigz"
+}
+
+// findGzipCompressor 按GZIP_COMPRESSOR选择gzip压缩程序，返回pigz路径，使用Go实现时返回空字符串。
+// auto（默认）：找到pigz时使用pigz；pigz：必须使用pigz；go：始终使用Go实现
+func findGzipCompressor(proj *project) (string, error) {
+	mode := proj.Getenv("GZIP_COMPRESSOR")
+	switch mode {
+	case "", "auto":
+		path, err := exec.LookPath(getPigzPath())
+		if err != nil {
+			return "", nil
+		}
+		return path, nil
+	case "pigz":
+		path, err := exec.LookPath(getPigzPath())
+		if err != nil {
+			return "", fmt.Errorf("未找到pigz程序，请安装pigz或配置PIGZ_PATH: %v", err)
+		}
+		return path, nil
+	case "go":
+		return "", nil
+	default:
+		return "", fmt.Errorf("GZIP_COMPRESSOR配置错误，应为auto、pigz或go，当前为: %s", mode)
+	}
+}
+
+// commandWriter 将数据写入外部压缩程序的标准输入，程序的输出写入下游
+type commandWriter struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// startCompressor 启动外部压缩程序，输出写入w
+func startCompressor(path string, args []string, w io.Writer) (*commandWriter, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动%s失败: %v", path, err)
+	}
+	return &commandWriter{name: path, cmd: cmd, stdin: stdin}, nil
+}
+
+func (c *commandWriter) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Abort 结束压缩程序，不等待剩余的输出
+func (c *commandWriter) Abort() {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
+
+// Close 关闭标准输入并等待程序写完全部输出
+func (c *commandWriter) Close() error {
+	c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s压缩失败: %v", c.name, err)
+	}
+	return nil
+}
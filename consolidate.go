@@ -0,0 +1,239 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 归档合并：长期保留时每天的备份会积累大量小对象，按月把旧的日备份合并为一个对象，减少对象数量和请求费用。
+// 合并后的对象是不再压缩的zip，每个条目是原来的一个备份文件，文件名为 前缀_<当月最后一个备份的时间戳>.monthly.zip，
+// 仍按文件名时间戳参与保留期清理，当月最后一个备份过期时整月一起删除。
+
+// consolidatedExt 合并后归档的扩展名
+const consolidatedExt = ".monthly.zip"
+
+// getConsolidateAfter 获取备份在多久之后按月合并（CONSOLIDATE_AFTER_DAYS），未配置时返回0表示不合并
+func getConsolidateAfter(proj *project) time.Duration {
+	daysStr := proj.Getenv("CONSOLIDATE_AFTER_DAYS")
+	if daysStr == "" {
+		return 0
+	}
+	days, err := strconv.Atoi(daysStr)
+	if err != nil || days < 1 {
+		fmt.Printf("警告: CONSOLIDATE_AFTER_DAYS格式错误: %s，不合并归档\n", daysStr)
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// consolidationGroup 同一前缀同一月份的待合并备份
+type consolidationGroup struct {
+	prefix string
+	month  string // YYYYMM
+	files  []string
+	size   int64 // 原备份的总大小
+}
+
+// findConsolidationGroups 找出整月都早于cutoff、且至少有两个日备份的前缀和月份
+func findConsolidationGroups(targetDir string, objects []cos.Object, cutoff time.Time) []*consolidationGroup {
+	groups := make(map[string]*consolidationGroup)
+	for _, object := range objects {
+		fileName := strings.TrimPrefix(object.Key, strings.Trim(targetDir, "/")+"/")
+		if strings.HasSuffix(object.Key, "/") || isMetaFile(fileName) || strings.HasSuffix(fileName, consolidatedExt) {
+			continue
+		}
+		prefix, timeStamp, ok := parseFileName(fileName)
+		if !ok {
+			continue
+		}
+		backupTime, err := parseTimeStamp(timeStamp)
+		if err != nil {
+			continue
+		}
+		// 当月结束后才合并，避免同一个月产生多个合并归档
+		monthStart := time.Date(backupTime.Year(), backupTime.Month(), 1, 0, 0, 0, 0, backupTime.Location())
+		if monthStart.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		key := prefix + "_" + timeStamp[:6]
+		if groups[key] == nil {
+			groups[key] = &consolidationGroup{prefix: prefix, month: timeStamp[:6]}
+		}
+		groups[key].files = append(groups[key].files, fileName)
+		groups[key].size += object.Size
+	}
+
+	var result []*consolidationGroup
+	for _, key := range sortedKeys(groups) {
+		if group := groups[key]; len(group.files) >= 2 {
+			sort.Strings(group.files)
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// performConsolidation 把过了合并期限的日备份按月合并
+func performConsolidation(client *cos.Client, proj *project) {
+	after := getConsolidateAfter(proj)
+	if after == 0 {
+		return
+	}
+
+	fmt.Printf("\n=== 开始合并归档%s ===\n", proj.logTag())
+	targetDir := proj.TargetDir
+
+	// 合并会删除原备份，冻结或暂停清理时跳过
+	if freeze := getFreeze(client); freeze != nil {
+		fmt.Printf("删除已冻结: %s\n", freeze.Reason)
+		fmt.Printf("=== 合并归档已跳过%s ===\n", proj.logTag())
+		return
+	}
+	if pause := getCleanupPause(client, targetDir); pause != nil {
+		fmt.Printf("清理已暂停（%s 起）: %s\n", pause.Since, pause.Reason)
+		fmt.Printf("=== 合并归档已跳过%s ===\n", proj.logTag())
+		return
+	}
+
+	objects, err := cachedListCOSObjects(client, targetDir)
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+		return
+	}
+
+	merged := 0
+	for _, group := range findConsolidationGroups(targetDir, objects, time.Now().Add(-after)) {
+		// 正在备份的前缀下次再合并
+		lock := prefixLock(targetDir, group.prefix)
+		if !lock.TryLock() {
+			fmt.Printf("前缀 %s 正在备份，本次跳过合并 %s\n", group.prefix, group.month)
+			continue
+		}
+		err := consolidateGroup(client, proj, group)
+		lock.Unlock()
+		if err != nil {
+			fmt.Printf("错误: 合并 %s %s 失败: %v\n", group.prefix, group.month, err)
+			continue
+		}
+		merged += len(group.files)
+	}
+	if merged > 0 {
+		invalidateListCache(targetDir)
+	}
+
+	fmt.Printf("=== 合并归档完成%s，合并了 %d 个备份 ===\n", proj.logTag(), merged)
+}
+
+// consolidateGroup 下载一个月的日备份打包为一个zip，上传并校验后删除原备份
+func consolidateGroup(client *cos.Client, proj *project, group *consolidationGroup) error {
+	targetDir := proj.TargetDir
+	_, lastTimeStamp, _ := parseFileName(group.files[len(group.files)-1])
+	fileName := group.prefix + "_" + lastTimeStamp + consolidatedExt
+	cosPath := cosObjectKey(targetDir, fileName)
+
+	fmt.Printf("合并 %s %s 的 %d 个备份 -> %s\n", group.prefix, group.month, len(group.files), cosPath)
+	ctx := context.Background()
+	localPath, release, err := getStagingArea().reserve(ctx, fileName, group.size)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := writeConsolidatedArchive(client, targetDir, group.files, localPath); err != nil {
+		return err
+	}
+	checksum, err := fileSHA256(localPath)
+	if err != nil {
+		return err
+	}
+
+	opt := &cos.ObjectPutOptions{ACLHeaderOptions: uploadACLHeader()}
+	if _, err := client.Object.PutFromFile(ctx, cosPath, localPath, opt); err != nil {
+		return fmt.Errorf("上传合并归档失败: %v", err)
+	}
+	// 确认合并归档完整可读后才删除原备份
+	if err := verifyUploadedObject(client, cosPath, checksum); err != nil {
+		return fmt.Errorf("校验合并归档失败，保留原备份: %v", err)
+	}
+	copyToStoragePlugins(proj, cosPath, localPath)
+	replicateBackup(ctx, client, proj, cosPath, localPath, nil)
+
+	var size int64
+	if info, err := os.Stat(localPath); err == nil {
+		size = info.Size()
+	}
+	err = updateCatalogIndex(client, targetDir, func(index *catalogIndex) {
+		index.addEntry(catalogEntry{Key: cosPath, Prefix: group.prefix, TimeStamp: lastTimeStamp, Size: size, SHA256: checksum})
+	})
+	if err != nil {
+		fmt.Printf("警告: %v\n", err)
+	}
+
+	deleted := deleteExpiredFiles(client, targetDir, group.files, getCleanupConcurrency(proj), func(batch []string) bool {
+		err := updateCatalogIndex(client, targetDir, func(index *catalogIndex) {
+			for _, name := range batch {
+				index.removeEntry(cosObjectKey(targetDir, name))
+			}
+		})
+		if err != nil {
+			fmt.Printf("警告: %v\n", err)
+		}
+		return getFreeze(client) == nil
+	})
+	var deletedKeys []string
+	for _, name := range deleted {
+		deletedKeys = append(deletedKeys, cosObjectKey(targetDir, name))
+	}
+	deleteFromStoragePlugins(proj, deletedKeys)
+	deleteFromReplicas(deletedKeys)
+
+	if len(deleted) < len(group.files) {
+		fmt.Printf("警告: %d 个原备份未删除，已包含在合并归档中，可手动删除\n", len(group.files)-len(deleted))
+	}
+	return nil
+}
+
+// writeConsolidatedArchive 把备份逐个从存储桶读出写入zip，条目不压缩（备份本身已压缩或加密）
+func writeConsolidatedArchive(client *cos.Client, targetDir string, fileNames []string, localPath string) error {
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("创建合并归档失败: %v", err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for _, name := range fileNames {
+		resp, err := client.Object.Get(context.Background(), cosObjectKey(targetDir, name), nil)
+		if err != nil {
+			return fmt.Errorf("下载 %s 失败: %v", name, err)
+		}
+		header := &zip.FileHeader{Name: name, Method: zip.Store}
+		if _, timeStamp, ok := parseFileName(name); ok {
+			if t, err := parseTimeStamp(timeStamp); err == nil {
+				header.Modified = t
+			}
+		}
+		w, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.Copy(w, resp.Body)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("写入 %s 失败: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("写入合并归档失败: %v", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 加密文件格式：
+//
+//	magic(8) | keyID长度(1) | keyID | nonce前缀(8) | 分块...
+//
+// 每个分块为 密文长度(4, 大端) | AES-256-GCM密文，nonce = nonce前缀 + 分块序号(4)，
+// 最后一个分块的附加数据为 1，其余为 0，用于发现被截断的文件。
+const (
+	encryptMagic     = "VCPENC01"
+	encryptChunkSize = 64 * 1024
+)
+
+// getEncryptionKeys 解析ENCRYPTION_KEYS，格式为 keyID:密钥，密钥为32字节的base64或hex编码
+func getEncryptionKeys(proj *project) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for keyID, encoded := range parseKeyValueList(proj.Getenv("ENCRYPTION_KEYS")) {
+		key, err := decodeEncryptionKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("密钥 %s 无效: %v", keyID, err)
+		}
+		keys[keyID] = key
+	}
+	return keys, nil
+}
+
+// decodeEncryptionKey 解码32字节的AES-256密钥
+func decodeEncryptionKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		key, err = hex.DecodeString(encoded)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("应为32字节的base64或hex编码")
+	}
+	return key, nil
+}
+
+// getSourceKeyID 获取源前缀对应的密钥ID，未配置时返回空字符串表示不加密
+func getSourceKeyID(proj *project, prefix string) string {
+	return parseKeyValueList(proj.Getenv("SOURCE_ENCRYPTION_KEYS"))[prefix]
+}
+
+// encryptWriter 将明文分块加密后写入下游
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+// newEncryptWriter 写入文件头并返回加密写入器，调用方必须Close以写入最后一个分块
+func newEncryptWriter(w io.Writer, keyID string, key []byte) (*encryptWriter, error) {
+	if len(keyID) == 0 || len(keyID) > 255 {
+		return nil, fmt.Errorf("密钥ID长度应为1-255: %q", keyID)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce[:8]); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %v", err)
+	}
+
+	header := []byte(encryptMagic)
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = append(header, nonce[:8]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("写入加密文件头失败: %v", err)
+	}
+
+	return &encryptWriter{w: w, aead: aead, nonce: nonce, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+// Write 缓冲明文，满一个分块后加密写出
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close 写出最后一个分块
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+// flush 加密并写出当前缓冲的分块
+func (e *encryptWriter) flush(final bool) error {
+	binary.BigEndian.PutUint32(e.nonce[8:], e.counter)
+	e.counter++
+
+	ad := []byte{0}
+	if final {
+		ad[0] = 1
+	}
+	sealed := e.aead.Seal(nil, e.nonce, e.buf, ad)
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// readEncryptionHeader 读取加密文件头，返回密钥ID和nonce前缀
+func readEncryptionHeader(r io.Reader) (string, []byte, error) {
+	magic := make([]byte, len(encryptMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", nil, fmt.Errorf("读取加密文件头失败: %v", err)
+	}
+	if string(magic[:len(encryptMagic)]) != encryptMagic {
+		return "", nil, fmt.Errorf("不是vcpsave加密文件")
+	}
+
+	rest := make([]byte, int(magic[len(encryptMagic)])+8)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return "", nil, fmt.Errorf("读取加密文件头失败: %v", err)
+	}
+	keyLen := len(rest) - 8
+	return string(rest[:keyLen]), rest[keyLen:], nil
+}
+
+// decryptReader 逐块解密加密文件
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     bytes.Buffer
+	done    bool
+}
+
+// newDecryptReader 读取文件头，并根据其中的密钥ID从keys中选择密钥
+func newDecryptReader(r io.Reader, keys map[string][]byte) (io.Reader, string, error) {
+	keyID, noncePrefix, err := readEncryptionHeader(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, keyID, fmt.Errorf("未配置密钥: %s", keyID)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, keyID, fmt.Errorf("创建解密器失败: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, keyID, fmt.Errorf("创建解密器失败: %v", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, noncePrefix)
+	return &decryptReader{r: r, aead: aead, nonce: nonce}, keyID, nil
+}
+
+// Read 返回解密后的明文
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	return d.buf.Read(p)
+}
+
+// next 读取并解密下一个分块
+func (d *decryptReader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("加密文件不完整")
+		}
+		return err
+	}
+
+	// 分块大小来自文件，超过加密时的上限说明文件已损坏，不按该大小分配内存
+	sealedSize := binary.BigEndian.Uint32(size[:])
+	if sealedSize > uint32(encryptChunkSize+d.aead.Overhead()) {
+		return fmt.Errorf("加密文件已损坏: 分块大小 %d 超过上限", sealedSize)
+	}
+	sealed := make([]byte, sealedSize)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("加密文件不完整: %v", err)
+	}
+
+	binary.BigEndian.PutUint32(d.nonce[8:], d.counter)
+	d.counter++
+
+	// 先按普通分块解密，失败再按最后一个分块解密
+	plain, err := d.aead.Open(nil, d.nonce, sealed, []byte{0})
+	if err != nil {
+		plain, err = d.aead.Open(nil, d.nonce, sealed, []byte{1})
+		if err != nil {
+			return fmt.Errorf("解密失败，密钥错误或文件已损坏")
+		}
+		d.done = true
+	}
+
+	d.buf.Write(plain)
+	return nil
+}
+
+// restoreProject 获取 decrypt、extract 读取密钥和ARCHIVE_PASSWORD的项目：指定了名称时使用该项目，
+// 未指定时只有一个项目则使用该项目，配置了多个项目时只使用全局配置
+func restoreProject(name string) (*project, error) {
+	projects, err := loadProjects()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" && len(projects) > 1 {
+		return nil, nil
+	}
+	return findProject(projects, name)
+}
+
+// runDecrypt 执行 decrypt 命令：使用ENCRYPTION_KEYS中的密钥解密本地文件
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	projectName := fs.String("project", "", "使用指定项目的ENCRYPTION_KEYS，配置了多个项目时默认只使用全局配置")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("用法: vcpsave decrypt [-project 项目] <加密文件> <输出文件>")
+	}
+	args = fs.Args()
+
+	proj, err := restoreProject(*projectName)
+	if err != nil {
+		return err
+	}
+	keys, err := getEncryptionKeys(proj)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer in.Close()
+
+	r, keyID, err := newDecryptReader(in, keys)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(args[1])
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		os.Remove(args[1])
+		return err
+	}
+
+	fmt.Printf("解密完成: %s -> %s (密钥ID: %s)\n", args[0], args[1], keyID)
+	return out.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// 配置文件：默认加载当前目录的 .env。可以用 --env-file（可重复）或 VCPSAVE_ENV_FILE（逗号分隔）
+// 指定配置文件的位置，多个文件按顺序叠加，例如公共配置加上每台机器的覆盖配置。
+
+// parseEnvFileArgs 取出命令行开头的 --env-file 参数，返回配置文件和剩余的参数
+func parseEnvFileArgs(args []string) ([]string, []string, error) {
+	var files []string
+	for len(args) > 0 {
+		name, value, hasValue := strings.Cut(args[0], "=")
+		if name != "--env-file" && name != "-env-file" {
+			break
+		}
+		if !hasValue {
+			if len(args) < 2 {
+				return nil, nil, fmt.Errorf("--env-file 缺少文件路径")
+			}
+			value = args[1]
+			args = args[1:]
+		}
+		if value == "" {
+			return nil, nil, fmt.Errorf("--env-file 缺少文件路径")
+		}
+		files = append(files, value)
+		args = args[1:]
+	}
+	return files, args, nil
+}
+
+// envFileKeys 配置文件中出现的配置项及所在的文件，严格配置模式用于检查未知的配置项
+var envFileKeys map[string]string
+
+// loadEnvFiles 按顺序加载配置文件，后面的文件覆盖前面文件中的同名配置，环境变量中已有的配置优先。
+// 未指定文件时使用VCPSAVE_ENV_FILE，仍未指定时加载当前目录的 .env，不存在时只输出警告
+func loadEnvFiles(files []string) error {
+	if len(files) == 0 {
+		files = parseSourcePaths(os.Getenv("VCPSAVE_ENV_FILE"))
+	}
+	optional := false
+	if len(files) == 0 {
+		files = []string{".env"}
+		optional = true
+	}
+
+	merged := make(map[string]string)
+	envFileKeys = make(map[string]string)
+	for _, file := range files {
+		values, err := godotenv.Read(file)
+		if err != nil {
+			if optional {
+				fmt.Printf("警告: 无法加载.env文件: %v\n", err)
+				fmt.Println("将使用环境变量中的配置")
+				return nil
+			}
+			return fmt.Errorf("加载配置文件失败: %v", err)
+		}
+		for key, value := range values {
+			merged[key] = value
+			envFileKeys[key] = file
+		}
+	}
+	for key, value := range merged {
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pendingAttrs 解压完成后才恢复的文件属性：只读属性和ACL可能阻止后续写入
+type pendingAttrs struct {
+	path    string
+	records map[string]string
+}
+
+// extractor 解压归档并记录需要恢复的文件属性
+type extractor struct {
+	dest     string
+	dictPath string            // zstd字典
+	keys     map[string][]byte // 解密密钥，遇到加密数据时读取
+	renamed  map[string]string // 备份清单中记录的改名条目，解压时恢复原名
+	decoder  *nameDecoder      // 旧编码条目名的转换
+	merge    bool              // 保留目标目录中已有的文件，只写入不存在的文件
+	proj     *project          // 读取ENCRYPTION_KEYS和ARCHIVE_PASSWORD的项目，nil时使用全局配置
+	files    int
+	kept     int // 合并时保留的已有文件数
+	pending  []pendingAttrs
+}
+
+// keepExisting 合并模式下目标文件已存在时保留，返回true表示跳过该条目
+func (e *extractor) keepExisting(target string) bool {
+	if !e.merge {
+		return false
+	}
+	if _, err := os.Lstat(target); err != nil {
+		return false
+	}
+	fmt.Printf("已存在，保留: %s\n", target)
+	e.kept++
+	return true
+}
+
+// targetPath 计算归档条目在目标目录下的路径，拒绝指向目标目录之外的条目
+func (e *extractor) targetPath(name string) (string, error) {
+	name = e.decoder.decode(name)
+	if original, ok := e.renamed[strings.TrimSuffix(name, "/")]; ok {
+		name = original
+	}
+	target := filepath.Join(e.dest, filepath.FromSlash(name))
+	if target != e.dest && !strings.HasPrefix(target, e.dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("归档条目路径不安全: %s", name)
+	}
+	return target, nil
+}
+
+// writeFile 将条目内容写入目标文件
+func (e *extractor) writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %v", err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("写入文件失败: %s: %v", target, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("写入文件失败: %s: %v", target, err)
+	}
+	e.files++
+	return nil
+}
+
+// extractTar 解压tar归档，恢复PAX扩展记录中的文件属性
+func (e *extractor) extractTar(r io.Reader) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取tar归档失败: %v", err)
+		}
+
+		target, err := e.targetPath(header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("创建目录失败: %v", err)
+			}
+		case tar.TypeReg:
+			if e.keepExisting(target) {
+				continue
+			}
+			if err := e.writeFile(target, tarReader, header.FileInfo().Mode()); err != nil {
+				return err
+			}
+			os.Chtimes(target, header.ModTime, header.ModTime)
+		default:
+			fmt.Printf("跳过特殊条目: %s\n", header.Name)
+			continue
+		}
+
+		if records := restorableRecords(header.PAXRecords); len(records) > 0 {
+			e.pending = append(e.pending, pendingAttrs{path: target, records: records})
+		}
+	}
+}
+
+// restorableRecords 筛选需要恢复的PAX扩展记录：程序写入的Windows属性和扩展属性
+func restorableRecords(paxRecords map[string]string) map[string]string {
+	records := make(map[string]string)
+	for key, value := range paxRecords {
+		if strings.HasPrefix(key, "VCPSAVE.") || strings.HasPrefix(key, paxXattrPrefix) {
+			records[key] = value
+		}
+	}
+	return records
+}
+
+// extractZip 解压ZIP归档，恢复外部属性中的Windows文件属性
+func (e *extractor) extractZip(path string) error {
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("打开ZIP归档失败: %v", err)
+	}
+	defer zipReader.Close()
+
+	for _, f := range zipReader.File {
+		target, err := e.targetPath(f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("创建目录失败: %v", err)
+			}
+		} else {
+			if e.keepExisting(target) {
+				continue
+			}
+			var rc io.ReadCloser
+			if f.Method == zipMethodAES {
+				// 设置了ARCHIVE_PASSWORD的备份，条目按WinZip AES加密
+				rc, err = openAESEntry(f, getArchivePassword(e.proj))
+			} else {
+				rc, err = f.Open()
+			}
+			if err != nil {
+				return fmt.Errorf("读取ZIP条目失败: %s: %v", f.Name, err)
+			}
+			err = e.writeFile(target, rc, f.Mode())
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			os.Chtimes(target, f.Modified, f.Modified)
+		}
+
+		if attrs := f.ExternalAttrs & 0xff; attrs != 0 {
+			e.pending = append(e.pending, pendingAttrs{
+				path:    target,
+				records: map[string]string{paxWinAttrs: strconv.FormatUint(uint64(attrs), 10)},
+			})
+		}
+	}
+	return nil
+}
+
+// applyPending 恢复文件属性，逆序处理使目录的属性在其内容之后恢复
+func (e *extractor) applyPending() int {
+	failed := 0
+	for i := len(e.pending) - 1; i >= 0; i-- {
+		if err := applyFileAttrs(e.pending[i].path, e.pending[i].records); err != nil {
+			fmt.Printf("警告: %s: %v\n", e.pending[i].path, err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// 归档格式的魔数，按文件内容而不是扩展名识别格式，改名或重新加密过的备份也能正确恢复
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// tarMagicOffset tar文件头中"ustar"标识的偏移
+const tarMagicOffset = 257
+
+// detectFormat 根据开头的字节识别格式：enc、gzip、zstd、zip、tar，无法识别时返回空字符串
+func detectFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(tarMagicOffset + 5)
+	switch {
+	case bytes.HasPrefix(head, []byte(encryptMagic)):
+		return "enc"
+	case bytes.HasPrefix(head, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(head, zstdMagic):
+		return "zstd"
+	case bytes.HasPrefix(head, zipMagic), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return "zip"
+	case len(head) >= tarMagicOffset+5 && string(head[tarMagicOffset:tarMagicOffset+5]) == "ustar":
+		return "tar"
+	}
+	return ""
+}
+
+// trimLayerExt 去掉一层加密或压缩的扩展名，用于单个文件备份的输出文件名
+func trimLayerExt(name string) string {
+	for _, ext := range []string{".enc", ".gz", ".zst"} {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok {
+			return trimmed
+		}
+	}
+	if trimmed, ok := strings.CutSuffix(name, ".tgz"); ok {
+		return trimmed + ".tar"
+	}
+	return name
+}
+
+// extractStream 识别r的格式并逐层解开：解密、解压，直到得到tar或ZIP归档，
+// 都不是时视为单个文件备份，写入目标目录
+func (e *extractor) extractStream(r *bufio.Reader, name string) error {
+	switch format := detectFormat(r); format {
+	case "enc":
+		if e.keys == nil {
+			keys, err := getEncryptionKeys(e.proj)
+			if err != nil {
+				return err
+			}
+			e.keys = keys
+		}
+		plain, keyID, err := newDecryptReader(r, e.keys)
+		if err != nil {
+			return fmt.Errorf("解密失败: %v", err)
+		}
+		fmt.Printf("检测到加密数据（密钥ID: %s）\n", keyID)
+		return e.extractStream(bufio.NewReader(plain), trimLayerExt(name))
+	case "gzip":
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("解压gzip失败: %v", err)
+		}
+		defer gzipReader.Close()
+		return e.extractStream(bufio.NewReader(gzipReader), trimLayerExt(name))
+	case "zstd":
+		zstdArgs := []string{"-q", "-d", "-c"}
+		if e.dictPath != "" {
+			zstdArgs = append(zstdArgs, "-D", e.dictPath)
+		}
+		cmd := exec.Command(getZstdPath(), zstdArgs...)
+		cmd.Stdin = r
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("启动zstd失败: %v", err)
+		}
+		err = e.extractStream(bufio.NewReader(stdout), trimLayerExt(name))
+		io.Copy(io.Discard, stdout)
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+			err = fmt.Errorf("zstd解压失败（使用了字典的备份需要 -dict 指定对应版本的字典）: %v", waitErr)
+		}
+		return err
+	case "tar":
+		return e.extractTar(r)
+	case "zip":
+		// ZIP的中央目录在文件末尾，需要随机读取，解密或解压得到的ZIP先写入临时文件
+		tmpFile, err := os.CreateTemp("", "vcpsave-extract-*.zip")
+		if err != nil {
+			return fmt.Errorf("创建临时文件失败: %v", err)
+		}
+		defer removeTempFile(tmpFile.Name())
+		_, err = io.Copy(tmpFile, r)
+		if closeErr := tmpFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("写入临时文件失败: %v", err)
+		}
+		return e.extractZip(tmpFile.Name())
+	default:
+		target, err := e.targetPath(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("未识别为归档，按单个文件恢复: %s\n", name)
+		if e.keepExisting(target) {
+			return nil
+		}
+		return e.writeFile(target, r, 0644)
+	}
+}
+
+// isEmptyDir 检查目录是否不存在或为空
+func isEmptyDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return len(entries) == 0
+}
+
+// checkWritable 在目录中创建并删除一个临时文件，确认可以写入，避免只读挂载的目录恢复到一半才失败
+func checkWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".vcpsave-probe-*")
+	if err != nil {
+		return fmt.Errorf("目标目录不可写（可能是只读挂载）: %v", err)
+	}
+	name := probe.Name()
+	probe.Close()
+	return os.Remove(name)
+}
+
+// snapshotExisting 覆盖前将目标目录的现有内容打包为同级目录中的tar文件，返回tar文件路径
+func snapshotExisting(dest string) (string, error) {
+	path := fmt.Sprintf("%s_before_restore_%s.tar", dest, time.Now().Format("20060102_150405"))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("创建现有内容的备份失败: %v", err)
+	}
+	err = tarArchiver{}.Archive(dest, file, nil)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("备份现有内容失败: %v", err)
+	}
+	return path, nil
+}
+
+// runExtract 将下载的备份解压到目标目录，并恢复归档中保存的文件属性和ACL。
+// 格式根据文件内容识别，加密的备份使用ENCRYPTION_KEYS中的密钥直接解密，加密的ZIP条目使用ARCHIVE_PASSWORD
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	dictPath := fs.String("dict", "", "zstd备份使用的字典文件（对象元数据 vcpsave-zstd-dict 记录了版本）")
+	manifestPath := fs.String("manifest", "", "备份清单文件，恢复开启FILENAME_SANITIZE时被改名的文件")
+	encoding := fs.String("encoding", getLegacyEncoding(), "不是UTF-8的条目名的编码，例如 gbk")
+	noMark := fs.Bool("no-mark", false, "不在新的目标目录中写入"+outputMarker+"标记（有标记的目录备份时会跳过）")
+	force := fs.Bool("force", false, "目标目录不为空时覆盖其中的同名文件")
+	merge := fs.Bool("merge", false, "目标目录不为空时保留已有的文件，只写入不存在的文件")
+	backupExisting := fs.Bool("backup-existing", false, "目标目录不为空时先将现有内容打包到同级目录，再覆盖")
+	projectName := fs.String("project", "", "使用指定项目的ENCRYPTION_KEYS和ARCHIVE_PASSWORD，配置了多个项目时默认只使用全局配置")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("用法: vcpsave extract [-dict 字典文件] [-manifest 清单文件] [-encoding 编码] [-no-mark] [-force | -merge] [-backup-existing] [-project 项目] <归档文件> <目标目录>")
+	}
+	if *force && *merge {
+		return fmt.Errorf("-force 和 -merge 不能同时使用")
+	}
+	proj, err := restoreProject(*projectName)
+	if err != nil {
+		return err
+	}
+	archivePath := fs.Arg(0)
+	dest, err := filepath.Abs(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("目标目录无效: %v", err)
+	}
+	// 解压到新目录时写入输出标记，避免目标目录位于备份源中时再次被备份；
+	// 恢复到已有内容的目录（如原位置）时不写入，以免恢复的数据以后不再备份
+	empty := isEmptyDir(dest)
+	if !empty && !*force && !*merge && !*backupExisting {
+		return fmt.Errorf("目标目录不为空: %s，覆盖同名文件请使用 -force，保留已有文件请使用 -merge，覆盖前备份现有内容请使用 -backup-existing", dest)
+	}
+	mark := !*noMark && empty
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("创建目标目录失败: %v", err)
+	}
+	if err := checkWritable(dest); err != nil {
+		return err
+	}
+	if !empty && *backupExisting {
+		snapshot, err := snapshotExisting(dest)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已备份目标目录的现有内容: %s\n", snapshot)
+	}
+	if mark {
+		if err := writeOutputMarker(dest); err != nil {
+			fmt.Printf("警告: 写入解压目录标记失败: %v\n", err)
+		}
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer file.Close()
+
+	e := &extractor{dest: dest, dictPath: *dictPath, decoder: newNameDecoder(strings.ToLower(*encoding)), merge: *merge, proj: proj}
+	if *manifestPath != "" {
+		data, err := os.ReadFile(*manifestPath)
+		if err != nil {
+			return fmt.Errorf("读取备份清单失败: %v", err)
+		}
+		var manifest backupManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("解析备份清单失败: %v", err)
+		}
+		e.renamed = manifest.Renamed
+	}
+	r := bufio.NewReader(file)
+	if detectFormat(r) == "zip" {
+		// 未经加密或压缩的ZIP直接随机读取
+		err = e.extractZip(archivePath)
+	} else {
+		err = e.extractStream(r, filepath.Base(archivePath))
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := e.applyPending()
+	fmt.Printf("解压完成: %s -> %s, %d 个文件\n", archivePath, dest, e.files)
+	if e.kept > 0 {
+		fmt.Printf("保留了 %d 个已存在的文件\n", e.kept)
+	}
+	if mark {
+		fmt.Printf("目标目录已写入 %s 标记，备份时会跳过，恢复后作为备份源使用时请删除该文件\n", outputMarker)
+	}
+	if failed > 0 {
+		fmt.Printf("警告: %d 个文件的属性或ACL恢复失败\n", failed)
+	}
+	return nil
+}
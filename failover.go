@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 地域故障切换：配置COS_FALLBACK_BUCKET后在后台定期检查主存储桶，连续多次不可用时新的备份运行改为上传到
+// 备用存储桶（索引、清单和运行报告也写入备用存储桶）。主存储桶连续多次恢复后，将故障期间写入备用存储桶的
+// 对象复制回主存储桶并合并索引，确认大小一致后从备用存储桶删除，之后的运行重新使用主存储桶。
+
+// regionFailover 主存储桶的健康状态和故障切换
+type regionFailover struct {
+	primary  *cos.Client
+	fallback *cos.Client
+	target   *bucketSelection // 备用存储桶，记录在切换期间的运行报告中
+
+	mu        sync.Mutex
+	active    bool // 已切换到备用存储桶
+	failures  int  // 主存储桶连续检查失败的次数
+	successes int  // 切换后主存储桶连续检查成功的次数
+}
+
+// failover 配置了备用存储桶时的故障切换状态，未配置时为nil
+var failover *regionFailover
+
+// getHealthCheckInterval 获取主存储桶的检查间隔，默认1分钟
+func getHealthCheckInterval() time.Duration {
+	interval := time.Minute
+	if intervalStr := os.Getenv("HEALTH_CHECK_INTERVAL"); intervalStr != "" {
+		if d, err := time.ParseDuration(intervalStr); err == nil && d > 0 {
+			interval = d
+		} else {
+			fmt.Printf("警告: HEALTH_CHECK_INTERVAL格式错误: %s，使用默认值 %v\n", intervalStr, interval)
+		}
+	}
+	return interval
+}
+
+// getHealthCheckFailures 获取切换前主存储桶连续检查失败的次数，也是切回前连续检查成功的次数，默认3
+func getHealthCheckFailures() int {
+	failures := 3
+	if failuresStr := os.Getenv("HEALTH_CHECK_FAILURES"); failuresStr != "" {
+		if n, err := strconv.Atoi(failuresStr); err == nil && n >= 1 {
+			failures = n
+		} else {
+			fmt.Printf("警告: HEALTH_CHECK_FAILURES格式错误: %s，使用默认值 %d\n", failuresStr, failures)
+		}
+	}
+	return failures
+}
+
+// initFailover 根据COS_FALLBACK_BUCKET（存储桶:地域）创建备用存储桶的客户端，只支持COS
+func initFailover(primary *cos.Client) error {
+	value := os.Getenv("COS_FALLBACK_BUCKET")
+	if value == "" {
+		return nil
+	}
+	if getStorageProvider() != "cos" {
+		fmt.Printf("警告: COS_FALLBACK_BUCKET只支持COS，使用%s时已忽略\n", getStorageProvider())
+		return nil
+	}
+	bucket, region, ok := strings.Cut(value, ":")
+	bucket, region = strings.TrimSpace(bucket), strings.TrimSpace(region)
+	if !ok || bucket == "" || region == "" {
+		return fmt.Errorf("COS_FALLBACK_BUCKET格式错误，应为 存储桶:地域: %s", value)
+	}
+	if bucket == os.Getenv("COS_BUCKET_NAME") {
+		return fmt.Errorf("COS_FALLBACK_BUCKET不能与主存储桶相同: %s", bucket)
+	}
+
+	failover = &regionFailover{
+		primary:  primary,
+		fallback: newCOSClient(bucket, region, os.Getenv("TENCENTCLOUD_SECRET_ID"), os.Getenv("TENCENTCLOUD_SECRET_KEY")),
+		target:   &bucketSelection{Bucket: bucket, Region: region},
+	}
+	fmt.Printf("已配置备用存储桶: %s, 地域: %s\n", bucket, region)
+	return nil
+}
+
+// uploadTarget 获取本次运行上传使用的存储客户端和运行报告中记录的存储桶，已切换时返回备用存储桶
+func uploadTarget(client *cos.Client) (*cos.Client, *bucketSelection) {
+	if failover == nil || client != failover.primary {
+		return client, selectedBucket
+	}
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	if !failover.active {
+		return client, selectedBucket
+	}
+	fmt.Printf("主存储桶不可用，本次运行上传到备用存储桶: %s (%s)\n", failover.target.Bucket, failover.target.Region)
+	return failover.fallback, failover.target
+}
+
+// checkPrimary 检查主存储桶是否可以访问
+func (f *regionFailover) checkPrimary() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := f.primary.Bucket.Head(ctx)
+	return err
+}
+
+// startHealthChecker 在后台定期检查主存储桶，持续不可用时切换到备用存储桶，恢复后复制回主存储桶
+func startHealthChecker(projects []*project) {
+	if failover == nil {
+		return
+	}
+	interval := getHealthCheckInterval()
+	threshold := getHealthCheckFailures()
+	fmt.Printf("已启用主存储桶健康检查: 检查间隔 %v，连续 %d 次失败时切换到备用存储桶\n", interval, threshold)
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			err := failover.checkPrimary()
+
+			failover.mu.Lock()
+			active := failover.active
+			if err != nil {
+				failover.successes = 0
+				failover.failures++
+				fmt.Printf("警告: 主存储桶健康检查失败 (%d/%d): %v\n", failover.failures, threshold, err)
+			} else {
+				failover.failures = 0
+				if active {
+					failover.successes++
+				}
+			}
+			switchOver := !active && failover.failures >= threshold
+			switchBack := active && failover.successes >= threshold
+			if switchOver {
+				failover.active = true
+				failover.target.SelectedAt = time.Now().Format(time.RFC3339)
+			}
+			failover.mu.Unlock()
+
+			if switchOver {
+				for _, proj := range projects {
+					sendAlert(proj, "已切换到备用存储桶", fmt.Sprintf("主存储桶连续 %d 次不可用（%v），新的备份上传到 %s (%s)",
+						threshold, err, failover.target.Bucket, failover.target.Region))
+				}
+			}
+			if switchBack {
+				failover.failBack(projects)
+			}
+		}
+	}()
+}
+
+// failBack 主存储桶恢复后，将备用存储桶中各项目目标目录的对象复制回主存储桶，全部成功后切回主存储桶。
+// 持有runMu，复制期间没有备份运行向备用存储桶写入
+func (f *regionFailover) failBack(projects []*project) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	fmt.Printf("\n=== 主存储桶已恢复，开始从备用存储桶复制回 ===\n")
+	copied := 0
+	var failed []string
+	for _, proj := range projects {
+		n, err := f.reconcile(proj.TargetDir)
+		copied += n
+		if err != nil {
+			fmt.Printf("错误: 复制回主存储桶失败%s: %v\n", proj.logTag(), err)
+			failed = append(failed, fmt.Sprintf("%s: %v", proj.TargetDir, err))
+		}
+	}
+	if len(failed) > 0 {
+		// 保持切换状态，下次检查时重试
+		f.mu.Lock()
+		f.successes = 0
+		f.mu.Unlock()
+		for _, proj := range projects {
+			sendAlert(proj, "复制回主存储桶失败", fmt.Sprintf("已复制 %d 个对象，仍使用备用存储桶: %s", copied, strings.Join(failed, "; ")))
+		}
+		return
+	}
+
+	f.mu.Lock()
+	f.active = false
+	f.successes = 0
+	f.mu.Unlock()
+	fmt.Printf("=== 已复制回 %d 个对象，切回主存储桶 ===\n", copied)
+	for _, proj := range projects {
+		sendAlert(proj, "已切回主存储桶", fmt.Sprintf("主存储桶已恢复，故障期间的 %d 个对象已复制回主存储桶", copied))
+	}
+}
+
+// reconcile 将备用存储桶目标目录中的对象复制回主存储桶：主存储桶中没有的对象和更新的状态标记直接复制，
+// 索引合并到主存储桶的索引中。复制并确认大小后从备用存储桶删除，返回复制的对象数
+func (f *regionFailover) reconcile(targetDir string) (int, error) {
+	objects, err := listCOSObjects(f.fallback, targetDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, nil
+	}
+	primaryObjects, err := listCOSObjects(f.primary, targetDir)
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[string]cos.Object, len(primaryObjects))
+	for _, object := range primaryObjects {
+		existing[object.Key] = object
+	}
+
+	indexKey := getIndexKey(targetDir)
+	dirPrefix := ""
+	if cleanDir := strings.Trim(targetDir, "/"); cleanDir != "" {
+		dirPrefix = cleanDir + "/"
+	}
+	copied := 0
+	var moved []string
+	for _, object := range objects {
+		if object.Key == indexKey || strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		if current, ok := existing[object.Key]; ok {
+			// 故障期间写入的状态标记比主存储桶中的新，其他同名对象已在主存储桶中
+			if !isStatusStampKey(strings.TrimPrefix(object.Key, dirPrefix)) || !isNewerObject(object, current) {
+				moved = append(moved, object.Key)
+				continue
+			}
+		}
+		if err := copyObject(f.fallback, f.primary, object.Key); err != nil {
+			return copied, fmt.Errorf("复制 %s 失败: %v", object.Key, err)
+		}
+		fmt.Printf("已复制回主存储桶: %s\n", object.Key)
+		copied++
+		moved = append(moved, object.Key)
+	}
+
+	// 合并故障期间的索引记录，再删除备用存储桶中的索引
+	index, err := loadCatalogIndex(f.fallback, targetDir)
+	if err != nil {
+		return copied, err
+	}
+	if len(index.Backups) > 0 {
+		err := updateCatalogIndex(f.primary, targetDir, func(primaryIndex *catalogIndex) {
+			for _, entry := range index.Backups {
+				primaryIndex.addEntry(entry)
+			}
+		})
+		if err != nil {
+			return copied, fmt.Errorf("合并索引失败: %v", err)
+		}
+	}
+	moved = append(moved, indexKey)
+
+	for _, key := range moved {
+		if _, err := f.fallback.Object.Delete(context.Background(), key); err != nil {
+			fmt.Printf("警告: 从备用存储桶删除失败: %s, 错误: %v\n", key, err)
+		}
+	}
+	return copied, nil
+}
+
+// copyObject 从src下载对象并上传到dst，确认大小一致
+func copyObject(src, dst *cos.Client, key string) error {
+	ctx := context.Background()
+	resp, err := src.Object.Get(ctx, key, nil)
+	if err != nil {
+		return fmt.Errorf("下载失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 保留对象的元数据（如加密密钥ID）
+	metadata := make(map[string]string)
+	for name, values := range resp.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-cos-meta-") && len(values) > 0 {
+			metadata[strings.TrimPrefix(lower, "x-cos-meta-")] = values[0]
+		}
+	}
+	size := resp.ContentLength
+	opt := &cos.ObjectPutOptions{
+		ACLHeaderOptions: uploadACLHeader(),
+		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+			ContentLength: size,
+			XCosMetaXXX:   metadataHeader(metadata),
+		},
+	}
+	if _, err := dst.Object.Put(ctx, key, resp.Body, opt); err != nil {
+		return fmt.Errorf("上传失败: %v", err)
+	}
+
+	head, err := dst.Object.Head(ctx, key, nil)
+	if err != nil {
+		return fmt.Errorf("验证失败: %v", err)
+	}
+	if head.ContentLength != size {
+		return fmt.Errorf("大小不一致: 应为 %d bytes，实际 %d bytes", size, head.ContentLength)
+	}
+	return nil
+}
+
+// isNewerObject 检查对象a是否比b更晚写入，时间无法解析时返回false
+func isNewerObject(a, b cos.Object) bool {
+	aTime, errA := time.Parse(time.RFC3339, a.LastModified)
+	bTime, errB := time.Parse(time.RFC3339, b.LastModified)
+	return errA == nil && errB == nil && aTime.After(bTime)
+}
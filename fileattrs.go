@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// 平台相关的文件属性以PAX扩展记录的形式保存在tar归档中，解压时恢复。
+// ZIP归档只能在外部属性的低字节中保存Windows文件属性（只读、隐藏、系统、存档）。
+const (
+	paxWinAttrs    = "VCPSAVE.winattrs" // Windows文件属性（十进制）
+	paxWinSDDL     = "VCPSAVE.sddl"     // Windows安全描述符（SDDL格式，包含所有者、组和DACL）
+	paxXattrPrefix = "SCHILY.xattr."    // macOS扩展属性，与GNU tar、bsdtar的格式相同
+)
+
+// noBackupMarker 目录中存在此文件时不备份该目录
+const noBackupMarker = ".nobackup"
+
+// outputMarker 本程序写入暂存目录和解压目标目录的标记，源中包含这些目录时跳过，避免备份自己的输出导致备份越来越大
+const outputMarker = ".vcpsave-output"
+
+// writeOutputMarker 在目录中写入输出标记，已存在时不重复写入
+func writeOutputMarker(dir string) error {
+	path := filepath.Join(dir, outputMarker)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, []byte("此目录由vcpsave生成，备份时会跳过。需要备份此目录时请删除本文件。\n"), 0644)
+}
+
+// isPreserveACLsEnabled 检查是否在tar归档中保存Windows ACL
+func isPreserveACLsEnabled() bool {
+	return os.Getenv("PRESERVE_ACLS") == "true"
+}
+
+// excludeReason 检查路径是否带有排除标记，返回排除原因，不排除时返回空字符串
+func excludeReason(path string, info os.FileInfo) string {
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, noBackupMarker)); err == nil {
+			return "包含" + noBackupMarker
+		}
+		if reason := ownOutputReason(path); reason != "" {
+			return reason
+		}
+	}
+	if hasBackupExcludeXattr(path) {
+		return "已从时间机器备份中排除"
+	}
+	return ""
+}
+
+// ownOutputReason 检查目录是否为本程序的输出：暂存目录、解压目标目录（输出标记）和本地存储目录
+func ownOutputReason(path string) string {
+	if _, err := os.Stat(filepath.Join(path, outputMarker)); err == nil {
+		return "vcpsave的暂存或解压目录"
+	}
+	if info, err := os.Stat(filepath.Join(path, fsInternalDir)); err == nil && info.IsDir() {
+		return "vcpsave的本地存储目录"
+	}
+	// 暂存目录的标记被删除时仍按路径识别
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	if stagingDir, err := filepath.Abs(getStagingArea().dir); err == nil && samePath(filepath.Clean(abs), stagingDir) {
+		return "vcpsave的暂存目录"
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// xattrNoFollow 不跟随符号链接（XATTR_NOFOLLOW）
+const xattrNoFollow = 0x1
+
+// timeMachineExcludeXattr 时间机器排除标记（tmutil addexclusion 设置）
+const timeMachineExcludeXattr = "com.apple.metadata:com_apple_backup_excludeItem"
+
+// listXattrs 列出文件的扩展属性名称
+func listXattrs(path string) ([]string, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	size, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)), 0, 0, xattrNoFollow, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, _, errno = syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), xattrNoFollow, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// getXattr 读取文件的扩展属性
+func getXattr(path, name string) ([]byte, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	size, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)),
+		0, 0, 0, xattrNoFollow)
+	if errno != 0 {
+		return nil, errno
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	buf := make([]byte, size)
+	size, _, errno = syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, xattrNoFollow)
+	if errno != 0 {
+		return nil, errno
+	}
+	return buf[:size], nil
+}
+
+// setXattr 设置文件的扩展属性
+func setXattr(path, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	var v uintptr
+	if len(value) > 0 {
+		v = uintptr(unsafe.Pointer(&value[0]))
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)),
+		v, uintptr(len(value)), 0, xattrNoFollow)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dosFileAttrs macOS没有Windows文件属性
+func dosFileAttrs(string) uint32 { return 0 }
+
+// readFileAttrs 读取文件的扩展属性（包括资源分叉），以tar通用的 SCHILY.xattr. 记录保存
+func readFileAttrs(path string) (map[string]string, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]string)
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err != nil {
+			return records, err
+		}
+		records[paxXattrPrefix+name] = string(value)
+	}
+	return records, nil
+}
+
+// applyFileAttrs 恢复解压后文件的扩展属性，忽略归档中的Windows文件属性和ACL
+func applyFileAttrs(path string, records map[string]string) error {
+	for key, value := range records {
+		name, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := setXattr(path, name, []byte(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasBackupExcludeXattr 检查文件是否被标记为不参与时间机器备份
+func hasBackupExcludeXattr(path string) bool {
+	_, err := getXattr(path, timeMachineExcludeXattr)
+	return err == nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package main
+
+// dosFileAttrs 其他系统没有Windows文件属性
+func dosFileAttrs(string) uint32 { return 0 }
+
+// readFileAttrs 其他系统不需要额外保存的文件属性
+func readFileAttrs(string) (map[string]string, error) { return nil, nil }
+
+// applyFileAttrs 其他系统忽略归档中的文件属性、ACL和扩展属性
+func applyFileAttrs(string, map[string]string) error { return nil }
+
+// hasBackupExcludeXattr 只有macOS有时间机器排除标记
+func hasBackupExcludeXattr(string) bool { return false }
@@ -0,0 +1,148 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procGetNamedSecurityInfoW                                = advapi32.NewProc("GetNamedSecurityInfoW")
+	procConvertSecurityDescriptorToStringSecurityDescriptorW = advapi32.NewProc("ConvertSecurityDescriptorToStringSecurityDescriptorW")
+	procConvertStringSecurityDescriptorToSecurityDescriptorW = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+	procSetFileSecurityW                                     = advapi32.NewProc("SetFileSecurityW")
+)
+
+const (
+	seFileObject  = 1 // SE_FILE_OBJECT
+	sddlRevision1 = 1 // SDDL_REVISION_1
+
+	ownerSecurityInformation = 0x1
+	groupSecurityInformation = 0x2
+	daclSecurityInformation  = 0x4
+
+	// 保存和恢复的文件属性，其余属性（目录、压缩等）由系统维护
+	preservedFileAttrs = syscall.FILE_ATTRIBUTE_READONLY | syscall.FILE_ATTRIBUTE_HIDDEN |
+		syscall.FILE_ATTRIBUTE_SYSTEM | syscall.FILE_ATTRIBUTE_ARCHIVE
+)
+
+// dosFileAttrs 获取文件的Windows属性，用于写入ZIP外部属性
+func dosFileAttrs(path string) uint32 {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return 0
+	}
+	return attrs & preservedFileAttrs
+}
+
+// getFileSDDL 获取文件的安全描述符（所有者、组和DACL），以SDDL字符串表示
+func getFileSDDL(path string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+
+	securityInfo := uintptr(ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation)
+	var sd uintptr
+	ret, _, _ := procGetNamedSecurityInfoW.Call(uintptr(unsafe.Pointer(p)), seFileObject, securityInfo,
+		0, 0, 0, 0, uintptr(unsafe.Pointer(&sd)))
+	if ret != 0 {
+		return "", syscall.Errno(ret)
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	var str *uint16
+	var length uint32
+	ret, _, err = procConvertSecurityDescriptorToStringSecurityDescriptorW.Call(sd, sddlRevision1, securityInfo,
+		uintptr(unsafe.Pointer(&str)), uintptr(unsafe.Pointer(&length)))
+	if ret == 0 {
+		return "", err
+	}
+	defer syscall.LocalFree(syscall.Handle(unsafe.Pointer(str)))
+
+	return syscall.UTF16ToString(unsafe.Slice(str, length)), nil
+}
+
+// setFileSDDL 按SDDL字符串设置文件的安全描述符，没有权限设置所有者时只恢复DACL
+func setFileSDDL(path, sddl string) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	s, err := syscall.UTF16PtrFromString(sddl)
+	if err != nil {
+		return err
+	}
+
+	var sd uintptr
+	ret, _, err := procConvertStringSecurityDescriptorToSecurityDescriptorW.Call(uintptr(unsafe.Pointer(s)), sddlRevision1,
+		uintptr(unsafe.Pointer(&sd)), 0)
+	if ret == 0 {
+		return fmt.Errorf("解析SDDL失败: %v", err)
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	ret, _, _ = procSetFileSecurityW.Call(uintptr(unsafe.Pointer(p)),
+		ownerSecurityInformation|groupSecurityInformation|daclSecurityInformation, sd)
+	if ret != 0 {
+		return nil
+	}
+	ret, _, err = procSetFileSecurityW.Call(uintptr(unsafe.Pointer(p)), daclSecurityInformation, sd)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// readFileAttrs 读取需要保存到tar归档中的文件属性和ACL
+func readFileAttrs(path string) (map[string]string, error) {
+	records := make(map[string]string)
+	if attrs := dosFileAttrs(path); attrs != 0 {
+		records[paxWinAttrs] = strconv.FormatUint(uint64(attrs), 10)
+	}
+
+	if isPreserveACLsEnabled() {
+		sddl, err := getFileSDDL(path)
+		if err != nil {
+			return records, fmt.Errorf("读取ACL失败: %v", err)
+		}
+		records[paxWinSDDL] = sddl
+	}
+	return records, nil
+}
+
+// applyFileAttrs 恢复解压后文件的属性和ACL
+func applyFileAttrs(path string, records map[string]string) error {
+	if sddl := records[paxWinSDDL]; sddl != "" {
+		if err := setFileSDDL(path, sddl); err != nil {
+			return fmt.Errorf("恢复ACL失败: %v", err)
+		}
+	}
+
+	if attrsStr := records[paxWinAttrs]; attrsStr != "" {
+		attrs, err := strconv.ParseUint(attrsStr, 10, 32)
+		if err != nil {
+			return fmt.Errorf("文件属性格式错误: %s", attrsStr)
+		}
+		p, err := syscall.UTF16PtrFromString(path)
+		if err != nil {
+			return err
+		}
+		if err := syscall.SetFileAttributes(p, uint32(attrs)&preservedFileAttrs); err != nil {
+			return fmt.Errorf("恢复文件属性失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// hasBackupExcludeXattr 只有macOS有时间机器排除标记
+func hasBackupExcludeXattr(string) bool { return false }
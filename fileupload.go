@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 逐个上传：FILE_UPLOAD_SOURCES中的目录源不打包，每个文件单独上传到 目标目录/files/<源名称>/<相对路径>，
+// 可以在控制台中直接浏览和下载单个文件。大小相同、且存储中的对象不早于本地修改时间的文件不再重复上传；
+// 本地删除的文件在存储中保留。这些对象不是带时间戳的备份，不写入清单和索引，清理和垃圾回收都会跳过files目录。
+
+// filesDirName 目标目录下存放逐个上传的源的子目录
+const filesDirName = "files"
+
+// isFileUploadSource 检查源是否逐个上传文件，FILE_UPLOAD_SOURCES 为源名称列表（逗号分隔）
+func isFileUploadSource(proj *project, sourcePath string) bool {
+	return containsString(parseSourcePaths(proj.Getenv("FILE_UPLOAD_SOURCES")), sourceName(sourcePath))
+}
+
+// getFileUploadDir 获取源逐个上传的文件所在的目录
+func getFileUploadDir(targetDir, srcName string) string {
+	return cosObjectKey(targetDir, filesDirName+"/"+sanitizeObjectName(srcName))
+}
+
+// uploadSourceFiles 将目录中的文件逐个上传，跳过存储中已有的未变化的文件
+func uploadSourceFiles(ctx context.Context, client *cos.Client, proj *project, sourcePath, readPath string) (*backupResult, error) {
+	srcName := sourceName(sourcePath)
+
+	// 逐个上传的对象需要可以直接浏览，不能加密；配置了加密时拒绝上传明文
+	if getSourceKeyID(proj, srcName) != "" || getArchivePassword(proj) != "" {
+		return nil, fmt.Errorf("逐个上传的源不支持加密，请从SOURCE_ENCRYPTION_KEYS中移除 %s 或不配置ARCHIVE_PASSWORD", srcName)
+	}
+	if parseKeyValueList(proj.Getenv("SOURCE_PIPELINE"))[srcName] != "" {
+		fmt.Printf("警告: %s 逐个上传文件，忽略SOURCE_PIPELINE\n", srcName)
+	}
+	if getOffloadMode(proj, sourcePath) != "" {
+		fmt.Printf("警告: %s 逐个上传文件，不支持归档后删除源文件\n", srcName)
+	}
+
+	opts, err := getArchiveOptions(proj, srcName)
+	if err != nil {
+		return nil, err
+	}
+	if conditions := opts.String(); conditions != "" {
+		fmt.Printf("只上传%s的文件\n", conditions)
+	}
+	if getNestedSourcePolicy(proj) == "exclude" {
+		opts.nested = nestedSources(proj, sourcePath)
+	}
+	opts.sanitizer = getNameSanitizer()
+	opts.decoder = newNameDecoder(getLegacyEncoding())
+
+	if isVSSEnabled(proj) {
+		snapshotPath, release, err := snapshotSource(readPath)
+		if err != nil {
+			fmt.Printf("警告: %v，直接读取源路径\n", err)
+		} else {
+			defer release()
+			readPath = snapshotPath
+		}
+	}
+
+	sourceFiles, _, sourceBytes := opts.previewSource(readPath)
+	fmt.Printf("源数据: %d 个文件, %.1f MB (%d bytes)\n", sourceFiles, float64(sourceBytes)/1024/1024, sourceBytes)
+	if sourceFiles == 0 {
+		fmt.Printf("警告: 源目录中没有需要上传的文件: %s\n", sourcePath)
+	}
+
+	uploadDir := getFileUploadDir(proj.TargetDir, srcName)
+	objects, err := listCOSObjects(client, uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]cos.Object, len(objects))
+	for _, object := range objects {
+		existing[object.Key] = object
+	}
+
+	fmt.Printf("开始逐个上传: %s -> %s/\n", sourcePath, uploadDir)
+	var uploaded, unchanged int
+	var uploadedBytes int64
+	err = filepath.Walk(readPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		relPath, err := filepath.Rel(readPath, path)
+		if err != nil {
+			return fmt.Errorf("计算相对路径失败: %v", err)
+		}
+		if relPath == "." {
+			return nil
+		}
+		if skip, err := opts.skipEntry(path, relPath, info); skip {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			fmt.Printf("跳过特殊文件: %s\n", path)
+			return nil
+		}
+
+		content, transformed, err := opts.transformFile(path, relPath)
+		if err != nil {
+			return err
+		}
+		size := info.Size()
+		if transformed {
+			size = int64(len(content))
+		}
+		key := uploadDir + "/" + opts.memberName(relPath)
+		if object, ok := existing[key]; ok && object.Size == size && !isModifiedAfter(info.ModTime(), object.LastModified) {
+			unchanged++
+			return nil
+		}
+
+		if err := uploadSingleFile(ctx, client, key, path, content, transformed, size); err != nil {
+			return fmt.Errorf("上传 %s 失败: %v", relPath, err)
+		}
+		uploaded++
+		uploadedBytes += size
+		return nil
+	})
+	// 已上传的文件保留在存储中，下次运行时跳过
+	if err != nil {
+		return nil, fmt.Errorf("逐个上传失败（已上传 %d 个文件）: %v", uploaded, err)
+	}
+	fmt.Printf("逐个上传完成: 上传 %d 个文件 (%.1f MB)，%d 个文件未变化\n", uploaded, float64(uploadedBytes)/1024/1024, unchanged)
+	return &backupResult{
+		Key:         uploadDir + "/",
+		Size:        uploadedBytes,
+		PerFile:     true,
+		SourceFiles: sourceFiles,
+		SourceBytes: sourceBytes,
+	}, nil
+}
+
+// uploadSingleFile 上传一个文件，转换后的内容直接上传，超大文件分块上传
+func uploadSingleFile(ctx context.Context, client *cos.Client, key, path string, content []byte, transformed bool, size int64) error {
+	opt := &cos.ObjectPutOptions{ACLHeaderOptions: uploadACLHeader()}
+	if transformed {
+		_, err := client.Object.Put(ctx, key, bytes.NewReader(content), opt)
+		return err
+	}
+	if size > getLargeFileThreshold() {
+		_, err := uploadLargeFile(ctx, client, key, path, nil)
+		return err
+	}
+	_, err := client.Object.PutFromFile(ctx, key, path, opt)
+	return err
+}
+
+// isModifiedAfter 检查本地文件是否在存储中的对象（COS返回的ISO8601时间）之后修改，时间无法解析时按已修改处理。
+// 存储返回的时间只精确到秒，比较前去掉修改时间的小数部分
+func isModifiedAfter(modTime time.Time, cosTime string) bool {
+	uploadedAt, err := time.Parse(time.RFC3339, cosTime)
+	if err != nil {
+		return true
+	}
+	return modTime.Truncate(time.Second).After(uploadedAt)
+}
+
+// isFileUploadKey 检查文件名（相对目标目录）是否在逐个上传的目录中
+func isFileUploadKey(fileName string) bool {
+	return strings.HasPrefix(fileName, filesDirName+"/")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveOptions 归档时对遍历到的条目的筛选，并记录已归档的文件
+type archiveOptions struct {
+	modifiedAfter  time.Time // 只归档此时间之后修改的文件，零值表示不限
+	modifiedBefore time.Time // 只归档此时间之前修改的文件，零值表示不限
+	nested         []string  // 单独备份的嵌套源，相对于源路径（斜杠分隔），不再归档
+
+	archived   []archivedFile    // 已写入归档的文件，用于归档后删除
+	checksums  map[string]string // 已写入归档的文件的SHA-256（相对路径，斜杠分隔），为nil时不计算
+	sanitizer  *nameSanitizer    // 条目名清理规则，为nil时使用原文件名
+	decoder    *nameDecoder      // 旧编码文件名的转换，为nil时不转换
+	transforms []*transformRule  // 写入归档前改写文件内容的规则
+	password   string            // zip条目的加密密码，为空时不加密
+
+	warnedNonUTF8 bool // 已提示过不是UTF-8的文件名
+}
+
+// archivedFile 已写入归档的文件，删除前用大小和修改时间确认文件未再变化
+type archivedFile struct {
+	RelPath     string
+	Size        int64
+	ModTime     time.Time
+	Transformed bool // 归档中是转换后的内容，不能代替源文件
+}
+
+// record 记录已写入归档的文件
+func (o *archiveOptions) record(relPath string, info os.FileInfo, transformed bool) {
+	if o == nil {
+		return
+	}
+	o.archived = append(o.archived, archivedFile{RelPath: relPath, Size: info.Size(), ModTime: info.ModTime(), Transformed: transformed})
+}
+
+// writeFile 将文件写入归档，content为转换后的内容时直接写入，否则复制文件内容
+func (o *archiveOptions) writeFile(w io.Writer, path, relPath string, content []byte, transformed bool) error {
+	if !transformed {
+		return o.copyFile(w, path, relPath)
+	}
+	if _, err := w.Write(content); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	return nil
+}
+
+// copyFile 将文件内容写入归档，启用了校验和时同时计算文件的SHA-256
+func (o *archiveOptions) copyFile(w io.Writer, path, relPath string) error {
+	if o == nil || o.checksums == nil {
+		return copyFileTo(w, path)
+	}
+	h := sha256.New()
+	if err := copyFileTo(io.MultiWriter(w, h), path); err != nil {
+		return err
+	}
+	o.checksums[filepath.ToSlash(relPath)] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// parseAge 解析时长，除Go的时长格式外支持以d结尾的天数，例如 30d
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("时长格式错误: %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("时长格式错误: %s", value)
+	}
+	return d, nil
+}
+
+// getArchiveOptions 获取源的归档筛选条件：
+// SOURCE_MAX_AGE（源名称:时长）只归档最近修改的文件，SOURCE_MIN_AGE 只归档修改时间早于该时长的文件
+func getArchiveOptions(proj *project, sourceName string) (*archiveOptions, error) {
+	opts := &archiveOptions{}
+	now := time.Now()
+
+	if maxAge := parseKeyValueList(proj.Getenv("SOURCE_MAX_AGE"))[sourceName]; maxAge != "" {
+		d, err := parseAge(maxAge)
+		if err != nil {
+			return nil, fmt.Errorf("SOURCE_MAX_AGE: %v", err)
+		}
+		opts.modifiedAfter = now.Add(-d)
+	}
+	if minAge := parseKeyValueList(proj.Getenv("SOURCE_MIN_AGE"))[sourceName]; minAge != "" {
+		d, err := parseAge(minAge)
+		if err != nil {
+			return nil, fmt.Errorf("SOURCE_MIN_AGE: %v", err)
+		}
+		opts.modifiedBefore = now.Add(-d)
+	}
+	transforms, err := getTransformRules(proj, sourceName)
+	if err != nil {
+		return nil, err
+	}
+	opts.transforms = transforms
+	return opts, nil
+}
+
+// String 返回筛选条件的描述，没有条件时返回空字符串
+func (o *archiveOptions) String() string {
+	var conditions []string
+	if !o.modifiedAfter.IsZero() {
+		conditions = append(conditions, "修改于 "+o.modifiedAfter.Format("2006-01-02 15:04:05")+" 之后")
+	}
+	if !o.modifiedBefore.IsZero() {
+		conditions = append(conditions, "修改于 "+o.modifiedBefore.Format("2006-01-02 15:04:05")+" 之前")
+	}
+	return strings.Join(conditions, "，")
+}
+
+// skipEntry 检查遍历到的条目是否跳过：带有排除标记的文件和目录、单独备份的嵌套源、不满足修改时间条件的文件。
+// 跳过目录时返回filepath.SkipDir，供遍历函数直接返回
+func (o *archiveOptions) skipEntry(path, relPath string, info os.FileInfo) (bool, error) {
+	skip, message := o.matchEntry(path, relPath, info)
+	if message != "" {
+		fmt.Println(message)
+	}
+	if skip && info.IsDir() {
+		return true, filepath.SkipDir
+	}
+	return skip, nil
+}
+
+// matchEntry 检查条目是否跳过，排除目录和文件时同时返回日志信息
+func (o *archiveOptions) matchEntry(path, relPath string, info os.FileInfo) (bool, string) {
+	if o != nil && info.IsDir() {
+		for _, nested := range o.nested {
+			if samePath(filepath.ToSlash(relPath), nested) {
+				return true, fmt.Sprintf("跳过单独备份的嵌套源: %s", path)
+			}
+		}
+	}
+
+	if reason := excludeReason(path, info); reason != "" {
+		return true, fmt.Sprintf("跳过排除的路径: %s (%s)", path, reason)
+	}
+
+	if info.IsDir() || o == nil {
+		return false, ""
+	}
+	modTime := info.ModTime()
+	if !o.modifiedAfter.IsZero() && modTime.Before(o.modifiedAfter) {
+		return true, ""
+	}
+	if !o.modifiedBefore.IsZero() && !modTime.Before(o.modifiedBefore) {
+		return true, ""
+	}
+	return false, ""
+}
+
+// previewSource 按筛选条件统计将要归档的文件数、归档条目数（文件、目录和其他条目）和总大小，不输出跳过的条目
+func (o *archiveOptions) previewSource(sourcePath string) (int, int, int64) {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return 0, 0, 0
+	}
+	if !info.IsDir() {
+		return 1, 1, info.Size()
+	}
+
+	files, entries := 0, 0
+	var total int64
+	filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil || relPath == "." {
+			return nil
+		}
+		if skip, _ := o.matchEntry(path, relPath, info); skip {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries++
+		if info.Mode().IsRegular() {
+			files++
+			total += info.Size()
+		}
+		return nil
+	})
+	return files, entries, total
+}
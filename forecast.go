@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// forecastSamples 预测耗时时每个源参考的最近成功次数
+const forecastSamples = 5
+
+// getBackupWindow 获取备份窗口（一次运行允许的最长耗时），未配置时返回0
+func getBackupWindow(proj *project) time.Duration {
+	windowStr := proj.Getenv("BACKUP_WINDOW")
+	if windowStr == "" {
+		return 0
+	}
+	window, err := time.ParseDuration(windowStr)
+	if err != nil || window <= 0 {
+		fmt.Printf("警告: BACKUP_WINDOW格式错误: %s\n", windowStr)
+		return 0
+	}
+	return window
+}
+
+// runForecast 根据本地历史中每个源最近几次成功备份的平均耗时预测本次运行的耗时
+type runForecast struct {
+	proj      *project
+	start     time.Time
+	window    time.Duration
+	durations map[string]time.Duration // 源路径 → 预计耗时，没有历史的源不在其中
+	warned    bool
+}
+
+// newRunForecast 创建运行预测，历史读取失败时只是没有预测
+func newRunForecast(proj *project, sourcePaths []string, start time.Time) *runForecast {
+	f := &runForecast{
+		proj:      proj,
+		start:     start,
+		window:    getBackupWindow(proj),
+		durations: make(map[string]time.Duration),
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Printf("警告: %v，无法预测完成时间\n", err)
+		return f
+	}
+
+	for _, sourcePath := range sourcePaths {
+		var total float64
+		count := 0
+		for i := len(records) - 1; i >= 0 && count < forecastSamples; i-- {
+			record := records[i]
+			if record.Project == proj.Name && record.Source == sourcePath && record.Status == "success" && !record.Recovered {
+				total += record.Duration
+				count++
+			}
+		}
+		if count > 0 {
+			f.durations[sourcePath] = time.Duration(total / float64(count) * float64(time.Second))
+		}
+	}
+	return f
+}
+
+// remaining 预计剩余源的耗时，返回没有历史的源数量
+func (f *runForecast) remaining(sourcePaths []string) (time.Duration, int) {
+	var total time.Duration
+	unknown := 0
+	for _, sourcePath := range sourcePaths {
+		if d, ok := f.durations[sourcePath]; ok {
+			total += d
+		} else {
+			unknown++
+		}
+	}
+	return total, unknown
+}
+
+// fits 检查在备份窗口内是否还来得及备份该源：没有历史的源只在窗口已经用完时返回false
+func (f *runForecast) fits(sourcePath string) bool {
+	if f.window <= 0 {
+		return true
+	}
+	return time.Since(f.start)+f.durations[sourcePath] <= f.window
+}
+
+// report 输出预计完成时间，预计超出备份窗口时告警（每次运行只告警一次）
+// remainingPaths 为尚未处理的源
+func (f *runForecast) report(remainingPaths []string) {
+	if len(f.durations) == 0 {
+		return
+	}
+
+	remaining, unknown := f.remaining(remainingPaths)
+	elapsed := time.Since(f.start)
+	finish := time.Now().Add(remaining)
+
+	note := ""
+	if unknown > 0 {
+		note = fmt.Sprintf("，另有 %d 个源没有历史记录未计入", unknown)
+	}
+	fmt.Printf("预计完成时间: %s (剩余约 %v%s)\n",
+		finish.Format("2006-01-02 15:04:05"), remaining.Round(time.Second), note)
+
+	if f.window > 0 && !f.warned && elapsed+remaining > f.window {
+		f.warned = true
+		sendAlert(f.proj, "备份预计超出窗口", fmt.Sprintf("本次备份开始于 %s，预计耗时 %v，超过备份窗口 %v",
+			f.start.Format("2006-01-02 15:04:05"), (elapsed+remaining).Round(time.Second), f.window))
+	}
+}
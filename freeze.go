@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 删除冻结：事故调查期间需要保留所有现有备份时，冻结后清理和垃圾回收不再删除任何对象，备份照常进行。
+// 冻结来源有两种：环境变量 BACKUP_FREEZE=true，或存储桶根目录下的标记对象 .vcpsave/freeze.json。
+// 标记对象通过 freeze 命令或 /api/freeze 设置，对使用同一存储桶的所有实例立即生效。
+
+// freezeState 删除冻结状态
+type freezeState struct {
+	Since  string `json:"since"`
+	Reason string `json:"reason"`
+	By     string `json:"by,omitempty"`
+	Source string `json:"source"` // env 或 marker
+}
+
+// getFreezeKey 获取冻结标记对象的键
+func getFreezeKey() string {
+	return cosObjectKey("", metaDirName+"/freeze.json")
+}
+
+// getFreeze 获取删除冻结状态，未冻结时返回nil
+// 无法读取冻结标记时按已冻结处理，宁可推迟删除也不在冻结期间误删
+func getFreeze(client *cos.Client) *freezeState {
+	if os.Getenv("BACKUP_FREEZE") == "true" {
+		reason := os.Getenv("BACKUP_FREEZE_REASON")
+		if reason == "" {
+			reason = "BACKUP_FREEZE=true"
+		}
+		return &freezeState{Reason: reason, Source: "env"}
+	}
+
+	resp, err := client.Object.Get(context.Background(), getFreezeKey(), nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil
+		}
+		return &freezeState{Reason: fmt.Sprintf("无法读取冻结标记: %v", err), Source: "marker"}
+	}
+	defer resp.Body.Close()
+
+	// 手动上传的标记可以是空文件，只要存在就冻结
+	state := &freezeState{}
+	if err := json.NewDecoder(resp.Body).Decode(state); err != nil || state.Reason == "" {
+		state.Reason = "存在冻结标记 " + getFreezeKey()
+	}
+	state.Source = "marker"
+	return state
+}
+
+// setFreeze 写入冻结标记
+func setFreeze(client *cos.Client, reason, by string) (*freezeState, error) {
+	state := &freezeState{Since: time.Now().Format(time.RFC3339), Reason: reason, By: by, Source: "marker"}
+	if err := putJSONObject(client, getFreezeKey(), state); err != nil {
+		return nil, fmt.Errorf("设置删除冻结失败: %v", err)
+	}
+	return state, nil
+}
+
+// liftFreeze 删除冻结标记，环境变量设置的冻结需要修改配置后重启解除
+func liftFreeze(client *cos.Client) error {
+	if _, err := client.Object.Delete(context.Background(), getFreezeKey()); err != nil && !cos.IsNotFoundError(err) {
+		return fmt.Errorf("解除删除冻结失败: %v", err)
+	}
+	if os.Getenv("BACKUP_FREEZE") == "true" {
+		return fmt.Errorf("已删除冻结标记，但BACKUP_FREEZE=true仍然生效，请修改配置后重启")
+	}
+	return nil
+}
+
+// printFreeze 输出冻结状态
+func printFreeze(state *freezeState) {
+	if state == nil {
+		fmt.Println("删除未冻结")
+		return
+	}
+	fmt.Printf("删除已冻结 (来源: %s)", state.Source)
+	if state.Since != "" {
+		fmt.Printf("，%s 起", state.Since)
+	}
+	if state.By != "" {
+		fmt.Printf("，操作人: %s", state.By)
+	}
+	fmt.Printf("\n原因: %s\n", state.Reason)
+}
+
+// runFreeze 查看、设置或解除删除冻结
+func runFreeze(client *cos.Client, args []string) error {
+	fs := flag.NewFlagSet("freeze", flag.ExitOnError)
+	reason := fs.String("reason", "", "冻结原因，设置后冻结所有删除")
+	lift := fs.Bool("lift", false, "解除冻结")
+	fs.Parse(args)
+
+	switch {
+	case *lift:
+		if err := liftFreeze(client); err != nil {
+			return err
+		}
+		fmt.Println("已解除删除冻结")
+	case *reason != "":
+		by, _ := os.Hostname()
+		state, err := setFreeze(client, *reason, by)
+		if err != nil {
+			return err
+		}
+		printFreeze(state)
+	default:
+		printFreeze(getFreeze(client))
+	}
+	return nil
+}
+
+// handleFreeze 查看（GET）、设置（POST，reason参数）或解除（DELETE）删除冻结
+func (s *webServer) handleFreeze(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		state := getFreeze(s.client)
+		writeJSON(w, map[string]interface{}{"frozen": state != nil, "freeze": state})
+	case http.MethodPost:
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			writeJSONError(w, http.StatusBadRequest, "缺少reason参数")
+			return
+		}
+		state, err := setFreeze(s.client, reason, r.RemoteAddr)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		fmt.Printf("Web界面冻结删除 (来源: %s): %s\n", r.RemoteAddr, reason)
+		writeJSON(w, map[string]interface{}{"frozen": true, "freeze": state})
+	case http.MethodDelete:
+		if err := liftFreeze(s.client); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		fmt.Printf("Web界面解除删除冻结 (来源: %s)\n", r.RemoteAddr)
+		writeJSON(w, map[string]interface{}{"frozen": false})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "请使用GET、POST或DELETE")
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 目录存储：本地目录、NAS、SFTP和FTP服务器、WebDAV网盘没有对象存储接口，仍使用COS SDK组装请求，
+// 由fsTransport在目录上执行，文件名生成、保留期清理、白名单等逻辑不需要区分存储类型。
+// 对象元数据和未完成的分块上传保存在目录下的 .vcpsave-local 中。
+
+// fsInternalDir 保存元数据、分块上传和临时文件的目录，不出现在对象列表中
+const fsInternalDir = ".vcpsave-local"
+
+// objectFS 目录存储的文件操作，路径为相对于存储根目录、斜杠分隔的路径
+type objectFS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	// Create 创建或覆盖文件，Close返回成功时数据已经落盘
+	Create(name string) (io.WriteCloser, error)
+	// Rename 重命名文件，目标已存在时覆盖
+	Rename(oldName, newName string) error
+	// Remove 删除文件或空目录
+	Remove(name string) error
+	MkdirAll(name string) error
+	ReadDir(name string) ([]fs.FileInfo, error)
+	// String 返回存储位置，用于日志和列出结果中的存储桶名称
+	String() string
+}
+
+// newFSClient 创建读写目录存储的客户端
+func newFSClient(fsys objectFS) *cos.Client {
+	// SDK只接受http(s)地址，请求由fsTransport处理，不会发送到网络
+	bu := &url.URL{Scheme: "http", Host: "localhost"}
+	client := cos.NewClient(&cos.BaseURL{BucketURL: bu}, &http.Client{
+		Transport: &meteredTransport{transport: &fsTransport{fs: fsys}},
+	})
+	client.UserAgent = userAgent()
+	client.Conf.EnableCRC = false
+	client.Conf.RetryOpt.AutoSwitchHost = false
+	return client
+}
+
+// fsTransport 在目录存储上执行COS SDK生成的请求，支持备份用到的对象读写、列出、复制和分块上传
+type fsTransport struct {
+	fs objectFS
+}
+
+// fsMeta 对象的元数据（x-cos-meta-*请求头）
+type fsMeta map[string]string
+
+// RoundTrip 实现 http.RoundTripper
+func (t *fsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return fsError(req, http.StatusBadRequest, "InvalidArgument", "对象键不能包含 . 或 ..")
+		}
+	}
+	if key == fsInternalDir || strings.HasPrefix(key, fsInternalDir+"/") {
+		return fsError(req, http.StatusForbidden, "AccessDenied", "不能访问 "+fsInternalDir)
+	}
+	if _, err := t.fs.Stat("."); err != nil {
+		return fsError(req, http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("存储目录不可用: %v", err))
+	}
+
+	query := req.URL.Query()
+	if key == "" {
+		switch {
+		case req.Method == http.MethodHead:
+			return fsResponse(req, http.StatusOK, nil, nil)
+		case req.Method == http.MethodGet && query.Has("uploads"):
+			return t.listUploads(req, query)
+		case req.Method == http.MethodGet && !hasSubresource(query):
+			return t.listObjects(req, query)
+		}
+		return fsError(req, http.StatusNotImplemented, "NotImplemented", "目录存储不支持存储桶配置")
+	}
+
+	switch {
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		return t.initiateUpload(req, key)
+	case req.Method == http.MethodPut && query.Has("uploadId"):
+		return t.uploadPart(req, query.Get("uploadId"), query.Get("partNumber"))
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		return t.completeUpload(req, key, query.Get("uploadId"))
+	case req.Method == http.MethodDelete && query.Has("uploadId"):
+		t.removeUpload(query.Get("uploadId"))
+		return fsResponse(req, http.StatusNoContent, nil, nil)
+	case req.Method == http.MethodGet && query.Has("uploadId"):
+		return t.listParts(req, key, query.Get("uploadId"))
+	case hasSubresource(query):
+		return fsError(req, http.StatusNotImplemented, "NotImplemented", "目录存储不支持对象配置")
+	case req.Method == http.MethodPut && req.Header.Get("x-cos-copy-source") != "":
+		return t.copyObject(req, key)
+	case req.Method == http.MethodPut:
+		return t.putObject(req, key)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		return t.getObject(req, key)
+	case req.Method == http.MethodDelete:
+		return t.deleteObject(req, key)
+	}
+	return fsError(req, http.StatusMethodNotAllowed, "MethodNotAllowed", req.Method)
+}
+
+// hasSubresource 请求是否带有列出对象以外的参数（acl、versioning等）
+func hasSubresource(query url.Values) bool {
+	for name := range query {
+		if !relayListParams[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// metaPath 对象元数据的路径
+func metaPath(key string) string {
+	return path.Join(fsInternalDir, "meta", key+".json")
+}
+
+// uploadDir 分块上传的目录
+func uploadDir(uploadID string) string {
+	return path.Join(fsInternalDir, "uploads", path.Base(uploadID))
+}
+
+// writeFile 先写入临时文件再重命名，中断时不会留下不完整的对象，返回内容的MD5
+func (t *fsTransport) writeFile(name string, r io.Reader) (string, error) {
+	tmpDir := path.Join(fsInternalDir, "tmp")
+	if err := t.fs.MkdirAll(tmpDir); err != nil {
+		return "", err
+	}
+	if err := t.fs.MkdirAll(path.Dir(name)); err != nil {
+		return "", err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	tmpName := path.Join(tmpDir, "object-"+hex.EncodeToString(id))
+	w, err := t.fs.Create(tmpName)
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), r); err != nil {
+		w.Close()
+		t.fs.Remove(tmpName)
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		t.fs.Remove(tmpName)
+		return "", err
+	}
+	if err := t.fs.Rename(tmpName, name); err != nil {
+		t.fs.Remove(tmpName)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readFile 读取整个文件
+func (t *fsTransport) readFile(name string) ([]byte, error) {
+	r, err := t.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// readMeta 读取对象元数据，没有元数据时返回nil
+func (t *fsTransport) readMeta(key string) fsMeta {
+	data, err := t.readFile(metaPath(key))
+	if err != nil {
+		return nil
+	}
+	var meta fsMeta
+	json.Unmarshal(data, &meta)
+	return meta
+}
+
+// writeMeta 保存对象元数据，没有元数据时删除旧的元数据
+func (t *fsTransport) writeMeta(key string, meta fsMeta) error {
+	name := metaPath(key)
+	if len(meta) == 0 {
+		if err := t.fs.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = t.writeFile(name, bytes.NewReader(data))
+	return err
+}
+
+// requestMeta 取出请求中的 x-cos-meta-* 请求头
+func requestMeta(header http.Header) fsMeta {
+	meta := make(fsMeta)
+	for name := range header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-cos-meta-") {
+			meta[lower] = header.Get(name)
+		}
+	}
+	return meta
+}
+
+// putObject 上传对象，以斜杠结尾的键创建目录
+func (t *fsTransport) putObject(req *http.Request, key string) (*http.Response, error) {
+	if strings.HasSuffix(key, "/") {
+		if err := t.fs.MkdirAll(strings.TrimSuffix(key, "/")); err != nil {
+			return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		return fsResponse(req, http.StatusOK, nil, nil)
+	}
+	body := io.Reader(http.NoBody)
+	if req.Body != nil {
+		body = req.Body
+	}
+	etag, err := t.writeFile(key, body)
+	if err == nil {
+		err = t.writeMeta(key, requestMeta(req.Header))
+	}
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("写入 %s 失败: %v", key, err))
+	}
+	return fsResponse(req, http.StatusOK, http.Header{"Etag": {`"` + etag + `"`}}, nil)
+}
+
+// getObject 下载对象或获取对象信息
+func (t *fsTransport) getObject(req *http.Request, key string) (*http.Response, error) {
+	info, err := t.fs.Stat(strings.TrimSuffix(key, "/"))
+	if err != nil || info.IsDir() != strings.HasSuffix(key, "/") {
+		return fsError(req, http.StatusNotFound, "NoSuchKey", "对象不存在: "+key)
+	}
+
+	header := http.Header{
+		"Last-Modified": {info.ModTime().UTC().Format(http.TimeFormat)},
+		"Content-Type":  {"application/octet-stream"},
+	}
+	for name, value := range t.readMeta(key) {
+		header.Set(name, value)
+	}
+	if info.IsDir() {
+		return fsResponse(req, http.StatusOK, header, nil)
+	}
+
+	header.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	resp, _ := fsResponse(req, http.StatusOK, header, nil)
+	resp.ContentLength = info.Size()
+	if req.Method == http.MethodGet {
+		body, err := t.fs.Open(key)
+		if err != nil {
+			return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// deleteObject 删除对象，对象不存在时与COS相同返回成功
+func (t *fsTransport) deleteObject(req *http.Request, key string) (*http.Response, error) {
+	if strings.HasSuffix(key, "/") {
+		// 目录不为空时保留，与COS删除目录标记不影响其中的对象一致
+		t.fs.Remove(strings.TrimSuffix(key, "/"))
+		return fsResponse(req, http.StatusNoContent, nil, nil)
+	}
+	if err := t.fs.Remove(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("删除 %s 失败: %v", key, err))
+	}
+	t.writeMeta(key, nil)
+	return fsResponse(req, http.StatusNoContent, nil, nil)
+}
+
+// copyObject 复制对象，复制源为 host/key 形式
+func (t *fsTransport) copyObject(req *http.Request, key string) (*http.Response, error) {
+	_, sourceKey, _ := strings.Cut(req.Header.Get("x-cos-copy-source"), "/")
+	sourceKey, err := url.PathUnescape(sourceKey)
+	if err != nil || sourceKey == "" || strings.HasSuffix(sourceKey, "/") {
+		return fsError(req, http.StatusBadRequest, "InvalidArgument", "复制源格式错误")
+	}
+	for _, segment := range strings.Split(sourceKey, "/") {
+		if segment == "." || segment == ".." || segment == fsInternalDir {
+			return fsError(req, http.StatusBadRequest, "InvalidArgument", "复制源格式错误")
+		}
+	}
+
+	meta := t.readMeta(sourceKey)
+	if strings.EqualFold(req.Header.Get("x-cos-metadata-directive"), "Replaced") {
+		meta = requestMeta(req.Header)
+	}
+	if sourceKey != key {
+		source, err := t.fs.Open(sourceKey)
+		if err != nil {
+			return fsError(req, http.StatusNotFound, "NoSuchKey", "复制源不存在: "+sourceKey)
+		}
+		_, err = t.writeFile(key, source)
+		source.Close()
+		if err != nil {
+			return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("复制 %s 失败: %v", sourceKey, err))
+		}
+	} else if _, err := t.fs.Stat(key); err != nil {
+		return fsError(req, http.StatusNotFound, "NoSuchKey", "复制源不存在: "+sourceKey)
+	}
+	if err := t.writeMeta(key, meta); err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	return fsXML(req, &cos.ObjectCopyResult{LastModified: time.Now().UTC().Format(time.RFC3339)})
+}
+
+// walkFiles 递归列出dir下键以prefix开头的文件，跳过 .vcpsave-local
+func (t *fsTransport) walkFiles(dir, prefix string, objects *[]cos.Object) error {
+	entries, err := t.fs.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		key := entry.Name()
+		if dir != "." {
+			key = dir + "/" + key
+		}
+		if entry.IsDir() {
+			// 只进入可能包含前缀的目录
+			if key != fsInternalDir && (strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/")) {
+				if err := t.walkFiles(key, prefix, objects); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			*objects = append(*objects, cos.Object{
+				Key:          key,
+				Size:         entry.Size(),
+				LastModified: entry.ModTime().UTC().Format(time.RFC3339),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	return nil
+}
+
+// listObjects 按前缀列出对象，只返回文件，不返回目录
+func (t *fsTransport) listObjects(req *http.Request, query url.Values) (*http.Response, error) {
+	// 从前缀所在的目录开始遍历
+	prefix := query.Get("prefix")
+	start := "."
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		start = prefix[:i]
+	}
+	var objects []cos.Object
+	if err := t.walkFiles(start, prefix, &objects); err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("列出文件失败: %v", err))
+	}
+	return fsXML(req, listObjectsPage(t.fs.String(), objects, query))
+}
+
+// listObjectsPage 按列出对象请求的marker、delimiter和max-keys从键以prefix开头的全部对象中取出一页
+func listObjectsPage(name string, objects []cos.Object, query url.Values) *cos.BucketGetResult {
+	prefix, marker, delimiter := query.Get("prefix"), query.Get("marker"), query.Get("delimiter")
+	maxKeys := 1000
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n > 0 && n < maxKeys {
+		maxKeys = n
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	result := &cos.BucketGetResult{Name: name, Prefix: prefix, Marker: marker, Delimiter: delimiter, MaxKeys: maxKeys}
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		if object.Key <= marker {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(object.Key[len(prefix):], delimiter); i >= 0 {
+				common := object.Key[:len(prefix)+i+len(delimiter)]
+				if !seen[common] {
+					seen[common] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, common)
+				}
+				result.NextMarker = object.Key
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, object)
+		result.NextMarker = object.Key
+	}
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+	return result
+}
+
+// fsUpload 未完成的分块上传
+type fsUpload struct {
+	Key       string `json:"key"`
+	Initiated string `json:"initiated"`
+	Meta      fsMeta `json:"meta"`
+}
+
+// initiateUpload 初始化分块上传，保存对象键和元数据
+func (t *fsTransport) initiateUpload(req *http.Request, key string) (*http.Response, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	uploadID := hex.EncodeToString(id)
+
+	data, _ := json.Marshal(&fsUpload{Key: key, Initiated: time.Now().UTC().Format(time.RFC3339), Meta: requestMeta(req.Header)})
+	if _, err := t.writeFile(path.Join(uploadDir(uploadID), "upload.json"), bytes.NewReader(data)); err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("初始化分块上传失败: %v", err))
+	}
+	return fsXML(req, &cos.InitiateMultipartUploadResult{Bucket: t.fs.String(), Key: key, UploadID: uploadID})
+}
+
+// readUpload 读取分块上传的信息
+func (t *fsTransport) readUpload(uploadID string) (*fsUpload, error) {
+	data, err := t.readFile(path.Join(uploadDir(uploadID), "upload.json"))
+	if err != nil {
+		return nil, err
+	}
+	upload := &fsUpload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// removeUpload 删除分块上传的目录
+func (t *fsTransport) removeUpload(uploadID string) {
+	dir := uploadDir(uploadID)
+	entries, _ := t.fs.ReadDir(dir)
+	for _, entry := range entries {
+		t.fs.Remove(path.Join(dir, entry.Name()))
+	}
+	t.fs.Remove(dir)
+}
+
+// uploadPart 上传一个分块
+func (t *fsTransport) uploadPart(req *http.Request, uploadID, partNumber string) (*http.Response, error) {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 {
+		return fsError(req, http.StatusBadRequest, "InvalidArgument", "分块编号格式错误: "+partNumber)
+	}
+	if _, err := t.readUpload(uploadID); err != nil {
+		return fsError(req, http.StatusNotFound, "NoSuchUpload", "分块上传不存在: "+uploadID)
+	}
+	etag, err := t.writeFile(path.Join(uploadDir(uploadID), strconv.Itoa(n)), req.Body)
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("写入分块失败: %v", err))
+	}
+	return fsResponse(req, http.StatusOK, http.Header{"Etag": {`"` + etag + `"`}}, nil)
+}
+
+// listParts 列出已上传的分块
+func (t *fsTransport) listParts(req *http.Request, key, uploadID string) (*http.Response, error) {
+	if _, err := t.readUpload(uploadID); err != nil {
+		return fsError(req, http.StatusNotFound, "NoSuchUpload", "分块上传不存在: "+uploadID)
+	}
+	entries, err := t.fs.ReadDir(uploadDir(uploadID))
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	result := &cos.ObjectListPartsResult{Bucket: t.fs.String(), Key: key, UploadID: uploadID}
+	for _, entry := range entries {
+		n, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		result.Parts = append(result.Parts, cos.Object{
+			PartNumber:   n,
+			Size:         entry.Size(),
+			LastModified: entry.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Sort(cos.ObjectList(result.Parts))
+	return fsXML(req, result)
+}
+
+// completeUpload 按请求中的分块顺序合并为对象
+func (t *fsTransport) completeUpload(req *http.Request, key, uploadID string) (*http.Response, error) {
+	upload, err := t.readUpload(uploadID)
+	if err != nil {
+		return fsError(req, http.StatusNotFound, "NoSuchUpload", "分块上传不存在: "+uploadID)
+	}
+	var opt cos.CompleteMultipartUploadOptions
+	if err := xml.NewDecoder(req.Body).Decode(&opt); err != nil || len(opt.Parts) == 0 {
+		return fsError(req, http.StatusBadRequest, "MalformedXML", "分块列表格式错误")
+	}
+	for _, part := range opt.Parts {
+		if _, err := t.fs.Stat(path.Join(uploadDir(uploadID), strconv.Itoa(part.PartNumber))); err != nil {
+			return fsError(req, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("分块 %d 不存在", part.PartNumber))
+		}
+	}
+
+	// 逐个打开分块，同一时间只保持一个文件打开
+	pr, pw := io.Pipe()
+	go func() {
+		for _, part := range opt.Parts {
+			r, err := t.fs.Open(path.Join(uploadDir(uploadID), strconv.Itoa(part.PartNumber)))
+			if err == nil {
+				_, err = io.Copy(pw, r)
+				r.Close()
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	etag, err := t.writeFile(key, pr)
+	pr.Close()
+	if err == nil {
+		err = t.writeMeta(key, upload.Meta)
+	}
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("合并分块失败: %v", err))
+	}
+	t.removeUpload(uploadID)
+	return fsXML(req, &cos.CompleteMultipartUploadResult{Bucket: t.fs.String(), Key: key, ETag: `"` + etag + `"`})
+}
+
+// listUploads 列出未完成的分块上传
+func (t *fsTransport) listUploads(req *http.Request, query url.Values) (*http.Response, error) {
+	result := &cos.ObjectListUploadsResult{Bucket: t.fs.String(), Prefix: query.Get("prefix")}
+	entries, _ := t.fs.ReadDir(path.Join(fsInternalDir, "uploads"))
+	for _, entry := range entries {
+		upload, err := t.readUpload(entry.Name())
+		if err != nil || !strings.HasPrefix(upload.Key, result.Prefix) {
+			continue
+		}
+		result.Upload = append(result.Upload, cos.ListUploadsResultUpload{
+			Key:       upload.Key,
+			UploadID:  entry.Name(),
+			Initiated: upload.Initiated,
+		})
+	}
+	sort.Slice(result.Upload, func(i, j int) bool { return result.Upload[i].Key < result.Upload[j].Key })
+	return fsXML(req, result)
+}
+
+// fsResponse 构造响应
+func fsResponse(req *http.Request, status int, header http.Header, body []byte) (*http.Response, error) {
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// fsXML 返回XML格式的结果
+func fsXML(req *http.Request, v interface{}) (*http.Response, error) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	return fsResponse(req, http.StatusOK, http.Header{"Content-Type": {"application/xml"}}, data)
+}
+
+// fsError 返回COS格式的错误，SDK据此生成 cos.ErrorResponse
+func fsError(req *http.Request, status int, code, message string) (*http.Response, error) {
+	data, _ := xml.Marshal(&cos.ErrorResponse{Code: code, Message: message})
+	return fsResponse(req, status, http.Header{"Content-Type": {"application/xml"}}, data)
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FTP：只提供FTP的老式NAS（STORAGE_PROVIDER=ftp），支持显式（AUTH TLS）和隐式FTPS。
+// 数据连接使用被动模式，远程目录作为目录存储，由fsTransport提供COS接口。
+// FTP的控制连接同一时间只能执行一个命令，并发的操作各自使用连接池中的一个连接。
+
+// ftpTimeout 控制连接和数据连接无响应的超时时间
+const ftpTimeout = 2 * time.Minute
+
+// ftpMaxIdle 连接池保留的空闲连接数
+const ftpMaxIdle = 4
+
+// ftpConfig FTP服务器的连接配置
+type ftpConfig struct {
+	host     string
+	port     string
+	user     string
+	password string
+	path     string // 远程目录，为空时使用登录后的当前目录
+	tls      string // none、explicit 或 implicit
+	tlsConf  *tls.Config
+}
+
+// getFTPConfig 读取FTP服务器的配置
+func getFTPConfig(getenv func(string) string) (*ftpConfig, error) {
+	cfg := &ftpConfig{
+		host:     getenv("FTP_HOST"),
+		port:     getenv("FTP_PORT"),
+		user:     getenv("FTP_USER"),
+		password: getenv("FTP_PASSWORD"),
+		path:     strings.TrimSuffix(getenv("FTP_PATH"), "/"),
+		tls:      strings.ToLower(getenv("FTP_TLS")),
+	}
+	if cfg.host == "" {
+		return nil, fmt.Errorf("FTP服务器未配置，请设置FTP_HOST")
+	}
+	if cfg.user == "" {
+		cfg.user = "anonymous"
+	}
+	switch cfg.tls {
+	case "":
+		cfg.tls = "none"
+	case "none", "explicit", "implicit":
+	default:
+		return nil, fmt.Errorf("FTP_TLS配置错误，应为none、explicit或implicit，当前为: %s", cfg.tls)
+	}
+	if cfg.port == "" {
+		cfg.port = "21"
+		if cfg.tls == "implicit" {
+			cfg.port = "990"
+		}
+	}
+	if cfg.tls != "none" {
+		cfg.tlsConf = &tls.Config{
+			ServerName: cfg.host,
+			// NAS通常使用自签名证书
+			InsecureSkipVerify: getenv("FTP_TLS_SKIP_VERIFY") == "true",
+			// 多数服务器要求数据连接复用控制连接的TLS会话
+			ClientSessionCache: tls.NewLRUClientSessionCache(16),
+		}
+	}
+	return cfg, nil
+}
+
+// deadlineConn 每次读写前更新超时时间，连接无响应时读写返回错误
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(ftpTimeout))
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(ftpTimeout))
+	return c.Conn.Write(p)
+}
+
+// ftpConn 一个已登录的控制连接
+type ftpConn struct {
+	cfg      *ftpConfig
+	conn     net.Conn
+	text     *textproto.Conn
+	features map[string]bool // FEAT返回的扩展命令，如MLST、UTF8
+	noEPSV   bool            // 服务器不支持EPSV，使用PASV
+	lastUsed time.Time
+}
+
+// dialFTP 连接FTP服务器并登录
+func dialFTP(cfg *ftpConfig) (*ftpConn, error) {
+	raw, err := net.DialTimeout("tcp", net.JoinHostPort(cfg.host, cfg.port), 15*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("连接FTP服务器失败: %v", err)
+	}
+	var conn net.Conn = &deadlineConn{raw}
+	if cfg.tls == "implicit" {
+		conn = tls.Client(conn, cfg.tlsConf)
+	}
+	c := &ftpConn{cfg: cfg, conn: conn, text: textproto.NewConn(conn), features: make(map[string]bool)}
+	if err := c.login(); err != nil {
+		c.close()
+		return nil, err
+	}
+	c.lastUsed = time.Now()
+	return c, nil
+}
+
+// login 读取欢迎信息、协商TLS并登录
+func (c *ftpConn) login() error {
+	if _, _, err := c.text.ReadResponse(2); err != nil {
+		return fmt.Errorf("连接FTP服务器失败: %v", err)
+	}
+	if c.cfg.tls == "explicit" {
+		if _, err := c.cmd(2, "AUTH TLS"); err != nil {
+			return fmt.Errorf("服务器不支持FTPS: %v", err)
+		}
+		c.conn = tls.Client(c.conn, c.cfg.tlsConf)
+		c.text = textproto.NewConn(c.conn)
+	}
+
+	code, err := c.cmdCode("USER " + c.cfg.user)
+	if err == nil && code == 331 {
+		_, err = c.cmd(2, "PASS "+c.cfg.password)
+	}
+	if err != nil {
+		return fmt.Errorf("FTP登录失败，请检查FTP_USER和FTP_PASSWORD: %v", err)
+	}
+
+	if c.cfg.tls != "none" {
+		if _, err := c.cmd(2, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, err := c.cmd(2, "PROT P"); err != nil {
+			return fmt.Errorf("服务器不支持加密数据连接: %v", err)
+		}
+	}
+	if message, err := c.cmd(2, "FEAT"); err == nil {
+		for _, line := range strings.Split(message, "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				c.features[strings.ToUpper(fields[0])] = true
+			}
+		}
+	}
+	if c.features["UTF8"] {
+		c.cmd(2, "OPTS UTF8 ON")
+	}
+	if _, err := c.cmd(2, "TYPE I"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cmd 发送命令，响应码不以expect开头时返回 *textproto.Error
+func (c *ftpConn) cmd(expect int, line string) (string, error) {
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return "", err
+	}
+	_, message, err := c.text.ReadResponse(expect)
+	return message, err
+}
+
+// cmdCode 发送命令并返回响应码，用于需要区分多个成功响应码的命令
+func (c *ftpConn) cmdCode(line string) (int, error) {
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return 0, err
+	}
+	code, _, err := c.text.ReadResponse(0)
+	if err == nil && code >= 400 {
+		err = &textproto.Error{Code: code}
+	}
+	return code, err
+}
+
+func (c *ftpConn) close() {
+	c.text.PrintfLine("QUIT")
+	c.conn.Close()
+}
+
+// passive 进入被动模式并建立数据连接。
+// PASV返回的地址常是NAS的内网地址，总是连接控制连接的地址，只使用返回的端口
+func (c *ftpConn) passive() (net.Conn, error) {
+	var port int
+	if !c.noEPSV {
+		message, err := c.cmd(229, "EPSV")
+		if err == nil {
+			// 229 Entering Extended Passive Mode (|||6446|)
+			start, end := strings.Index(message, "(|||"), strings.LastIndex(message, "|)")
+			if start < 0 || end < start+4 {
+				return nil, fmt.Errorf("EPSV响应格式错误: %s", message)
+			}
+			port, err = strconv.Atoi(message[start+4 : end])
+			if err != nil {
+				return nil, fmt.Errorf("EPSV响应格式错误: %s", message)
+			}
+		} else if !isFTPReply(err) {
+			return nil, err
+		} else {
+			c.noEPSV = true
+		}
+	}
+	if port == 0 {
+		message, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		start, end := strings.Index(message, "("), strings.LastIndex(message, ")")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("PASV响应格式错误: %s", message)
+		}
+		parts := strings.Split(message[start+1:end], ",")
+		if len(parts) != 6 {
+			return nil, fmt.Errorf("PASV响应格式错误: %s", message)
+		}
+		p1, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+		p2, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("PASV响应格式错误: %s", message)
+		}
+		port = p1<<8 | p2
+	}
+
+	raw, err := net.DialTimeout("tcp", net.JoinHostPort(c.cfg.host, strconv.Itoa(port)), 15*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("建立FTP数据连接失败: %v", err)
+	}
+	return &deadlineConn{raw}, nil
+}
+
+// transfer 建立数据连接并发送传输命令（RETR、STOR、MLSD、LIST），返回数据连接
+func (c *ftpConn) transfer(line string) (net.Conn, error) {
+	data, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		data.Close()
+		return nil, err
+	}
+	if _, _, err := c.text.ReadResponse(1); err != nil {
+		data.Close()
+		return nil, err
+	}
+	if c.cfg.tls != "none" {
+		tlsData := tls.Client(data, c.cfg.tlsConf)
+		if err := tlsData.Handshake(); err != nil {
+			data.Close()
+			c.text.ReadResponse(0)
+			return nil, fmt.Errorf("FTP数据连接TLS握手失败: %v", err)
+		}
+		return tlsData, nil
+	}
+	return data, nil
+}
+
+// finish 关闭数据连接并读取传输结果
+func (c *ftpConn) finish(data net.Conn) error {
+	data.Close()
+	_, _, err := c.text.ReadResponse(2)
+	return err
+}
+
+// isFTPReply 错误是否为服务器的响应，此时控制连接仍然可用
+func isFTPReply(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply)
+}
+
+// ftpNotFound 550响应转换为fs.ErrNotExist
+func ftpNotFound(err error, name string) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code == 550 {
+		return &fs.PathError{Op: "ftp", Path: name, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// ftpFS FTP服务器上的目录
+type ftpFS struct {
+	cfg *ftpConfig
+
+	mu   sync.Mutex
+	idle []*ftpConn
+	dirs sync.Map // 已确认存在的目录
+}
+
+// newFTPFS 连接FTP服务器，把相对的远程目录转换为绝对路径，之后检查目录时切换工作目录不影响其他操作
+func newFTPFS(cfg *ftpConfig) (*ftpFS, error) {
+	c, err := dialFTP(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !path.IsAbs(cfg.path) {
+		// 257 "/home/user" is the current directory
+		message, err := c.cmd(257, "PWD")
+		start, end := strings.Index(message, `"`), strings.LastIndex(message, `"`)
+		if err != nil || start < 0 || end <= start {
+			c.close()
+			return nil, fmt.Errorf("获取FTP当前目录失败: %v %s", err, message)
+		}
+		cfg.path = path.Join(strings.ReplaceAll(message[start+1:end], `""`, `"`), cfg.path)
+	}
+	f := &ftpFS{cfg: cfg}
+	f.release(c, nil)
+	return f, nil
+}
+
+// acquire 从连接池取出连接，长时间空闲的连接先确认仍然可用
+func (f *ftpFS) acquire() (*ftpConn, error) {
+	for {
+		f.mu.Lock()
+		if len(f.idle) == 0 {
+			f.mu.Unlock()
+			return dialFTP(f.cfg)
+		}
+		c := f.idle[len(f.idle)-1]
+		f.idle = f.idle[:len(f.idle)-1]
+		f.mu.Unlock()
+
+		if time.Since(c.lastUsed) < 30*time.Second {
+			return c, nil
+		}
+		if _, err := c.cmd(2, "NOOP"); err == nil {
+			return c, nil
+		}
+		c.conn.Close()
+	}
+}
+
+// release 归还连接，出现网络错误时关闭连接
+func (f *ftpFS) release(c *ftpConn, err error) {
+	if err != nil && !isFTPReply(err) && !errors.Is(err, fs.ErrNotExist) {
+		c.conn.Close()
+		return
+	}
+	c.lastUsed = time.Now()
+	f.mu.Lock()
+	if len(f.idle) < ftpMaxIdle {
+		f.idle = append(f.idle, c)
+		c = nil
+	}
+	f.mu.Unlock()
+	if c != nil {
+		c.close()
+	}
+}
+
+// with 使用连接池中的连接执行操作
+func (f *ftpFS) with(op func(c *ftpConn) error) error {
+	c, err := f.acquire()
+	if err != nil {
+		return err
+	}
+	err = op(c)
+	f.release(c, err)
+	return err
+}
+
+// remotePath 远程路径
+func (f *ftpFS) remotePath(name string) string {
+	if p := path.Join(f.cfg.path, name); p != "" {
+		return p
+	}
+	return "."
+}
+
+func (f *ftpFS) String() string {
+	scheme := "ftp"
+	if f.cfg.tls != "none" {
+		scheme = "ftps"
+	}
+	return fmt.Sprintf("%s://%s@%s:%s/%s", scheme, f.cfg.user, f.cfg.host, f.cfg.port, strings.TrimPrefix(f.cfg.path, "/"))
+}
+
+func (f *ftpFS) Stat(name string) (fs.FileInfo, error) {
+	p := f.remotePath(name)
+	var info fs.FileInfo
+	err := f.with(func(c *ftpConn) error {
+		if c.features["MLST"] {
+			message, err := c.cmd(2, "MLST "+p)
+			if err != nil {
+				return ftpNotFound(err, name)
+			}
+			// 250-Listing path\n type=file;size=123;modify=20250101120000; path\n250 End
+			for _, line := range strings.Split(message, "\n") {
+				if facts, _, ok := strings.Cut(strings.TrimSpace(line), " "); ok && strings.Contains(facts, "=") {
+					info = parseMLSxEntry(facts, path.Base(name))
+					return nil
+				}
+			}
+			return fmt.Errorf("MLST响应格式错误: %s", message)
+		}
+
+		// 没有MLST时用SIZE、MDTM获取文件信息，能进入的为目录
+		if message, err := c.cmd(213, "SIZE "+p); err == nil {
+			size, _ := strconv.ParseInt(strings.TrimSpace(message), 10, 64)
+			fileInfo := &ftpFileInfo{name: path.Base(name), size: size}
+			if message, err := c.cmd(213, "MDTM "+p); err == nil {
+				fileInfo.modTime, _ = parseFTPTime(strings.TrimSpace(message))
+			} else if !isFTPReply(err) {
+				return err
+			}
+			info = fileInfo
+			return nil
+		} else if !isFTPReply(err) {
+			return err
+		}
+		if _, err := c.cmd(2, "CWD "+p); err != nil {
+			return ftpNotFound(err, name)
+		}
+		info = &ftpFileInfo{name: path.Base(name), dir: true}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (f *ftpFS) Open(name string) (io.ReadCloser, error) {
+	c, err := f.acquire()
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.transfer("RETR " + f.remotePath(name))
+	if err != nil {
+		f.release(c, err)
+		return nil, ftpNotFound(err, name)
+	}
+	return &ftpReadFile{fsys: f, c: c, data: data}, nil
+}
+
+func (f *ftpFS) Create(name string) (io.WriteCloser, error) {
+	c, err := f.acquire()
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.transfer("STOR " + f.remotePath(name))
+	if err != nil {
+		f.release(c, err)
+		return nil, err
+	}
+	return &ftpWriteFile{fsys: f, c: c, data: data}, nil
+}
+
+func (f *ftpFS) Rename(oldName, newName string) error {
+	oldPath, newPath := f.remotePath(oldName), f.remotePath(newName)
+	rename := func(c *ftpConn) error {
+		if _, err := c.cmd(3, "RNFR "+oldPath); err != nil {
+			return ftpNotFound(err, oldName)
+		}
+		_, err := c.cmd(2, "RNTO "+newPath)
+		return err
+	}
+	return f.with(func(c *ftpConn) error {
+		err := rename(c)
+		if err == nil || !isFTPReply(err) {
+			return err
+		}
+		// 部分服务器不覆盖已有文件，删除后重试
+		if _, delErr := c.cmd(2, "DELE "+newPath); delErr != nil {
+			return err
+		}
+		return rename(c)
+	})
+}
+
+func (f *ftpFS) Remove(name string) error {
+	p := f.remotePath(name)
+	err := f.with(func(c *ftpConn) error {
+		_, err := c.cmd(2, "DELE "+p)
+		if err == nil || !isFTPReply(err) {
+			return err
+		}
+		// 目录需要用RMD删除，目录不为空时失败
+		if _, rmdErr := c.cmd(2, "RMD "+p); rmdErr == nil {
+			f.dirs.Delete(name)
+			return nil
+		}
+		return err
+	})
+	if err != nil && isFTPReply(err) {
+		// 550既可能是不存在也可能是没有权限，确认后再返回不存在
+		if _, statErr := f.Stat(name); errors.Is(statErr, fs.ErrNotExist) {
+			return statErr
+		}
+	}
+	return err
+}
+
+func (f *ftpFS) MkdirAll(name string) error {
+	if name == "." || name == "" {
+		return nil
+	}
+	if _, ok := f.dirs.Load(name); ok {
+		return nil
+	}
+	if info, err := f.Stat(name); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s 不是目录", f.remotePath(name))
+		}
+		f.dirs.Store(name, true)
+		return nil
+	}
+	if err := f.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	p := f.remotePath(name)
+	err := f.with(func(c *ftpConn) error {
+		_, err := c.cmd(2, "MKD "+p)
+		return err
+	})
+	if err != nil {
+		// 可能已被并发的请求创建
+		if info, statErr := f.Stat(name); statErr != nil || !info.IsDir() {
+			return err
+		}
+	}
+	f.dirs.Store(name, true)
+	return nil
+}
+
+func (f *ftpFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	p := f.remotePath(name)
+	var infos []fs.FileInfo
+	err := f.with(func(c *ftpConn) error {
+		command := "LIST " + p
+		if c.features["MLST"] {
+			command = "MLSD " + p
+		}
+		data, err := c.transfer(command)
+		if err != nil {
+			return ftpNotFound(err, name)
+		}
+		listing, readErr := io.ReadAll(data)
+		if err := c.finish(data); err != nil {
+			return err
+		}
+		if readErr != nil {
+			return readErr
+		}
+
+		for _, line := range strings.Split(string(listing), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if line == "" {
+				continue
+			}
+			var info *ftpFileInfo
+			if c.features["MLST"] {
+				facts, entryName, ok := strings.Cut(line, " ")
+				if !ok {
+					continue
+				}
+				info = parseMLSxEntry(facts, entryName)
+			} else {
+				info = parseListEntry(line)
+			}
+			if info != nil && info.name != "." && info.name != ".." {
+				infos = append(infos, info)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// parseMLSxEntry 解析MLST/MLSD的事实列表，如 type=file;size=123;modify=20250101120000;
+// 当前目录和上级目录返回名称 . 和 ..
+func parseMLSxEntry(facts, name string) *ftpFileInfo {
+	info := &ftpFileInfo{name: name}
+	for _, fact := range strings.Split(facts, ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			switch strings.ToLower(value) {
+			case "dir":
+				info.dir = true
+			case "cdir":
+				info.name, info.dir = ".", true
+			case "pdir":
+				info.name, info.dir = "..", true
+			}
+		case "size":
+			info.size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			info.modTime, _ = parseFTPTime(value)
+		}
+	}
+	return info
+}
+
+// parseFTPTime 解析MDTM和MLST的时间，格式为 YYYYMMDDHHMMSS[.sss]，UTC
+func parseFTPTime(value string) (time.Time, error) {
+	value, _, _ = strings.Cut(value, ".")
+	return time.Parse("20060102150405", value)
+}
+
+// parseListEntry 解析不支持MLSD的服务器的LIST输出，支持Unix（ls -l）和Windows（IIS）格式，无法识别时返回nil
+func parseListEntry(line string) *ftpFileInfo {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil
+	}
+
+	// 01-15-25  10:30AM       <DIR>          name
+	if t, err := time.Parse("01-02-06 03:04PM", fields[0]+" "+fields[1]); err == nil {
+		info := &ftpFileInfo{name: listEntryName(line, 3), modTime: t}
+		if fields[2] == "<DIR>" {
+			info.dir = true
+		} else {
+			info.size, _ = strconv.ParseInt(fields[2], 10, 64)
+		}
+		return info
+	}
+
+	// drwxr-xr-x 1 owner group 4096 Jan 15 10:30 name
+	if len(fields) < 9 || len(fields[0]) < 10 {
+		return nil
+	}
+	info := &ftpFileInfo{name: listEntryName(line, 8)}
+	switch fields[0][0] {
+	case 'd':
+		info.dir = true
+	case 'l':
+		// 符号链接按文件处理，去掉链接目标
+		info.name, _, _ = strings.Cut(info.name, " -> ")
+	case '-':
+	default:
+		return nil
+	}
+	info.size, _ = strconv.ParseInt(fields[4], 10, 64)
+	if t, err := time.Parse("Jan 2 2006", strings.Join(fields[5:8], " ")); err == nil {
+		info.modTime = t
+	} else if t, err := time.Parse("Jan 2 15:04", strings.Join(fields[5:8], " ")); err == nil {
+		// 最近半年内的文件不显示年份
+		now := time.Now().UTC()
+		info.modTime = t.AddDate(now.Year(), 0, 0)
+		if info.modTime.After(now.AddDate(0, 0, 1)) {
+			info.modTime = info.modTime.AddDate(-1, 0, 0)
+		}
+	}
+	return info
+}
+
+// listEntryName 跳过前skip个字段，返回剩余部分作为文件名，保留文件名中的空格
+func listEntryName(line string, skip int) string {
+	rest := strings.TrimLeft(line, " ")
+	for i := 0; i < skip; i++ {
+		end := strings.IndexByte(rest, ' ')
+		if end < 0 {
+			return ""
+		}
+		rest = strings.TrimLeft(rest[end:], " ")
+	}
+	return rest
+}
+
+// ftpFileInfo FTP服务器上的文件信息
+type ftpFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *ftpFileInfo) Name() string       { return i.name }
+func (i *ftpFileInfo) Size() int64        { return i.size }
+func (i *ftpFileInfo) ModTime() time.Time { return i.modTime }
+func (i *ftpFileInfo) IsDir() bool        { return i.dir }
+func (i *ftpFileInfo) Sys() interface{}   { return nil }
+
+func (i *ftpFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ftpReadFile 下载中的文件，关闭时归还连接
+type ftpReadFile struct {
+	fsys *ftpFS
+	c    *ftpConn
+	data net.Conn
+	eof  bool
+}
+
+func (r *ftpReadFile) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *ftpReadFile) Close() error {
+	err := r.c.finish(r.data)
+	if !r.eof {
+		// 未读完就关闭时服务器返回426，控制连接的状态不确定，不再复用
+		r.c.conn.Close()
+		return nil
+	}
+	r.fsys.release(r.c, err)
+	return err
+}
+
+// ftpWriteFile 上传中的文件，关闭时等待服务器确认传输完成
+type ftpWriteFile struct {
+	fsys *ftpFS
+	c    *ftpConn
+	data net.Conn
+}
+
+func (w *ftpWriteFile) Write(p []byte) (int, error) {
+	return w.data.Write(p)
+}
+
+func (w *ftpWriteFile) Close() error {
+	err := w.c.finish(w.data)
+	w.fsys.release(w.c, err)
+	return err
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// listCOSObjects 分页获取COS目录下的全部对象（包含大小和修改时间）
+func listCOSObjects(client *cos.Client, dirPath string) ([]cos.Object, error) {
+	var objects []cos.Object
+
+	prefix := ""
+	if cleanDir := strings.Trim(dirPath, "/"); cleanDir != "" {
+		prefix = cleanDir + "/"
+	}
+
+	marker := ""
+	for {
+		opt := &cos.BucketGetOptions{
+			Prefix:  prefix,
+			Marker:  marker,
+			MaxKeys: 1000,
+		}
+
+		v, _, err := client.Bucket.Get(context.Background(), opt)
+		if err != nil {
+			return nil, fmt.Errorf("获取COS文件列表失败: %v", err)
+		}
+
+		objects = append(objects, v.Contents...)
+
+		if !v.IsTruncated {
+			break
+		}
+		marker = v.NextMarker
+		if marker == "" && len(v.Contents) > 0 {
+			marker = v.Contents[len(v.Contents)-1].Key
+		}
+	}
+
+	return objects, nil
+}
+
+// listIncompleteUploads 分页获取COS目录下未完成的分块上传
+func listIncompleteUploads(client *cos.Client, dirPath string) ([]cos.ListUploadsResultUpload, error) {
+	var uploads []cos.ListUploadsResultUpload
+
+	prefix := ""
+	if cleanDir := strings.Trim(dirPath, "/"); cleanDir != "" {
+		prefix = cleanDir + "/"
+	}
+
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		opt := &cos.ObjectListUploadsOptions{
+			Prefix:         prefix,
+			MaxUploads:     1000,
+			KeyMarker:      keyMarker,
+			UploadIdMarker: uploadIDMarker,
+		}
+
+		v, _, err := client.Object.ListUploads(context.Background(), opt)
+		if err != nil {
+			return nil, fmt.Errorf("获取未完成的分块上传失败: %v", err)
+		}
+
+		uploads = append(uploads, v.Upload...)
+
+		if !v.IsTruncated {
+			break
+		}
+		keyMarker, uploadIDMarker = v.NextKeyMarker, v.NextUploadIdMarker
+	}
+
+	return uploads, nil
+}
+
+// getGCGracePeriod 获取垃圾回收的宽限期，避免误删正在进行中的上传
+func getGCGracePeriod(proj *project) time.Duration {
+	graceHours := 24 // 默认24小时
+	if graceStr := proj.Getenv("GC_GRACE_HOURS"); graceStr != "" {
+		if hours, err := strconv.Atoi(graceStr); err == nil && hours >= 0 {
+			graceHours = hours
+		} else {
+			fmt.Printf("警告: GC_GRACE_HOURS格式错误: %s，使用默认值 %d\n", graceStr, graceHours)
+		}
+	}
+	return time.Duration(graceHours) * time.Hour
+}
+
+// referencedKeys 返回远程索引中记录的对象键，分卷备份包含每一卷
+func referencedKeys(client *cos.Client, targetDir string) (map[string]bool, error) {
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool, len(index.Backups))
+	for _, entry := range index.Backups {
+		referenced[entry.Key] = true
+		for n := 1; n <= entry.Parts; n++ {
+			referenced[splitPartName(entry.Key, n)] = true
+		}
+	}
+	return referenced, nil
+}
+
+// isOlderThan 检查COS返回的时间（ISO8601格式）是否早于指定时长
+func isOlderThan(cosTime string, d time.Duration) bool {
+	parsedTime, err := time.Parse(time.RFC3339, cosTime)
+	if err != nil {
+		fmt.Printf("警告: 时间解析失败: %s, 错误: %v\n", cosTime, err)
+		return false
+	}
+	return time.Since(parsedTime) > d
+}
+
+// performGC 执行垃圾回收，查找目标目录中不属于程序备份的孤立对象
+// 孤立对象包括：中断后遗留的分块上传、远程索引中没有记录的文件（未完成或未写入索引的上传、零散文件）
+func performGC(client *cos.Client, proj *project) {
+	// 检查是否启用垃圾回收
+	if proj.Getenv("GC_ENABLED") != "true" {
+		return
+	}
+
+	fmt.Printf("\n=== 开始执行垃圾回收%s ===\n", proj.logTag())
+	targetDir := proj.TargetDir
+
+	// 回收策略：report 只报告，delete 删除
+	policy := proj.Getenv("GC_POLICY")
+	if policy == "" {
+		policy = "report"
+	}
+	if policy != "report" && policy != "delete" {
+		fmt.Printf("错误: GC_POLICY配置错误，应为report或delete，当前为: %s\n", policy)
+		return
+	}
+
+	// 删除冻结期间只报告
+	if freeze := getFreeze(client); freeze != nil && policy == "delete" {
+		fmt.Printf("删除已冻结，本次垃圾回收只报告不删除: %s\n", freeze.Reason)
+		policy = "report"
+	}
+
+	// 目标目录为存储桶根目录时，存储桶中其他程序的对象也会被当作孤立对象
+	if strings.Trim(targetDir, "/") == "" && policy == "delete" {
+		fmt.Printf("警告: 目标目录为存储桶根目录，GC_POLICY=delete 不生效，本次垃圾回收只报告不删除\n")
+		policy = "report"
+	}
+
+	grace := getGCGracePeriod(proj)
+	fmt.Printf("垃圾回收配置: 策略=%s, 宽限期=%v\n", policy, grace)
+
+	strayCount, abortedCount, removedCount := 0, 0, 0
+
+	// 查找中断后遗留的分块上传
+	uploads, err := listIncompleteUploads(client, targetDir)
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+	} else {
+		// 本地记录的大文件上传会在下次运行时继续，不能终止
+		pending := loadPendingUploadIDs()
+
+		for _, upload := range uploads {
+			if !isOlderThan(upload.Initiated, grace) || pending[upload.UploadID] {
+				continue
+			}
+			abortedCount++
+			fmt.Printf("发现未完成的分块上传: %s (UploadId: %s, 发起时间: %s)\n", upload.Key, upload.UploadID, upload.Initiated)
+
+			if policy == "delete" {
+				_, err := client.Object.AbortMultipartUpload(context.Background(), upload.Key, upload.UploadID)
+				if err != nil {
+					fmt.Printf("终止分块上传失败: %s, 错误: %v\n", upload.Key, err)
+				} else {
+					fmt.Printf("已终止分块上传: %s\n", upload.Key)
+					removedCount++
+				}
+			}
+		}
+	}
+
+	// 查找远程索引中没有记录的文件
+	referenced, err := referencedKeys(client, targetDir)
+	if err != nil {
+		fmt.Printf("错误: %v，跳过孤立文件检查\n", err)
+	}
+	if referenced != nil && len(referenced) == 0 && policy == "delete" {
+		// 索引为空（丢失或从未写入）时目标目录中的所有备份都会被当作孤立文件
+		fmt.Printf("警告: 远程索引中没有任何备份，本次只报告孤立文件，请先运行 repair 重建索引\n")
+		policy = "report"
+	}
+	objects, err := cachedListCOSObjects(client, targetDir)
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+	} else if referenced != nil {
+		dirPrefix := ""
+		if cleanDir := strings.Trim(targetDir, "/"); cleanDir != "" {
+			dirPrefix = cleanDir + "/"
+		}
+		metaKeys := make(map[string]bool)
+		for _, object := range objects {
+			if isMetaFile(strings.TrimPrefix(object.Key, dirPrefix)) {
+				metaKeys[object.Key] = true
+			}
+		}
+
+		unindexed := 0
+		for _, object := range objects {
+			// 跳过目录标记
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+
+			fileName := strings.TrimPrefix(object.Key, dirPrefix)
+			// 子目录中的文件不属于本项目
+			if isMetaFile(fileName) || strings.Contains(fileName, "/") {
+				continue
+			}
+			if referenced[object.Key] {
+				continue
+			}
+
+			if !isOlderThan(object.LastModified, grace) {
+				continue
+			}
+
+			// 有清单的备份只是没有写入索引（升级前的备份或写入索引失败），不是孤立文件
+			base := splitBaseName(fileName)
+			if _, _, isOurFormat := parseFileName(base); isOurFormat && metaKeys[getManifestKey(targetDir, base)] {
+				fmt.Printf("发现未写入索引的备份: %s，不清除\n", object.Key)
+				unindexed++
+				continue
+			}
+
+			strayCount++
+			fmt.Printf("发现孤立文件: %s (大小: %d bytes, 修改时间: %s)\n", object.Key, object.Size, object.LastModified)
+
+			if policy == "delete" {
+				if err := deleteCOSFile(client, targetDir, fileName); err != nil {
+					fmt.Printf("删除失败: %v\n", err)
+				} else {
+					removedCount++
+				}
+			}
+		}
+		if unindexed > 0 {
+			fmt.Printf("警告: %d 个备份未写入远程索引，请运行 repair 重建索引\n", unindexed)
+		}
+	}
+
+	fmt.Printf("=== 垃圾回收完成，未完成上传 %d 个，孤立文件 %d 个，已清除 %d 个 ===\n",
+		abortedCount, strayCount, removedCount)
+}
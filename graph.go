@@ -0,0 +1,257 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 备份链导出：graph命令按前缀列出目标目录中的备份，按时间连成链，标出每个备份的类型（完整备份、按月合并的归档）、
+// 保留原因（白名单、删除冻结、清理暂停）和按保留期计算的过期时间，输出Graphviz dot或Mermaid格式，
+// 用于文档和保留策略的评审。本程序的每个备份都是完整备份，没有增量链。
+
+// graphNode 备份链中的一个备份
+type graphNode struct {
+	id        string
+	fileName  string
+	timeStamp string
+	size      int64
+	monthly   bool      // 按月合并的归档
+	parts     int       // 分卷数，未分卷时为0
+	hold      string    // 不会被清理的原因，为空表示按保留期清理
+	expiresAt time.Time // 按保留期计算的过期时间，不清理时为零值
+	expired   bool      // 下次清理时删除
+}
+
+// label 返回节点显示的文字
+func (n *graphNode) label() []string {
+	kind := "完整备份"
+	if n.monthly {
+		kind = "月合并"
+	}
+	if n.parts > 0 {
+		kind = fmt.Sprintf("%s（%d 卷）", kind, n.parts)
+	}
+	lines := []string{n.timeStamp, fmt.Sprintf("%s %.1f MB", kind, float64(n.size)/1024/1024)}
+	switch {
+	case n.hold != "":
+		lines = append(lines, "保留: "+n.hold)
+	case n.expired:
+		lines = append(lines, "已过期，下次清理删除")
+	case !n.expiresAt.IsZero():
+		lines = append(lines, "过期: "+n.expiresAt.In(getBackupLocation()).Format("2006-01-02 15:04"))
+	}
+	return lines
+}
+
+// graphChains 按前缀分组的备份链，每条链按时间排序
+type graphChains struct {
+	prefixes []string
+	chains   map[string][]*graphNode
+}
+
+// buildGraphChains 列出目标目录中的备份，计算每个备份的保留状态；prefix不为空时只包含该前缀
+func buildGraphChains(client *cos.Client, proj *project, prefix string) (*graphChains, error) {
+	objects, err := cachedListCOSObjects(client, proj.TargetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与清理相同的判断：未开启清理、删除冻结和清理暂停时全部保留，白名单中的前缀保留
+	cleanupHold := ""
+	if proj.Getenv("CLEANUP_ENABLED") != "true" {
+		cleanupHold = "未开启清理"
+	} else if freeze := getFreeze(client); freeze != nil {
+		cleanupHold = "删除冻结"
+	} else if pause := getCleanupPause(client, proj.TargetDir); pause != nil {
+		cleanupHold = "清理暂停"
+	}
+	maxAge := getRetention(proj)
+	whitelist := getWhiteList(proj)
+
+	g := &graphChains{chains: make(map[string][]*graphNode)}
+	splits := make(map[string]*graphNode) // 分卷备份的名称 → 节点
+	for _, object := range objects {
+		fileName := strings.TrimPrefix(object.Key, strings.Trim(proj.TargetDir, "/")+"/")
+		if strings.HasSuffix(object.Key, "/") || isMetaFile(fileName) {
+			continue
+		}
+		p, timeStamp, ok := parseFileName(fileName)
+		if !ok || (prefix != "" && p != prefix) {
+			continue
+		}
+		// 同一备份的分卷作为一个节点，大小为各卷之和
+		base := splitBaseName(fileName)
+		if node := splits[base]; node != nil {
+			node.size += object.Size
+			node.parts++
+			continue
+		}
+		node := &graphNode{
+			fileName:  fileName,
+			timeStamp: timeStamp,
+			size:      object.Size,
+			monthly:   strings.HasSuffix(fileName, consolidatedExt),
+			hold:      cleanupHold,
+		}
+		if base != fileName {
+			node.fileName, node.parts = base, 1
+			splits[base] = node
+		}
+		if node.hold == "" && isWhitelisted(p, whitelist) {
+			node.hold = "白名单"
+		}
+		if node.hold == "" {
+			if backupTime, err := parseTimeStamp(timeStamp); err == nil {
+				node.expiresAt = backupTime.Add(maxAge)
+			}
+			node.expired = isFileOlderThan(timeStamp, maxAge)
+		}
+		if g.chains[p] == nil {
+			g.prefixes = append(g.prefixes, p)
+		}
+		g.chains[p] = append(g.chains[p], node)
+	}
+	if prefix != "" && len(g.prefixes) == 0 {
+		return nil, fmt.Errorf("目标目录 %s 中没有前缀为 %s 的备份", proj.TargetDir, prefix)
+	}
+
+	sort.Strings(g.prefixes)
+	n := 0
+	for _, p := range g.prefixes {
+		chain := g.chains[p]
+		sort.Slice(chain, func(i, j int) bool { return chain[i].timeStamp < chain[j].timeStamp })
+		for _, node := range chain {
+			n++
+			node.id = fmt.Sprintf("n%d", n)
+		}
+	}
+	return g, nil
+}
+
+// writeDot 输出Graphviz dot格式
+func (g *graphChains) writeDot(w io.Writer, title string) {
+	fmt.Fprintf(w, "digraph vcpsave {\n")
+	fmt.Fprintf(w, "  label=%q;\n  rankdir=LR;\n", title)
+	fmt.Fprintf(w, "  node [shape=box, style=\"rounded,filled\", fillcolor=white, fontname=\"sans-serif\"];\n")
+	for i, p := range g.prefixes {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%q;\n", i, p)
+		for _, node := range g.chains[p] {
+			attrs := []string{fmt.Sprintf("label=%q", strings.Join(node.label(), "\n"))}
+			if node.monthly {
+				attrs = append(attrs, "fillcolor=lightblue")
+			}
+			if node.hold != "" {
+				attrs = append(attrs, "color=darkgreen", "penwidth=2")
+			}
+			if node.expired {
+				attrs = append(attrs, "style=\"rounded,dashed\"", "fontcolor=gray50", "color=gray50")
+			}
+			fmt.Fprintf(w, "    %s [%s];\n", node.id, strings.Join(attrs, ", "))
+		}
+		chain := g.chains[p]
+		for j := 1; j < len(chain); j++ {
+			fmt.Fprintf(w, "    %s -> %s;\n", chain[j-1].id, chain[j].id)
+		}
+		fmt.Fprintf(w, "  }\n")
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// writeMermaid 输出Mermaid流程图格式，可以直接嵌入Markdown文档
+func (g *graphChains) writeMermaid(w io.Writer, title string) {
+	fmt.Fprintf(w, "---\ntitle: %s\n---\nflowchart LR\n", title)
+	var monthly, hold, expired []string
+	for i, p := range g.prefixes {
+		fmt.Fprintf(w, "  subgraph s%d[\"%s\"]\n", i, mermaidText(p))
+		chain := g.chains[p]
+		for _, node := range chain {
+			lines := node.label()
+			for k := range lines {
+				lines[k] = mermaidText(lines[k])
+			}
+			fmt.Fprintf(w, "    %s[\"%s\"]\n", node.id, strings.Join(lines, "<br/>"))
+			if node.monthly {
+				monthly = append(monthly, node.id)
+			}
+			if node.hold != "" {
+				hold = append(hold, node.id)
+			}
+			if node.expired {
+				expired = append(expired, node.id)
+			}
+		}
+		for j := 1; j < len(chain); j++ {
+			fmt.Fprintf(w, "    %s --> %s\n", chain[j-1].id, chain[j].id)
+		}
+		fmt.Fprintf(w, "  end\n")
+	}
+	fmt.Fprintf(w, "  classDef monthly fill:#dbeafe\n")
+	fmt.Fprintf(w, "  classDef hold stroke:#15803d,stroke-width:3px\n")
+	fmt.Fprintf(w, "  classDef expired stroke-dasharray:5 5,color:#888\n")
+	for _, class := range []struct {
+		name string
+		ids  []string
+	}{{"monthly", monthly}, {"hold", hold}, {"expired", expired}} {
+		if len(class.ids) > 0 {
+			fmt.Fprintf(w, "  class %s %s\n", strings.Join(class.ids, ","), class.name)
+		}
+	}
+}
+
+// mermaidText 转义Mermaid标签中的引号
+func mermaidText(s string) string {
+	return strings.ReplaceAll(s, "\"", "#quot;")
+}
+
+// runGraph 执行 graph 命令：导出项目备份链的图
+func runGraph(client *cos.Client, projects []*project, args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	projectName := fs.String("project", "", "项目名称，默认为第一个项目")
+	prefix := fs.String("prefix", "", "只导出指定前缀（源名称）的备份链，默认导出全部前缀")
+	format := fs.String("format", "dot", "输出格式：dot 或 mermaid")
+	output := fs.String("o", "", "输出文件，默认输出到标准输出")
+	fs.Parse(args)
+
+	if *format != "dot" && *format != "mermaid" {
+		return fmt.Errorf("不支持的格式: %s，可用: dot, mermaid", *format)
+	}
+	var proj *project
+	for _, p := range projects {
+		if *projectName == "" || p.Name == *projectName {
+			proj = p
+			break
+		}
+	}
+	if proj == nil {
+		return fmt.Errorf("未找到项目: %s", *projectName)
+	}
+
+	g, err := buildGraphChains(client, proj, *prefix)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("创建输出文件失败: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	title := fmt.Sprintf("%s 备份链（保留期 %v，%s）", proj.TargetDir, getRetention(proj), time.Now().In(getBackupLocation()).Format("2006-01-02 15:04"))
+	if *format == "mermaid" {
+		g.writeMermaid(w, title)
+	} else {
+		g.writeDot(w, title)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 事件管理：同一个源连续失败达到阈值时，通过值班系统（PagerDuty、Opsgenie）创建事件，
+// 该源再次备份成功时自动解决事件。连续失败次数根据本地历史计算，程序重启后不会丢失。
+
+// incidentNotifier 支持创建和解决事件的告警渠道，key 用于去重和解决同一个事件
+type incidentNotifier interface {
+	notifier
+	// Trigger 创建事件，重复触发同一个key时由值班系统合并
+	Trigger(key string, a alert) error
+	// Resolve 解决事件
+	Resolve(key string, a alert) error
+}
+
+// getIncidentThreshold 获取创建事件所需的连续失败次数，默认3
+func getIncidentThreshold(proj *project) int {
+	threshold := 3
+	if thresholdStr := proj.Getenv("INCIDENT_FAILURE_THRESHOLD"); thresholdStr != "" {
+		if n, err := strconv.Atoi(thresholdStr); err == nil && n > 0 {
+			threshold = n
+		} else {
+			fmt.Printf("警告: INCIDENT_FAILURE_THRESHOLD格式错误: %s，使用默认值 %d\n", thresholdStr, threshold)
+		}
+	}
+	return threshold
+}
+
+// incidentKey 事件的去重键，每个项目的每个源对应一个事件
+func incidentKey(proj *project, prefix string) string {
+	if proj.Name == "" {
+		return "vcpsave/" + prefix
+	}
+	return "vcpsave/" + proj.Name + "/" + prefix
+}
+
+// failureStreak 从历史记录中计算源最近的连续失败次数，跳过和取消的记录不计入。
+// 最近一次为成功时返回成功之前的连续失败次数和true
+func failureStreak(records []historyRecord, proj *project, prefix string) (int, bool, string) {
+	streak := 0
+	recovered := false
+	lastError := ""
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Project != proj.Name || r.Prefix != prefix || r.Recovered {
+			continue
+		}
+		switch r.Status {
+		case "success":
+			if streak > 0 || recovered {
+				return streak, recovered, lastError
+			}
+			recovered = true
+		case "failed":
+			if streak == 0 {
+				lastError = r.Error
+			}
+			streak++
+		}
+	}
+	return streak, recovered, lastError
+}
+
+// sourceIncidentNotifiers 返回源的告警路由上支持事件的告警渠道
+func sourceIncidentNotifiers(proj *project, source string) ([]string, []incidentNotifier) {
+	var names []string
+	var incidentNotifiers []incidentNotifier
+	activeNames, active := routedNotifiers(alertTargets(proj, source))
+	for i, n := range active {
+		if in, ok := n.(incidentNotifier); ok {
+			incidentNotifiers = append(incidentNotifiers, in)
+			names = append(names, activeNames[i])
+		}
+	}
+	return names, incidentNotifiers
+}
+
+// updateIncidents 根据本次运行的结果创建或解决事件，按ALERT_ROUTES选择每个源的值班系统
+func updateIncidents(proj *project, results []historyRecord) {
+	var records []historyRecord
+	loaded := false
+
+	threshold := getIncidentThreshold(proj)
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if seen[result.Prefix] {
+			continue
+		}
+		seen[result.Prefix] = true
+
+		names, incidentNotifiers := sourceIncidentNotifiers(proj, result.Prefix)
+		if len(incidentNotifiers) == 0 {
+			continue
+		}
+		if !loaded {
+			var err error
+			if records, err = loadHistory(); err != nil {
+				fmt.Printf("警告: %v\n", err)
+				return
+			}
+			loaded = true
+		}
+
+		streak, recovered, lastError := failureStreak(records, proj, result.Prefix)
+		if streak < threshold {
+			continue
+		}
+
+		key := incidentKey(proj, result.Prefix)
+		for i, n := range incidentNotifiers {
+			if recovered {
+				a := alert{Project: proj.Name, Source: result.Prefix, Title: "备份已恢复", Time: time.Now(),
+					Message: fmt.Sprintf("%s 在连续 %d 次失败后备份成功", result.Prefix, streak)}
+				if err := n.Resolve(key, a); err != nil {
+					fmt.Printf("警告: 通过 %s 解决事件失败: %v\n", names[i], err)
+				} else {
+					fmt.Printf("已解决事件%s: %s\n", proj.logTag(), key)
+				}
+				continue
+			}
+
+			a := alert{Project: proj.Name, Source: result.Prefix, Title: "备份连续失败", Time: time.Now(),
+				Message: fmt.Sprintf("%s 连续 %d 次备份失败，最近一次错误: %s", result.Prefix, streak, lastError)}
+			if err := n.Trigger(key, a); err != nil {
+				fmt.Printf("警告: 通过 %s 创建事件失败: %v\n", names[i], err)
+			} else {
+				fmt.Printf("已创建事件%s: %s\n", proj.logTag(), key)
+			}
+		}
+	}
+}
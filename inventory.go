@@ -0,0 +1,364 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 存储桶清单分析：inventory命令读取COS清单功能生成的清单文件（manifest.json或CSV，支持gzip压缩），
+// 按项目当前的保留期、白名单和清理安全阈值离线模拟清理，输出会被删除的备份和未来一段时间的存储量预测，
+// 不调用任何API，可以在调整保留策略之前评估影响。
+
+// defaultInventorySchema COS清单的默认字段，清单文件没有manifest.json时可以用 -schema 指定
+const defaultInventorySchema = "Bucket, Key, Size, LastModifiedDate, ETag, StorageClass"
+
+// inventoryObject 清单中的一个对象
+type inventoryObject struct {
+	Key  string
+	Size int64
+}
+
+// inventoryManifest COS清单的manifest.json
+type inventoryManifest struct {
+	FileSchema string `json:"fileSchema"`
+	Files      []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// inventoryBackup 目标目录中的一个备份
+type inventoryBackup struct {
+	fileName string
+	prefix   string
+	time     time.Time
+	size     int64
+}
+
+// readInventory 读取清单文件：manifest.json按其中的字段和文件列表读取数据文件，其他文件按CSV读取
+func readInventory(file, schema string) ([]inventoryObject, error) {
+	if strings.HasSuffix(file, ".json") {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取清单失败: %v", err)
+		}
+		var manifest inventoryManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("解析清单失败: %s: %v", file, err)
+		}
+		if schema == "" {
+			schema = manifest.FileSchema
+		}
+		var objects []inventoryObject
+		for _, f := range manifest.Files {
+			dataFile, err := findInventoryDataFile(filepath.Dir(file), f.Key)
+			if err != nil {
+				return nil, err
+			}
+			fileObjects, err := readInventoryCSV(dataFile, schema)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, fileObjects...)
+		}
+		return objects, nil
+	}
+	return readInventoryCSV(file, schema)
+}
+
+// findInventoryDataFile 在manifest.json所在目录中查找数据文件：按清单中的对象键、data子目录或文件名查找
+func findInventoryDataFile(dir, key string) (string, error) {
+	candidates := []string{
+		filepath.Join(dir, filepath.FromSlash(key)),
+		filepath.Join(dir, "data", path.Base(key)),
+		filepath.Join(dir, path.Base(key)),
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("未找到清单数据文件: %s（请下载到manifest.json所在目录）", key)
+}
+
+// readInventoryCSV 读取一个CSV清单文件，第一行是字段名时按字段名读取，否则按schema读取
+func readInventoryCSV(file, schema string) ([]inventoryObject, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取清单失败: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("解压清单失败: %s: %v", file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if schema == "" {
+		schema = defaultInventorySchema
+	}
+	keyCol, sizeCol := inventoryColumns(strings.Split(schema, ","))
+
+	var objects []inventoryObject
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析清单失败: %s: %v", file, err)
+		}
+		if line == 1 {
+			if k, s := inventoryColumns(record); k >= 0 && s >= 0 {
+				keyCol, sizeCol = k, s
+				continue
+			}
+		}
+		if keyCol < 0 || sizeCol < 0 || keyCol >= len(record) || sizeCol >= len(record) {
+			return nil, fmt.Errorf("清单字段中缺少Key或Size: %s 第 %d 行", file, line)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(record[sizeCol]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("清单中的大小格式错误: %s 第 %d 行: %s", file, line, record[sizeCol])
+		}
+		// 清单中的对象键经过URL编码
+		key := record[keyCol]
+		if decoded, err := url.PathUnescape(key); err == nil {
+			key = decoded
+		}
+		objects = append(objects, inventoryObject{Key: key, Size: size})
+	}
+	return objects, nil
+}
+
+// inventoryColumns 返回Key和Size字段的位置，不存在时为-1
+func inventoryColumns(fields []string) (int, int) {
+	keyCol, sizeCol := -1, -1
+	for i, field := range fields {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "key":
+			keyCol = i
+		case "size":
+			sizeCol = i
+		}
+	}
+	return keyCol, sizeCol
+}
+
+// inventoryBackups 从清单中选出项目目标目录中的备份
+func inventoryBackups(proj *project, objects []inventoryObject) []inventoryBackup {
+	dirPrefix := ""
+	if cleanDir := strings.Trim(proj.TargetDir, "/"); cleanDir != "" {
+		dirPrefix = cleanDir + "/"
+	}
+	var backups []inventoryBackup
+	splits := make(map[string]int) // 分卷备份的名称 → 在backups中的位置
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, dirPrefix) || strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		fileName := strings.TrimPrefix(object.Key, dirPrefix)
+		if isMetaFile(fileName) {
+			continue
+		}
+		prefix, timeStamp, ok := parseFileName(fileName)
+		if !ok {
+			continue
+		}
+		backupTime, err := parseTimeStamp(timeStamp)
+		if err != nil {
+			continue
+		}
+		// 同一备份的分卷作为一个备份，大小为各卷之和
+		if base := splitBaseName(fileName); base != fileName {
+			if i, ok := splits[base]; ok {
+				backups[i].size += object.Size
+				continue
+			}
+			splits[base] = len(backups)
+			fileName = base
+		}
+		backups = append(backups, inventoryBackup{fileName: fileName, prefix: prefix, time: backupTime, size: object.Size})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.Before(backups[j].time) })
+	return backups
+}
+
+// inventoryProjection 按前缀的备份间隔和近期大小推算之后的备份
+type inventoryProjection struct {
+	prefix   string
+	next     time.Time
+	interval time.Duration
+	size     int64
+}
+
+// projectNewBackups 为仍在定期备份的前缀推算备份间隔和大小：间隔为历史备份的平均间隔，
+// 大小为最近7个备份的平均值；只有一个备份或最近一次备份早于两个间隔之前的前缀不推算
+func projectNewBackups(backups []inventoryBackup, now time.Time) []inventoryProjection {
+	byPrefix := make(map[string][]inventoryBackup)
+	for _, b := range backups {
+		byPrefix[b.prefix] = append(byPrefix[b.prefix], b)
+	}
+	var projections []inventoryProjection
+	for _, prefix := range sortedKeys(byPrefix) {
+		list := byPrefix[prefix]
+		if len(list) < 2 {
+			continue
+		}
+		last := list[len(list)-1]
+		interval := last.time.Sub(list[0].time) / time.Duration(len(list)-1)
+		if interval <= 0 || now.Sub(last.time) > 2*interval {
+			continue
+		}
+		recent := list[max(0, len(list)-7):]
+		var total int64
+		for _, b := range recent {
+			total += b.size
+		}
+		next := last.time.Add(interval)
+		for next.Before(now) {
+			next = next.Add(interval)
+		}
+		projections = append(projections, inventoryProjection{prefix: prefix, next: next, interval: interval, size: total / int64(len(recent))})
+	}
+	return projections
+}
+
+// runInventory 执行 inventory 命令：按项目的保留策略离线模拟清理和存储量变化
+func runInventory(projects []*project, args []string) error {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	projectName := fs.String("project", "", "项目名称，默认为第一个项目")
+	schema := fs.String("schema", "", "CSV清单的字段（逗号分隔），默认使用manifest.json中的fileSchema或 "+defaultInventorySchema)
+	at := fs.String("at", "", "模拟清理的时间（2006-01-02 或 2006-01-02 15:04），默认为当前时间")
+	days := fs.Int("days", 90, "存储量预测的天数")
+	step := fs.Int("step", 7, "存储量预测每行间隔的天数")
+	verbose := fs.Bool("v", false, "列出每个会被删除的备份")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("用法: vcpsave inventory [-project 名称] [-at 时间] [-days 90] <manifest.json 或 清单.csv[.gz]>...")
+	}
+	if *days < 0 || *step < 1 {
+		return fmt.Errorf("-days 不能为负数，-step 至少为1")
+	}
+	var proj *project
+	for _, p := range projects {
+		if *projectName == "" || p.Name == *projectName {
+			proj = p
+			break
+		}
+	}
+	if proj == nil {
+		return fmt.Errorf("未找到项目: %s", *projectName)
+	}
+
+	now := time.Now()
+	if *at != "" {
+		var err error
+		if now, err = time.ParseInLocation("2006-01-02 15:04", *at, getBackupLocation()); err != nil {
+			if now, err = time.ParseInLocation("2006-01-02", *at, getBackupLocation()); err != nil {
+				return fmt.Errorf("-at 格式错误: %s", *at)
+			}
+		}
+	}
+
+	var objects []inventoryObject
+	for _, file := range fs.Args() {
+		fileObjects, err := readInventory(file, *schema)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileObjects...)
+	}
+	backups := inventoryBackups(proj, objects)
+
+	maxAge := getRetention(proj)
+	whitelist := getWhiteList(proj)
+	enabled := proj.Getenv("CLEANUP_ENABLED") == "true"
+	fmt.Printf("\n=== 清单分析%s ===\n", proj.logTag())
+	fmt.Printf("清单对象: %d 个，目标目录 %s 中的备份: %d 个\n", len(objects), proj.TargetDir, len(backups))
+	fmt.Printf("模拟时间: %s，保留期: %v，白名单: %v\n", now.In(getBackupLocation()).Format("2006-01-02 15:04"), maxAge, whitelist)
+	if !enabled {
+		fmt.Printf("未开启清理（CLEANUP_ENABLED），按当前配置不会删除任何备份\n")
+	}
+
+	// 按当前配置清理会删除的备份
+	var expired []string
+	expiredSize := make(map[string]int64)
+	expiredCount := make(map[string]int)
+	totals := make(map[string]int)
+	totalSize := make(map[string]int64)
+	for _, b := range backups {
+		totals[b.prefix]++
+		totalSize[b.prefix] += b.size
+		if enabled && !isWhitelisted(b.prefix, whitelist) && now.Sub(b.time) > maxAge {
+			expired = append(expired, b.fileName)
+			expiredCount[b.prefix]++
+			expiredSize[b.prefix] += b.size
+			if *verbose {
+				fmt.Printf("  将删除: %s (%.1f MB)\n", b.fileName, float64(b.size)/1024/1024)
+			}
+		}
+	}
+
+	fmt.Printf("\n%-30s %12s %14s %14s\n", "前缀", "删除/总数", "删除 (MB)", "保留 (MB)")
+	var deletedBytes, keptBytes int64
+	for _, prefix := range sortedKeys(totals) {
+		fmt.Printf("%-30s %12s %14.1f %14.1f\n", prefix, fmt.Sprintf("%d/%d", expiredCount[prefix], totals[prefix]),
+			float64(expiredSize[prefix])/1024/1024, float64(totalSize[prefix]-expiredSize[prefix])/1024/1024)
+		deletedBytes += expiredSize[prefix]
+		keptBytes += totalSize[prefix] - expiredSize[prefix]
+	}
+	fmt.Printf("合计: 删除 %d 个备份 (%.1f MB)，保留 %.1f MB\n", len(expired), float64(deletedBytes)/1024/1024, float64(keptBytes)/1024/1024)
+	if err := checkDeleteThreshold(proj, expired, totals); err != nil {
+		fmt.Printf("注意: 本次清理会被安全阈值跳过: %v\n", err)
+	}
+
+	// 存储量预测：现有备份按保留期过期，仍在定期备份的前缀按历史间隔和大小继续产生新备份
+	projections := projectNewBackups(backups, now)
+	fmt.Printf("\n存储量预测（%d 个前缀按历史间隔继续备份）:\n", len(projections))
+	fmt.Printf("%-12s %8s %14s %14s\n", "日期", "备份数", "总大小 (MB)", "其中新增 (MB)")
+	for day := 0; day <= *days; day += *step {
+		t := now.AddDate(0, 0, day)
+		retained := func(prefix string, backupTime time.Time) bool {
+			return !enabled || isWhitelisted(prefix, whitelist) || t.Sub(backupTime) <= maxAge
+		}
+		count := 0
+		var size, added int64
+		for _, b := range backups {
+			if retained(b.prefix, b.time) {
+				count++
+				size += b.size
+			}
+		}
+		for _, p := range projections {
+			for next := p.next; !next.After(t); next = next.Add(p.interval) {
+				if retained(p.prefix, next) {
+					count++
+					size += p.size
+					added += p.size
+				}
+			}
+		}
+		fmt.Printf("%-12s %8d %14.1f %14.1f\n", t.In(getBackupLocation()).Format("2006-01-02"), count, float64(size)/1024/1024, float64(added)/1024/1024)
+	}
+	return nil
+}
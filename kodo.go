@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// 七牛云Kodo：Kodo提供S3兼容接口（SigV4签名），与阿里云OSS相同直接复用s3Transport，
+// 备份、列出、清理逻辑与COS、S3相同。
+
+// kodoRegionAliases 七牛控制台和旧版SDK中的区域代码对应的S3接口地域
+var kodoRegionAliases = map[string]string{
+	"z0":  "cn-east-1",
+	"z1":  "cn-north-1",
+	"z2":  "cn-south-1",
+	"na0": "us-north-1",
+	"as0": "ap-southeast-1",
+}
+
+// getKodoConfig 读取七牛云Kodo的配置，转换为S3兼容存储的连接配置
+func getKodoConfig(getenv func(string) string) (*s3Config, error) {
+	region := getenv("KODO_REGION")
+	if region == "" {
+		return nil, fmt.Errorf("Kodo地域未配置，请设置KODO_REGION，例如 cn-east-1（华东-浙江）")
+	}
+	if alias, ok := kodoRegionAliases[region]; ok {
+		region = alias
+	}
+
+	endpoint := getenv("KODO_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.qiniucs.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("KODO_ENDPOINT格式错误: %s", endpoint)
+	}
+
+	cfg := &s3Config{
+		endpoint:      u,
+		region:        region,
+		bucket:        getenv("KODO_BUCKET"),
+		accessKey:     getenv("KODO_ACCESS_KEY"),
+		secretKey:     getenv("KODO_SECRET_KEY"),
+		virtualHosted: true,
+	}
+	if cfg.bucket == "" {
+		return nil, fmt.Errorf("存储空间名称未配置，请设置KODO_BUCKET")
+	}
+	if cfg.accessKey == "" || cfg.secretKey == "" {
+		return nil, fmt.Errorf("Kodo密钥未配置，请设置KODO_ACCESS_KEY和KODO_SECRET_KEY")
+	}
+	return cfg, nil
+}
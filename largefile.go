@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 单个超大文件（虚拟机镜像、数据库导出等）直接从源文件分块上传，不产生中间副本。
+// 上传进度保存在本地数据目录，程序重启后继续未完成的分块上传。
+
+// maxUploadParts COS分块上传的最大分块数
+const maxUploadParts = 10000
+
+// largeUploadState 未完成的大文件分块上传状态
+type largeUploadState struct {
+	Source   string `json:"source"`
+	Size     int64  `json:"size"`
+	ModTime  string `json:"mod_time"`
+	Key      string `json:"key"`
+	UploadID string `json:"upload_id"`
+	PartSize int64  `json:"part_size"`
+
+	// 已上传的分块数和字节数，每个分块完成后更新，用于查看长时间上传的进度
+	PartsDone int    `json:"parts_done,omitempty"`
+	BytesDone int64  `json:"bytes_done,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// largeUploadOptions 分块上传的可选限制，初始备份模式使用
+type largeUploadOptions struct {
+	partSize int64        // 分块大小，0表示按LARGE_FILE_PART_SIZE_MB
+	window   *timeWindow  // 只在时间窗口内上传，窗口结束时暂停
+	limiter  *rateLimiter // 上传限速
+}
+
+// getLargeFileThreshold 获取按大文件分块上传的阈值
+func getLargeFileThreshold() int64 {
+	thresholdMB := 1024 // 默认1GB
+	if thresholdStr := os.Getenv("LARGE_FILE_THRESHOLD_MB"); thresholdStr != "" {
+		if threshold, err := strconv.Atoi(thresholdStr); err == nil && threshold >= 1 {
+			thresholdMB = threshold
+		} else {
+			fmt.Printf("警告: LARGE_FILE_THRESHOLD_MB格式错误: %s，使用默认值 %d\n", thresholdStr, thresholdMB)
+		}
+	}
+	return int64(thresholdMB) * 1024 * 1024
+}
+
+// getLargeFilePartSize 获取分块大小，超过最大分块数时自动增大
+func getLargeFilePartSize(fileSize int64) int64 {
+	partSizeMB := 64 // 默认64MB
+	if sizeStr := os.Getenv("LARGE_FILE_PART_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size >= 1 {
+			partSizeMB = size
+		} else {
+			fmt.Printf("警告: LARGE_FILE_PART_SIZE_MB格式错误: %s，使用默认值 %d\n", sizeStr, partSizeMB)
+		}
+	}
+	return fitPartSize(int64(partSizeMB)*1024*1024, fileSize)
+}
+
+// fitPartSize 分块数超过上限时增大分块
+func fitPartSize(partSize, fileSize int64) int64 {
+	if minSize := (fileSize + maxUploadParts - 1) / maxUploadParts; partSize < minSize {
+		partSize = minSize
+	}
+	return partSize
+}
+
+// getUploadPartRetries 获取单个分块的重试次数
+func getUploadPartRetries() int {
+	retries := 3
+	if retriesStr := os.Getenv("UPLOAD_PART_RETRIES"); retriesStr != "" {
+		if n, err := strconv.Atoi(retriesStr); err == nil && n >= 0 {
+			retries = n
+		} else {
+			fmt.Printf("警告: UPLOAD_PART_RETRIES格式错误: %s，使用默认值 %d\n", retriesStr, retries)
+		}
+	}
+	return retries
+}
+
+// getUploadStateDir 获取分块上传状态目录
+func getUploadStateDir() string {
+	return filepath.Join(getDataDir(), "uploads")
+}
+
+// getUploadStatePath 获取源文件对应的上传状态文件路径
+func getUploadStatePath(sourcePath string) string {
+	absPath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		absPath = sourcePath
+	}
+	sum := sha1.Sum([]byte(absPath))
+	return filepath.Join(getUploadStateDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+// loadUploadState 读取上传状态，不存在时返回nil
+func loadUploadState(path string) (*largeUploadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	state := &largeUploadState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveUploadState 保存上传状态
+func saveUploadState(path string, state *largeUploadState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadPendingUploadIDs 获取本地记录的未完成分块上传，垃圾回收时不应终止它们
+func loadPendingUploadIDs() map[string]bool {
+	ids := make(map[string]bool)
+
+	entries, err := os.ReadDir(getUploadStateDir())
+	if err != nil {
+		return ids
+	}
+	for _, entry := range entries {
+		state, err := loadUploadState(filepath.Join(getUploadStateDir(), entry.Name()))
+		if err == nil && state != nil {
+			ids[state.UploadID] = true
+		}
+	}
+	return ids
+}
+
+// listUploadedParts 获取分块上传中已上传的分块
+func listUploadedParts(client *cos.Client, key, uploadID string) (map[int]cos.Object, error) {
+	parts := make(map[int]cos.Object)
+
+	marker := ""
+	for {
+		v, _, err := client.Object.ListParts(context.Background(), key, uploadID, &cos.ObjectListPartsOptions{
+			MaxParts:         "1000",
+			PartNumberMarker: marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range v.Parts {
+			parts[part.PartNumber] = part
+		}
+		if !v.IsTruncated {
+			break
+		}
+		marker = v.NextPartNumberMarker
+	}
+	return parts, nil
+}
+
+// uploadLargeFile 从源文件直接分块上传，每个分块失败后单独重试，中断后可在下次运行时继续，ctx取消时同样保留上传状态
+func uploadLargeFile(ctx context.Context, client *cos.Client, cosPath, sourcePath string, metadata map[string]string) (*backupResult, error) {
+	return uploadLargeFileWith(ctx, client, cosPath, sourcePath, metadata, &largeUploadOptions{})
+}
+
+// uploadLargeFileWith 按opts的分块大小、时间窗口和限速分块上传。
+// 时间窗口结束时保留上传状态并返回errUploadPaused，下次运行时继续
+func uploadLargeFileWith(ctx context.Context, client *cos.Client, cosPath, sourcePath string, metadata map[string]string, opts *largeUploadOptions) (*backupResult, error) {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件信息失败: %v", err)
+	}
+
+	statePath := getUploadStatePath(sourcePath)
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		fmt.Printf("警告: 读取上传状态失败: %v，将重新上传\n", err)
+		state = nil
+	}
+
+	// 源文件在上次中断后被修改过，之前上传的分块不能再使用
+	modTime := info.ModTime().Format(time.RFC3339Nano)
+	if state != nil && (state.Size != info.Size() || state.ModTime != modTime) {
+		fmt.Printf("源文件已变化，放弃未完成的上传: %s\n", state.Key)
+		client.Object.AbortMultipartUpload(context.Background(), state.Key, state.UploadID)
+		state = nil
+	}
+
+	uploaded := make(map[int]cos.Object)
+	if state != nil {
+		uploaded, err = listUploadedParts(client, state.Key, state.UploadID)
+		if err != nil {
+			fmt.Printf("警告: 获取已上传分块失败: %v，将重新上传\n", err)
+			state = nil
+			uploaded = make(map[int]cos.Object)
+		} else {
+			fmt.Printf("继续未完成的上传: %s (已上传 %d 个分块)\n", state.Key, len(uploaded))
+		}
+	}
+
+	if state == nil {
+		v, _, err := client.Object.InitiateMultipartUpload(context.Background(), cosPath, &cos.InitiateMultipartUploadOptions{
+			ACLHeaderOptions: uploadACLHeader(),
+			ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+				XCosMetaXXX: metadataHeader(metadata),
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("初始化分块上传失败: %v", err)
+		}
+
+		state = &largeUploadState{
+			Source:   sourcePath,
+			Size:     info.Size(),
+			ModTime:  modTime,
+			Key:      cosPath,
+			UploadID: v.UploadID,
+			PartSize: getLargeFilePartSize(info.Size()),
+		}
+		if opts.partSize > 0 {
+			state.PartSize = fitPartSize(opts.partSize, info.Size())
+		}
+		if err := saveUploadState(statePath, state); err != nil {
+			fmt.Printf("警告: 保存上传状态失败: %v，中断后将无法继续\n", err)
+		}
+	}
+
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer file.Close()
+
+	totalParts := int((state.Size + state.PartSize - 1) / state.PartSize)
+	if totalParts == 0 {
+		totalParts = 1
+	}
+	fmt.Printf("开始分块上传大文件: %s -> %s (%d bytes, %d 个分块)\n", sourcePath, state.Key, state.Size, totalParts)
+
+	// 续传时已上传的分块计入进度
+	var resumedParts int
+	var resumedBytes int64
+	for _, part := range uploaded {
+		resumedParts++
+		resumedBytes += part.Size
+	}
+	state.BytesDone = resumedBytes
+	uploads.start(state.Key, totalParts, state.Size, resumedParts, resumedBytes)
+	defer uploads.finish(state.Key)
+
+	retries := getUploadPartRetries()
+	for partNumber := 1; partNumber <= totalParts; partNumber++ {
+		offset := int64(partNumber-1) * state.PartSize
+		partSize := state.PartSize
+		if offset+partSize > state.Size {
+			partSize = state.Size - offset
+		}
+
+		// 已上传且大小一致的分块直接跳过
+		if part, ok := uploaded[partNumber]; ok && part.Size == partSize {
+			continue
+		}
+		if opts.window != nil && !opts.window.contains(time.Now()) {
+			fmt.Printf("已到上传时间窗口结束，暂停上传: %s (已上传 %d/%d 个分块)\n", state.Key, len(uploaded), totalParts)
+			return nil, errUploadPaused
+		}
+
+		var lastErr error
+		for attempt := 0; attempt <= retries; attempt++ {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("上传已取消: %v", ctx.Err())
+			}
+			if attempt > 0 {
+				wait := time.Duration(attempt*attempt) * time.Second
+				fmt.Printf("分块 %d 上传失败: %v，%v 后第 %d 次重试\n", partNumber, lastErr, wait, attempt)
+				time.Sleep(wait)
+			}
+
+			var body io.Reader = io.NewSectionReader(file, offset, partSize)
+			if opts.limiter != nil {
+				body = opts.limiter.reader(ctx, body)
+			}
+			resp, err := client.Object.UploadPart(ctx, state.Key, state.UploadID, partNumber,
+				body, &cos.ObjectUploadPartOptions{ContentLength: partSize})
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			uploaded[partNumber] = cos.Object{PartNumber: partNumber, ETag: resp.Header.Get("ETag"), Size: partSize}
+			lastErr = nil
+			break
+		}
+		if lastErr != nil {
+			// 保留上传状态，下次运行时从此分块继续
+			return nil, fmt.Errorf("上传分块 %d 失败: %v", partNumber, lastErr)
+		}
+
+		uploads.partDone(state.Key, partSize)
+		fmt.Printf("分块 %d/%d 上传成功\n", partNumber, totalParts)
+
+		state.PartsDone = len(uploaded)
+		state.BytesDone += partSize
+		state.UpdatedAt = time.Now().Format(time.RFC3339)
+		if err := saveUploadState(statePath, state); err != nil {
+			fmt.Printf("警告: 保存上传进度失败: %v\n", err)
+		}
+	}
+
+	opt := &cos.CompleteMultipartUploadOptions{}
+	for partNumber := 1; partNumber <= totalParts; partNumber++ {
+		part := uploaded[partNumber]
+		opt.Parts = append(opt.Parts, cos.Object{PartNumber: partNumber, ETag: part.ETag})
+	}
+	sort.Sort(cos.ObjectList(opt.Parts))
+
+	if _, _, err := client.Object.CompleteMultipartUpload(context.Background(), state.Key, state.UploadID, opt); err != nil {
+		return nil, fmt.Errorf("完成分块上传失败: %v", err)
+	}
+
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("警告: 删除上传状态失败: %v\n", err)
+	}
+
+	fmt.Printf("大文件上传成功: %s\n", state.Key)
+	return &backupResult{Key: state.Key, Size: state.Size, KeyID: metadata["vcpsave-key-id"]}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 同一周期内清理和垃圾回收都要列出整个目标目录，大目录分页列出的请求数很多。
+// 周期开始时启用列表缓存，第一次列出后复用结果，周期结束时丢弃；
+// 本周期的备份上传后使对应目录的缓存失效，删除的对象从缓存中移除。
+
+// listCache 一个周期内的目录列表缓存
+type listCache struct {
+	mu         sync.Mutex
+	enabled    bool
+	objects    map[string][]cos.Object // 目录前缀 -> 对象列表
+	generation map[string]int          // 每次失效加1，列出期间发生失效时不缓存结果
+}
+
+var cycleListCache = &listCache{}
+
+// listCachePrefix 返回目录对应的列表前缀，与listCOSObjects一致
+func listCachePrefix(dirPath string) string {
+	if cleanDir := strings.Trim(dirPath, "/"); cleanDir != "" {
+		return cleanDir + "/"
+	}
+	return ""
+}
+
+// beginListCache 开始一个周期，清空并启用列表缓存
+func beginListCache() {
+	c := cycleListCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = true
+	c.objects = make(map[string][]cos.Object)
+	c.generation = make(map[string]int)
+}
+
+// endListCache 结束周期，丢弃缓存，之后的列出都直接请求COS
+func endListCache() {
+	c := cycleListCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = false
+	c.objects = nil
+	c.generation = nil
+}
+
+// get 返回缓存的列表和当前版本，未启用或未缓存时ok为false
+func (c *listCache) get(dirPath string) (objects []cos.Object, generation int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return nil, 0, false
+	}
+	prefix := listCachePrefix(dirPath)
+	objects, ok = c.objects[prefix]
+	return objects, c.generation[prefix], ok
+}
+
+// put 缓存列出结果，列出期间目录已失效时丢弃
+func (c *listCache) put(dirPath string, generation int, objects []cos.Object) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := listCachePrefix(dirPath)
+	if !c.enabled || c.generation[prefix] != generation {
+		return
+	}
+	c.objects[prefix] = objects
+}
+
+// invalidateListCache 目录中上传了新对象，丢弃该目录的缓存
+func invalidateListCache(dirPath string) {
+	c := cycleListCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return
+	}
+	prefix := listCachePrefix(dirPath)
+	delete(c.objects, prefix)
+	c.generation[prefix]++
+}
+
+// forgetListedObject 对象已删除，从所有包含它的缓存列表中移除
+func forgetListedObject(key string) {
+	c := cycleListCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return
+	}
+	for prefix, objects := range c.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		kept := make([]cos.Object, 0, len(objects))
+		for _, object := range objects {
+			if object.Key != key {
+				kept = append(kept, object)
+			}
+		}
+		c.objects[prefix] = kept
+	}
+}
+
+// cachedListCOSObjects 列出目录，本周期已列出过且未失效时使用缓存
+func cachedListCOSObjects(client *cos.Client, dirPath string) ([]cos.Object, error) {
+	objects, generation, ok := cycleListCache.get(dirPath)
+	if ok {
+		fmt.Printf("使用本周期的列表缓存: %s (%d 个对象)\n", dirPath, len(objects))
+		return objects, nil
+	}
+
+	objects, err := listCOSObjects(client, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	cycleListCache.put(dirPath, generation, objects)
+	return objects, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 高负载时暂停压缩：配置了LOAD_PAUSE_*阈值后在后台定期读取系统负载和压力（Linux的PSI），超过任一阈值时
+// 归档和压缩在写出数据前暂停，负载降到阈值的80%以下后继续，避免备份与生产业务争抢CPU和磁盘。
+// 暂停只作用于归档输出，外部压缩程序（pigz、zstd）因读不到输入而一同暂停。
+
+// loadResumeRatio 负载降到阈值的该比例以下时才继续，避免在阈值附近反复暂停
+const loadResumeRatio = 0.8
+
+// systemLoad 一次采样的系统负载，不支持的指标为-1
+type systemLoad struct {
+	LoadPerCPU  float64 // 1分钟平均负载除以CPU数
+	CPUPressure float64 // 最近10秒有任务等待CPU的时间比例（%）
+	IOPressure  float64 // 最近10秒有任务等待IO的时间比例（%）
+}
+
+// String 返回用于日志的负载摘要
+func (l *systemLoad) String() string {
+	s := fmt.Sprintf("每CPU负载 %.2f", l.LoadPerCPU)
+	if l.CPUPressure >= 0 {
+		s += fmt.Sprintf(", CPU压力 %.1f%%", l.CPUPressure)
+	}
+	if l.IOPressure >= 0 {
+		s += fmt.Sprintf(", IO压力 %.1f%%", l.IOPressure)
+	}
+	return s
+}
+
+// loadThresholds 暂停压缩的负载阈值，为0的阈值不检查
+type loadThresholds struct {
+	LoadPerCPU  float64
+	CPUPressure float64
+	IOPressure  float64
+}
+
+// exceeds 检查负载是否超过阈值按ratio缩放后的值
+func (t loadThresholds) exceeds(l *systemLoad, ratio float64) bool {
+	return (t.LoadPerCPU > 0 && l.LoadPerCPU > t.LoadPerCPU*ratio) ||
+		(t.CPUPressure > 0 && l.CPUPressure > t.CPUPressure*ratio) ||
+		(t.IOPressure > 0 && l.IOPressure > t.IOPressure*ratio)
+}
+
+// getLoadThresholds 读取 LOAD_PAUSE_LOAD（每CPU负载）、LOAD_PAUSE_CPU_PRESSURE、LOAD_PAUSE_IO_PRESSURE（%），
+// 都未配置时返回nil
+func getLoadThresholds() *loadThresholds {
+	parse := func(key string) float64 {
+		valueStr := os.Getenv(key)
+		if valueStr == "" {
+			return 0
+		}
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil || value <= 0 {
+			fmt.Printf("警告: %s格式错误: %s，不检查该项\n", key, valueStr)
+			return 0
+		}
+		return value
+	}
+	t := loadThresholds{
+		LoadPerCPU:  parse("LOAD_PAUSE_LOAD"),
+		CPUPressure: parse("LOAD_PAUSE_CPU_PRESSURE"),
+		IOPressure:  parse("LOAD_PAUSE_IO_PRESSURE"),
+	}
+	if t.LoadPerCPU == 0 && t.CPUPressure == 0 && t.IOPressure == 0 {
+		return nil
+	}
+	return &t
+}
+
+// getLoadCheckInterval 获取负载的采样间隔，默认5秒
+func getLoadCheckInterval() time.Duration {
+	interval := 5 * time.Second
+	if intervalStr := os.Getenv("LOAD_CHECK_INTERVAL"); intervalStr != "" {
+		if d, err := time.ParseDuration(intervalStr); err == nil && d > 0 {
+			interval = d
+		} else {
+			fmt.Printf("警告: LOAD_CHECK_INTERVAL格式错误: %s，使用默认值 %v\n", intervalStr, interval)
+		}
+	}
+	return interval
+}
+
+// getLoadPauseMax 获取每个归档累计暂停的最长时间，超过后不再暂停，避免备份一直无法完成，默认1小时
+func getLoadPauseMax() time.Duration {
+	maxPause := time.Hour
+	if maxStr := os.Getenv("LOAD_PAUSE_MAX"); maxStr != "" {
+		if d, err := time.ParseDuration(maxStr); err == nil && d > 0 {
+			maxPause = d
+		} else {
+			fmt.Printf("警告: LOAD_PAUSE_MAX格式错误: %s，使用默认值 %v\n", maxStr, maxPause)
+		}
+	}
+	return maxPause
+}
+
+// loadMonitor 定期采样系统负载，记录当前是否应暂停压缩
+type loadMonitor struct {
+	thresholds loadThresholds
+	interval   time.Duration
+	maxPause   time.Duration
+
+	mu      sync.Mutex
+	high    bool
+	load    *systemLoad
+	resumed chan struct{} // 负载降低时关闭
+}
+
+// loadPause 配置了负载阈值时的负载监控，未配置或系统不支持时为nil
+var loadPause *loadMonitor
+
+// initLoadMonitor 根据配置启动负载监控
+func initLoadMonitor() {
+	thresholds := getLoadThresholds()
+	if thresholds == nil {
+		return
+	}
+	load, err := readSystemLoad()
+	if err != nil {
+		fmt.Printf("警告: 读取系统负载失败，不在高负载时暂停压缩: %v\n", err)
+		return
+	}
+	if load == nil {
+		fmt.Printf("警告: 当前系统不支持读取负载，忽略LOAD_PAUSE_*配置\n")
+		return
+	}
+	if load.CPUPressure < 0 && load.IOPressure < 0 && (thresholds.CPUPressure > 0 || thresholds.IOPressure > 0) {
+		fmt.Printf("警告: 系统不支持压力指标（PSI），LOAD_PAUSE_CPU_PRESSURE和LOAD_PAUSE_IO_PRESSURE不生效\n")
+	}
+
+	loadPause = &loadMonitor{
+		thresholds: *thresholds,
+		interval:   getLoadCheckInterval(),
+		maxPause:   getLoadPauseMax(),
+		resumed:    make(chan struct{}),
+	}
+	close(loadPause.resumed)
+	loadPause.update(load)
+	fmt.Printf("已启用高负载暂停压缩: 采样间隔 %v，当前%s\n", loadPause.interval, load)
+	go loadPause.run()
+}
+
+// run 定期采样负载
+func (m *loadMonitor) run() {
+	for {
+		time.Sleep(m.interval)
+		load, err := readSystemLoad()
+		if err != nil {
+			fmt.Printf("警告: 读取系统负载失败: %v\n", err)
+			continue
+		}
+		m.update(load)
+	}
+}
+
+// update 根据新的采样更新暂停状态：超过阈值时暂停，降到阈值的80%以下时继续
+func (m *loadMonitor) update(load *systemLoad) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load = load
+	switch {
+	case !m.high && m.thresholds.exceeds(load, 1):
+		m.high = true
+		m.resumed = make(chan struct{})
+	case m.high && !m.thresholds.exceeds(load, loadResumeRatio):
+		m.high = false
+		close(m.resumed)
+	}
+}
+
+// state 返回当前是否处于高负载、最近的采样和负载降低时关闭的通道
+func (m *loadMonitor) state() (bool, *systemLoad, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.high, m.load, m.resumed
+}
+
+// loadThrottled 配置了负载监控时包装w，高负载期间暂停写入
+func loadThrottled(ctx context.Context, w io.Writer) io.Writer {
+	if loadPause == nil {
+		return w
+	}
+	return &loadWriter{ctx: ctx, w: w, monitor: loadPause}
+}
+
+// loadWriter 高负载期间暂停写入的写入器，每个归档累计暂停超过LOAD_PAUSE_MAX后不再暂停
+type loadWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	monitor *loadMonitor
+	paused  time.Duration
+}
+
+func (l *loadWriter) Write(p []byte) (int, error) {
+	if err := l.wait(); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
+
+// wait 高负载时等待负载降低、ctx取消或累计暂停时间用完
+func (l *loadWriter) wait() error {
+	if l.paused >= l.monitor.maxPause {
+		return nil
+	}
+	high, load, resumed := l.monitor.state()
+	if !high {
+		return nil
+	}
+
+	fmt.Printf("系统负载过高（%s），暂停压缩\n", load)
+	start := time.Now()
+	timer := time.NewTimer(l.monitor.maxPause - l.paused)
+	defer timer.Stop()
+	select {
+	case <-resumed:
+		l.paused += time.Since(start)
+		_, load, _ = l.monitor.state()
+		fmt.Printf("系统负载已降低（%s），继续压缩，已暂停 %v\n", load, time.Since(start).Round(time.Second))
+	case <-timer.C:
+		l.paused = l.monitor.maxPause
+		fmt.Printf("警告: 累计暂停已达到 %v，负载仍然过高，继续压缩\n", l.monitor.maxPause)
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	}
+	return nil
+}
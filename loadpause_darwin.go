@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// readSystemLoad 通过sysctl读取平均负载（输出如"{ 1.52 1.71 1.80 }"），macOS没有压力指标
+func readSystemLoad() (*systemLoad, error) {
+	out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+	if err != nil {
+		return nil, fmt.Errorf("执行sysctl失败: %v", err)
+	}
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(string(out)), "{}"))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("vm.loadavg格式错误: %s", out)
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("vm.loadavg格式错误: %v", err)
+	}
+	return &systemLoad{LoadPerCPU: load1 / float64(runtime.NumCPU()), CPUPressure: -1, IOPressure: -1}, nil
+}
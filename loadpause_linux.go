@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// readSystemLoad 读取/proc/loadavg和PSI（/proc/pressure，内核4.20以上），不支持PSI时压力为-1
+func readSystemLoad() (*systemLoad, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("/proc/loadavg格式错误")
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("/proc/loadavg格式错误: %v", err)
+	}
+	return &systemLoad{
+		LoadPerCPU:  load1 / float64(runtime.NumCPU()),
+		CPUPressure: readPressure("/proc/pressure/cpu"),
+		IOPressure:  readPressure("/proc/pressure/io"),
+	}, nil
+}
+
+// readPressure 读取PSI文件中 some 行的 avg10，无法读取时返回-1
+func readPressure(path string) float64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		if value, ok := strings.CutPrefix(fields[1], "avg10="); ok {
+			if pressure, err := strconv.ParseFloat(value, 64); err == nil {
+				return pressure
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// readSystemLoad 其他系统不读取负载
+func readSystemLoad() (*systemLoad, error) {
+	return nil, nil
+}
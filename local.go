@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// 本地目录或NAS：不能访问云存储的机器（STORAGE_PROVIDER=local）把备份写入本地目录或已挂载的NAS共享目录，
+// COS_TARGET_DIR 为该目录下的子目录。
+
+// getLocalStoragePath 读取本地存储目录
+// 目录必须已经存在，避免NAS未挂载时把备份写到挂载点下的本地磁盘
+func getLocalStoragePath(getenv func(string) string) (string, error) {
+	root := getenv("LOCAL_STORAGE_PATH")
+	if root == "" {
+		return "", fmt.Errorf("本地存储目录未配置，请设置LOCAL_STORAGE_PATH，例如 /mnt/nas/backup 或 D:\\backup")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("LOCAL_STORAGE_PATH格式错误: %v", err)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", fmt.Errorf("本地存储目录不可用: %v，使用NAS时请确认已挂载", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("LOCAL_STORAGE_PATH不是目录: %s", root)
+	}
+	return root, nil
+}
+
+// localFS 本地目录
+type localFS struct {
+	root string
+}
+
+func (f *localFS) path(name string) string {
+	return filepath.Join(f.root, filepath.FromSlash(name))
+}
+
+func (f *localFS) String() string {
+	return f.root
+}
+
+func (f *localFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(f.path(name))
+}
+
+func (f *localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(f.path(name))
+}
+
+func (f *localFS) Create(name string) (io.WriteCloser, error) {
+	file, err := os.Create(f.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &syncFile{file}, nil
+}
+
+func (f *localFS) Rename(oldName, newName string) error {
+	return os.Rename(f.path(oldName), f.path(newName))
+}
+
+func (f *localFS) Remove(name string) error {
+	return os.Remove(f.path(name))
+}
+
+func (f *localFS) MkdirAll(name string) error {
+	return os.MkdirAll(f.path(name), 0755)
+}
+
+func (f *localFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(f.path(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// syncFile 关闭前确认数据落盘，NAS上写入成功才返回
+type syncFile struct {
+	*os.File
+}
+
+func (f *syncFile) Close() error {
+	if err := f.File.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
@@ -1,67 +1,205 @@
 package main
 
 import (
-	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // Windows等没有时区数据库的系统也能使用BACKUP_TIMEZONE
 
-	"github.com/joho/godotenv"
 	"github.com/tencentyun/cos-go-sdk-v5"
+
+	"vcpsave/pkg/retention"
+	"vcpsave/pkg/storage"
 )
 
-// initCOSClient 初始化COS客户端
+// initCOSClient 初始化主存储的客户端
 func initCOSClient() (*cos.Client, error) {
-	// 加载.env文件
-	err := godotenv.Load()
-	if err != nil {
-		fmt.Printf("警告: 无法加载.env文件: %v\n", err)
-		fmt.Println("将使用环境变量中的配置")
+	provider := getStorageProvider()
+
+	// 配置了多个地域的存储桶时选择延迟最低的一个
+	if candidates := parseKeyValueList(os.Getenv("COS_BUCKET_CANDIDATES")); provider == "cos" && len(candidates) > 0 {
+		secretId := os.Getenv("TENCENTCLOUD_SECRET_ID")
+		secretKey := os.Getenv("TENCENTCLOUD_SECRET_KEY")
+		if secretId == "" || secretKey == "" {
+			return nil, fmt.Errorf("腾讯云密钥未配置，请在配置文件或环境变量中设置TENCENTCLOUD_SECRET_ID和TENCENTCLOUD_SECRET_KEY")
+		}
+		selection, err := selectLowestLatencyBucket(candidates, secretId, secretKey)
+		if err != nil {
+			return nil, err
+		}
+		applyBucketSelection(selection)
+	}
+	return newStorageClient(provider, os.Getenv)
+}
+
+// newStorageClient 按配置创建存储客户端，getenv读取配置，副本存储使用带前缀的配置
+func newStorageClient(provider string, getenv func(string) string) (*cos.Client, error) {
+	// S3兼容存储（AWS S3、MinIO等）、阿里云OSS、七牛云Kodo、华为云OBS、vcpsave中继、本地目录、SFTP和FTP服务器、WebDAV网盘、rclone支持的存储
+	switch provider {
+	case "cos":
+	case "s3":
+		cfg, err := getS3Config(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("使用S3兼容存储: %s, 存储桶: %s, 地域: %s\n", cfg.endpoint.Host, cfg.bucket, cfg.region)
+		return newS3Client(cfg), nil
+	case "oss":
+		cfg, err := getOSSConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("使用阿里云OSS: %s, 存储桶: %s\n", cfg.endpoint.Host, cfg.bucket)
+		return newS3Client(cfg), nil
+	case "kodo":
+		cfg, err := getKodoConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("使用七牛云Kodo: %s, 存储空间: %s\n", cfg.endpoint.Host, cfg.bucket)
+		return newS3Client(cfg), nil
+	case "obs":
+		cfg, err := getOBSConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("使用华为云OBS: %s, 桶: %s\n", cfg.endpoint.Host, cfg.bucket)
+		return newS3Client(cfg), nil
+	case "relay":
+		relayURL, token, err := getRelayConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("通过中继访问存储桶: %s\n", relayURL.Host)
+		return newRelayClient(relayURL, token), nil
+	case "local":
+		root, err := getLocalStoragePath(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("使用本地存储目录: %s\n", root)
+		client := newFSClient(&localFS{root: root})
+		if check := getMountCheck(getenv, root); check != nil {
+			if err := check.run(); err != nil {
+				return nil, err
+			}
+			registerMountCheck(client, check)
+		}
+		return client, nil
+	case "sftp":
+		cfg, err := getSFTPConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fsys := &sftpFS{cfg: cfg}
+		if _, err := fsys.Stat("."); err != nil {
+			return nil, fmt.Errorf("SFTP目录不可用: %v", err)
+		}
+		fmt.Printf("使用SFTP服务器: %s\n", fsys)
+		return newFSClient(fsys), nil
+	case "ftp":
+		cfg, err := getFTPConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fsys, err := newFTPFS(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := fsys.Stat("."); err != nil {
+			return nil, fmt.Errorf("FTP目录不可用: %v", err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("FTP_PATH不是目录: %s", fsys)
+		}
+		fmt.Printf("使用FTP服务器: %s\n", fsys)
+		return newFSClient(fsys), nil
+	case "webdav":
+		cfg, err := getWebDAVConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fsys := newWebDAVFS(cfg)
+		if info, err := fsys.Stat("."); err != nil {
+			return nil, fmt.Errorf("WebDAV目录不可用: %v", err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("WEBDAV_URL不是目录: %s", fsys)
+		}
+		fmt.Printf("使用WebDAV: %s\n", fsys)
+		return newFSClient(fsys), nil
+	case "rclone":
+		cfg, err := getRcloneConfig(getenv)
+		if err != nil {
+			return nil, err
+		}
+		fsys := &rcloneFS{cfg: cfg}
+		if err := fsys.MkdirAll(fsInternalDir); err != nil {
+			return nil, fmt.Errorf("rclone存储不可用: %v", err)
+		}
+		fmt.Printf("使用rclone: %s\n", fsys)
+		return newFSClient(fsys), nil
+	default:
+		// 通过 vcpsave/pkg/storage 注册的存储
+		if factory, ok := storage.Lookup(provider); ok {
+			store, err := factory(getenv)
+			if err != nil {
+				return nil, fmt.Errorf("初始化存储 %s 失败: %v", provider, err)
+			}
+			fmt.Printf("使用注册的存储: %s\n", provider)
+			return newBackendClient(provider, store), nil
+		}
+		providers := append([]string{"cos", "s3", "oss", "kodo", "obs", "relay", "local", "sftp", "ftp", "webdav", "rclone"}, storage.Names()...)
+		return nil, fmt.Errorf("STORAGE_PROVIDER配置错误，应为%s，当前为: %s", strings.Join(providers, "、"), provider)
 	}
 
 	// 从环境变量中获取腾讯云密钥
-	secretId := os.Getenv("TENCENTCLOUD_SECRET_ID")
-	secretKey := os.Getenv("TENCENTCLOUD_SECRET_KEY")
+	secretId := getenv("TENCENTCLOUD_SECRET_ID")
+	secretKey := getenv("TENCENTCLOUD_SECRET_KEY")
 
 	if secretId == "" || secretKey == "" {
-		return nil, fmt.Errorf("腾讯云密钥未配置，请在.env文件或环境变量中设置TENCENTCLOUD_SECRET_ID和TENCENTCLOUD_SECRET_KEY")
+		return nil, fmt.Errorf("腾讯云密钥未配置，请在配置文件或环境变量中设置TENCENTCLOUD_SECRET_ID和TENCENTCLOUD_SECRET_KEY")
 	}
 
 	// 从环境变量中获取存储桶名称和地域
-	bucketName := os.Getenv("COS_BUCKET_NAME")
+	bucketName := getenv("COS_BUCKET_NAME")
 	if bucketName == "" {
-		return nil, fmt.Errorf("存储桶名称未配置，请在.env文件中设置COS_BUCKET_NAME")
+		return nil, fmt.Errorf("存储桶名称未配置，请在配置文件中设置COS_BUCKET_NAME")
 	}
 
-	region := os.Getenv("COS_REGION")
+	region := getenv("COS_REGION")
 	if region == "" {
-		return nil, fmt.Errorf("地域未配置，请在.env文件中设置COS_REGION")
+		return nil, fmt.Errorf("地域未配置，请在配置文件中设置COS_REGION")
 	}
 
 	fmt.Printf("使用存储桶: %s, 地域: %s\n", bucketName, region)
 
+	return newCOSClient(bucketName, region, secretId, secretKey), nil
+}
+
+// newCOSClient 创建访问指定存储桶的COS客户端
+func newCOSClient(bucketName, region, secretId, secretKey string) *cos.Client {
 	// CI 任务需要提供 CIURL
 	bu, _ := url.Parse(fmt.Sprintf("https://%s.cos.%s.myqcloud.com", bucketName, region))
 	cu, _ := url.Parse(fmt.Sprintf("https://%s.ci.%s.myqcloud.com", bucketName, region))
 	b := &cos.BaseURL{BucketURL: bu, CIURL: cu}
 
-	// 创建客户端
+	// 创建客户端，自定义请求头在签名前添加
 	client := cos.NewClient(b, &http.Client{
-		Transport: &cos.AuthorizationTransport{
+		Transport: &meteredTransport{transport: withRequestHeaders(&cos.AuthorizationTransport{
 			SecretID:  secretId,
 			SecretKey: secretKey,
-		},
+		})},
 	})
+	client.UserAgent = userAgent()
 
-	return client, nil
+	return client
 }
 
 // ensureCOSDirectory 确保COS目录存在，不存在则创建
@@ -104,23 +242,49 @@ func ensureCOSDirectory(client *cos.Client, dirPath string) error {
 	return fmt.Errorf("检查目录失败: %v", err)
 }
 
-// generateFileName 根据路径生成带时间戳的文件名
-func generateFileName(sourcePath string, isDir bool) string {
-	now := time.Now()
-	timeStamp := now.Format("20060102_150405")
+// timeStampLayout 文件名中时间戳的格式
+const timeStampLayout = retention.TimeStampLayout
+
+var (
+	backupLocation     *time.Location
+	backupLocationOnce sync.Once
+)
 
-	// 获取文件或文件夹名称
-	fileName := filepath.Base(sourcePath)
+// getBackupLocation 获取文件名时间戳和保留期计算使用的时区，由BACKUP_TIMEZONE指定，默认为系统时区。
+// 显式配置时区后，在CST主机上创建的备份不会因为清理运行在UTC容器中而被算错年龄
+func getBackupLocation() *time.Location {
+	backupLocationOnce.Do(func() {
+		backupLocation = time.Local
+		if name := os.Getenv("BACKUP_TIMEZONE"); name != "" {
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				fmt.Printf("警告: BACKUP_TIMEZONE无效: %s，使用系统时区: %v\n", name, err)
+				return
+			}
+			backupLocation = loc
+		}
+	})
+	return backupLocation
+}
 
-	if isDir {
-		// 文件夹压缩为ZIP
-		return fmt.Sprintf("%s_%s.zip", fileName, timeStamp)
-	} else {
-		// 文件保持原格式，添加时间戳
-		ext := filepath.Ext(fileName)
-		nameWithoutExt := fileName[:len(fileName)-len(ext)]
-		return fmt.Sprintf("%s_%s%s", nameWithoutExt, timeStamp, ext)
+// parseTimeStamp 按备份时区解析文件名中的时间戳
+func parseTimeStamp(timeStamp string) (time.Time, error) {
+	return retention.ParseTimeStamp(timeStamp, getBackupLocation())
+}
+
+// generateFileName 根据路径生成带时间戳的文件名，ext为流水线输出的扩展名
+func generateFileName(sourcePath string, isDir bool, ext string) string {
+	now := time.Now().In(getBackupLocation())
+	timeStamp := now.Format(timeStampLayout)
+
+	// 获取文件或文件夹名称，开启文件名清理时替换存储服务不接受的字符
+	fileName := sanitizeObjectName(sourceName(sourcePath))
+
+	if !isDir {
+		// 文件的原扩展名已包含在ext中
+		fileName = fileName[:len(fileName)-len(filepath.Ext(fileName))]
 	}
+	return fmt.Sprintf("%s_%s%s", fileName, timeStamp, ext)
 }
 
 // parseSourcePaths 解析SOURCEFOLDER环境变量，支持多个路径
@@ -149,71 +313,6 @@ func isDirectory(path string) (bool, error) {
 	return info.IsDir(), nil
 }
 
-// zipFolder 将文件夹压缩为ZIP文件
-func zipFolder(source, target string) error {
-	// 创建目标ZIP文件
-	zipFile, err := os.Create(target)
-	if err != nil {
-		return fmt.Errorf("创建ZIP文件失败: %v", err)
-	}
-	defer zipFile.Close()
-
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	// 遍历源文件夹
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// 计算相对路径
-		relPath, err := filepath.Rel(source, path)
-		if err != nil {
-			return fmt.Errorf("计算相对路径失败: %v", err)
-		}
-
-		// 跳过根目录本身
-		if relPath == "." {
-			return nil
-		}
-
-		// 创建ZIP文件头
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return fmt.Errorf("创建文件头失败: %v", err)
-		}
-
-		// 设置ZIP文件头中的路径
-		header.Name = relPath
-		if info.IsDir() {
-			header.Name += "/"
-		}
-
-		// 创建文件写入器
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return fmt.Errorf("创建ZIP写入器失败: %v", err)
-		}
-
-		// 如果是文件，复制文件内容
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return fmt.Errorf("打开文件失败: %v", err)
-			}
-			defer file.Close()
-
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return fmt.Errorf("复制文件内容失败: %v", err)
-			}
-		}
-
-		return nil
-	})
-}
-
 // getNextCleanupTime 计算下次清理时间
 func getNextCleanupTime() (time.Time, error) {
 	cleanupTime := os.Getenv("CLEANUP_TIME")
@@ -232,8 +331,11 @@ func getNextCleanupTime() (time.Time, error) {
 	if err1 != nil || err2 != nil {
 		return time.Time{}, fmt.Errorf("CLEANUP_TIME解析失败: %v, %v", err1, err2)
 	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return time.Time{}, fmt.Errorf("CLEANUP_TIME超出范围，应为00:00到23:59，当前为: %s", cleanupTime)
+	}
 
-	now := time.Now()
+	now := time.Now().In(getBackupLocation())
 
 	// 构造今天的清理时间
 	cleanupToday := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
@@ -247,8 +349,8 @@ func getNextCleanupTime() (time.Time, error) {
 }
 
 // getWhiteList 获取白名单前缀
-func getWhiteList() []string {
-	whitelistStr := os.Getenv("CLEANUP_WHITELIST")
+func getWhiteList(proj *project) []string {
+	whitelistStr := proj.Getenv("CLEANUP_WHITELIST")
 	if whitelistStr == "" {
 		return []string{}
 	}
@@ -264,25 +366,33 @@ func getWhiteList() []string {
 	return result
 }
 
+// parseKeyValueList 解析逗号分隔的 键:值 列表，例如 VCPToolBox:app,Documents:hr
+func parseKeyValueList(value string) map[string]string {
+	result := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if key != "" && val != "" {
+			result[key] = val
+		}
+	}
+	return result
+}
+
 // parseFileName 解析文件名，提取前缀和时间戳
 func parseFileName(fileName string) (prefix string, timeStamp string, isOurFormat bool) {
 	// 匹配我们的文件格式：前缀_YYYYMMDD_HHMMSS.扩展名
 	// 例如：test1_20251021_095449.txt 或 VCPToolBox_20251021_095449.zip
-	re := regexp.MustCompile(`^(.+?)_(\d{8}_\d{6})\..+$`)
-	matches := re.FindStringSubmatch(fileName)
-
-	if len(matches) == 3 {
-		return matches[1], matches[2], true
-	}
-
-	// 如果不匹配我们的格式，返回空值
-	return "", "", false
+	return retention.ParseFileName(fileName)
 }
 
-// isFileOlderThanDays 检查文件是否超过指定天数
-func isFileOlderThanDays(timeStamp string, maxDays int) bool {
-	// 解析时间戳：YYYYMMDD_HHMMSS (本地时间)
-	parsedTime, err := time.ParseInLocation("20060102_150405", timeStamp, time.Local)
+// isFileOlderThan 检查文件是否超过指定保留期
+func isFileOlderThan(timeStamp string, maxAge time.Duration) bool {
+	// 解析时间戳：YYYYMMDD_HHMMSS (备份时区)
+	parsedTime, err := parseTimeStamp(timeStamp)
 	if err != nil {
 		fmt.Printf("警告: 时间戳解析失败: %s, 错误: %v\n", timeStamp, err)
 		return false
@@ -291,14 +401,14 @@ func isFileOlderThanDays(timeStamp string, maxDays int) bool {
 	// 计算文件年龄
 	now := time.Now()
 	age := now.Sub(parsedTime)
-	older := age.Hours() > float64(maxDays*24)
+	older := age > maxAge
 
 	// 调试信息
-	if maxDays == 0 {
-		fmt.Printf("调试: 文件时间 %s, 当前时间 %s, 年龄 %.1f 小时, 超过 %d 天: %v\n",
+	if isDebugEnabled() {
+		fmt.Printf("调试: 文件时间 %s, 当前时间 %s, 年龄 %.1f 小时, 超过 %v: %v\n",
 			parsedTime.Format("2006-01-02 15:04:05"),
 			now.Format("2006-01-02 15:04:05"),
-			age.Hours(), maxDays, older)
+			age.Hours(), maxAge, older)
 	}
 
 	return older
@@ -306,29 +416,20 @@ func isFileOlderThanDays(timeStamp string, maxDays int) bool {
 
 // isWhitelisted 检查文件前缀是否在白名单中
 func isWhitelisted(prefix string, whitelist []string) bool {
-	for _, allowedPrefix := range whitelist {
-		if prefix == allowedPrefix {
-			return true
-		}
-	}
-	return false
+	return retention.Policy{Whitelist: whitelist}.Whitelisted(prefix)
 }
 
 // listCOSFiles 获取COS目录中的文件列表
 func listCOSFiles(client *cos.Client, dirPath string) ([]string, error) {
 	var fileNames []string
 
-	opt := &cos.BucketGetOptions{
-		Prefix:  strings.Trim(dirPath, "/") + "/",
-		MaxKeys: 1000,
-	}
-
-	v, _, err := client.Bucket.Get(context.Background(), opt)
+	// 分页获取，目录下可能有上万个文件；同一周期内复用列表缓存
+	objects, err := cachedListCOSObjects(client, dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("获取COS文件列表失败: %v", err)
+		return nil, err
 	}
 
-	for _, content := range v.Contents {
+	for _, content := range objects {
 		// 跳过目录标记（以/结尾的）
 		if !strings.HasSuffix(content.Key, "/") {
 			// 移除目录前缀，只保留文件名
@@ -353,209 +454,947 @@ func deleteCOSFile(client *cos.Client, dirPath, fileName string) error {
 	if err != nil {
 		return fmt.Errorf("删除COS文件失败: %s, 错误: %v", cosPath, err)
 	}
+	forgetListedObject(cosPath)
 
 	fmt.Printf("已删除文件: %s\n", cosPath)
 	return nil
 }
 
-// performBackup 执行备份操作
-func performBackup(client *cos.Client, targetDir string) {
-	fmt.Printf("\n=== 开始执行备份 ===\n")
+// backupResult 单个路径的备份结果
+type backupResult struct {
+	Key    string // 上传后的COS路径
+	Size   int64  // 对象大小
+	KeyID  string // 加密使用的密钥ID，未加密时为空
+	SHA256 string // 流式上传时计算的SHA-256
+
+	SourceFiles   int               // 归档的源文件数
+	SourceBytes   int64             // 归档前源文件的总大小
+	FileChecksums map[string]string // 每个源文件的SHA-256，未启用MANIFEST_CHECKSUMS时为nil
+	RenamedFiles  map[string]string // 文件名清理后改名的条目 -> 原路径
+	Replicas      []replicaResult   // 复制到每个副本存储的结果，未配置副本时为nil
+	Sensitive     []string          // 可能包含密钥的文件，未开启SENSITIVE_SCAN时为nil
+	PerFile       bool              // 逐个上传的源，Key为文件所在的目录
+	Parts         int               // 分卷数，Key不带卷号，未分卷时为0
+}
+
+// backupSource 备份单个路径，ctx取消时中止归档和上传
+func backupSource(ctx context.Context, client *cos.Client, proj *project, sourcePath string) (*backupResult, error) {
+	// 路径模板中的日期、主机名变量在运行时展开
+	readPath := expandSourcePath(proj, sourcePath)
+	if readPath != sourcePath {
+		fmt.Printf("路径模板: %s -> %s\n", sourcePath, readPath)
+	}
+
+	// 检查路径是否存在
+	if _, err := os.Stat(readPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("路径不存在: %s", readPath)
+	}
+
+	// 检查是文件还是目录
+	isDir, err := isDirectory(readPath)
+	if err != nil {
+		return nil, fmt.Errorf("检查路径类型失败: %v", err)
+	}
+
+	// 逐个上传文件，不经过流水线
+	if isFileUploadSource(proj, sourcePath) {
+		if !isDir {
+			return nil, fmt.Errorf("逐个上传只支持目录源: %s", sourcePath)
+		}
+		return uploadSourceFiles(ctx, client, proj, sourcePath, readPath)
+	}
+
+	// 构建流水线：归档 → 压缩 → 加密 → 暂存
+	p, err := buildPipeline(proj, sourcePath, isDir)
+	if err != nil {
+		return nil, err
+	}
+	if conditions := p.options.String(); conditions != "" && isDir {
+		fmt.Printf("只归档%s的文件\n", conditions)
+	}
+	if isDir && getNestedSourcePolicy(proj) == "exclude" {
+		p.options.nested = nestedSources(proj, sourcePath)
+	}
+	if isManifestChecksumsEnabled(proj) || isRansomwareDetectionEnabled(proj) {
+		p.options.checksums = make(map[string]string)
+	}
+	p.options.sanitizer = getNameSanitizer()
+	p.options.decoder = newNameDecoder(getLegacyEncoding())
+
+	// 从卷影副本读取，避免被占用的文件读取失败；创建失败时直接读取源路径
+	livePath := readPath
+	if isVSSEnabled(proj) {
+		snapshotPath, release, err := snapshotSource(readPath)
+		if err != nil {
+			fmt.Printf("警告: %v，直接读取源路径\n", err)
+		} else {
+			defer release()
+			readPath = snapshotPath
+		}
+	}
+
+	// 归档前输出文件数和大小，意外挂载了空目录等问题可以立即在日志中发现
+	sourceFiles, sourceEntries, sourceBytes := p.options.previewSource(readPath)
+	fmt.Printf("源数据: %d 个文件, %.1f MB (%d bytes)\n", sourceFiles, float64(sourceBytes)/1024/1024, sourceBytes)
+	if isDir && sourceFiles == 0 {
+		fmt.Printf("警告: 源目录中没有需要归档的文件: %s\n", sourcePath)
+	}
+	p.checkZip64(proj, sourceEntries, sourceBytes)
+
+	cosFileName := generateFileName(sourcePath, isDir, p.Ext(sourcePath))
+	cosPath := cosObjectKey(proj.TargetDir, cosFileName)
 
+	// 使用了zstd字典时先上传字典，字典不可用时备份无法恢复，不上传备份
+	if err := uploadZstdDict(client, proj, sourceName(sourcePath), p.Metadata()); err != nil {
+		return nil, err
+	}
+
+	result, err := uploadPipeline(ctx, client, proj, p, sourcePath, readPath, cosFileName, cosPath)
+	if err != nil {
+		return nil, err
+	}
+	// 流式上传和超大文件分块上传没有完整的本地文件，从主存储复制到副本
+	if result.Replicas == nil {
+		result.Replicas = replicateBackup(ctx, client, proj, result.Key, "", p.Metadata())
+	}
+	result.SourceFiles, result.SourceBytes = sourceFiles, sourceBytes
+	result.FileChecksums = p.options.checksums
+	result.RenamedFiles = p.options.renamedMembers()
+	if isSensitiveScanEnabled(proj) {
+		result.Sensitive = scanSensitiveFiles(proj, sourcePath, p.options.archived)
+		reportSensitiveFiles(sourcePath, result.Sensitive, result.KeyID != "" || p.options.password != "")
+	}
+	checkRansomware(client, proj, sourcePath, readPath, p.options.checksums)
+
+	// 归档后删除或移走已上传的源文件，删除前校验上传结果
+	if mode := getOffloadMode(proj, sourcePath); mode != "" {
+		if failed := replicaFailures(result.Replicas); failed > 0 && isReplicaRequired(proj) {
+			fmt.Printf("警告: %d 个副本存储复制失败，保留源文件: %s\n", failed, sourcePath)
+		} else if !isDir {
+			fmt.Printf("警告: 归档后删除只支持目录源，已忽略: %s\n", sourcePath)
+		} else if result.Parts > 0 {
+			fmt.Printf("警告: 分卷备份不支持归档后删除，保留源文件: %s\n", sourcePath)
+		} else {
+			offloadArchivedFiles(client, proj, mode, result, livePath, p.options.archived)
+		}
+	}
+	return result, nil
+}
+
+// uploadPipeline 运行流水线并上传到cosPath：流式上传、超大文件分块上传，或先写入暂存文件再上传
+func uploadPipeline(ctx context.Context, client *cos.Client, proj *project, p *pipeline, sourcePath, readPath, cosFileName, cosPath string) (*backupResult, error) {
+	// 各阶段需要记录的信息（如加密密钥ID）写入对象元数据
+	metadata := p.Metadata()
+
+	// 初始备份模式：归档保存在本地，按时间窗口和限速分多次上传
+	if needsSeed(proj, sourcePath) {
+		warnStoragePluginsSkipped(proj, sourcePath)
+		return seedUpload(ctx, client, proj, p, sourcePath, readPath, cosFileName, cosPath)
+	}
+
+	// 分卷上传：源数据可能超过一卷时按卷切分，逐卷暂存和上传
+	if partSize := getArchiveSplitSize(proj); partSize > 0 && estimateStagingSize(readPath) > partSize {
+		return uploadSplitArchive(ctx, client, proj, p, sourcePath, readPath, cosFileName, cosPath, partSize)
+	}
+
+	// 按源数据大小预留暂存空间，超过上限或磁盘剩余空间不足的源改为流式上传
+	area := getStagingArea()
+	streaming := isStreamUploadEnabled() && !p.isPassthrough()
+	var stagingSize int64
+	if !p.isPassthrough() && !streaming {
+		stagingSize = estimateStagingSize(readPath)
+		if !area.fits(stagingSize) {
+			fmt.Printf("源数据超过暂存空间上限，改为流式上传: %s\n", sourcePath)
+			streaming = true
+		} else if !area.hasFreeSpace(stagingSize) {
+			fmt.Printf("暂存目录所在磁盘的剩余空间不足（需要 %d MB），改为流式上传: %s\n", stagingSize/1024/1024, sourcePath)
+			streaming = true
+		}
+	}
+
+	// 流式上传：归档直接写入分块上传，不产生本地临时文件
+	if streaming {
+		warnStoragePluginsSkipped(proj, sourcePath)
+		fmt.Printf("开始流式上传: %s -> %s (流水线: %s)\n", sourcePath, cosPath, p)
+		return streamPipelineToCOS(ctx, client, cosPath, p, readPath, metadata)
+	}
+
+	result := &backupResult{KeyID: metadata["vcpsave-key-id"]}
+	localFilePath := readPath
+	if p.isPassthrough() {
+		// 超大文件：直接从源文件分块上传，支持中断后继续
+		if info, err := os.Stat(readPath); err == nil && info.Size() > getLargeFileThreshold() {
+			warnStoragePluginsSkipped(proj, sourcePath)
+			return uploadLargeFile(ctx, client, cosPath, readPath, metadata)
+		}
+
+		// 文件：直接上传
+		fmt.Printf("直接上传文件: %s\n", sourcePath)
+	} else {
+		stagingPath, release, err := area.reserve(ctx, cosFileName, stagingSize)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		localFilePath = stagingPath
+
+		fmt.Printf("开始处理: %s -> %s (流水线: %s)\n", sourcePath, localFilePath, p)
+		err = p.runToFile(ctx, readPath, localFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("处理失败: %v", err)
+		}
+		fmt.Printf("处理完成: %s\n", localFilePath)
+		if info, err := os.Stat(localFilePath); err == nil {
+			resourceMeterFrom(ctx).addTempDisk(info.Size())
+		}
+	}
+
+	// 记录上传内容的校验和，写入清单并用于上传后的链式校验
+	local, err := fileChecksum(localFilePath)
+	if err != nil {
+		return nil, err
+	}
+	result.SHA256 = local.SHA256()
+
+	opt := &cos.ObjectPutOptions{
+		ACLHeaderOptions: uploadACLHeader(),
+		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+			XCosMetaXXX: metadataHeader(metadata),
+		},
+	}
+
+	// 上传文件
+	fmt.Printf("开始上传文件: %s -> %s\n", localFilePath, cosPath)
+	_, err = client.Object.PutFromFile(ctx, cosPath, localFilePath, opt)
+	if err != nil {
+		return nil, fmt.Errorf("上传文件失败: %v", err)
+	}
+	result.Key = cosPath
+
+	// 校验通过后才复制到插件、副本并删除暂存文件
+	fmt.Printf("文件上传成功: %s\n", cosPath)
+	if result.Size, err = verifyUploadChain(client, cosPath, local); err != nil {
+		// 删除校验失败的对象，避免被当作有效备份；暂存文件改名保留，便于排查和重新上传
+		if _, delErr := client.Object.Delete(context.Background(), cosPath); delErr != nil {
+			fmt.Printf("警告: 删除校验失败的对象失败: %v\n", delErr)
+		}
+		if localFilePath != readPath {
+			if renameErr := os.Rename(localFilePath, localFilePath+".failed"); renameErr == nil {
+				fmt.Printf("已保留暂存文件: %s.failed\n", localFilePath)
+			}
+		}
+		return nil, fmt.Errorf("上传校验失败: %v", err)
+	}
+	fmt.Printf("文件验证成功，大小: %d bytes\n", result.Size)
+
+	copyToStoragePlugins(proj, cosPath, localFilePath)
+	result.Replicas = replicateBackup(ctx, client, proj, cosPath, localFilePath, metadata)
+	return result, nil
+}
+
+// removeTempFile 删除临时文件，文件不存在时忽略
+func removeTempFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("警告: 删除临时文件失败: %s, 错误: %v\n", path, err)
+	}
+}
+
+// getProjectSources 获取项目配置的所有源路径
+func getProjectSources(proj *project) []string {
 	// 本地文件/文件夹路径配置
-	sourceFolders := os.Getenv("SOURCEFOLDER")
+	sourceFolders := proj.Getenv("SOURCEFOLDER")
 	if sourceFolders == "" {
-		fmt.Printf("警告: SOURCEFOLDER未配置")
-		return
+		fmt.Printf("警告: SOURCEFOLDER未配置\n")
+		return nil
 	}
 
 	// 解析多个路径
-	sourcePaths := parseSourcePaths(sourceFolders)
+	return parseSourcePaths(sourceFolders)
+}
+
+// dailySources 获取每日定时备份的源，配置了备份间隔的源由各自的定时任务备份
+func dailySources(proj *project) []string {
+	var sourcePaths []string
+	for _, sourcePath := range getProjectSources(proj) {
+		if getSourceInterval(proj, sourcePath) == 0 {
+			sourcePaths = append(sourcePaths, sourcePath)
+		}
+	}
+	return sourcePaths
+}
+
+// performBackup 执行每日定时备份
+func performBackup(client *cos.Client, proj *project) {
+	sourcePaths := dailySources(proj)
+	if len(sourcePaths) == 0 {
+		return
+	}
+	performBackupSources(client, proj, sourcePaths, newRunState(proj, sourcePaths))
+}
+
+// performBackupSources 备份指定的源，同一个源上一次备份仍在进行时跳过，全部成功或跳过时返回true。
+// state不为nil时在处理每个源之前保存进度，程序中断后可以继续
+func performBackupSources(client *cos.Client, proj *project, sourcePaths []string, state *runState) bool {
+	fmt.Printf("\n=== 开始执行备份%s ===\n", proj.logTag())
+	targetDir := proj.TargetDir
+
+	// 主存储桶持续不可用时，本次运行的备份、索引和报告都写入备用存储桶
+	client, target := uploadTarget(client)
+
+	// 优先级高的源先备份
+	sourcePaths = sortSourcesByPriority(proj, sourcePaths)
 	fmt.Printf("发现 %d 个路径需要处理:\n", len(sourcePaths))
 	for i, path := range sourcePaths {
-		fmt.Printf("  %d. %s\n", i+1, path)
+		if priority := getSourcePriority(proj, path); priority != 0 {
+			fmt.Printf("  %d. %s (优先级: %d)\n", i+1, path, priority)
+		} else {
+			fmt.Printf("  %d. %s\n", i+1, path)
+		}
+	}
+	deferBelow := getDeferPriority(proj)
+
+	// 记录本次运行生效的配置，写入运行报告和每个备份的清单
+	runStart := time.Now()
+	config := configSnapshot(proj)
+	runMeter := startResourceMeter(nil)
+
+	// 根据历史耗时预测完成时间
+	forecast := newRunForecast(proj, sourcePaths, runStart)
+	forecast.report(sourcePaths)
+
+	// 存储目录所在的网络文件系统挂载异常时，本次运行的源全部失败
+	mountErr := checkStorageMount(client)
+	if mountErr != nil {
+		fmt.Printf("错误: %v\n", mountErr)
+		sendAlert(proj, "存储挂载异常", fmt.Sprintf("%v，本次运行的备份全部失败", mountErr))
 	}
 
 	// 处理每个路径
-	var tempFiles []string // 存储临时文件路径，用于最后清理
+	var records []historyRecord
+	var entries []catalogEntry
 	successCount := 0
+	skippedCount := 0
+	disabledCount := 0
 
-	for _, sourcePath := range sourcePaths {
+	for i, sourcePath := range sourcePaths {
+		state.progress(sourcePaths[:i])
+		if i > 0 {
+			forecast.report(sourcePaths[i:])
+		}
 		fmt.Printf("\n--- 处理: %s ---\n", sourcePath)
 
-		// 检查路径是否存在
-		if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-			fmt.Printf("错误: 路径不存在: %s\n", sourcePath)
-			continue
+		record := historyRecord{
+			Project: proj.Name,
+			Source:  sourcePath,
+			Prefix:  sourceName(sourcePath),
 		}
 
-		// 检查是文件还是目录
-		isDir, err := isDirectory(sourcePath)
-		if err != nil {
-			fmt.Printf("错误: 检查路径类型失败: %v\n", err)
+		// 暂时禁用的源记录为 disabled，保留在报告和状态中
+		if reason := sourceDisabledReason(proj, sourcePath); reason != "" {
+			fmt.Printf("已禁用，跳过: %s (%s)\n", sourcePath, reason)
+			record.StartTime = time.Now().Format(time.RFC3339)
+			record.Status = "disabled"
+			record.Error = reason
+			records = append(records, record)
+			disabledCount++
 			continue
 		}
 
-		var localFilePath string
-		var cosFileName string
+		if mountErr != nil {
+			record.StartTime = time.Now().Format(time.RFC3339)
+			record.Status = "failed"
+			record.Error = mountErr.Error()
+			records = append(records, record)
+			continue
+		}
 
-		if isDir {
-			// 文件夹：压缩为ZIP
-			cosFileName = generateFileName(sourcePath, true)
-			localFilePath = filepath.Join(os.TempDir(), cosFileName)
+		// 备份窗口不足时推迟低优先级的源，留给下次运行
+		if getSourcePriority(proj, sourcePath) < deferBelow && !forecast.fits(sourcePath) {
+			fmt.Printf("备份窗口不足，推迟低优先级的源: %s\n", sourcePath)
+			record.StartTime = time.Now().Format(time.RFC3339)
+			record.Status = "skipped"
+			record.Error = "备份窗口不足，已推迟到下次运行"
+			records = append(records, record)
+			skippedCount++
+			continue
+		}
 
-			fmt.Printf("开始压缩文件夹: %s -> %s\n", sourcePath, localFilePath)
-			err = zipFolder(sourcePath, localFilePath)
-			if err != nil {
-				fmt.Printf("错误: 压缩文件夹失败: %v\n", err)
+		// 源路径不存在时按策略跳过、等待或使本次运行失败
+		if missing := checkMissingSource(proj, sourcePath); missing != "" {
+			fmt.Printf("警告: 路径不存在: %s\n", sourcePath)
+			record.StartTime = time.Now().Format(time.RFC3339)
+			record.Error = "路径不存在"
+			if missing == "skip" {
+				record.Status = "skipped"
+				records = append(records, record)
+				skippedCount++
 				continue
 			}
-			fmt.Printf("文件夹压缩成功: %s\n", localFilePath)
-			tempFiles = append(tempFiles, localFilePath) // 添加到临时文件列表
-		} else {
-			// 文件：直接上传
-			cosFileName = generateFileName(sourcePath, false)
-			localFilePath = sourcePath
-			fmt.Printf("直接上传文件: %s\n", sourcePath)
+
+			record.Status = "failed"
+			records = append(records, record)
+			remaining := len(sourcePaths) - i - 1
+			if remaining > 0 {
+				fmt.Printf("本次运行失败，跳过剩余 %d 个路径\n", remaining)
+				skippedCount += remaining
+			}
+			break
 		}
 
-		// 构造COS路径
-		var cosPath string
-		if targetDir == "" {
-			cosPath = cosFileName
-		} else {
-			cleanDir := strings.TrimRight(targetDir, "/")
-			cleanFileName := strings.TrimLeft(cosFileName, "/")
-			cosPath = fmt.Sprintf("%s/%s", cleanDir, cleanFileName)
+		// 同一个源的上一次备份仍在进行时，按OVERLAP_POLICY跳过、排队或取消上一次
+		ctx, release, overlap := getSourceRun(proj, sourcePath).acquire(getOverlapPolicy(proj))
+		recordOverlap(proj, sourcePath, overlap)
+		if overlap == overlapSkipped {
+			record.StartTime = time.Now().Format(time.RFC3339)
+			record.Status = "skipped"
+			records = append(records, record)
+			skippedCount++
+			continue
 		}
 
-		// 上传文件
-		fmt.Printf("开始上传文件: %s -> %s\n", localFilePath, cosPath)
-		_, err = client.Object.PutFromFile(context.Background(), cosPath, localFilePath, nil)
+		startTime := time.Now()
+		record.StartTime = startTime.Format(time.RFC3339)
+		meter := startResourceMeter(runMeter)
+
+		// 上传期间持有前缀锁，清理会跳过该前缀
+		lock := prefixLock(targetDir, record.Prefix)
+		lock.Lock()
+		result, err := backupSource(withResourceMeter(ctx, meter), client, proj, sourcePath)
+		lock.Unlock()
+		record.Resources = meter.stop()
+		fmt.Printf("资源使用: %v\n", record.Resources)
+		// 目录中可能有了新对象，本周期之后的清理和垃圾回收需要重新列出
+		invalidateListCache(targetDir)
+		cancelled := ctx.Err() != nil
+		release()
+		record.Duration = time.Since(startTime).Seconds()
+		if errors.Is(err, errUploadPaused) {
+			// 初始备份在时间窗口外暂停，不算失败
+			fmt.Printf("%v: %s\n", err, sourcePath)
+			record.Status = "skipped"
+			record.Error = err.Error()
+			records = append(records, record)
+			skippedCount++
+			continue
+		}
 		if err != nil {
-			fmt.Printf("错误: 上传文件失败: %v\n", err)
+			fmt.Printf("错误: %v\n", err)
+			record.Status = "failed"
+			if cancelled {
+				record.Status = "cancelled"
+			}
+			record.Error = err.Error()
+			records = append(records, record)
 			continue
 		}
 
-		// 验证上传
-		fmt.Printf("文件上传成功: %s\n", cosPath)
-		resp, err := client.Object.Head(context.Background(), cosPath, nil)
-		if err != nil {
-			fmt.Printf("警告: 验证上传文件失败: %v\n", err)
-		} else {
-			fmt.Printf("文件验证成功，大小: %d bytes\n", resp.ContentLength)
+		record.Status = "success"
+		record.Key = result.Key
+		record.Size = result.Size
+		record.KeyID = result.KeyID
+		record.Replicas = result.Replicas
+		record.Sensitive = result.Sensitive
+		if failed := replicaFailures(record.Replicas); failed > 0 && isReplicaRequired(proj) {
+			// 备份已上传到主存储，仍然写入清单和索引
+			record.Status = "failed"
+			record.Error = fmt.Sprintf("%d 个副本存储复制失败", failed)
+		}
+		records = append(records, record)
+
+		// 逐个上传的源没有备份文件，不写入清单和索引
+		if result.PerFile {
+			if record.Status == "success" {
+				saveStatusStamp(client, proj, record.Prefix, sourcePath, result)
+				successCount++
+			}
+			continue
+		}
+
+		prefix, timeStamp, _ := parseFileName(filepath.Base(result.Key))
+		manifest := &backupManifest{
+			Key:       result.Key,
+			Source:    sourcePath,
+			Prefix:    prefix,
+			TimeStamp: timeStamp,
+			TimeZone:  getBackupLocation().String(),
+			Size:      result.Size,
+			KeyID:     result.KeyID,
+			SHA256:    result.SHA256,
+			Files:     result.SourceFiles,
+			RawSize:   result.SourceBytes,
+			Checksums: result.FileChecksums,
+			Renamed:   result.RenamedFiles,
+			Sensitive: result.Sensitive,
+			Parts:     result.Parts,
+			CreatedAt: time.Now().Format(time.RFC3339),
+			Config:    config,
+		}
+		if err := saveManifest(client, targetDir, manifest); err != nil {
+			fmt.Printf("警告: 写入备份清单失败: %v\n", err)
 		}
 
-		successCount++
+		entries = append(entries, catalogEntry{
+			Key:       result.Key,
+			Prefix:    prefix,
+			TimeStamp: timeStamp,
+			Size:      result.Size,
+			Source:    sourcePath,
+			KeyID:     result.KeyID,
+			SHA256:    result.SHA256,
+			Parts:     result.Parts,
+		})
+
+		if record.Status == "success" {
+			saveStatusStamp(client, proj, prefix, sourcePath, result)
+			successCount++
+		}
 	}
 
-	// 清理临时文件
-	for _, tempFile := range tempFiles {
-		if err := os.Remove(tempFile); err != nil {
-			fmt.Printf("警告: 删除临时文件失败: %s, 错误: %v\n", tempFile, err)
+	// 更新远程索引和本地历史，索引失败时不影响备份本身
+	if len(entries) > 0 {
+		err := updateCatalogIndex(client, targetDir, func(index *catalogIndex) {
+			for _, entry := range entries {
+				index.addEntry(entry)
+			}
+		})
+		if err != nil {
+			fmt.Printf("警告: 更新远程索引失败: %v\n", err)
 		}
 	}
+	if err := appendHistory(records...); err != nil {
+		fmt.Printf("警告: %v\n", err)
+	}
+	state.finish()
+	updateIncidents(proj, records)
+
+	report := &runReport{
+		Project:   proj.Name,
+		StartTime: runStart.Format(time.RFC3339),
+		EndTime:   time.Now().Format(time.RFC3339),
+		Succeeded: successCount,
+		Failed:    len(sourcePaths) - successCount - skippedCount - disabledCount,
+		Skipped:   skippedCount,
+		Disabled:  disabledCount,
+		Results:   records,
+		Resources: runMeter.stop(),
+		Config:    config,
+		Target:    target,
+	}
+	report.Hooks = runCompletionHooks(proj, report, runStart)
+	if err := saveRunReport(client, targetDir, runStart, report); err != nil {
+		fmt.Printf("警告: 写入运行报告失败: %v\n", err)
+	}
+	recordRunStatus(proj, report)
+	recordRunUsage(proj, report.Resources)
+	publishRunSummary(proj, report)
+	emitHookEvent(proj, "run_finished", report)
 
 	// 输出备份汇总信息
-	fmt.Printf("\n=== 备份完成 ===\n")
+	fmt.Printf("\n=== 备份完成%s ===\n", proj.logTag())
 	fmt.Printf("总路径数: %d\n", len(sourcePaths))
 	fmt.Printf("成功上传: %d\n", successCount)
-	fmt.Printf("失败数量: %d\n", len(sourcePaths)-successCount)
+	fmt.Printf("失败数量: %d\n", report.Failed)
+	if skippedCount > 0 {
+		fmt.Printf("跳过数量: %d\n", skippedCount)
+	}
+	if disabledCount > 0 {
+		fmt.Printf("已禁用: %d\n", disabledCount)
+	}
+	replicaFailed := 0
+	for _, record := range records {
+		replicaFailed += replicaFailures(record.Replicas)
+	}
+	if replicaFailed > 0 {
+		fmt.Printf("副本复制失败: %d\n", replicaFailed)
+	}
+	fmt.Printf("资源使用: %v\n", report.Resources)
+	return report.Failed == 0
 }
 
 // performCleanup 执行清理操作
-func performCleanup(client *cos.Client, targetDir string) {
+func performCleanup(client *cos.Client, proj *project) {
 	// 检查是否启用清理
-	cleanupEnabled := os.Getenv("CLEANUP_ENABLED")
+	cleanupEnabled := proj.Getenv("CLEANUP_ENABLED")
 	if cleanupEnabled != "true" {
 		return
 	}
 
-	fmt.Printf("\n=== 开始执行定时清理 ===\n")
+	fmt.Printf("\n=== 开始执行定时清理%s ===\n", proj.logTag())
+	targetDir := proj.TargetDir
 
 	// 获取配置
-	cleanupDaysStr := os.Getenv("CLEANUP_DAYS")
-	cleanupDays := 7 // 默认7天
-	if cleanupDaysStr != "" {
-		if days, err := strconv.Atoi(cleanupDaysStr); err == nil {
-			cleanupDays = days
+	maxAge := getRetention(proj)
+	whitelist := getWhiteList(proj)
+	fmt.Printf("清理配置: 保留期=%v, 白名单=%v\n", maxAge, whitelist)
+
+	// 事故调查期间冻结所有删除
+	if freeze := getFreeze(client); freeze != nil {
+		fmt.Printf("删除已冻结: %s\n", freeze.Reason)
+		fmt.Printf("=== 清理已跳过%s，解除冻结后恢复 ===\n", proj.logTag())
+		return
+	}
+
+	// 检测到疑似勒索软件后暂停清理，保留旧备份
+	if pause := getCleanupPause(client, targetDir); pause != nil {
+		fmt.Printf("清理已暂停（%s 起）: %s\n", pause.Since, pause.Reason)
+		fmt.Printf("=== 清理已跳过%s，确认安全后运行 resume-cleanup 恢复 ===\n", proj.logTag())
+		return
+	}
+
+	// 本地时钟不可信时不能按时间删除
+	if err := checkClockSkew(client, proj); err != nil {
+		sendAlert(proj, "清理已跳过", fmt.Sprintf("%v，请检查系统时间", err))
+		fmt.Printf("=== 清理已跳过%s ===\n", proj.logTag())
+		return
+	}
+
+	skipped := newSkipSummary()
+	var expired []string
+
+	// 上次清理中断时，直接继续删除当时已决定删除的文件，不再重新列出和判断
+	if journal := loadCleanupJournal(client, targetDir); journal != nil {
+		fmt.Printf("继续 %s 开始的清理，剩余 %d 个文件\n", journal.StartedAt, len(journal.Pending))
+		expired = journal.Pending
+	} else {
+		var totals map[string]int
+		var err error
+		expired, totals, err = findExpiredFiles(client, proj, skipped)
+		if err != nil {
+			fmt.Printf("错误: %v\n", err)
+			return
+		}
+
+		// 删除数量超过安全阈值时跳过本次清理
+		if err := checkDeleteThreshold(proj, expired, totals); err != nil {
+			sendAlert(proj, "清理已跳过", fmt.Sprintf("%v。如确认需要删除，请设置 CLEANUP_CONFIRM_MASS_DELETE=true 后重新运行", err))
+			skipped.print()
+			fmt.Printf("=== 清理已跳过%s ===\n", proj.logTag())
+			return
+		}
+
+		// 删除前记录决定，中断后下次继续
+		if err := saveCleanupJournal(client, targetDir, expired); err != nil {
+			fmt.Printf("警告: %v\n", err)
 		}
 	}
 
-	whitelist := getWhiteList()
-	fmt.Printf("清理配置: 保留天数=%d, 白名单=%v\n", cleanupDays, whitelist)
+	journaled := len(expired) > 0
+
+	// 正在备份的前缀留到下次清理，其余前缀在删除期间加锁
+	expired, unlock := lockExpiredPrefixes(targetDir, expired, skipped)
+
+	// 并发删除过期文件，每删除一批同步移除对应的索引记录和清理进度，删除期间被冻结时立即停止
+	deleted := deleteExpiredFiles(client, targetDir, expired, getCleanupConcurrency(proj), func(batch []string) bool {
+		if err := checkpointCleanup(client, targetDir, batch, false); err != nil {
+			fmt.Printf("警告: %v\n", err)
+		}
+		if freeze := getFreeze(client); freeze != nil {
+			fmt.Printf("删除已冻结，停止清理: %s\n", freeze.Reason)
+			return false
+		}
+		return true
+	})
+	unlock()
+
+	// 本次清理已结束，删除失败和加锁跳过的文件在下次清理时重新判断
+	if journaled {
+		if err := checkpointCleanup(client, targetDir, nil, true); err != nil {
+			fmt.Printf("警告: %v\n", err)
+		}
+	}
+
+	// 存储插件和副本存储中的副本随主存储一起删除
+	var deletedKeys []string
+	for _, fileName := range deleted {
+		deletedKeys = append(deletedKeys, cosObjectKey(targetDir, fileName))
+	}
+	deleteFromStoragePlugins(proj, deletedKeys)
+	deleteFromReplicas(deletedKeys)
+	emitHookEvent(proj, "cleanup_finished", map[string]interface{}{"deleted": deletedKeys})
+
+	skipped.print()
+	fmt.Printf("=== 清理完成%s，删除了 %d 个文件 ===\n", proj.logTag(), len(deleted))
+}
+
+// findExpiredFiles 列出目标目录并按保留期和白名单找出过期的备份，同时返回每个前缀的备份总数
+func findExpiredFiles(client *cos.Client, proj *project, skipped *skipSummary) ([]string, map[string]int, error) {
+	maxAge := getRetention(proj)
+	whitelist := getWhiteList(proj)
 
 	// 获取文件列表
-	fileNames, err := listCOSFiles(client, targetDir)
+	fileNames, err := listCOSFiles(client, proj.TargetDir)
 	if err != nil {
-		fmt.Printf("错误: %v\n", err)
-		return
+		return nil, nil, err
 	}
 
 	fmt.Printf("发现 %d 个文件需要检查\n", len(fileNames))
 
-	deletedCount := 0
+	var expired []string
+	totals := make(map[string]int) // 每个前缀的备份总数，用于安全阈值检查
+	counted := make(map[string]bool)
 	for _, fileName := range fileNames {
+		// 跳过程序元数据
+		if isMetaFile(fileName) {
+			continue
+		}
+		// 备份都在目标目录的第一层，子目录中的文件不属于本项目
+		if strings.Contains(fileName, "/") {
+			skipped.add("子目录中的文件", fileName)
+			continue
+		}
+
 		prefix, timeStamp, isOurFormat := parseFileName(fileName)
 
 		// 检查是否是我们上传的文件格式
 		if !isOurFormat {
-			fmt.Printf("跳过非程序上传文件: %s\n", fileName)
+			skipped.add("非程序上传文件", fileName)
 			continue
 		}
+		// 同一备份的分卷只计一次，所有分卷的时间戳相同，一起过期
+		if unit := splitBaseName(fileName); !counted[unit] {
+			counted[unit] = true
+			totals[prefix]++
+		}
 
-		// 检查文件是否超过保留天数
-		if !isFileOlderThanDays(timeStamp, cleanupDays) {
-			fmt.Printf("文件未超过保留天数: %s\n", fileName)
+		// 检查文件是否超过保留期
+		if !isFileOlderThan(timeStamp, maxAge) {
+			skipped.add("未超过保留期", fileName)
 			continue
 		}
 
 		// 检查文件前缀是否在白名单中
 		if isWhitelisted(prefix, whitelist) {
-			fmt.Printf("文件在白名单中，跳过删除: %s\n", fileName)
+			skipped.add("在白名单中", fileName)
 			continue
 		}
 
-		// 删除文件
 		fmt.Printf("删除过期文件: %s (前缀: %s, 时间: %s)\n", fileName, prefix, timeStamp)
-		err := deleteCOSFile(client, targetDir, fileName)
-		if err != nil {
-			fmt.Printf("删除失败: %v\n", err)
-		} else {
-			deletedCount++
-		}
+		expired = append(expired, fileName)
 	}
-
-	fmt.Printf("=== 清理完成，删除了 %d 个文件 ===\n", deletedCount)
+	return expired, totals, nil
 }
 
 func main() {
-	// 加载.env文件
-	err := godotenv.Load()
+	// ssh获取SFTP密码时调用本程序
+	if runSSHAskpass() {
+		return
+	}
+
+	// 加载配置文件，--env-file 需要放在子命令之前
+	envFiles, args, err := parseEnvFileArgs(os.Args[1:])
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(2)
+	}
+	os.Args = append(os.Args[:1], args...)
+	if err := loadEnvFiles(envFiles); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 处理不需要访问COS的子命令
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		if err := runDecrypt(os.Args[2:]); err != nil {
+			fmt.Printf("错误: 解密失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "extract" {
+		if err := runExtract(os.Args[2:]); err != nil {
+			fmt.Printf("错误: 解压失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			fmt.Printf("错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		if err := runToken(os.Args[2:]); err != nil {
+			fmt.Printf("错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 加载项目，每个项目有独立的COS目标目录和策略
+	projects, err := loadProjects()
 	if err != nil {
-		fmt.Printf("警告: 无法加载.env文件: %v\n", err)
-		fmt.Println("将使用环境变量中的配置")
+		fmt.Printf("错误: 加载项目配置失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 严格配置模式下配置有误时立即退出，不在缺少配置的情况下空转
+	if isStrictConfig() {
+		if err := checkStrictConfig(projects, len(os.Args) < 2); err != nil {
+			fmt.Printf("错误: %v\n", err)
+			if len(os.Args) > 1 && os.Args[1] == "check" {
+				os.Exit(checkUnknown)
+			}
+			os.Exit(2)
+		}
 	}
 
-	// COS上的目标目录
-	targetDir := os.Getenv("COS_TARGET_DIR")
+	// 离线分析存储桶清单，不访问COS
+	if len(os.Args) > 1 && os.Args[1] == "inventory" {
+		if err := runInventory(projects, os.Args[2:]); err != nil {
+			fmt.Printf("错误: 分析清单失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// 初始化COS客户端
 	client, err := initCOSClient()
 	if err != nil {
 		fmt.Printf("错误: 初始化COS客户端失败: %v\n", err)
+		if len(os.Args) > 1 && os.Args[1] == "check" {
+			os.Exit(checkUnknown)
+		}
+		return
+	}
+	initReplicas()
+	if err := initFailover(client); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
+	}
+	initLoadMonitor()
+
+	// 处理子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "repair":
+			if err := runRepair(client, projects, os.Args[2:]); err != nil {
+				fmt.Printf("错误: 修复索引失败: %v\n", err)
+				os.Exit(1)
+			}
+		case "run":
+			ok, err := runOnce(client, projects, os.Args[2:])
+			if err != nil {
+				fmt.Printf("错误: %v\n", err)
+				os.Exit(2)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		case "check":
+			os.Exit(runCheck(client, projects, os.Args[2:]))
+		case "relay":
+			if err := runRelay(client); err != nil {
+				fmt.Printf("错误: 中继停止: %v\n", err)
+				os.Exit(1)
+			}
+		case "freeze":
+			if err := runFreeze(client, os.Args[2:]); err != nil {
+				fmt.Printf("错误: %v\n", err)
+				os.Exit(1)
+			}
+		case "resume-cleanup":
+			if err := runResumeCleanup(client, projects, os.Args[2:]); err != nil {
+				fmt.Printf("错误: %v\n", err)
+				os.Exit(1)
+			}
+		case "verify":
+			drifted, err := runVerify(client, projects, os.Args[2:])
+			if err != nil {
+				fmt.Printf("错误: %v\n", err)
+				os.Exit(2)
+			}
+			if drifted {
+				os.Exit(1)
+			}
+		case "graph":
+			if err := runGraph(client, projects, os.Args[2:]); err != nil {
+				fmt.Printf("错误: 导出备份链失败: %v\n", err)
+				os.Exit(1)
+			}
+		case "accesslog":
+			if err := runAccessLog(projects, os.Args[2:]); err != nil {
+				fmt.Printf("错误: 分析访问日志失败: %v\n", err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("错误: 未知命令: %s\n", os.Args[1])
+			fmt.Println("可用命令: run, check, verify, freeze, resume-cleanup, repair, graph, inventory, decrypt, extract, accesslog, token, bench, relay")
+			os.Exit(2)
+		}
 		return
 	}
 
+	// 确保存储桶存在，并检查是否允许公开访问
+	if err := ensureBucket(client); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
+	}
+	if err := checkBucketPublicAccess(client); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
+	}
+
 	// 确保目标目录存在
-	err = ensureCOSDirectory(client, targetDir)
-	if err != nil {
-		fmt.Printf("错误: 确保目录存在失败: %v\n", err)
-		return
+	for _, proj := range projects {
+		err = ensureCOSDirectory(client, proj.TargetDir)
+		if err != nil {
+			fmt.Printf("错误: 确保目录存在失败: %v\n", err)
+			return
+		}
 	}
+
+	// 启动时检查权限，缺少权限时立即退出
+	for _, proj := range projects {
+		if err := probeTarget(client, proj.TargetDir); err != nil {
+			fmt.Printf("错误: 项目 %s 权限检查失败: %v\n", proj, err)
+			fmt.Println("请检查密钥是否具有目标目录的上传、读取、列出和删除权限")
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("权限检查通过\n")
+
 	fmt.Printf("程序启动，将持续运行并定时执行备份和清理任务\n")
-	fmt.Printf("存储桶: %s, 地域: %s, 目标目录: %s\n",
-		os.Getenv("COS_BUCKET_NAME"), os.Getenv("COS_REGION"), targetDir)
+	for _, proj := range projects {
+		fmt.Printf("存储桶: %s, 地域: %s, 项目: %s, 目标目录: %s\n",
+			os.Getenv("COS_BUCKET_NAME"), os.Getenv("COS_REGION"), proj, proj.TargetDir)
+	}
+
+	// 启动备份新鲜度检查
+	for _, proj := range projects {
+		startSLAChecker(client, proj)
+	}
+
+	// 启动主存储桶健康检查，持续不可用时切换到备用存储桶
+	startHealthChecker(projects)
+
+	// 启动存储桶策略漂移检测
+	for _, proj := range projects {
+		startPolicyChecker(client, proj)
+	}
+
+	for _, proj := range projects {
+		if cfg := getMQTTConfig(proj); cfg != nil {
+			fmt.Printf("已启用MQTT状态发布%s: %s\n", proj.logTag(), cfg)
+		}
+	}
+
+	// 检查重复或互相包含的源路径
+	checkNestedSources(projects)
+
+	// 首次运行前等待源路径就绪
+	waitForSources(projects)
+
+	// 继续上次被中断的运行，启动按间隔备份的源
+	resumeInterruptedRuns(client, projects)
+	startIntervalSchedulers(client, projects)
+
+	// 启动Web界面
+	if err := startWebServer(client, projects); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
+	}
 
 	// 主循环
 	for {
@@ -572,6 +1411,7 @@ func main() {
 
 		fmt.Printf("\n当前时间: %s\n", now.Format("2006-01-02 15:04:05"))
 		fmt.Printf("下次清理时间: %s\n", nextCleanupTime.Format("2006-01-02 15:04:05"))
+		recordNextRun(nextCleanupTime)
 		fmt.Printf("等待时间: %v\n", waitDuration)
 
 		// 等待到清理时间
@@ -580,11 +1420,43 @@ func main() {
 			time.Sleep(waitDuration)
 		}
 
-		// 执行备份
-		performBackup(client, targetDir)
+		// 运行前重新检查权限，失败时跳过该项目
+		var ready []*project
+		for _, proj := range projects {
+			if isProbeBeforeRunEnabled(proj) {
+				if err := probeTarget(client, proj.TargetDir); err != nil {
+					sendAlert(proj, "权限检查失败", err.Error())
+					continue
+				}
+			}
+			ready = append(ready, proj)
+		}
 
-		// 执行清理
-		performCleanup(client, targetDir)
+		// 备份与清理、垃圾回收作为独立任务同时运行，互不等待；
+		// 同一前缀的上传和删除由前缀锁互斥，清理和垃圾回收共用本周期的列表缓存
+		beginListCache()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			runMu.Lock()
+			defer runMu.Unlock()
+			for _, proj := range ready {
+				performBackup(client, proj)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			cleanupMu.Lock()
+			defer cleanupMu.Unlock()
+			for _, proj := range ready {
+				performCleanup(client, proj)
+				performConsolidation(client, proj)
+				performGC(client, proj)
+			}
+		}()
+		wg.Wait()
+		endListCache()
 
 		// 等待1分钟后重新计算清理时间
 		fmt.Printf("\n等待1分钟后重新计算清理时间...\n")
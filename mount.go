@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 挂载检查：LOCAL_STORAGE_PATH位于腾讯云CFS、NFS或SMB等网络文件系统时，挂载失效后读写可能一直阻塞，
+// 未挂载时备份会写入挂载点下的本地磁盘。开启LOCAL_MOUNT_CHECK后，连接存储、每次备份运行和复制到该存储前
+// 检查文件系统（statfs），确认目录不在根文件系统上，并写入、读回、删除一个测试文件，失败时本次运行失败并告警。
+
+// mountCheck 本地存储目录的挂载检查
+type mountCheck struct {
+	root    string
+	timeout time.Duration
+}
+
+var (
+	mountChecksMu sync.Mutex
+	mountChecks   = make(map[*cos.Client]*mountCheck)
+)
+
+// getMountCheck 读取挂载检查配置，未开启时返回nil
+func getMountCheck(getenv func(string) string, root string) *mountCheck {
+	if getenv("LOCAL_MOUNT_CHECK") != "true" {
+		return nil
+	}
+	timeout := 30 * time.Second
+	if timeoutStr := getenv("LOCAL_MOUNT_TIMEOUT"); timeoutStr != "" {
+		if seconds, err := strconv.Atoi(timeoutStr); err == nil && seconds > 0 {
+			timeout = time.Duration(seconds) * time.Second
+		} else {
+			fmt.Printf("警告: LOCAL_MOUNT_TIMEOUT格式错误: %s，使用默认值 %v\n", timeoutStr, timeout)
+		}
+	}
+	return &mountCheck{root: root, timeout: timeout}
+}
+
+// registerMountCheck 记录存储客户端对应的挂载检查
+func registerMountCheck(client *cos.Client, check *mountCheck) {
+	mountChecksMu.Lock()
+	defer mountChecksMu.Unlock()
+	mountChecks[client] = check
+}
+
+// checkStorageMount 检查存储客户端的挂载状态，没有开启挂载检查的存储直接返回nil
+func checkStorageMount(client *cos.Client) error {
+	mountChecksMu.Lock()
+	check := mountChecks[client]
+	mountChecksMu.Unlock()
+	if check == nil {
+		return nil
+	}
+	return check.run()
+}
+
+// run 执行检查，超时视为挂载失效（失效的NFS挂载上的操作可能永远不会返回）
+func (c *mountCheck) run() error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check()
+	}()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("存储目录挂载异常: %s: %v", c.root, err)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("存储目录挂载异常: %s: %v 内没有响应，挂载可能已失效", c.root, c.timeout)
+	}
+}
+
+// check 检查文件系统并写入、读回、删除测试文件
+func (c *mountCheck) check() error {
+	stat, err := statMount(c.root)
+	if err != nil {
+		return fmt.Errorf("读取文件系统信息失败: %v", err)
+	}
+
+	same, err := sameFileSystem(c.root, string(filepath.Separator))
+	if err != nil {
+		return fmt.Errorf("读取目录信息失败: %v", err)
+	}
+	if same {
+		return fmt.Errorf("与根目录在同一文件系统上，网络存储可能没有挂载")
+	}
+
+	dir := filepath.Join(c.root, fsInternalDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("测试写入失败: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	path := filepath.Join(dir, fmt.Sprintf("mountcheck_%s_%d", hostname, time.Now().UnixNano()))
+	content := []byte("vcpsave mount check " + time.Now().Format(time.RFC3339Nano))
+	defer os.Remove(path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("测试写入失败: %v", err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("测试写入失败: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("测试写入失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("测试写入失败: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("测试读取失败: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		return fmt.Errorf("测试读取的内容与写入的不一致")
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("删除测试文件失败: %v", err)
+	}
+
+	if stat != nil {
+		fmt.Printf("挂载检查通过: %s (%s, 可用 %.1f GB / %.1f GB)\n", c.root, stat.fsType,
+			float64(stat.free)/1024/1024/1024, float64(stat.total)/1024/1024/1024)
+	} else {
+		fmt.Printf("挂载检查通过: %s\n", c.root)
+	}
+	return nil
+}
+
+// mountStat 存储目录所在文件系统的信息
+type mountStat struct {
+	fsType      string
+	total, free uint64
+}
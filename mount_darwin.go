@@ -0,0 +1,15 @@
+package main
+
+import "syscall"
+
+// fsTypeName 返回文件系统类型名称
+func fsTypeName(st *syscall.Statfs_t) string {
+	name := make([]byte, 0, len(st.Fstypename))
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return string(name)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// linuxFSTypes 常见文件系统的statfs类型编号
+var linuxFSTypes = map[int64]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0xef53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+	0x01021994: "tmpfs",
+}
+
+// fsTypeName 返回文件系统类型名称，未知的类型返回编号
+func fsTypeName(st *syscall.Statfs_t) string {
+	if name, ok := linuxFSTypes[int64(st.Type)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", st.Type)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+// statMount 其他系统不读取文件系统信息，只做测试写入
+func statMount(path string) (*mountStat, error) {
+	return nil, nil
+}
+
+// sameFileSystem Windows的网络驱动器未连接时路径不存在，不需要比较文件系统
+func sameFileSystem(a, b string) (bool, error) {
+	return false, nil
+}
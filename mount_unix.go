@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// statMount 读取目录所在文件系统的类型和空间
+func statMount(path string) (*mountStat, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &mountStat{
+		fsType: fsTypeName(&st),
+		total:  st.Blocks * uint64(st.Bsize),
+		free:   st.Bavail * uint64(st.Bsize),
+	}, nil
+}
+
+// sameFileSystem 检查两个路径是否在同一文件系统上
+func sameFileSystem(a, b string) (bool, error) {
+	var sa, sb syscall.Stat_t
+	if err := syscall.Stat(a, &sa); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat(b, &sb); err != nil {
+		return false, err
+	}
+	return sa.Dev == sb.Dev, nil
+}
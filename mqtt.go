@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 运行结果和新鲜度状态以保留消息（retained）发布到MQTT，
+// Home Assistant等家庭自动化系统订阅后可以在仪表盘中显示备份状态。
+// 只使用QoS 0发布，实现了MQTT 3.1.1中所需的最小子集，每次发布单独建立连接。
+
+// mqttConfig MQTT连接配置
+type mqttConfig struct {
+	broker   string // host:port
+	useTLS   bool
+	username string
+	password string
+	clientID string
+	topic    string // 主题前缀
+}
+
+// getMQTTConfig 获取项目的MQTT配置，未配置MQTT_BROKER时返回nil
+// MQTT_BROKER 格式为 tcp://host:1883 或 ssl://host:8883，主题前缀默认 vcpsave/<项目>
+func getMQTTConfig(proj *project) *mqttConfig {
+	broker := proj.Getenv("MQTT_BROKER")
+	if broker == "" {
+		return nil
+	}
+
+	cfg := &mqttConfig{
+		username: proj.Getenv("MQTT_USERNAME"),
+		password: proj.Getenv("MQTT_PASSWORD"),
+		clientID: proj.Getenv("MQTT_CLIENT_ID"),
+		topic:    strings.TrimRight(proj.Getenv("MQTT_TOPIC"), "/"),
+	}
+	switch {
+	case strings.HasPrefix(broker, "ssl://"), strings.HasPrefix(broker, "tls://"):
+		cfg.useTLS = true
+		broker = broker[len("ssl://"):]
+	case strings.HasPrefix(broker, "tcp://"), strings.HasPrefix(broker, "mqtt://"):
+		broker = broker[strings.Index(broker, "://")+3:]
+	}
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		if cfg.useTLS {
+			broker = net.JoinHostPort(broker, "8883")
+		} else {
+			broker = net.JoinHostPort(broker, "1883")
+		}
+	}
+	cfg.broker = broker
+
+	if cfg.clientID == "" {
+		hostname, _ := os.Hostname()
+		cfg.clientID = "vcpsave-" + hostname
+	}
+	if cfg.topic == "" {
+		cfg.topic = "vcpsave/default"
+		if proj.Name != "" {
+			cfg.topic = "vcpsave/" + proj.Name
+		}
+	}
+	return cfg
+}
+
+// appendMQTTString 写入带2字节长度前缀的字符串
+func appendMQTTString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// mqttPacket 组装控制报文：固定头 + 剩余长度 + 内容
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// publish 连接broker并以保留消息发布到 主题前缀/subtopic
+func (cfg *mqttConfig) publish(subtopic string, payload []byte) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if cfg.useTLS {
+		host, _, _ := net.SplitHostPort(cfg.broker)
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.broker, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", cfg.broker)
+	}
+	if err != nil {
+		return fmt.Errorf("连接MQTT服务器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// CONNECT：协议名、级别4（3.1.1）、标志、保活时间
+	flags := byte(0x02) // clean session
+	connect := appendMQTTString(nil, "MQTT")
+	connect = append(connect, 4, 0, 0, 60)
+	connect = appendMQTTString(connect, cfg.clientID)
+	if cfg.username != "" {
+		flags |= 0x80
+		connect = appendMQTTString(connect, cfg.username)
+		if cfg.password != "" {
+			flags |= 0x40
+			connect = appendMQTTString(connect, cfg.password)
+		}
+	}
+	connect[7] = flags
+	if _, err := conn.Write(mqttPacket(0x10, connect)); err != nil {
+		return fmt.Errorf("发送MQTT连接请求失败: %v", err)
+	}
+
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		return fmt.Errorf("读取MQTT连接响应失败: %v", err)
+	}
+	if connack[0] != 0x20 || connack[3] != 0 {
+		return fmt.Errorf("MQTT服务器拒绝连接，返回码: %d", connack[3])
+	}
+
+	// PUBLISH：QoS 0，retain
+	publish := appendMQTTString(nil, cfg.topic+"/"+subtopic)
+	publish = append(publish, payload...)
+	if _, err := conn.Write(mqttPacket(0x31, publish)); err != nil {
+		return fmt.Errorf("发布MQTT消息失败: %v", err)
+	}
+
+	conn.Write(mqttPacket(0xE0, nil))
+	return nil
+}
+
+// publishMQTTJSON 将数据序列化为JSON发布，失败时只输出警告
+func publishMQTTJSON(proj *project, subtopic string, v interface{}) {
+	cfg := getMQTTConfig(proj)
+	if cfg == nil {
+		return
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("警告: 序列化MQTT消息失败: %v\n", err)
+		return
+	}
+	if err := cfg.publish(subtopic, payload); err != nil {
+		fmt.Printf("警告: %v\n", err)
+	}
+}
+
+// mqttRunSummary 发布到 <主题前缀>/run 的运行摘要
+type mqttRunSummary struct {
+	Status    string          `json:"status"` // success 或 failed
+	StartTime string          `json:"start_time"`
+	EndTime   string          `json:"end_time"`
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Skipped   int             `json:"skipped"`
+	Disabled  int             `json:"disabled"`
+	Sources   []mqttRunSource `json:"sources"`
+}
+
+// mqttRunSource 运行摘要中一个源的结果
+type mqttRunSource struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Size     int64   `json:"size,omitempty"`
+	Duration float64 `json:"duration_seconds,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// publishRunSummary 发布一次备份运行的摘要
+func publishRunSummary(proj *project, report *runReport) {
+	summary := mqttRunSummary{
+		Status:    "success",
+		StartTime: report.StartTime,
+		EndTime:   report.EndTime,
+		Succeeded: report.Succeeded,
+		Failed:    report.Failed,
+		Skipped:   report.Skipped,
+		Disabled:  report.Disabled,
+		Sources:   []mqttRunSource{},
+	}
+	if report.Failed > 0 {
+		summary.Status = "failed"
+	}
+	for _, r := range report.Results {
+		summary.Sources = append(summary.Sources, mqttRunSource{
+			Name:     r.Prefix,
+			Status:   r.Status,
+			Size:     r.Size,
+			Duration: r.Duration,
+			Error:    r.Error,
+		})
+	}
+	publishMQTTJSON(proj, "run", summary)
+}
+
+// mqttFreshness 发布到 <主题前缀>/freshness 的新鲜度状态
+type mqttFreshness struct {
+	Status    string                   `json:"status"` // ok 或 violated
+	CheckedAt string                   `json:"checked_at"`
+	Sources   map[string]mqttSourceSLA `json:"sources"`
+}
+
+// mqttSourceSLA 一个源的新鲜度状态
+type mqttSourceSLA struct {
+	OK         bool    `json:"ok"`
+	LastBackup string  `json:"last_backup,omitempty"`
+	AgeHours   float64 `json:"age_hours,omitempty"`
+	SLAHours   float64 `json:"sla_hours"`
+}
+
+// publishFreshness 发布所有配置了SLA的源的新鲜度状态
+func publishFreshness(proj *project, slas map[string]time.Duration, latest map[string]time.Time) {
+	freshness := mqttFreshness{
+		Status:    "ok",
+		CheckedAt: time.Now().Format(time.RFC3339),
+		Sources:   make(map[string]mqttSourceSLA),
+	}
+	for prefix, sla := range slas {
+		status := mqttSourceSLA{SLAHours: sla.Hours()}
+		if t, ok := latest[prefix]; ok {
+			status.LastBackup = t.Format(time.RFC3339)
+			status.AgeHours = float64(int(time.Since(t).Hours()*10)) / 10
+			status.OK = time.Since(t) <= sla
+		}
+		if !status.OK {
+			freshness.Status = "violated"
+		}
+		freshness.Sources[prefix] = status
+	}
+	publishMQTTJSON(proj, "freshness", freshness)
+}
+
+// String 返回用于日志的服务器地址和主题
+func (cfg *mqttConfig) String() string {
+	return fmt.Sprintf("%s (主题: %s/run, %s/freshness)", cfg.broker, cfg.topic, cfg.topic)
+}
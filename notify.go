@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 告警通知：每种渠道实现notifier接口并在init中注册，
+// 告警时所有已配置的渠道同时发送，新增渠道无需修改调用sendAlert的代码。
+//
+// 告警路由：ALERT_ROUTES=db_*:dba,configs:infra 按源名称（备份文件前缀，支持*通配符）把告警发送到不同的路由，
+// 路由的渠道配置使用 ALERT_ROUTE_路由名大写_ 作为前缀，例如 ALERT_ROUTE_DBA_ALERT_WEBHOOK_URL，
+// 未设置带前缀的配置时使用项目配置。没有匹配的源和不属于某个源的告警使用项目本身的渠道。
+
+// alert 一条告警
+type alert struct {
+	Project string
+	Source  string // 告警所属的源名称，不属于某个源时为空
+	Title   string
+	Message string
+	Time    time.Time
+}
+
+// subject 返回带程序名和项目名的告警标题，用于推送通知
+func (a alert) subject() string {
+	if a.Project == "" {
+		return "vcpsave: " + a.Title
+	}
+	return fmt.Sprintf("vcpsave [%s]: %s", a.Project, a.Title)
+}
+
+// notifier 告警渠道
+type notifier interface {
+	// Notify 发送一条告警
+	Notify(a alert) error
+}
+
+// notifierFactory 根据项目配置创建告警渠道，项目未配置该渠道时返回nil
+type notifierFactory func(proj *project) notifier
+
+var notifierFactories = map[string]notifierFactory{}
+
+// registerNotifier 注册告警渠道
+func registerNotifier(name string, factory notifierFactory) {
+	notifierFactories[name] = factory
+}
+
+// activeNotifiers 返回项目已配置的告警渠道，按名称排序
+func activeNotifiers(proj *project) ([]string, []notifier) {
+	var names []string
+	for name := range notifierFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var activeNames []string
+	var active []notifier
+	for _, name := range names {
+		if n := notifierFactories[name](proj); n != nil {
+			activeNames = append(activeNames, name)
+			active = append(active, n)
+		}
+	}
+	return activeNames, active
+}
+
+// alertRoute 一条告警路由规则
+type alertRoute struct {
+	pattern string
+	routes  []string
+}
+
+// getAlertRoutes 解析ALERT_ROUTES，规则按书写顺序匹配，一个规则可以用+发送到多个路由，
+// default 表示项目本身的渠道
+func getAlertRoutes(proj *project) []alertRoute {
+	var rules []alertRoute
+	for _, item := range strings.Split(proj.Getenv("ALERT_ROUTES"), ",") {
+		pattern, routes, ok := strings.Cut(strings.TrimSpace(item), ":")
+		pattern, routes = strings.TrimSpace(pattern), strings.TrimSpace(routes)
+		if !ok || pattern == "" || routes == "" {
+			if item = strings.TrimSpace(item); item != "" {
+				fmt.Printf("警告: ALERT_ROUTES格式错误，应为 源名称:路由，已忽略: %s\n", item)
+			}
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Printf("警告: ALERT_ROUTES中的通配符格式错误，已忽略: %s\n", pattern)
+			continue
+		}
+		rule := alertRoute{pattern: pattern}
+		for _, route := range strings.Split(routes, "+") {
+			if route = strings.TrimSpace(route); route != "" {
+				rule.routes = append(rule.routes, route)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// alertRouteProject 返回路由的配置，ALERT_ROUTE_路由名大写_ 前缀的配置覆盖项目配置
+func alertRouteProject(proj *project, route string) *project {
+	if route == "default" {
+		return proj
+	}
+	return &project{Name: proj.Name, TargetDir: proj.TargetDir, envPrefix: "ALERT_ROUTE_" + strings.ToUpper(route) + "_", parent: proj}
+}
+
+// alertTargets 返回源的告警应使用的配置，没有匹配的路由时为项目本身
+func alertTargets(proj *project, source string) []*project {
+	if source != "" {
+		for _, rule := range getAlertRoutes(proj) {
+			if matched, _ := path.Match(rule.pattern, source); !matched {
+				continue
+			}
+			var targets []*project
+			for _, route := range rule.routes {
+				targets = append(targets, alertRouteProject(proj, route))
+			}
+			return targets
+		}
+	}
+	return []*project{proj}
+}
+
+// keySource 从备份的对象键中取出源名称（备份文件前缀），不是本程序的备份文件时返回空
+func keySource(key string) string {
+	prefix, _, ok := parseFileName(path.Base(key))
+	if !ok {
+		return ""
+	}
+	return prefix
+}
+
+// routedNotifiers 返回告警路由上已配置的告警渠道，多个路由使用相同配置的渠道只返回一次
+func routedNotifiers(targets []*project) ([]string, []notifier) {
+	var names []string
+	var active []notifier
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		targetNames, targetActive := activeNotifiers(target)
+		for i, n := range targetActive {
+			id := fmt.Sprintf("%s %+v", targetNames[i], n)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			names = append(names, targetNames[i])
+			active = append(active, n)
+		}
+	}
+	return names, active
+}
+
+// sendAlert 发送告警：输出日志，并通过项目配置的所有告警渠道同时发送
+// 每个项目可以单独配置告警渠道
+func sendAlert(proj *project, title, message string) {
+	sendSourceAlert(proj, "", title, message)
+}
+
+// sendSourceAlert 发送属于某个源的告警，按ALERT_ROUTES选择告警渠道
+func sendSourceAlert(proj *project, source, title, message string) {
+	sendRoutedAlert(proj, alertTargets(proj, source), source, title, message)
+}
+
+// sendRoutedAlert 通过targets上已配置的告警渠道同时发送告警
+func sendRoutedAlert(proj *project, targets []*project, source, title, message string) {
+	fmt.Printf("告警%s: %s: %s\n", proj.logTag(), title, message)
+
+	a := alert{Project: proj.Name, Source: source, Title: title, Message: message, Time: time.Now()}
+	names, active := routedNotifiers(targets)
+
+	var wg sync.WaitGroup
+	for i, n := range active {
+		wg.Add(1)
+		go func(name string, n notifier) {
+			defer wg.Done()
+			if err := n.Notify(a); err != nil {
+				fmt.Printf("警告: 通过 %s 发送告警失败: %v\n", name, err)
+			}
+		}(names[i], n)
+	}
+	wg.Wait()
+}
+
+// sendSourceAlerts 发送涉及多个源的告警，messages的键为源名称。
+// 使用相同告警路由的源合并为一条告警，未配置ALERT_ROUTES时与sendAlert相同只发送一条
+func sendSourceAlerts(proj *project, title string, messages map[string]string) {
+	sources := make([]string, 0, len(messages))
+	for source := range messages {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	var groups []string
+	grouped := make(map[string][]string)
+	for _, source := range sources {
+		var routes []string
+		for _, target := range alertTargets(proj, source) {
+			routes = append(routes, target.envPrefix)
+		}
+		group := strings.Join(routes, "+")
+		if _, ok := grouped[group]; !ok {
+			groups = append(groups, group)
+		}
+		grouped[group] = append(grouped[group], source)
+	}
+
+	for _, group := range groups {
+		var lines []string
+		for _, source := range grouped[group] {
+			lines = append(lines, messages[source])
+		}
+		// 同一组的源路由相同，多个源合并时告警不属于单个源
+		source := ""
+		if len(grouped[group]) == 1 {
+			source = grouped[group][0]
+		}
+		sendRoutedAlert(proj, alertTargets(proj, grouped[group][0]), source, title, strings.Join(lines, "\n"))
+	}
+}
+
+// postJSON 以JSON格式POST数据，供各告警渠道使用
+func postJSON(url string, v interface{}, header map[string]string) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化告警失败: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	return doNotifyRequest(req)
+}
+
+// doNotifyRequest 发送告警请求，状态码不是2xx时返回错误
+func doNotifyRequest(req *http.Request) error {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// getNotifyPriority 读取告警渠道的优先级配置，超出范围时使用默认值
+func getNotifyPriority(proj *project, key string, defaultValue, min, max int) int {
+	priorityStr := proj.Getenv(key)
+	if priorityStr == "" {
+		return defaultValue
+	}
+	priority, err := strconv.Atoi(priorityStr)
+	if err != nil || priority < min || priority > max {
+		fmt.Printf("警告: %s格式错误: %s，使用默认值 %d\n", key, priorityStr, defaultValue)
+		return defaultValue
+	}
+	return priority
+}
@@ -0,0 +1,34 @@
+package main
+
+import "strings"
+
+func init() {
+	registerNotifier("gotify", newGotifyNotifier)
+}
+
+// gotifyNotifier 通过Gotify服务推送告警，GOTIFY_TOKEN为应用令牌
+type gotifyNotifier struct {
+	url      string
+	token    string
+	priority int
+}
+
+func newGotifyNotifier(proj *project) notifier {
+	url, token := proj.Getenv("GOTIFY_URL"), proj.Getenv("GOTIFY_TOKEN")
+	if url == "" || token == "" {
+		return nil
+	}
+	return &gotifyNotifier{
+		url:      strings.TrimRight(url, "/"),
+		token:    token,
+		priority: getNotifyPriority(proj, "GOTIFY_PRIORITY", 8, 0, 10),
+	}
+}
+
+func (n *gotifyNotifier) Notify(a alert) error {
+	return postJSON(n.url+"/message", map[string]interface{}{
+		"title":    a.subject(),
+		"message":  a.Message,
+		"priority": n.priority,
+	}, map[string]string{"X-Gotify-Key": n.token})
+}
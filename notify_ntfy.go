@@ -0,0 +1,47 @@
+package main
+
+import "strings"
+
+func init() {
+	registerNotifier("ntfy", newNtfyNotifier)
+}
+
+// ntfyNotifier 通过ntfy.sh（或自建ntfy服务）推送告警
+type ntfyNotifier struct {
+	url      string
+	topic    string
+	token    string
+	priority int
+}
+
+func newNtfyNotifier(proj *project) notifier {
+	topic := proj.Getenv("NTFY_TOPIC")
+	if topic == "" {
+		return nil
+	}
+	url := proj.Getenv("NTFY_URL")
+	if url == "" {
+		url = "https://ntfy.sh"
+	}
+	return &ntfyNotifier{
+		url:      strings.TrimRight(url, "/"),
+		topic:    topic,
+		token:    proj.Getenv("NTFY_TOKEN"),
+		priority: getNotifyPriority(proj, "NTFY_PRIORITY", 4, 1, 5),
+	}
+}
+
+// Notify 使用JSON发布接口，标题和内容可以包含中文
+func (n *ntfyNotifier) Notify(a alert) error {
+	var header map[string]string
+	if n.token != "" {
+		header = map[string]string{"Authorization": "Bearer " + n.token}
+	}
+	return postJSON(n.url, map[string]interface{}{
+		"topic":    n.topic,
+		"title":    a.subject(),
+		"message":  a.Message,
+		"priority": n.priority,
+		"tags":     []string{"floppy_disk"},
+	}, header)
+}
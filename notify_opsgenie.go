@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+func init() {
+	registerNotifier("opsgenie", newOpsgenieNotifier)
+}
+
+// opsgenieNotifier 通过Opsgenie Alert API创建和关闭告警，使用alias去重
+type opsgenieNotifier struct {
+	url    string
+	apiKey string
+}
+
+func newOpsgenieNotifier(proj *project) notifier {
+	apiKey := proj.Getenv("OPSGENIE_API_KEY")
+	if apiKey == "" {
+		return nil
+	}
+	apiURL := proj.Getenv("OPSGENIE_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.opsgenie.com"
+	}
+	return &opsgenieNotifier{url: strings.TrimRight(apiURL, "/"), apiKey: apiKey}
+}
+
+// Notify 普通告警不发送到值班系统，只有连续失败才创建事件
+func (n *opsgenieNotifier) Notify(alert) error { return nil }
+
+func (n *opsgenieNotifier) Trigger(key string, a alert) error {
+	message := a.subject()
+	if len([]rune(message)) > 130 {
+		message = string([]rune(message)[:130])
+	}
+	return postJSON(n.url+"/v2/alerts", map[string]interface{}{
+		"message":     message,
+		"alias":       key,
+		"description": a.Message,
+		"source":      "vcpsave",
+		"priority":    "P2",
+	}, n.header())
+}
+
+func (n *opsgenieNotifier) Resolve(key string, a alert) error {
+	closeURL := n.url + "/v2/alerts/" + url.PathEscape(key) + "/close?identifierType=alias"
+	return postJSON(closeURL, map[string]string{"source": "vcpsave", "note": a.Message}, n.header())
+}
+
+func (n *opsgenieNotifier) header() map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + n.apiKey}
+}
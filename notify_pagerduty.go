@@ -0,0 +1,52 @@
+package main
+
+import "time"
+
+func init() {
+	registerNotifier("pagerduty", newPagerDutyNotifier)
+}
+
+// pagerDutyEventsURL PagerDuty Events API v2
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyNotifier 通过PagerDuty Events API创建和解决事件
+type pagerDutyNotifier struct {
+	routingKey string
+}
+
+func newPagerDutyNotifier(proj *project) notifier {
+	routingKey := proj.Getenv("PAGERDUTY_ROUTING_KEY")
+	if routingKey == "" {
+		return nil
+	}
+	return &pagerDutyNotifier{routingKey: routingKey}
+}
+
+// Notify 普通告警不发送到值班系统，只有连续失败才创建事件
+func (n *pagerDutyNotifier) Notify(alert) error { return nil }
+
+func (n *pagerDutyNotifier) Trigger(key string, a alert) error {
+	return n.send("trigger", key, map[string]interface{}{
+		"summary":   a.subject() + ": " + a.Message,
+		"source":    "vcpsave",
+		"severity":  "error",
+		"timestamp": a.Time.Format(time.RFC3339),
+	})
+}
+
+func (n *pagerDutyNotifier) Resolve(key string, a alert) error {
+	return n.send("resolve", key, nil)
+}
+
+// send 发送事件，dedup_key 相同的事件由PagerDuty合并
+func (n *pagerDutyNotifier) send(action, key string, payload map[string]interface{}) error {
+	event := map[string]interface{}{
+		"routing_key":  n.routingKey,
+		"event_action": action,
+		"dedup_key":    key,
+	}
+	if payload != nil {
+		event["payload"] = payload
+	}
+	return postJSON(pagerDutyEventsURL, event, nil)
+}
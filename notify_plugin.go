@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+func init() {
+	registerNotifier("plugin", newPluginNotifier)
+}
+
+// pluginNotifier 将告警作为 alert 事件发送给PLUGIN_HOOKS中的事件插件
+type pluginNotifier struct {
+	hooks []string
+}
+
+func newPluginNotifier(proj *project) notifier {
+	hooks := getHookPlugins(proj)
+	if len(hooks) == 0 {
+		return nil
+	}
+	return &pluginNotifier{hooks: hooks}
+}
+
+func (n *pluginNotifier) Notify(a alert) error {
+	sendHookEvent(n.hooks, hookEvent{Type: "alert", Project: a.Project, Time: time.Now().Format(time.RFC3339), Data: map[string]string{
+		"title":   a.Title,
+		"message": a.Message,
+		"source":  a.Source,
+		"time":    a.Time.Format(time.RFC3339),
+	}})
+	return nil
+}
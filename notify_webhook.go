@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+func init() {
+	registerNotifier("webhook", newWebhookNotifier)
+}
+
+// webhookNotifier 以JSON格式将告警POST到ALERT_WEBHOOK_URL
+type webhookNotifier struct {
+	url string
+}
+
+func newWebhookNotifier(proj *project) notifier {
+	url := proj.Getenv("ALERT_WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+	return &webhookNotifier{url: url}
+}
+
+func (n *webhookNotifier) Notify(a alert) error {
+	return postJSON(n.url, map[string]string{
+		"project": a.Project,
+		"source":  a.Source,
+		"title":   a.Title,
+		"message": a.Message,
+		"time":    a.Time.Format(time.RFC3339),
+	}, nil)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// 华为云OBS：OBS兼容S3接口（虚拟主机形式地址 + SigV4签名），与阿里云OSS相同直接复用s3Transport，
+// 备份、列出、清理逻辑与COS、S3相同。
+
+// getOBSConfig 读取华为云OBS的配置，转换为S3兼容存储的连接配置
+func getOBSConfig(getenv func(string) string) (*s3Config, error) {
+	region := getenv("OBS_REGION")
+	if region == "" {
+		return nil, fmt.Errorf("OBS区域未配置，请设置OBS_REGION，例如 cn-north-4（华北-北京四）")
+	}
+
+	// 默认使用公网地址，华为云专属云、Stack等私有部署需要配置OBS_ENDPOINT
+	endpoint := getenv("OBS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://obs.%s.myhuaweicloud.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("OBS_ENDPOINT格式错误: %s", endpoint)
+	}
+
+	cfg := &s3Config{
+		endpoint:      u,
+		region:        region,
+		bucket:        getenv("OBS_BUCKET"),
+		accessKey:     getenv("OBS_ACCESS_KEY_ID"),
+		secretKey:     getenv("OBS_SECRET_ACCESS_KEY"),
+		virtualHosted: true,
+	}
+	if cfg.bucket == "" {
+		return nil, fmt.Errorf("桶名称未配置，请设置OBS_BUCKET")
+	}
+	if cfg.accessKey == "" || cfg.secretKey == "" {
+		return nil, fmt.Errorf("OBS密钥未配置，请设置OBS_ACCESS_KEY_ID和OBS_SECRET_ACCESS_KEY")
+	}
+	return cfg, nil
+}
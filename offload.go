@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// getOffloadMode 获取源的归档后处理方式（SOURCE_OFFLOAD，源名称:方式）：
+// delete 删除已上传的源文件，trash 移到本地回收目录，未配置时返回空字符串
+func getOffloadMode(proj *project, sourcePath string) string {
+	mode := parseKeyValueList(proj.Getenv("SOURCE_OFFLOAD"))[sourceName(sourcePath)]
+	switch mode {
+	case "", "delete", "trash":
+		return mode
+	default:
+		fmt.Printf("警告: %s 的SOURCE_OFFLOAD格式错误: %s，不删除源文件\n", sourcePath, mode)
+		return ""
+	}
+}
+
+// getOffloadTrashDir 获取trash方式的本地回收目录
+func getOffloadTrashDir(proj *project) string {
+	if dir := proj.Getenv("OFFLOAD_TRASH_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(getDataDir(), "trash")
+}
+
+// fileSHA256 计算本地文件的SHA-256（hex）
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("计算SHA-256失败: %v", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("计算SHA-256失败: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileChecksum 计算本地文件的大小、SHA-256、CRC64和MD5，用于上传后的链式校验
+func fileChecksum(path string) (*checksumReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("计算校验和失败: %v", err)
+	}
+	defer file.Close()
+
+	checksum := newChecksumReader(file)
+	if _, err := io.Copy(io.Discard, checksum); err != nil {
+		return nil, fmt.Errorf("计算校验和失败: %v", err)
+	}
+	return checksum, nil
+}
+
+// verifyUploadChain 在删除本地文件前逐步校验上传结果，任一步失败即返回错误：
+// 对象可以HEAD读取 → 大小一致 → 哈希一致（优先比对COS的CRC64，其次单次上传ETag的MD5，
+// 存储端都不提供时下载比对SHA-256）。每一步的结果都输出日志，成功时返回对象大小
+func verifyUploadChain(client *cos.Client, key string, local *checksumReader) (int64, error) {
+	resp, err := client.Object.Head(context.Background(), key, nil)
+	if err != nil {
+		fmt.Printf("校验 %s: HEAD失败: %v\n", key, err)
+		return 0, fmt.Errorf("对象不可读取: %v", err)
+	}
+	fmt.Printf("校验 %s: HEAD成功\n", key)
+
+	if resp.ContentLength != local.size {
+		fmt.Printf("校验 %s: 大小不一致: 本地 %d, 存储桶 %d\n", key, local.size, resp.ContentLength)
+		return 0, fmt.Errorf("大小不一致: 本地 %d, 存储桶 %d", local.size, resp.ContentLength)
+	}
+	fmt.Printf("校验 %s: 大小一致 (%d bytes)\n", key, local.size)
+
+	if remoteCRC := resp.Header.Get("x-cos-hash-crc64ecma"); remoteCRC != "" {
+		if remoteCRC != local.CRC64() {
+			fmt.Printf("校验 %s: CRC64不一致: 本地 %s, 存储桶 %s\n", key, local.CRC64(), remoteCRC)
+			return 0, fmt.Errorf("CRC64不一致: 本地 %s, 存储桶 %s", local.CRC64(), remoteCRC)
+		}
+		fmt.Printf("校验 %s: CRC64一致 (%s)\n", key, remoteCRC)
+		return local.size, nil
+	}
+
+	// 分块上传和部分加密方式的ETag不是内容MD5，不一致时改为下载校验
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if etag != "" && !strings.Contains(etag, "-") && strings.EqualFold(etag, local.MD5()) {
+		fmt.Printf("校验 %s: ETag与MD5一致 (%s)\n", key, etag)
+		return local.size, nil
+	}
+
+	if err := verifyUploadedObject(client, key, local.SHA256()); err != nil {
+		return 0, err
+	}
+	return local.size, nil
+}
+
+// verifyUploadedObject 重新下载对象并校验SHA-256，确认存储桶中的备份完整可读
+func verifyUploadedObject(client *cos.Client, key, expectedSHA256 string) error {
+	if expectedSHA256 == "" {
+		return fmt.Errorf("缺少上传内容的SHA-256，无法校验")
+	}
+
+	// 先确认对象可以HEAD读取，再下载完整内容
+	if _, err := client.Object.Head(context.Background(), key, nil); err != nil {
+		fmt.Printf("校验 %s: HEAD失败: %v\n", key, err)
+		return fmt.Errorf("对象不可读取: %v", err)
+	}
+
+	resp, err := client.Object.Get(context.Background(), key, nil)
+	if err != nil {
+		fmt.Printf("校验 %s: 下载失败: %v\n", key, err)
+		return fmt.Errorf("下载备份失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checksum := newChecksumReader(resp.Body)
+	if _, err := io.Copy(io.Discard, checksum); err != nil {
+		fmt.Printf("校验 %s: 下载失败: %v\n", key, err)
+		return fmt.Errorf("下载备份失败: %v", err)
+	}
+	if actual := checksum.SHA256(); actual != expectedSHA256 {
+		fmt.Printf("校验 %s: SHA-256不一致: 本地 %s, 存储桶 %s\n", key, expectedSHA256, actual)
+		return fmt.Errorf("SHA-256不一致: 上传 %s, 存储桶 %s", expectedSHA256, actual)
+	}
+	fmt.Printf("校验 %s: SHA-256一致 (%s)\n", key, expectedSHA256)
+	return nil
+}
+
+// moveFile 移动文件，跨文件系统时复制后删除
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// offloadArchivedFiles 校验上传结果后删除（或移到回收目录）已归档的源文件。
+// 只有下载校验通过才会处理；归档后大小或修改时间发生变化的文件保留
+func offloadArchivedFiles(client *cos.Client, proj *project, mode string, result *backupResult, sourceRoot string, archived []archivedFile) {
+	if len(archived) == 0 {
+		return
+	}
+
+	action := "删除"
+	if mode == "trash" {
+		action = "移走"
+	}
+	fmt.Printf("校验备份后%s %d 个源文件: %s\n", action, len(archived), result.Key)
+	if err := verifyUploadedObject(client, result.Key, result.SHA256); err != nil {
+		fmt.Printf("校验失败，保留全部 %d 个源文件: %s\n", len(archived), result.Key)
+		sendSourceAlert(proj, keySource(result.Key), "归档后删除已跳过", fmt.Sprintf("%s 校验失败，源文件全部保留: %v", result.Key, err))
+		return
+	}
+
+	trashRoot := ""
+	if mode == "trash" {
+		prefix, timeStamp, _ := parseFileName(filepath.Base(result.Key))
+		trashRoot = filepath.Join(getOffloadTrashDir(proj), prefix, timeStamp)
+	}
+
+	processed, kept := 0, 0
+	for _, file := range archived {
+		path := filepath.Join(sourceRoot, file.RelPath)
+		if file.Transformed {
+			fmt.Printf("归档中是转换后的内容，保留: %s\n", path)
+			kept++
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != file.Size || !info.ModTime().Equal(file.ModTime) {
+			fmt.Printf("归档后文件已变化，保留: %s\n", path)
+			kept++
+			continue
+		}
+
+		if mode == "trash" {
+			err = moveFile(path, filepath.Join(trashRoot, file.RelPath))
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			fmt.Printf("警告: 处理源文件失败，保留: %s: %v\n", path, err)
+			kept++
+			continue
+		}
+		fmt.Printf("已%s源文件: %s\n", action, path)
+		processed++
+	}
+
+	if mode == "trash" {
+		fmt.Printf("已将 %d 个源文件移到 %s，保留 %d 个\n", processed, trashRoot, kept)
+	} else {
+		fmt.Printf("已删除 %d 个源文件，保留 %d 个\n", processed, kept)
+	}
+}
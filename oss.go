@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// 阿里云OSS：OSS提供S3兼容接口（虚拟主机形式地址 + SigV4签名），直接复用s3Transport，
+// 备份、列出、清理逻辑与COS、S3相同。
+
+// getOSSConfig 读取阿里云OSS的配置，转换为S3兼容存储的连接配置
+func getOSSConfig(getenv func(string) string) (*s3Config, error) {
+	region := getenv("OSS_REGION")
+	if region == "" {
+		return nil, fmt.Errorf("OSS地域未配置，请设置OSS_REGION，例如 cn-hangzhou")
+	}
+
+	// 默认使用外网地址，同地域的ECS可以配置内网地址 https://oss-<地域>-internal.aliyuncs.com
+	endpoint := getenv("OSS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://oss-%s.aliyuncs.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("OSS_ENDPOINT格式错误: %s", endpoint)
+	}
+
+	cfg := &s3Config{
+		endpoint: u,
+		// SigV4签名中OSS的地域带 oss- 前缀
+		region:    "oss-" + region,
+		bucket:    getenv("OSS_BUCKET"),
+		accessKey: getenv("OSS_ACCESS_KEY_ID"),
+		secretKey: getenv("OSS_ACCESS_KEY_SECRET"),
+		// OSS只支持虚拟主机形式的地址
+		virtualHosted: true,
+	}
+	if cfg.bucket == "" {
+		return nil, fmt.Errorf("存储桶名称未配置，请设置OSS_BUCKET")
+	}
+	if cfg.accessKey == "" || cfg.secretKey == "" {
+		return nil, fmt.Errorf("OSS密钥未配置，请设置OSS_ACCESS_KEY_ID和OSS_ACCESS_KEY_SECRET")
+	}
+	return cfg, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// 并行gzip：没有pigz时gzip阶段的Go实现。与pigz相同，输入按块分给多个goroutine分别压缩，
+// 每块以前一块的最后32KB作为字典，压缩结果以同步刷新结束（字节对齐、不是最后一块），按顺序拼接后
+// 追加一个空的结束块，组成一个标准的gzip成员，任何gzip实现都可以解压。
+
+const (
+	// pgzipBlockSize 每块输入的大小
+	pgzipBlockSize = 1 << 20
+	// pgzipDictSize deflate的窗口大小，也是每块使用的字典大小
+	pgzipDictSize = 32 << 10
+)
+
+// pgzipBlock 一块输入的压缩结果
+type pgzipBlock struct {
+	data []byte
+	err  error
+}
+
+// pgzipWriter 并行压缩的gzip写入器
+type pgzipWriter struct {
+	w     io.Writer
+	level int
+
+	buf  []byte // 当前块的输入
+	dict []byte // 上一块的最后32KB
+	crc  uint32
+	size uint32 // 输入大小（模2^32）
+
+	pending chan chan pgzipBlock // 按顺序等待写出的块，容量即并行数
+	done    chan error           // 写出协程结束时的错误
+	aborted chan struct{}        // 中止时关闭，剩余的块不再写出
+	closed  bool
+
+	mu  sync.Mutex
+	err error // 写出协程遇到的第一个错误，Write据此提前返回
+}
+
+// newParallelGzipWriter 创建并行gzip写入器，threads为同时压缩的块数
+func newParallelGzipWriter(w io.Writer, level, threads int) (*pgzipWriter, error) {
+	// 与gzip.Writer相同的文件头：无文件名、无修改时间，操作系统未知
+	xfl := byte(0)
+	switch level {
+	case flate.BestCompression:
+		xfl = 2
+	case flate.BestSpeed:
+		xfl = 4
+	}
+	if _, err := w.Write([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, xfl, 255}); err != nil {
+		return nil, err
+	}
+
+	z := &pgzipWriter{
+		w:       w,
+		level:   level,
+		buf:     make([]byte, 0, pgzipBlockSize),
+		pending: make(chan chan pgzipBlock, threads),
+		done:    make(chan error, 1),
+		aborted: make(chan struct{}),
+	}
+	go z.writeBlocks()
+	return z, nil
+}
+
+// writeBlocks 按顺序写出压缩好的块，出错后继续取出剩余的块，避免压缩协程阻塞
+func (z *pgzipWriter) writeBlocks() {
+	var err error
+	for result := range z.pending {
+		block := <-result
+		select {
+		case <-z.aborted:
+			continue
+		default:
+		}
+		if err == nil {
+			if err = block.err; err == nil {
+				_, err = z.w.Write(block.data)
+			}
+			if err != nil {
+				z.mu.Lock()
+				z.err = err
+				z.mu.Unlock()
+			}
+		}
+	}
+	z.done <- err
+}
+
+// failed 返回写出协程已遇到的错误
+func (z *pgzipWriter) failed() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *pgzipWriter) Write(p []byte) (int, error) {
+	if err := z.failed(); err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+n]
+		p = p[n:]
+		written += n
+		if len(z.buf) == cap(z.buf) {
+			if err := z.flushBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushBlock 将当前块交给压缩协程，已有threads块在压缩时等待；下游已出错时返回错误，不再压缩
+func (z *pgzipWriter) flushBlock() error {
+	if err := z.failed(); err != nil {
+		return err
+	}
+	input, dict := z.buf, z.dict
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, input)
+	z.size += uint32(len(input))
+	if len(input) >= pgzipDictSize {
+		z.dict = input[len(input)-pgzipDictSize:]
+	} else {
+		z.dict = append(append([]byte(nil), dict...), input...)
+		if len(z.dict) > pgzipDictSize {
+			z.dict = z.dict[len(z.dict)-pgzipDictSize:]
+		}
+	}
+	z.buf = make([]byte, 0, pgzipBlockSize)
+
+	result := make(chan pgzipBlock, 1)
+	z.pending <- result
+	go func() {
+		var out bytes.Buffer
+		fw, err := flate.NewWriterDict(&out, z.level, dict)
+		if err == nil {
+			if _, err = fw.Write(input); err == nil {
+				err = fw.Flush()
+			}
+		}
+		result <- pgzipBlock{data: out.Bytes(), err: err}
+	}()
+	return nil
+}
+
+// Abort 丢弃未写出的块并等待压缩协程结束
+func (z *pgzipWriter) Abort() {
+	if z.closed {
+		return
+	}
+	z.closed = true
+	close(z.aborted)
+	close(z.pending)
+	<-z.done
+}
+
+// Close 压缩剩余的输入，写入结束块和gzip尾部（CRC32和输入大小）
+func (z *pgzipWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if len(z.buf) > 0 {
+		// 下游的错误由写出协程返回
+		z.flushBlock()
+	}
+	close(z.pending)
+	if err := <-z.done; err != nil {
+		return err
+	}
+
+	// 空的最后一块（固定哈夫曼编码，只有块结束符）
+	trailer := []byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(trailer[2:], z.crc)
+	binary.LittleEndian.PutUint32(trailer[6:], z.size)
+	_, err := z.w.Write(trailer)
+	return err
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 备份流水线：遍历 → 归档 → 压缩 → 加密 → 暂存 → 上传
+//
+// 归档器负责遍历源路径并写出归档流，之后依次经过各个转换阶段，
+// 最终写入暂存文件交给上传。新的格式或转换只需注册归档器或阶段，无需修改编排代码。
+
+// archiver 遍历源路径并写出归档流
+type archiver interface {
+	// Ext 返回归档文件的扩展名，sourcePath 为源路径
+	Ext(sourcePath string) string
+	// Archive 将源路径写入w，opts 为筛选条件
+	Archive(sourcePath string, w io.Writer, opts *archiveOptions) error
+}
+
+// pipelineStage 流水线中的转换阶段，包装下游写入器
+type pipelineStage interface {
+	// Ext 返回追加到文件名的扩展名
+	Ext() string
+	// Wrap 返回写入上游数据的写入器，Close时需刷新自身但不关闭下游
+	Wrap(w io.Writer) (io.WriteCloser, error)
+}
+
+// metadataStage 需要在对象元数据中记录信息的阶段
+type metadataStage interface {
+	Metadata() map[string]string
+}
+
+// abortableWriter 运行外部进程或协程的阶段写入器，流水线出错时中止并释放资源，不刷新剩余数据
+type abortableWriter interface {
+	Abort()
+}
+
+// stageFactory 根据项目和源名称创建转换阶段
+type stageFactory func(proj *project, sourceName string) (pipelineStage, error)
+
+var (
+	archivers = map[string]archiver{
+		"zip": zipArchiver{},
+		"tar": tarArchiver{},
+		"raw": rawArchiver{},
+	}
+	stageFactories = map[string]stageFactory{
+		"gzip":    newGzipStage,
+		"zstd":    newZstdStage,
+		"encrypt": newEncryptStage,
+	}
+)
+
+// registerArchiver 注册归档器
+func registerArchiver(name string, a archiver) {
+	archivers[name] = a
+}
+
+// registerStage 注册转换阶段
+func registerStage(name string, factory stageFactory) {
+	stageFactories[name] = factory
+}
+
+// pipeline 一个源的备份流水线
+type pipeline struct {
+	names     []string
+	archiver  archiver
+	stages    []pipelineStage
+	options   *archiveOptions
+	defaulted bool // 未配置SOURCE_PIPELINE，归档器为默认选择（包括DEFAULT_PIPELINE）
+}
+
+// getSourcePipeline 获取源配置的流水线，格式为 源名称:归档器+阶段+...，例如 VCPToolBox:tar+gzip+encrypt
+// 未配置时目录使用DEFAULT_PIPELINE（默认zip）、文件直接上传，配置了加密密钥的源追加encrypt阶段
+func getSourcePipeline(proj *project, sourceName string, isDir bool) []string {
+	if spec := parseKeyValueList(proj.Getenv("SOURCE_PIPELINE"))[sourceName]; spec != "" {
+		return splitPipelineSpec(spec)
+	}
+
+	names := []string{"raw"}
+	if isDir {
+		names = []string{"zip"}
+		if spec := proj.Getenv("DEFAULT_PIPELINE"); spec != "" {
+			names = splitPipelineSpec(spec)
+		}
+	}
+	if getSourceKeyID(proj, sourceName) != "" && !containsString(names, "encrypt") {
+		names = append(names, "encrypt")
+	}
+	return names
+}
+
+// splitPipelineSpec 拆分 归档器+阶段+... 格式的流水线
+func splitPipelineSpec(spec string) []string {
+	var names []string
+	for _, name := range strings.Split(spec, "+") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// buildPipeline 根据配置构建源的流水线
+func buildPipeline(proj *project, sourcePath string, isDir bool) (*pipeline, error) {
+	srcName := sourceName(sourcePath)
+	names := getSourcePipeline(proj, srcName, isDir)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("流水线为空: %s", srcName)
+	}
+
+	a, ok := archivers[names[0]]
+	if !ok {
+		return nil, fmt.Errorf("未知的归档器: %s，可用: %s", names[0], strings.Join(sortedKeys(archivers), ", "))
+	}
+	if _, isRaw := a.(rawArchiver); isRaw == isDir {
+		if isDir {
+			return nil, fmt.Errorf("目录不能使用raw归档器: %s", sourcePath)
+		}
+		return nil, fmt.Errorf("文件只能使用raw归档器: %s", sourcePath)
+	}
+
+	options, err := getArchiveOptions(proj, srcName)
+	if err != nil {
+		return nil, err
+	}
+
+	// ARCHIVE_PASSWORD只对zip归档生效，其他格式使用encrypt阶段
+	if password := getArchivePassword(proj); password != "" {
+		if _, isZip := a.(zipArchiver); isZip {
+			options.password = password
+		} else if !containsString(names, "encrypt") {
+			fmt.Printf("警告: ARCHIVE_PASSWORD只对zip归档生效，%s 使用 %s 流水线，备份未加密\n", srcName, strings.Join(names, "+"))
+		}
+	}
+
+	p := &pipeline{names: names, archiver: a, options: options}
+	p.defaulted = parseKeyValueList(proj.Getenv("SOURCE_PIPELINE"))[srcName] == ""
+	for _, name := range names[1:] {
+		factory, ok := stageFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("未知的流水线阶段: %s，可用: %s", name, strings.Join(sortedKeys(stageFactories), ", "))
+		}
+		stage, err := factory(proj, srcName)
+		if err != nil {
+			return nil, fmt.Errorf("创建流水线阶段 %s 失败: %v", name, err)
+		}
+		p.stages = append(p.stages, stage)
+	}
+	return p, nil
+}
+
+// String 返回流水线描述，例如 tar → gzip → encrypt
+func (p *pipeline) String() string {
+	return strings.Join(p.names, " → ")
+}
+
+// Ext 返回流水线输出文件的完整扩展名
+func (p *pipeline) Ext(sourcePath string) string {
+	ext := p.archiver.Ext(sourcePath)
+	for _, stage := range p.stages {
+		ext += stage.Ext()
+	}
+	return ext
+}
+
+// isPassthrough 检查流水线是否不做任何处理，此时可以直接上传源文件
+func (p *pipeline) isPassthrough() bool {
+	_, isRaw := p.archiver.(rawArchiver)
+	return isRaw && len(p.stages) == 0 && len(p.options.transforms) == 0
+}
+
+// Metadata 汇总各阶段需要记录的对象元数据
+func (p *pipeline) Metadata() map[string]string {
+	metadata := make(map[string]string)
+	for _, stage := range p.stages {
+		if ms, ok := stage.(metadataStage); ok {
+			for k, v := range ms.Metadata() {
+				metadata[k] = v
+			}
+		}
+	}
+	return metadata
+}
+
+// Run 将源路径依次经过归档和各转换阶段写入w
+func (p *pipeline) Run(sourcePath string, w io.Writer) error {
+	// 从最后一个阶段开始向上游包装写入器
+	closers := make([]io.Closer, len(p.stages))
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		wrapped, err := p.stages[i].Wrap(w)
+		if err != nil {
+			abortStages(closers[i+1:])
+			return err
+		}
+		closers[i] = wrapped
+		w = wrapped
+	}
+
+	if err := p.archiver.Archive(sourcePath, w, p.options); err != nil {
+		abortStages(closers)
+		return err
+	}
+
+	// 从上游到下游依次关闭，确保每个阶段的缓冲数据都被刷新
+	for i, c := range closers {
+		if err := c.Close(); err != nil {
+			abortStages(closers[i+1:])
+			return err
+		}
+	}
+	return nil
+}
+
+// abortStages 流水线出错时释放各阶段：先从下游开始中止外部进程，上游阶段写出剩余数据时立即出错而不会阻塞，
+// 其他阶段直接关闭，错误忽略
+func abortStages(closers []io.Closer) {
+	for i := len(closers) - 1; i >= 0; i-- {
+		if a, ok := closers[i].(abortableWriter); ok {
+			a.Abort()
+		} else {
+			closers[i].Close()
+		}
+	}
+}
+
+// runToFile 运行流水线并将输出写入本地文件
+func (p *pipeline) runToFile(ctx context.Context, sourcePath, target string) error {
+	file, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("创建暂存文件失败: %v", err)
+	}
+	defer file.Close()
+
+	if err := p.Run(sourcePath, &ctxWriter{ctx: ctx, w: loadThrottled(ctx, file)}); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ctxWriter 在ctx取消后拒绝写入，用于中止正在进行的归档
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// ZIP格式（不含ZIP64扩展）的限制，超过时需要ZIP64
+const (
+	zip32MaxEntries = 65535
+	zip32MaxSize    = 1<<32 - 1
+)
+
+// getZip64Policy 获取源数据超过ZIP格式限制时的处理方式：tar（默认）未配置流水线的源改用tar归档，
+// zip64 继续生成ZIP64归档
+func getZip64Policy(proj *project) string {
+	policy := proj.Getenv("ZIP64_POLICY")
+	switch policy {
+	case "tar", "zip64":
+		return policy
+	case "":
+		return "tar"
+	default:
+		fmt.Printf("警告: ZIP64_POLICY格式错误: %s，使用默认值 tar\n", policy)
+		return "tar"
+	}
+}
+
+// checkZip64 源数据超过4GB或65535个条目（文件和目录都占一个条目）时，ZIP归档需要ZIP64扩展。
+// 流式写入的ZIP64归档只在中央目录中记录64位大小，部分解压工具（旧版unzip、Windows资源管理器、
+// 流式解压库）无法正确打开，因此未配置流水线的源改用tar归档，显式配置了zip的源输出警告
+func (p *pipeline) checkZip64(proj *project, entries int, size int64) {
+	if _, isZip := p.archiver.(zipArchiver); !isZip || (entries < zip32MaxEntries && size < zip32MaxSize) {
+		return
+	}
+
+	// 设置了密码的zip改用tar会丢失加密，继续使用ZIP64
+	if p.defaulted && getZip64Policy(proj) == "tar" && p.options.password == "" {
+		fmt.Printf("源数据超过ZIP格式的限制（%d 个条目, %d bytes），改用tar归档\n", entries, size)
+		p.archiver = archivers["tar"]
+		p.names[0] = "tar"
+		return
+	}
+	fmt.Printf("警告: 源数据超过ZIP格式的限制（%d 个条目, %d bytes），将生成ZIP64归档，"+
+		"部分解压工具可能无法打开，可使用 vcpsave extract 解压\n", entries, size)
+}
+
+// zipArchiver 将目录压缩为ZIP
+type zipArchiver struct{}
+
+func (zipArchiver) Ext(string) string { return ".zip" }
+
+func (zipArchiver) Archive(source string, w io.Writer, opts *archiveOptions) error {
+	zipWriter := zip.NewWriter(w)
+
+	// 遍历源文件夹
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// 计算相对路径
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
+			return fmt.Errorf("计算相对路径失败: %v", err)
+		}
+
+		// 跳过根目录本身
+		if relPath == "." {
+			return nil
+		}
+
+		// 跳过带有排除标记的条目和不满足筛选条件的文件
+		if skip, err := opts.skipEntry(path, relPath, info); skip {
+			return err
+		}
+
+		// 创建ZIP文件头
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("创建文件头失败: %v", err)
+		}
+
+		// 设置ZIP文件头中的路径，ZIP规定使用斜杠分隔
+		header.Name = opts.memberName(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		// UTF-8文件名设置UTF-8标志（通用标志第11位），解压工具按UTF-8显示中文文件名；
+		// 无法转换的旧编码文件名原样写入，不设置该标志
+		header.NonUTF8 = !utf8.ValidString(header.Name)
+
+		// Windows文件属性（只读、隐藏等）保存在外部属性的低字节
+		header.ExternalAttrs |= dosFileAttrs(path)
+
+		// 匹配转换规则的文件先转换，出错时不写入条目
+		var content []byte
+		var transformed bool
+		if !info.IsDir() {
+			if content, transformed, err = opts.transformFile(path, relPath); err != nil {
+				return err
+			}
+		}
+
+		// 创建文件写入器，设置了密码时文件内容按WinZip AES加密
+		var writer io.Writer
+		var encrypted io.WriteCloser
+		if opts.password != "" && !info.IsDir() {
+			encrypted, err = createAESEntry(zipWriter, header, opts.password)
+			writer = encrypted
+		} else {
+			writer, err = zipWriter.CreateHeader(header)
+		}
+		if err != nil {
+			return fmt.Errorf("创建ZIP写入器失败: %v", err)
+		}
+
+		// 如果是文件，复制文件内容
+		if !info.IsDir() {
+			if err := opts.writeFile(writer, path, relPath, content, transformed); err != nil {
+				return err
+			}
+			if encrypted != nil {
+				if err := encrypted.Close(); err != nil {
+					return fmt.Errorf("写入加密条目失败: %v", err)
+				}
+			}
+			opts.record(relPath, info, transformed)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("写入ZIP目录失败: %v", err)
+	}
+	return nil
+}
+
+// tarArchiver 将目录打包为tar
+type tarArchiver struct{}
+
+func (tarArchiver) Ext(string) string { return ".tar" }
+
+func (tarArchiver) Archive(source string, w io.Writer, opts *archiveOptions) error {
+	tarWriter := tar.NewWriter(w)
+
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
+			return fmt.Errorf("计算相对路径失败: %v", err)
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		// 跳过带有排除标记的条目和不满足筛选条件的文件
+		if skip, err := opts.skipEntry(path, relPath, info); skip {
+			return err
+		}
+
+		// 只打包普通文件和目录
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			fmt.Printf("跳过特殊文件: %s\n", path)
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("创建文件头失败: %v", err)
+		}
+		header.Name = opts.memberName(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		// 平台相关的文件属性、ACL和扩展属性写入PAX扩展记录
+		records, err := readFileAttrs(path)
+		if err != nil {
+			fmt.Printf("警告: %s: %v\n", path, err)
+		}
+		if len(records) > 0 {
+			header.PAXRecords = records
+			header.Format = tar.FormatPAX
+		}
+
+		// 转换后的内容大小可能不同，需要在写入文件头之前转换
+		var content []byte
+		var transformed bool
+		if !info.IsDir() {
+			if content, transformed, err = opts.transformFile(path, relPath); err != nil {
+				return err
+			}
+			if transformed {
+				header.Size = int64(len(content))
+			}
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("写入tar文件头失败: %v", err)
+		}
+
+		if !info.IsDir() {
+			if err := opts.writeFile(tarWriter, path, relPath, content, transformed); err != nil {
+				return err
+			}
+			opts.record(relPath, info, transformed)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("写入tar结尾失败: %v", err)
+	}
+	return nil
+}
+
+// rawArchiver 单个文件不归档，原样输出
+type rawArchiver struct{}
+
+func (rawArchiver) Ext(sourcePath string) string { return filepath.Ext(sourcePath) }
+
+func (rawArchiver) Archive(source string, w io.Writer, opts *archiveOptions) error {
+	content, transformed, err := opts.transformFile(source, filepath.Base(source))
+	if err != nil {
+		return err
+	}
+	return opts.writeFile(w, source, filepath.Base(source), content, transformed)
+}
+
+// copyFileTo 将文件内容复制到w
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("复制文件内容失败: %v", err)
+	}
+	return nil
+}
+
+// getGzipLevel 获取gzip阶段的压缩级别（1-9），未配置时使用默认级别
+func getGzipLevel(proj *project) int {
+	levelStr := proj.Getenv("GZIP_LEVEL")
+	if levelStr == "" {
+		return gzip.DefaultCompression
+	}
+	level, err := strconv.Atoi(levelStr)
+	if err != nil || level < gzip.BestSpeed || level > gzip.BestCompression {
+		fmt.Printf("警告: GZIP_LEVEL格式错误: %s，使用默认级别\n", levelStr)
+		return gzip.DefaultCompression
+	}
+	return level
+}
+
+// gzipStage gzip压缩阶段，可用时使用pigz，否则使用Go实现并行压缩
+type gzipStage struct {
+	level   int
+	pigz    string // pigz路径，为空时使用Go实现
+	threads int
+}
+
+func newGzipStage(proj *project, _ string) (pipelineStage, error) {
+	pigz, err := findGzipCompressor(proj)
+	if err != nil {
+		return nil, err
+	}
+	return gzipStage{level: getGzipLevel(proj), pigz: pigz, threads: getCompressThreads(proj)}, nil
+}
+
+func (gzipStage) Ext() string { return ".gz" }
+
+func (s gzipStage) Wrap(w io.Writer) (io.WriteCloser, error) {
+	if s.pigz != "" {
+		args := []string{"-c", "-p", strconv.Itoa(s.threads)}
+		if s.level != gzip.DefaultCompression {
+			args = append(args, "-"+strconv.Itoa(s.level))
+		}
+		cw, err := startCompressor(s.pigz, args, w)
+		if err == nil {
+			fmt.Printf("使用pigz压缩，%d 个线程\n", s.threads)
+			return cw, nil
+		}
+		fmt.Printf("警告: %v，改用Go实现压缩\n", err)
+	}
+	if s.threads > 1 {
+		return newParallelGzipWriter(w, s.level, s.threads)
+	}
+	return gzip.NewWriterLevel(w, s.level)
+}
+
+// encryptStage 使用源配置的密钥加密
+type encryptStage struct {
+	keyID string
+	key   []byte
+}
+
+func newEncryptStage(proj *project, sourceName string) (pipelineStage, error) {
+	keyID := getSourceKeyID(proj, sourceName)
+	if keyID == "" {
+		return nil, fmt.Errorf("源 %s 未在SOURCE_ENCRYPTION_KEYS中配置密钥", sourceName)
+	}
+
+	keys, err := getEncryptionKeys(proj)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("未配置密钥: %s", keyID)
+	}
+	return &encryptStage{keyID: keyID, key: key}, nil
+}
+
+func (s *encryptStage) Ext() string { return ".enc" }
+
+func (s *encryptStage) Wrap(w io.Writer) (io.WriteCloser, error) {
+	return newEncryptWriter(w, s.keyID, s.key)
+}
+
+func (s *encryptStage) Metadata() map[string]string {
+	return map[string]string{"vcpsave-key-id": s.keyID}
+}
+
+// sortedKeys 返回排序后的map键，用于错误提示
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
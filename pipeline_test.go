@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout 返回f执行期间写入标准输出的内容
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+func TestCheckZip64(t *testing.T) {
+	tests := []struct {
+		name        string
+		archiver    string
+		defaulted   bool
+		policy      string
+		password    string
+		entries     int
+		size        int64
+		wantArchive string
+		wantWarning bool
+	}{
+		{name: "未超过限制", archiver: "zip", defaulted: true, entries: 100, size: 1 << 20, wantArchive: "zip"},
+		{name: "刚好低于限制", archiver: "zip", defaulted: true, entries: zip32MaxEntries - 1, size: zip32MaxSize - 1, wantArchive: "zip"},
+		{name: "条目数超过限制改用tar", archiver: "zip", defaulted: true, entries: zip32MaxEntries, size: 1 << 20, wantArchive: "tar"},
+		{name: "大小超过4GB改用tar", archiver: "zip", defaulted: true, entries: 10, size: 5 << 30, wantArchive: "tar"},
+		{name: "显式配置tar策略", archiver: "zip", defaulted: true, policy: "tar", entries: 70000, size: 1 << 20, wantArchive: "tar"},
+		{name: "zip64策略继续使用ZIP64", archiver: "zip", defaulted: true, policy: "zip64", entries: 70000, size: 1 << 20, wantArchive: "zip", wantWarning: true},
+		{name: "显式配置的zip只警告", archiver: "zip", defaulted: false, entries: 70000, size: 1 << 20, wantArchive: "zip", wantWarning: true},
+		{name: "设置了密码只警告", archiver: "zip", defaulted: true, password: "secret", entries: 10, size: 5 << 30, wantArchive: "zip", wantWarning: true},
+		{name: "tar不受限制", archiver: "tar", defaulted: true, entries: 70000, size: 5 << 30, wantArchive: "tar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ZIP64_POLICY", tt.policy)
+			p := &pipeline{
+				archiver:  archivers[tt.archiver],
+				names:     []string{tt.archiver},
+				defaulted: tt.defaulted,
+				options:   &archiveOptions{password: tt.password},
+			}
+			output := captureStdout(t, func() {
+				p.checkZip64(&project{Name: "test"}, tt.entries, tt.size)
+			})
+
+			if got := p.names[0]; got != tt.wantArchive {
+				t.Errorf("归档器 = %s，应为 %s", got, tt.wantArchive)
+			}
+			if _, isZip := p.archiver.(zipArchiver); isZip != (tt.wantArchive == "zip") {
+				t.Errorf("归档器类型 = %T，应为 %s", p.archiver, tt.wantArchive)
+			}
+			if got := strings.Contains(output, "将生成ZIP64归档"); got != tt.wantWarning {
+				t.Errorf("ZIP64警告 = %v，应为 %v，输出: %q", got, tt.wantWarning, output)
+			}
+		})
+	}
+}
+
+// TestPreviewSourceEntries 目录和文件都计入归档条目数
+func TestPreviewSourceEntries(t *testing.T) {
+	source := t.TempDir()
+	for d := 0; d < 10; d++ {
+		dir := filepath.Join(source, fmt.Sprintf("d%d", d), "sub")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, entries, size := (&archiveOptions{}).previewSource(source)
+	if files != 10 || entries != 30 || size != 40 {
+		t.Errorf("previewSource = (%d, %d, %d)，应为 (10, 30, 40)", files, entries, size)
+	}
+}
+
+// TestRestoreManyEntries 超过65535个条目的源备份后能完整恢复。需要创建大量文件，
+// 设置 VCPSAVE_SLOW_TESTS=1 时才运行
+func TestRestoreManyEntries(t *testing.T) {
+	if testing.Short() || os.Getenv("VCPSAVE_SLOW_TESTS") == "" {
+		t.Skip("创建大量文件较慢，设置 VCPSAVE_SLOW_TESTS=1 运行")
+	}
+
+	source := t.TempDir()
+	const dirs, filesPerDir = 100, 656 // 65600个文件，超过ZIP的65535个条目
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(source, fmt.Sprintf("d%03d", d))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < filesPerDir; f++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d.txt", f)), []byte(fmt.Sprintf("%d/%d", d, f)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		policy      string
+		wantArchive string
+	}{
+		{policy: "tar", wantArchive: "tar"},
+		{policy: "zip64", wantArchive: "zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			t.Setenv("ZIP64_POLICY", tt.policy)
+			proj := &project{Name: "test"}
+			p, err := buildPipeline(proj, source, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, entries, size := p.options.previewSource(source)
+			if files != dirs*filesPerDir || entries != dirs*(filesPerDir+1) {
+				t.Fatalf("源文件数 = %d，条目数 = %d，应为 %d 和 %d", files, entries, dirs*filesPerDir, dirs*(filesPerDir+1))
+			}
+			p.checkZip64(proj, entries, size)
+			if p.names[0] != tt.wantArchive {
+				t.Fatalf("归档器 = %s，应为 %s", p.names[0], tt.wantArchive)
+			}
+
+			archivePath := filepath.Join(t.TempDir(), "backup"+p.Ext(source))
+			if err := p.runToFile(context.Background(), source, archivePath); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			dest := t.TempDir()
+			e := &extractor{dest: dest}
+			if err := e.extractStream(bufio.NewReader(file), filepath.Base(archivePath)); err != nil {
+				t.Fatal(err)
+			}
+			if e.files != dirs*filesPerDir {
+				t.Fatalf("恢复的文件数 = %d，应为 %d", e.files, dirs*filesPerDir)
+			}
+			for _, name := range []string{"d000/f000.txt", "d050/f321.txt", "d099/f655.txt"} {
+				want, _ := os.ReadFile(filepath.Join(source, name))
+				got, err := os.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(got) != string(want) {
+					t.Errorf("%s 内容 = %q，应为 %q (%v)", name, got, want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+// Package backup 提供可嵌入其他Go程序的备份和清理接口，
+// 无需调用vcpsave可执行文件即可将目录或文件备份到对象存储，并按保留策略清理过期备份。
+//
+//	store := storage.NewCOS("examplebucket-1250000000", "ap-guangzhou", secretID, secretKey)
+//	result, err := backup.Run(ctx, store, backup.Options{Source: "/data/VCPToolBox", TargetDir: "backups"})
+//	deleted, err := backup.Cleanup(ctx, store, "backups", retention.Policy{MaxAge: 7 * 24 * time.Hour})
+//
+// 生成的备份与vcpsave命令行工具的命名格式相同，两者可以共用同一个目标目录。
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"vcpsave/pkg/retention"
+	"vcpsave/pkg/storage"
+)
+
+// Options 一次备份的选项
+type Options struct {
+	// Source 要备份的目录或文件。目录打包为zip，文件原样上传
+	Source string
+	// TargetDir 存储中的目标目录，空字符串表示根目录
+	TargetDir string
+	// Location 文件名时间戳的时区，nil表示本地时区
+	Location *time.Location
+	// Exclude 跳过的文件或目录名（与Base名称比较，支持filepath.Match通配符）
+	Exclude []string
+}
+
+// Result 一次备份的结果
+type Result struct {
+	Key   string // 上传后的对象键
+	Size  int64  // 上传的字节数
+	Files int    // 打包的文件数，备份单个文件时为1
+}
+
+// objectKey 拼接目标目录和文件名得到对象键
+func objectKey(targetDir, fileName string) string {
+	if cleanDir := strings.Trim(targetDir, "/"); cleanDir != "" {
+		return cleanDir + "/" + fileName
+	}
+	return fileName
+}
+
+// FileName 返回源在t时刻的备份文件名：目录为 名称_时间戳.zip，文件为 名称_时间戳.原扩展名
+func FileName(source string, isDir bool, t time.Time, loc *time.Location) string {
+	name := filepath.Base(source)
+	ext := ".zip"
+	if !isDir {
+		ext = filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext)
+	}
+	return fmt.Sprintf("%s_%s%s", name, retention.FormatTimeStamp(t, loc), ext)
+}
+
+// Run 备份一个目录或文件并上传到存储
+func Run(ctx context.Context, store storage.Storage, opts Options) (*Result, error) {
+	info, err := os.Stat(opts.Source)
+	if err != nil {
+		return nil, fmt.Errorf("读取源失败: %v", err)
+	}
+
+	key := objectKey(opts.TargetDir, FileName(opts.Source, info.IsDir(), time.Now(), opts.Location))
+	if !info.IsDir() {
+		if err := store.PutFile(ctx, key, opts.Source); err != nil {
+			return nil, err
+		}
+		return &Result{Key: key, Size: info.Size(), Files: 1}, nil
+	}
+
+	tmpFile, err := os.CreateTemp("", "vcpsave-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	files, err := writeZip(ctx, tmpFile, opts.Source, opts.Exclude)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("打包目录失败: %v", err)
+	}
+
+	zipInfo, err := os.Stat(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.PutFile(ctx, key, tmpPath); err != nil {
+		return nil, err
+	}
+	return &Result{Key: key, Size: zipInfo.Size(), Files: files}, nil
+}
+
+// excluded 检查文件或目录名是否匹配排除规则
+func excluded(name string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// writeZip 将目录打包为zip写入w，返回打包的文件数
+func writeZip(ctx context.Context, w io.Writer, dir string, exclude []string) (int, error) {
+	zw := zip.NewWriter(w)
+	files := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if path == dir {
+			return nil
+		}
+		if excluded(info.Name(), exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err := zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+
+		writer, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(writer, file); err != nil {
+			return err
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return files, zw.Close()
+}
+
+// Cleanup 删除目标目录中按保留策略已过期的备份，返回已删除的对象键。
+// 只处理目标目录下一层的备份文件，子目录（包括vcpsave的 .vcpsave 元数据目录）中的对象不会被删除
+func Cleanup(ctx context.Context, store storage.Storage, targetDir string, policy retention.Policy) ([]string, error) {
+	prefix := objectKey(targetDir, "")
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNames []string
+	for _, object := range objects {
+		fileName := strings.TrimPrefix(object.Key, prefix)
+		if fileName != "" && !strings.Contains(fileName, "/") {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+
+	var deleted []string
+	for _, fileName := range policy.Select(fileNames, time.Now()) {
+		key := objectKey(targetDir, fileName)
+		if err := store.Delete(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted, nil
+}
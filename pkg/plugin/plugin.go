@@ -0,0 +1,78 @@
+// Package plugin 实现与外部可执行文件插件通信的stdio JSON协议。
+//
+// 每次调用启动一次插件进程：向标准输入写入一个JSON请求后关闭标准输入，
+// 插件向标准输出写入一个JSON响应后退出。插件的标准错误输出原样转发，用于日志。
+//
+//	请求: {"version": 1, "method": "put_file", "params": {"key": "...", "path": "..."}}
+//	响应: {"result": {...}} 或 {"error": "错误信息"}
+//
+// 插件以非0退出码退出或响应中error不为空时调用失败。插件不认识的method应返回error。
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ProtocolVersion 协议版本，随请求发送给插件
+const ProtocolVersion = 1
+
+// request 发送给插件的请求
+type request struct {
+	Version int         `json:"version"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// response 插件返回的响应
+type response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Call 调用插件的method，params序列化为请求参数，响应的result解析到result（为nil时忽略）。
+// command为插件可执行文件路径，不是已存在的文件时按空格拆分为路径和参数
+func Call(ctx context.Context, command, method string, params, result interface{}) error {
+	args := []string{command}
+	if _, err := os.Stat(command); err != nil {
+		args = strings.Fields(command)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("插件命令为空")
+	}
+	name := filepath.Base(args[0])
+
+	payload, err := json.Marshal(request{Version: ProtocolVersion, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("序列化插件请求失败: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(append(payload, '\n'))
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("插件 %s 执行 %s 失败: %v", name, method, err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &resp); err != nil {
+		return fmt.Errorf("插件 %s 的 %s 响应格式错误: %v", name, method, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("插件 %s 执行 %s 失败: %s", name, method, resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("插件 %s 的 %s 结果格式错误: %v", name, method, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package retention 实现vcpsave备份文件的命名格式解析和保留期判断。
+//
+// 备份文件名格式为 前缀_YYYYMMDD_HHMMSS.扩展名，例如 VCPToolBox_20251021_095449.zip，
+// 只有符合该格式的文件才会参与保留期判断，其他文件永远不会被选为过期。
+package retention
+
+import (
+	"regexp"
+	"time"
+)
+
+// TimeStampLayout 文件名中时间戳的格式
+const TimeStampLayout = "20060102_150405"
+
+var fileNamePattern = regexp.MustCompile(`^(.+?)_(\d{8}_\d{6})\..+$`)
+
+// ParseFileName 解析备份文件名，返回前缀和时间戳，不是备份文件名格式时ok为false
+func ParseFileName(fileName string) (prefix, timeStamp string, ok bool) {
+	matches := fileNamePattern.FindStringSubmatch(fileName)
+	if len(matches) != 3 {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// ParseTimeStamp 按指定时区解析文件名中的时间戳，loc为nil时使用本地时区
+func ParseTimeStamp(timeStamp string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	return time.ParseInLocation(TimeStampLayout, timeStamp, loc)
+}
+
+// FormatTimeStamp 返回时间在指定时区下的文件名时间戳，loc为nil时使用本地时区
+func FormatTimeStamp(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.Local
+	}
+	return t.In(loc).Format(TimeStampLayout)
+}
+
+// Policy 保留策略
+type Policy struct {
+	// MaxAge 备份保留期，超过此时长的备份为过期
+	MaxAge time.Duration
+	// Whitelist 永不过期的前缀
+	Whitelist []string
+	// Location 文件名时间戳的时区，nil表示本地时区
+	Location *time.Location
+}
+
+// Whitelisted 检查前缀是否在白名单中
+func (p Policy) Whitelisted(prefix string) bool {
+	for _, allowed := range p.Whitelist {
+		if prefix == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired 检查备份文件在now时是否已过期。不是备份文件名格式、在白名单中或时间戳无法解析的文件不会过期
+func (p Policy) Expired(fileName string, now time.Time) bool {
+	prefix, timeStamp, ok := ParseFileName(fileName)
+	if !ok || p.Whitelisted(prefix) {
+		return false
+	}
+	backupTime, err := ParseTimeStamp(timeStamp, p.Location)
+	if err != nil {
+		return false
+	}
+	return now.Sub(backupTime) > p.MaxAge
+}
+
+// Select 从文件名列表中选出在now时已过期的备份，保持原有顺序
+func (p Policy) Select(fileNames []string, now time.Time) []string {
+	var expired []string
+	for _, fileName := range fileNames {
+		if p.Expired(fileName, now) {
+			expired = append(expired, fileName)
+		}
+	}
+	return expired
+}
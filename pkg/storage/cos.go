@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// COS 腾讯云COS存储
+type COS struct {
+	client *cos.Client
+}
+
+// NewCOS 创建访问指定存储桶的COS存储，bucketName包含APPID，例如 examplebucket-1250000000
+func NewCOS(bucketName, region, secretID, secretKey string) *COS {
+	u, _ := url.Parse(fmt.Sprintf("https://%s.cos.%s.myqcloud.com", bucketName, region))
+	client := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
+		Transport: &cos.AuthorizationTransport{
+			SecretID:  secretID,
+			SecretKey: secretKey,
+		},
+	})
+	return &COS{client: client}
+}
+
+// FromClient 使用已创建的COS客户端
+func FromClient(client *cos.Client) *COS {
+	return &COS{client: client}
+}
+
+// List 分页列出键以prefix开头的全部对象
+func (s *COS) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	marker := ""
+	for {
+		v, _, err := s.client.Bucket.Get(ctx, &cos.BucketGetOptions{
+			Prefix:  prefix,
+			Marker:  marker,
+			MaxKeys: 1000,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("获取COS文件列表失败: %v", err)
+		}
+
+		for _, content := range v.Contents {
+			lastModified, _ := time.Parse(time.RFC3339, content.LastModified)
+			objects = append(objects, Object{Key: content.Key, Size: content.Size, LastModified: lastModified})
+		}
+
+		if !v.IsTruncated {
+			break
+		}
+		marker = v.NextMarker
+		if marker == "" && len(v.Contents) > 0 {
+			marker = v.Contents[len(v.Contents)-1].Key
+		}
+	}
+	return objects, nil
+}
+
+// Head 获取对象的大小、修改时间和元数据
+func (s *COS) Head(ctx context.Context, key string) (*Object, error) {
+	resp, err := s.client.Object.Head(ctx, key, nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, fmt.Errorf("对象不存在: %s: %w", key, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("获取COS文件信息失败: %s, 错误: %v", key, err)
+	}
+	object := &Object{Key: key, Size: resp.ContentLength, Metadata: make(map[string]string)}
+	object.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for name := range resp.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-cos-meta-") {
+			object.Metadata[strings.TrimPrefix(lower, "x-cos-meta-")] = resp.Header.Get(name)
+		}
+	}
+	return object, nil
+}
+
+// Get 读取对象的内容
+func (s *COS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.client.Object.Get(ctx, key, nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, fmt.Errorf("对象不存在: %s: %w", key, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("下载COS文件失败: %s, 错误: %v", key, err)
+	}
+	return resp.Body, nil
+}
+
+// Put 从r上传size字节到key
+func (s *COS) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error {
+	header := &http.Header{}
+	for name, value := range metadata {
+		header.Set("x-cos-meta-"+name, value)
+	}
+	opt := &cos.ObjectPutOptions{ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+		ContentLength: size,
+		XCosMetaXXX:   header,
+	}}
+	if _, err := s.client.Object.Put(ctx, key, r, opt); err != nil {
+		return fmt.Errorf("上传文件失败: %s, 错误: %v", key, err)
+	}
+	return nil
+}
+
+// PutFile 上传本地文件，大文件由SDK自动分块
+func (s *COS) PutFile(ctx context.Context, key, localPath string) error {
+	if _, _, err := s.client.Object.Upload(ctx, key, localPath, nil); err != nil {
+		return fmt.Errorf("上传文件失败: %s, 错误: %v", key, err)
+	}
+	return nil
+}
+
+// Delete 删除对象
+func (s *COS) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.Object.Delete(ctx, key); err != nil {
+		return fmt.Errorf("删除COS文件失败: %s, 错误: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"vcpsave/pkg/plugin"
+)
+
+// Exec 由外部可执行文件实现的存储，通过plugin包的stdio JSON协议通信。
+// 插件需要实现以下方法：
+//
+//	list     {"prefix": "..."}                             -> {"objects": [{"key": "...", "size": 0, "last_modified": "RFC3339"}]}
+//	head     {"key": "..."}                                -> {"exists": true, "size": 0, "last_modified": "RFC3339", "metadata": {}}
+//	get_file {"key": "...", "path": "..."}                 -> {}
+//	put_file {"key": "...", "path": "...", "metadata": {}} -> {}
+//	delete   {"key": "..."}                                -> {}
+//
+// head 对象不存在时返回 {"exists": false}。get_file 将对象写入path，put_file 读取path上传，
+// 不需要元数据的插件可以忽略 metadata。
+type Exec struct {
+	command string
+}
+
+// NewExec 创建使用插件command的存储
+func NewExec(command string) *Exec {
+	return &Exec{command: command}
+}
+
+// String 返回插件命令，用于日志
+func (s *Exec) String() string {
+	return s.command
+}
+
+// execObject 插件返回的对象
+type execObject struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"last_modified"`
+}
+
+// List 列出键以prefix开头的全部对象
+func (s *Exec) List(ctx context.Context, prefix string) ([]Object, error) {
+	var result struct {
+		Objects []execObject `json:"objects"`
+	}
+	if err := plugin.Call(ctx, s.command, "list", map[string]string{"prefix": prefix}, &result); err != nil {
+		return nil, err
+	}
+
+	objects := make([]Object, 0, len(result.Objects))
+	for _, o := range result.Objects {
+		lastModified, _ := time.Parse(time.RFC3339, o.LastModified)
+		objects = append(objects, Object{Key: o.Key, Size: o.Size, LastModified: lastModified})
+	}
+	return objects, nil
+}
+
+// Head 由插件获取对象的大小、修改时间和元数据
+func (s *Exec) Head(ctx context.Context, key string) (*Object, error) {
+	var result struct {
+		Exists   bool              `json:"exists"`
+		Metadata map[string]string `json:"metadata"`
+		execObject
+	}
+	if err := plugin.Call(ctx, s.command, "head", map[string]string{"key": key}, &result); err != nil {
+		return nil, err
+	}
+	if !result.Exists {
+		return nil, fmt.Errorf("对象不存在: %s: %w", key, fs.ErrNotExist)
+	}
+	lastModified, _ := time.Parse(time.RFC3339, result.LastModified)
+	return &Object{Key: key, Size: result.Size, LastModified: lastModified, Metadata: result.Metadata}, nil
+}
+
+// tempFileReader 读取完后删除的临时文件
+type tempFileReader struct {
+	*os.File
+}
+
+// Close 关闭并删除临时文件
+func (f *tempFileReader) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// Get 由插件将对象下载到临时文件后读取
+func (s *Exec) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if _, err := s.Head(ctx, key); err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.CreateTemp("", "vcpsave-plugin-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	tmpFile.Close()
+	if err := plugin.Call(ctx, s.command, "get_file", map[string]string{"key": key, "path": tmpFile.Name()}, nil); err != nil {
+		os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	file, err := os.Open(tmpFile.Name())
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	return &tempFileReader{file}, nil
+}
+
+// Put 将r写入临时文件后由插件上传
+func (s *Exec) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error {
+	tmpFile, err := os.CreateTemp("", "vcpsave-plugin-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	n, err := io.Copy(tmpFile, r)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("上传内容不完整: 应为 %d bytes，实际 %d bytes", size, n)
+	}
+	return s.putFile(ctx, key, tmpFile.Name(), metadata)
+}
+
+// PutFile 由插件读取本地文件并上传到key
+func (s *Exec) PutFile(ctx context.Context, key, localPath string) error {
+	return s.putFile(ctx, key, localPath, nil)
+}
+
+// putFile 调用插件的put_file
+func (s *Exec) putFile(ctx context.Context, key, localPath string, metadata map[string]string) error {
+	params := map[string]interface{}{"key": key, "path": localPath}
+	if len(metadata) > 0 {
+		params["metadata"] = metadata
+	}
+	return plugin.Call(ctx, s.command, "put_file", params, nil)
+}
+
+// Delete 删除key
+func (s *Exec) Delete(ctx context.Context, key string) error {
+	return plugin.Call(ctx, s.command, "delete", map[string]string{"key": key}, nil)
+}
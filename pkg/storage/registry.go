@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory 根据配置创建存储。getenv读取配置项，例如 os.Getenv，
+// 也可能是多目标复制中带 REPLICA_名称_ 前缀的配置
+type Factory func(getenv func(string) string) (Storage, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register 注册名为name的存储，通常在实现存储的包的init中调用。名称重复时panic
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("storage: 注册的存储 " + name + " 为nil")
+	}
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("storage: 存储 %s 已注册", name))
+	}
+	registry[name] = factory
+}
+
+// Lookup 查找已注册的存储
+func Lookup(name string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// Names 返回已注册的存储名称，按名称排序
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
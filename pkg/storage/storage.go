@@ -0,0 +1,37 @@
+// Package storage 定义备份使用的对象存储接口，并提供腾讯云COS的实现。
+//
+// 其他存储可以实现 Storage 接口并在init中调用 Register 注册，vcpsave命令行工具
+// 导入该包后即可通过 STORAGE_PROVIDER=名称 使用，无需修改命令行工具的代码。
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Object 存储中的一个对象
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// Metadata 对象的自定义元数据，键为小写。List返回的对象不包含元数据
+	Metadata map[string]string
+}
+
+// Storage 对象存储。对象键使用 / 分隔，不以 / 开头。
+// 对象不存在时Head和Get返回的错误满足 errors.Is(err, fs.ErrNotExist)
+type Storage interface {
+	// List 列出键以prefix开头的全部对象
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Head 获取对象的大小、修改时间和元数据
+	Head(ctx context.Context, key string) (*Object, error)
+	// Get 读取对象的内容，调用方负责关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put 从r上传size字节到key，并保存元数据，已存在的对象被覆盖
+	Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error
+	// PutFile 上传本地文件到key
+	PutFile(ctx context.Context, key, localPath string) error
+	// Delete 删除key，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"vcpsave/pkg/plugin"
+	"vcpsave/pkg/storage"
+)
+
+// 外部可执行文件插件：不修改本程序即可接入其他存储或在备份事件时执行自定义操作。
+// 插件与本程序通过 vcpsave/pkg/plugin 的stdio JSON协议通信，每次调用启动一次插件进程。
+
+// getPluginTimeout 获取单次插件调用的超时时间，默认5分钟
+func getPluginTimeout() time.Duration {
+	timeout := 5 * time.Minute
+	if timeoutStr := os.Getenv("PLUGIN_TIMEOUT"); timeoutStr != "" {
+		if d, err := time.ParseDuration(timeoutStr); err == nil && d > 0 {
+			timeout = d
+		} else {
+			fmt.Printf("警告: PLUGIN_TIMEOUT格式错误: %s，使用默认值 %v\n", timeoutStr, timeout)
+		}
+	}
+	return timeout
+}
+
+// parsePluginList 解析分号分隔的插件命令列表，命令中可以包含空格和逗号
+func parsePluginList(value string) []string {
+	var commands []string
+	for _, command := range strings.Split(value, ";") {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// getHookPlugins 获取项目的事件插件，PLUGIN_HOOKS 为分号分隔的命令
+func getHookPlugins(proj *project) []string {
+	return parsePluginList(proj.Getenv("PLUGIN_HOOKS"))
+}
+
+// getStoragePlugins 获取项目的存储插件，PLUGIN_STORAGE 为分号分隔的命令。
+// 上传到COS的备份会同时复制到每个存储插件，清理删除时同步删除
+func getStoragePlugins(proj *project) []*storage.Exec {
+	var stores []*storage.Exec
+	for _, command := range parsePluginList(proj.Getenv("PLUGIN_STORAGE")) {
+		stores = append(stores, storage.NewExec(command))
+	}
+	return stores
+}
+
+// hookEvent 发送给事件插件的事件
+type hookEvent struct {
+	Type    string      `json:"type"` // run_finished、cleanup_finished 或 alert
+	Project string      `json:"project,omitempty"`
+	Time    string      `json:"time"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// emitHookEvent 将事件同时发送给项目的所有事件插件，失败时只输出警告
+func emitHookEvent(proj *project, eventType string, data interface{}) {
+	sendHookEvent(getHookPlugins(proj), hookEvent{Type: eventType, Project: proj.Name, Time: time.Now().Format(time.RFC3339), Data: data})
+}
+
+// sendHookEvent 将事件同时发送给hooks中的所有事件插件
+func sendHookEvent(hooks []string, event hookEvent) {
+	var wg sync.WaitGroup
+	for _, command := range hooks {
+		wg.Add(1)
+		go func(command string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), getPluginTimeout())
+			defer cancel()
+			if err := plugin.Call(ctx, command, "event", event, nil); err != nil {
+				fmt.Printf("警告: %v\n", err)
+			}
+		}(command)
+	}
+	wg.Wait()
+}
+
+// copyToStoragePlugins 将已上传到COS的备份文件复制到项目的所有存储插件，对象键与COS相同
+func copyToStoragePlugins(proj *project, key, localPath string) {
+	for _, store := range getStoragePlugins(proj) {
+		ctx, cancel := context.WithTimeout(context.Background(), getPluginTimeout())
+		if err := store.PutFile(ctx, key, localPath); err != nil {
+			fmt.Printf("警告: 复制到存储插件失败: %v\n", err)
+		} else {
+			fmt.Printf("已复制到存储插件: %s -> %s\n", key, store)
+		}
+		cancel()
+	}
+}
+
+// warnStoragePluginsSkipped 流式上传和超大文件分块上传没有完整的本地文件，不能复制到存储插件
+func warnStoragePluginsSkipped(proj *project, sourcePath string) {
+	if len(getStoragePlugins(proj)) > 0 {
+		fmt.Printf("警告: 流式上传和超大文件分块上传的备份不会复制到存储插件: %s\n", sourcePath)
+	}
+}
+
+// deleteFromStoragePlugins 从项目的所有存储插件中删除已清理的备份
+func deleteFromStoragePlugins(proj *project, keys []string) {
+	for _, store := range getStoragePlugins(proj) {
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), getPluginTimeout())
+			if err := store.Delete(ctx, key); err != nil {
+				fmt.Printf("警告: 从存储插件删除失败: %v\n", err)
+			}
+			cancel()
+		}
+	}
+}
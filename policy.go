@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 存储桶策略漂移检测：定期读取存储桶的版本控制、加密、对象锁定、ACL和生命周期规则，
+// 与配置中期望的策略比较。控制台上的修改（如关闭版本控制、添加过期规则）会悄悄破坏保留期假设，
+// 发现新的偏差时发送告警，并将检查结果写入目标目录的 .vcpsave/policy.json。
+
+// desiredPolicy 配置中期望的存储桶策略，空值表示不检查
+type desiredPolicy struct {
+	Versioning     string   // Enabled、Suspended
+	Encryption     string   // AES256、SM4、KMS、none
+	ObjectLockDays int      // 对象锁定的最短保留天数
+	PrivateACL     bool     // 存储桶ACL不允许所有人访问
+	LifecycleRules []string // 期望启用的生命周期规则ID，"none"表示不允许任何规则
+}
+
+// getDesiredPolicy 读取期望的存储桶策略，没有配置任何POLICY_*项时返回nil
+func getDesiredPolicy(proj *project) *desiredPolicy {
+	p := &desiredPolicy{}
+	configured := false
+
+	if versioning := proj.Getenv("POLICY_VERSIONING"); versioning != "" {
+		if versioning == "Enabled" || versioning == "Suspended" {
+			p.Versioning = versioning
+		} else {
+			fmt.Printf("警告: POLICY_VERSIONING格式错误: %s，可选值: Enabled, Suspended\n", versioning)
+		}
+		configured = true
+	}
+
+	if encryption := proj.Getenv("POLICY_ENCRYPTION"); encryption != "" {
+		switch encryption {
+		case "AES256", "SM4", "KMS", "none":
+			p.Encryption = encryption
+		default:
+			fmt.Printf("警告: POLICY_ENCRYPTION格式错误: %s，可选值: AES256, SM4, KMS, none\n", encryption)
+		}
+		configured = true
+	}
+
+	if daysStr := proj.Getenv("POLICY_OBJECT_LOCK_DAYS"); daysStr != "" {
+		if days, err := strconv.Atoi(daysStr); err == nil && days > 0 {
+			p.ObjectLockDays = days
+		} else {
+			fmt.Printf("警告: POLICY_OBJECT_LOCK_DAYS格式错误: %s\n", daysStr)
+		}
+		configured = true
+	}
+
+	if acl := proj.Getenv("POLICY_ACL"); acl != "" {
+		if acl == "private" {
+			p.PrivateACL = true
+		} else {
+			fmt.Printf("警告: POLICY_ACL格式错误: %s，可选值: private\n", acl)
+		}
+		configured = true
+	}
+
+	if rules := proj.Getenv("POLICY_LIFECYCLE_RULES"); rules != "" {
+		for _, id := range strings.Split(rules, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				p.LifecycleRules = append(p.LifecycleRules, id)
+			}
+		}
+		configured = true
+	}
+
+	if !configured {
+		return nil
+	}
+	return p
+}
+
+// getPolicyCheckInterval 获取策略检查的间隔，默认6小时
+func getPolicyCheckInterval() time.Duration {
+	interval := 6 * time.Hour
+	if intervalStr := os.Getenv("POLICY_CHECK_INTERVAL"); intervalStr != "" {
+		if d, err := time.ParseDuration(intervalStr); err == nil && d > 0 {
+			interval = d
+		} else {
+			fmt.Printf("警告: POLICY_CHECK_INTERVAL格式错误: %s，使用默认值 %v\n", intervalStr, interval)
+		}
+	}
+	return interval
+}
+
+// bucketState 存储桶的实际策略
+type bucketState struct {
+	Versioning     string          `json:"versioning"`
+	Encryption     string          `json:"encryption"`
+	ObjectLockDays int             `json:"object_lock_days"` // 0表示未开启对象锁定
+	PublicGrants   []string        `json:"public_grants,omitempty"`
+	LifecycleRules []lifecycleRule `json:"lifecycle_rules,omitempty"`
+}
+
+// lifecycleRule 生命周期规则中与备份保留相关的部分
+type lifecycleRule struct {
+	ID             string `json:"id"`
+	Enabled        bool   `json:"enabled"`
+	Prefix         string `json:"prefix"`
+	ExpirationDays int    `json:"expiration_days,omitempty"`
+	ExpirationDate string `json:"expiration_date,omitempty"`
+	Transition     string `json:"transition,omitempty"`
+}
+
+// policyReport 一次策略检查的结果
+type policyReport struct {
+	CheckedAt string      `json:"checked_at"`
+	Bucket    string      `json:"bucket"`
+	Immutable bool        `json:"immutable"` // 对象锁定的保留天数不短于备份保留期
+	State     bucketState `json:"state"`
+	Drift     []string    `json:"drift"`
+}
+
+// getPolicyReportKey 获取策略检查结果的对象键
+func getPolicyReportKey(targetDir string) string {
+	return cosObjectKey(targetDir, metaDirName+"/policy.json")
+}
+
+// readBucketState 读取存储桶的实际策略，未配置的项（接口返回404）视为未开启
+func readBucketState(client *cos.Client) (*bucketState, error) {
+	ctx := context.Background()
+	state := &bucketState{Versioning: "Off", Encryption: "none"}
+
+	versioning, _, err := client.Bucket.GetVersioning(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("读取版本控制配置失败: %v", err)
+	}
+	if versioning.Status != "" {
+		state.Versioning = versioning.Status
+	}
+
+	encryption, _, err := client.Bucket.GetEncryption(ctx)
+	if err != nil && !cos.IsNotFoundError(err) {
+		return nil, fmt.Errorf("读取加密配置失败: %v", err)
+	}
+	if err == nil && encryption.Rule != nil && encryption.Rule.SSEAlgorithm != "" {
+		state.Encryption = encryption.Rule.SSEAlgorithm
+		if state.Encryption == "cos/kms" {
+			state.Encryption = "KMS"
+		}
+	}
+
+	lock, _, err := client.Bucket.GetObjectLockConfiguration(ctx)
+	if err != nil && !cos.IsNotFoundError(err) {
+		return nil, fmt.Errorf("读取对象锁定配置失败: %v", err)
+	}
+	if err == nil && lock.ObjectLockEnabled == "Enabled" && lock.Rule != nil {
+		state.ObjectLockDays = lock.Rule.Days
+	}
+
+	acl, _, err := client.Bucket.GetACL(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("读取存储桶ACL失败: %v", err)
+	}
+	for _, grant := range acl.AccessControlList {
+		if grant.Grantee != nil && grant.Grantee.URI == allUsersURI {
+			state.PublicGrants = append(state.PublicGrants, grant.Permission)
+		}
+	}
+
+	lifecycle, _, err := client.Bucket.GetLifecycle(ctx)
+	if err != nil && !cos.IsNotFoundError(err) {
+		return nil, fmt.Errorf("读取生命周期配置失败: %v", err)
+	}
+	if err == nil {
+		for _, rule := range lifecycle.Rules {
+			r := lifecycleRule{ID: rule.ID, Enabled: rule.Status == "Enabled"}
+			if rule.Filter != nil {
+				r.Prefix = rule.Filter.Prefix
+				if rule.Filter.And != nil && r.Prefix == "" {
+					r.Prefix = rule.Filter.And.Prefix
+				}
+			}
+			if rule.Expiration != nil {
+				r.ExpirationDays = rule.Expiration.Days
+				r.ExpirationDate = rule.Expiration.Date
+			}
+			if len(rule.Transition) > 0 {
+				r.Transition = rule.Transition[0].StorageClass
+			}
+			state.LifecycleRules = append(state.LifecycleRules, r)
+		}
+	}
+	return state, nil
+}
+
+// comparePolicy 比较实际策略与期望策略，返回偏差描述。
+// 无论是否配置POLICY_LIFECYCLE_RULES，覆盖目标目录且早于保留期过期的规则都视为偏差
+func comparePolicy(proj *project, desired *desiredPolicy, state *bucketState) []string {
+	var drift []string
+
+	if desired.Versioning != "" {
+		actual := state.Versioning
+		if actual == "Off" && desired.Versioning == "Suspended" {
+			actual = "Suspended"
+		}
+		if actual != desired.Versioning {
+			drift = append(drift, fmt.Sprintf("版本控制为 %s，期望 %s", state.Versioning, desired.Versioning))
+		}
+	}
+
+	if desired.Encryption != "" && state.Encryption != desired.Encryption {
+		drift = append(drift, fmt.Sprintf("默认加密为 %s，期望 %s", state.Encryption, desired.Encryption))
+	}
+
+	if desired.ObjectLockDays > 0 && state.ObjectLockDays < desired.ObjectLockDays {
+		if state.ObjectLockDays == 0 {
+			drift = append(drift, fmt.Sprintf("未开启对象锁定，期望保留 %d 天", desired.ObjectLockDays))
+		} else {
+			drift = append(drift, fmt.Sprintf("对象锁定保留 %d 天，期望至少 %d 天", state.ObjectLockDays, desired.ObjectLockDays))
+		}
+	}
+
+	if desired.PrivateACL && len(state.PublicGrants) > 0 {
+		drift = append(drift, fmt.Sprintf("存储桶允许所有人访问 (%s)，期望 private", strings.Join(state.PublicGrants, ", ")))
+	}
+
+	if len(desired.LifecycleRules) > 0 {
+		expected := make(map[string]bool)
+		for _, id := range desired.LifecycleRules {
+			if id != "none" {
+				expected[id] = true
+			}
+		}
+		actual := make(map[string]bool)
+		for _, rule := range state.LifecycleRules {
+			if !rule.Enabled {
+				continue
+			}
+			actual[rule.ID] = true
+			if !expected[rule.ID] {
+				drift = append(drift, fmt.Sprintf("存在未预期的生命周期规则: %s", rule.ID))
+			}
+		}
+		for id := range expected {
+			if !actual[id] {
+				drift = append(drift, fmt.Sprintf("缺少生命周期规则: %s", id))
+			}
+		}
+	}
+
+	targetPrefix := strings.Trim(proj.TargetDir, "/") + "/"
+	retention := getRetention(proj)
+	for _, rule := range state.LifecycleRules {
+		if !rule.Enabled || !strings.HasPrefix(targetPrefix, rule.Prefix) {
+			continue
+		}
+		if rule.ExpirationDate != "" {
+			drift = append(drift, fmt.Sprintf("生命周期规则 %s 将在 %s 删除目标目录中的备份", rule.ID, rule.ExpirationDate))
+		} else if rule.ExpirationDays > 0 && time.Duration(rule.ExpirationDays)*24*time.Hour < retention {
+			drift = append(drift, fmt.Sprintf("生命周期规则 %s 在 %d 天后删除目标目录中的备份，短于保留期 %v",
+				rule.ID, rule.ExpirationDays, retention))
+		}
+	}
+
+	sort.Strings(drift)
+	return drift
+}
+
+// checkBucketPolicy 检查一次存储桶策略并写入检查结果
+func checkBucketPolicy(client *cos.Client, proj *project, desired *desiredPolicy) (*policyReport, error) {
+	state, err := readBucketState(client)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &policyReport{
+		CheckedAt: time.Now().Format(time.RFC3339),
+		Bucket:    os.Getenv("COS_BUCKET_NAME"),
+		Immutable: state.ObjectLockDays > 0 && time.Duration(state.ObjectLockDays)*24*time.Hour >= getRetention(proj),
+		State:     *state,
+		Drift:     comparePolicy(proj, desired, state),
+	}
+	if err := putJSONObject(client, getPolicyReportKey(proj.TargetDir), report); err != nil {
+		fmt.Printf("警告: 保存策略检查结果失败: %v\n", err)
+	}
+	return report, nil
+}
+
+// startPolicyChecker 启动后台策略漂移检测，出现新的偏差时发送告警
+func startPolicyChecker(client *cos.Client, proj *project) {
+	desired := getDesiredPolicy(proj)
+	if desired == nil {
+		return
+	}
+	if getStorageProvider() != "cos" {
+		fmt.Printf("警告: 存储桶策略检查只支持COS，使用%s时已跳过%s\n", getStorageProvider(), proj.logTag())
+		return
+	}
+
+	interval := getPolicyCheckInterval()
+	fmt.Printf("已启用存储桶策略检查%s: 检查间隔 %v\n", proj.logTag(), interval)
+
+	go func() {
+		reported := make(map[string]bool)
+		for {
+			report, err := checkBucketPolicy(client, proj, desired)
+			if err != nil {
+				fmt.Printf("错误: 存储桶策略检查失败%s: %v\n", proj.logTag(), err)
+			} else {
+				current := make(map[string]bool)
+				var newDrift []string
+				for _, d := range report.Drift {
+					current[d] = true
+					if !reported[d] {
+						newDrift = append(newDrift, d)
+					}
+				}
+				if len(newDrift) > 0 {
+					sendAlert(proj, "存储桶策略偏离配置", strings.Join(newDrift, "\n"))
+				}
+				if len(report.Drift) == 0 && len(reported) > 0 {
+					fmt.Printf("存储桶策略已与配置一致%s\n", proj.logTag())
+				}
+				reported = current
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// 源优先级：SOURCE_PRIORITY=mysql:10,media:-5，数值大的源每次运行先归档和上传，默认0。
+// 配置了BACKUP_WINDOW时，优先级低于PRIORITY_DEFER_BELOW的源在窗口不足时推迟到下次运行。
+
+// getSourcePriority 获取源的优先级，未配置时为0
+func getSourcePriority(proj *project, sourcePath string) int {
+	priorityStr := parseKeyValueList(proj.Getenv("SOURCE_PRIORITY"))[sourceName(sourcePath)]
+	if priorityStr == "" {
+		return 0
+	}
+	priority, err := strconv.Atoi(priorityStr)
+	if err != nil {
+		fmt.Printf("警告: %s 的SOURCE_PRIORITY格式错误: %s，使用默认优先级0\n", sourcePath, priorityStr)
+		return 0
+	}
+	return priority
+}
+
+// getDeferPriority 获取可以推迟的优先级上限，低于此值的源在备份窗口不足时推迟，默认0（只推迟负优先级的源）
+func getDeferPriority(proj *project) int {
+	threshold := 0
+	if thresholdStr := proj.Getenv("PRIORITY_DEFER_BELOW"); thresholdStr != "" {
+		if n, err := strconv.Atoi(thresholdStr); err == nil {
+			threshold = n
+		} else {
+			fmt.Printf("警告: PRIORITY_DEFER_BELOW格式错误: %s，使用默认值 %d\n", thresholdStr, threshold)
+		}
+	}
+	return threshold
+}
+
+// sortSourcesByPriority 按优先级从高到低排列源，优先级相同时保持配置顺序
+func sortSourcesByPriority(proj *project, sourcePaths []string) []string {
+	priorities := make(map[string]int, len(sourcePaths))
+	for _, sourcePath := range sourcePaths {
+		priorities[sourcePath] = getSourcePriority(proj, sourcePath)
+	}
+	sorted := append([]string(nil), sourcePaths...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priorities[sorted[i]] > priorities[sorted[j]]
+	})
+	return sorted
+}
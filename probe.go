@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// isProbeBeforeRunEnabled 检查是否在每次运行前执行权限探测
+func isProbeBeforeRunEnabled(proj *project) bool {
+	return proj.Getenv("PROBE_BEFORE_RUN") == "true"
+}
+
+// probeTarget 在目标目录下写入、读取、列出并删除一个很小的标记对象，
+// 提前发现缺失的权限，避免备份进行到一半才失败
+func probeTarget(client *cos.Client, targetDir string) error {
+	hostname, _ := os.Hostname()
+	probeKey := cosObjectKey(targetDir, fmt.Sprintf("%s/probe_%s_%d", metaDirName, hostname, time.Now().UnixNano()))
+	ctx := context.Background()
+
+	if _, err := client.Object.Put(ctx, probeKey, strings.NewReader("vcpsave probe"), nil); err != nil {
+		return fmt.Errorf("写入权限检查失败 (PutObject %s): %v", probeKey, err)
+	}
+
+	// 之后的步骤失败时也要尽量删除标记对象
+	deleted := false
+	defer func() {
+		if !deleted {
+			client.Object.Delete(ctx, probeKey)
+		}
+	}()
+
+	if _, err := client.Object.Head(ctx, probeKey, nil); err != nil {
+		return fmt.Errorf("读取权限检查失败 (HeadObject %s): %v", probeKey, err)
+	}
+
+	if _, _, err := client.Bucket.Get(ctx, &cos.BucketGetOptions{Prefix: probeKey, MaxKeys: 1}); err != nil {
+		return fmt.Errorf("列出权限检查失败 (GetBucket %s): %v", probeKey, err)
+	}
+
+	if _, err := client.Object.Delete(ctx, probeKey); err != nil {
+		return fmt.Errorf("删除权限检查失败 (DeleteObject %s): %v", probeKey, err)
+	}
+	deleted = true
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// uploadProgress 一个正在进行的分块上传的进度
+type uploadProgress struct {
+	Key         string    `json:"key"`
+	PartsDone   int       `json:"parts_done"`
+	PartsTotal  int       `json:"parts_total"` // 流式上传时总分块数未知，为0
+	BytesDone   int64     `json:"bytes_done"`
+	BytesTotal  int64     `json:"bytes_total"` // 流式上传时为0
+	BytesPerSec float64   `json:"bytes_per_sec"`
+	StartedAt   time.Time `json:"started_at"`
+	LastPartAt  time.Time `json:"last_part_at"`
+
+	resumedBytes int64 // 续传前已上传的字节，不计入速度
+}
+
+// progressTracker 记录所有正在进行的分块上传，供状态API和监控指标读取
+type progressTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadProgress
+}
+
+// uploads 全局上传进度
+var uploads = &progressTracker{uploads: make(map[string]*uploadProgress)}
+
+// start 开始跟踪一个上传，续传时partsDone和bytesDone为已上传的部分
+func (t *progressTracker) start(key string, partsTotal int, bytesTotal int64, partsDone int, bytesDone int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.uploads[key] = &uploadProgress{
+		Key:        key,
+		PartsDone:  partsDone,
+		PartsTotal: partsTotal,
+		BytesDone:  bytesDone,
+		BytesTotal: bytesTotal,
+		StartedAt:  now,
+		LastPartAt: now,
+
+		resumedBytes: bytesDone,
+	}
+}
+
+// partDone 记录一个分块上传完成，速度按本次运行新上传的字节计算
+func (t *progressTracker) partDone(key string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.uploads[key]
+	if !ok {
+		return
+	}
+	p.PartsDone++
+	p.BytesDone += size
+	p.LastPartAt = time.Now()
+	if elapsed := p.LastPartAt.Sub(p.StartedAt).Seconds(); elapsed > 0 {
+		p.BytesPerSec = float64(p.BytesDone-p.resumedBytes) / elapsed
+	}
+}
+
+// finish 停止跟踪一个上传
+func (t *progressTracker) finish(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.uploads, key)
+}
+
+// snapshot 返回所有上传进度的副本
+func (t *progressTracker) snapshot() []uploadProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make([]uploadProgress, 0, len(t.uploads))
+	for _, p := range t.uploads {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// handleStatus 返回当前是否有备份、清理在运行以及各分块上传的进度
+func (s *webServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	running := !runMu.TryLock()
+	if !running {
+		runMu.Unlock()
+	}
+	cleaning := !cleanupMu.TryLock()
+	if !cleaning {
+		cleanupMu.Unlock()
+	}
+	writeJSON(w, map[string]interface{}{
+		"running":  running,
+		"cleaning": cleaning,
+		"uploads":  uploads.snapshot(),
+	})
+}
+
+// handleMetrics 以Prometheus文本格式输出上传进度指标
+func (s *webServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	progress := uploads.snapshot()
+	metrics := []struct {
+		name, help string
+		value      func(p uploadProgress) float64
+	}{
+		{"vcpsave_upload_parts_done", "已上传的分块数", func(p uploadProgress) float64 { return float64(p.PartsDone) }},
+		{"vcpsave_upload_parts_total", "总分块数，流式上传时为0", func(p uploadProgress) float64 { return float64(p.PartsTotal) }},
+		{"vcpsave_upload_bytes_done", "已上传的字节数", func(p uploadProgress) float64 { return float64(p.BytesDone) }},
+		{"vcpsave_upload_bytes_per_second", "上传速度", func(p uploadProgress) float64 { return p.BytesPerSec }},
+		{"vcpsave_upload_last_part_timestamp_seconds", "最近一个分块完成的时间", func(p uploadProgress) float64 { return float64(p.LastPartAt.Unix()) }},
+	}
+
+	fmt.Fprintf(w, "# HELP vcpsave_backup_overlaps_total 备份重叠次数（按处理结果）\n# TYPE vcpsave_backup_overlaps_total counter\n")
+	overlaps := getOverlapCounts()
+	for _, outcome := range sortedKeys(overlaps) {
+		fmt.Fprintf(w, "vcpsave_backup_overlaps_total{outcome=%q} %d\n", outcome, overlaps[outcome])
+	}
+
+	fmt.Fprintf(w, "# HELP vcpsave_staging_reserved_bytes 当前预留的暂存空间\n# TYPE vcpsave_staging_reserved_bytes gauge\n")
+	fmt.Fprintf(w, "vcpsave_staging_reserved_bytes %d\n", getStagingArea().reservedBytes())
+
+	runUsage := getRunUsage()
+	usageMetrics := []struct {
+		name, help string
+		value      func(u resourceUsage) float64
+	}{
+		{"vcpsave_last_run_cpu_seconds", "最近一次运行的CPU时间", func(u resourceUsage) float64 { return u.CPUSeconds }},
+		{"vcpsave_last_run_peak_memory_bytes", "最近一次运行的内存峰值", func(u resourceUsage) float64 { return float64(u.PeakMemoryBytes) }},
+		{"vcpsave_last_run_temp_disk_bytes", "最近一次运行写入的临时文件大小", func(u resourceUsage) float64 { return float64(u.TempDiskBytes) }},
+		{"vcpsave_last_run_network_sent_bytes", "最近一次运行上传的字节数", func(u resourceUsage) float64 { return float64(u.NetworkSentBytes) }},
+		{"vcpsave_last_run_network_received_bytes", "最近一次运行下载的字节数", func(u resourceUsage) float64 { return float64(u.NetworkReceivedBytes) }},
+	}
+	for _, m := range usageMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, name := range sortedKeys(runUsage) {
+			fmt.Fprintf(w, "%s{project=%q} %g\n", m.name, name, m.value(runUsage[name]))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP vcpsave_uploads_in_progress 正在进行的分块上传数\n# TYPE vcpsave_uploads_in_progress gauge\n")
+	fmt.Fprintf(w, "vcpsave_uploads_in_progress %d\n", len(progress))
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, p := range progress {
+			fmt.Fprintf(w, "%s{key=%q} %g\n", m.name, p.Key, m.value(p))
+		}
+	}
+}
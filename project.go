@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// project 一个独立的备份项目，拥有自己的源、COS目标目录、保留策略、白名单和告警渠道
+//
+// 通过 PROJECTS=app1,app2 定义多个项目，项目的配置使用 项目名大写_ 作为前缀，
+// 例如 APP1_SOURCEFOLDER、APP1_COS_TARGET_DIR、APP1_CLEANUP_DAYS，
+// 未设置带前缀的配置时使用全局配置。未定义PROJECTS时只有一个使用全局配置的默认项目。
+type project struct {
+	Name      string
+	TargetDir string
+	envPrefix string
+	// parent 未单独设置的配置从parent读取，用于告警路由等在项目配置上叠加的配置
+	parent *project
+}
+
+// Getenv 读取项目配置，项目未单独设置时回退到全局配置；nil表示只使用全局配置
+func (proj *project) Getenv(key string) string {
+	if proj != nil && proj.envPrefix != "" {
+		if value, ok := os.LookupEnv(proj.envPrefix + key); ok {
+			return value
+		}
+	}
+	if proj != nil && proj.parent != nil {
+		return proj.parent.Getenv(key)
+	}
+	return os.Getenv(key)
+}
+
+// String 返回项目名称，用于日志
+func (proj *project) String() string {
+	if proj == nil || proj.Name == "" {
+		return "默认"
+	}
+	return proj.Name
+}
+
+// logTag 返回日志中标识项目的后缀，默认项目不输出
+func (proj *project) logTag() string {
+	if proj == nil || proj.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", proj.Name)
+}
+
+// loadProjects 根据PROJECTS加载项目列表
+func loadProjects() ([]*project, error) {
+	names := parseSourcePaths(os.Getenv("PROJECTS"))
+	if len(names) == 0 {
+		return []*project{{TargetDir: os.Getenv("COS_TARGET_DIR")}}, nil
+	}
+
+	var projects []*project
+	usedDirs := make(map[string]string)
+	for _, name := range names {
+		envPrefix := strings.ToUpper(name) + "_"
+
+		// 目标目录必须单独配置，保证各项目的备份和清理互不影响
+		targetDir, ok := os.LookupEnv(envPrefix + "COS_TARGET_DIR")
+		if !ok {
+			return nil, fmt.Errorf("项目 %s 未配置 %sCOS_TARGET_DIR", name, envPrefix)
+		}
+		if _, ok := os.LookupEnv(envPrefix + "SOURCEFOLDER"); !ok {
+			return nil, fmt.Errorf("项目 %s 未配置 %sSOURCEFOLDER", name, envPrefix)
+		}
+
+		// 列表是递归的，一个项目的目标目录在另一个之内时，外层项目的清理和垃圾回收会删除内层项目的备份
+		cleanDir := strings.Trim(targetDir, "/")
+		for _, usedDir := range sortedKeys(usedDirs) {
+			if targetDirsOverlap(cleanDir, usedDir) {
+				return nil, fmt.Errorf("项目 %s 的目标目录 %q 与项目 %s 的目标目录 %q 相同或互相包含", name, targetDir, usedDirs[usedDir], usedDir)
+			}
+		}
+		usedDirs[cleanDir] = name
+
+		projects = append(projects, &project{Name: name, TargetDir: targetDir, envPrefix: envPrefix})
+	}
+	return projects, nil
+}
+
+// targetDirsOverlap 检查两个目标目录（去掉首尾的/）是否相同或按路径段互相包含，存储桶根目录包含所有目录
+func targetDirsOverlap(a, b string) bool {
+	return a == "" || b == "" || a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// findProject 按名称查找项目，名称为空时返回唯一的项目
+func findProject(projects []*project, name string) (*project, error) {
+	if name == "" {
+		if len(projects) == 1 {
+			return projects[0], nil
+		}
+		return nil, fmt.Errorf("配置了多个项目，请使用 -project 指定")
+	}
+	for _, proj := range projects {
+		if proj.Name == name {
+			return proj, nil
+		}
+	}
+	return nil, fmt.Errorf("未找到项目: %s", name)
+}
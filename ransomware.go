@@ -0,0 +1,246 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 勒索软件启发式检测：备份时把源文件的校验和与上一次备份的清单比较，
+// 大比例文件被修改或删除、修改后的文件内容变为高熵（像加密数据）、或出现勒索软件常用的扩展名时告警，
+// 并可以自动暂停清理，保留被加密之前的旧备份。暂停后需要用 resume-cleanup 手动恢复。
+
+const (
+	ransomwareMinFiles      = 20        // 上一次备份的文件少于此数时不按比例判断，避免小目录误报
+	ransomwareEntropySample = 64 * 1024 // 计算熵时读取的文件开头字节数
+	ransomwareHighEntropy   = 7.5       // 每字节的香农熵（比特）超过此值视为加密或压缩数据
+)
+
+// 本身就是压缩格式、内容天然为高熵的扩展名，不参与熵判断
+var compressedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".mp3": true, ".mp4": true, ".mkv": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".jar": true, ".apk": true,
+}
+
+// defaultRansomwareExtensions 勒索软件常用的扩展名
+const defaultRansomwareExtensions = ".encrypted,.locked,.crypt,.crypted,.locky,.wncry,.cerber,.zepto,.odin,.ryk"
+
+// isRansomwareDetectionEnabled 是否启用勒索软件检测，启用后备份时总是计算文件校验和
+func isRansomwareDetectionEnabled(proj *project) bool {
+	return proj.Getenv("RANSOMWARE_DETECTION") == "true"
+}
+
+// getRansomwareThreshold 获取触发告警的变化比例（百分比），默认50
+func getRansomwareThreshold(proj *project) float64 {
+	threshold := 50.0
+	if thresholdStr := proj.Getenv("RANSOMWARE_CHANGE_THRESHOLD"); thresholdStr != "" {
+		if v, err := strconv.ParseFloat(thresholdStr, 64); err == nil && v > 0 && v <= 100 {
+			threshold = v
+		} else {
+			fmt.Printf("警告: RANSOMWARE_CHANGE_THRESHOLD格式错误: %s，使用默认值 %g\n", thresholdStr, threshold)
+		}
+	}
+	return threshold
+}
+
+// getRansomwareExtensions 获取可疑的扩展名列表
+func getRansomwareExtensions(proj *project) map[string]bool {
+	value := proj.Getenv("RANSOMWARE_EXTENSIONS")
+	if value == "" {
+		value = defaultRansomwareExtensions
+	}
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(value, ",") {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			extensions[ext] = true
+		}
+	}
+	return extensions
+}
+
+// shannonEntropy 计算文件开头部分每字节的香农熵
+func shannonEntropy(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, ransomwareEntropySample)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	var counts [256]int
+	for _, b := range buf[:n] {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy, nil
+}
+
+// ransomwareFindings 一次备份的检测结果
+type ransomwareFindings struct {
+	changedPercent float64  // 上一次备份中被修改或删除的文件比例
+	highEntropy    []string // 修改后变为高熵的文件
+	suspiciousExt  []string // 新增或修改的、使用可疑扩展名的文件
+}
+
+// analyzeRansomware 比较本次和上一次备份的校验和，sourceRoot为读取源文件的路径
+func analyzeRansomware(proj *project, previous, current map[string]string, sourceRoot string) *ransomwareFindings {
+	drift := compareChecksums(previous, current)
+	findings := &ransomwareFindings{}
+	if len(previous) > 0 {
+		findings.changedPercent = float64(len(drift.modified)+len(drift.removed)) * 100 / float64(len(previous))
+	}
+
+	extensions := getRansomwareExtensions(proj)
+	for _, relPath := range append(append([]string{}, drift.added...), drift.modified...) {
+		if extensions[strings.ToLower(filepath.Ext(relPath))] {
+			findings.suspiciousExt = append(findings.suspiciousExt, relPath)
+		}
+	}
+
+	// 单个文件源的校验和以文件名为键
+	root := sourceRoot
+	if info, err := os.Stat(sourceRoot); err == nil && !info.IsDir() {
+		root = filepath.Dir(sourceRoot)
+	}
+	for _, relPath := range drift.modified {
+		if compressedExtensions[strings.ToLower(filepath.Ext(relPath))] {
+			continue
+		}
+		entropy, err := shannonEntropy(filepath.Join(root, filepath.FromSlash(relPath)))
+		if err == nil && entropy > ransomwareHighEntropy {
+			findings.highEntropy = append(findings.highEntropy, relPath)
+		}
+	}
+	return findings
+}
+
+// suspicious 检测结果是否需要告警，返回告警原因
+func (f *ransomwareFindings) suspicious(proj *project, previousFiles int) []string {
+	var reasons []string
+	if previousFiles >= ransomwareMinFiles && f.changedPercent >= getRansomwareThreshold(proj) {
+		reasons = append(reasons, fmt.Sprintf("上一次备份中 %.1f%% 的文件被修改或删除", f.changedPercent))
+	}
+	if len(f.highEntropy) > 0 && (len(f.highEntropy) >= ransomwareMinFiles || len(f.highEntropy)*2 >= previousFiles) {
+		reasons = append(reasons, fmt.Sprintf("%d 个修改的文件内容变为高熵（疑似被加密），例如 %s", len(f.highEntropy), f.highEntropy[0]))
+	}
+	if len(f.suspiciousExt) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d 个文件使用勒索软件常用的扩展名，例如 %s", len(f.suspiciousExt), f.suspiciousExt[0]))
+	}
+	return reasons
+}
+
+// checkRansomware 将本次备份的校验和与上一次备份比较，可疑时告警并按配置暂停清理
+func checkRansomware(client *cos.Client, proj *project, sourcePath, sourceRoot string, checksums map[string]string) {
+	if !isRansomwareDetectionEnabled(proj) || checksums == nil {
+		return
+	}
+
+	previous, err := latestManifest(client, proj.TargetDir, sanitizeObjectName(sourceName(sourcePath)))
+	if err != nil || previous.Checksums == nil {
+		// 第一次备份或上一次备份没有记录校验和
+		return
+	}
+
+	findings := analyzeRansomware(proj, previous.Checksums, checksums, sourceRoot)
+	reasons := findings.suspicious(proj, len(previous.Checksums))
+	if len(reasons) == 0 {
+		return
+	}
+
+	message := fmt.Sprintf("源 %s 自上一次备份 %s 以来: %s", sourcePath, previous.Key, strings.Join(reasons, "；"))
+	if proj.Getenv("RANSOMWARE_PAUSE_CLEANUP") == "true" {
+		if err := pauseCleanup(client, proj.TargetDir, message); err != nil {
+			fmt.Printf("警告: %v\n", err)
+		} else {
+			message += "。已暂停清理以保留旧备份，确认安全后运行 resume-cleanup 恢复"
+		}
+	}
+	sendSourceAlert(proj, sourceName(sourcePath), "疑似勒索软件", message)
+}
+
+// cleanupPause 清理暂停状态
+type cleanupPause struct {
+	Since  string `json:"since"`
+	Reason string `json:"reason"`
+}
+
+// pauseCleanup 在远程索引中记录清理暂停，多台主机共用目标目录时同样生效
+func pauseCleanup(client *cos.Client, targetDir, reason string) error {
+	err := updateCatalogIndex(client, targetDir, func(index *catalogIndex) {
+		if index.CleanupPause == nil {
+			index.CleanupPause = &cleanupPause{Since: time.Now().Format(time.RFC3339), Reason: reason}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("暂停清理失败: %v", err)
+	}
+	return nil
+}
+
+// getCleanupPause 获取目标目录的清理暂停状态，未暂停时返回nil
+func getCleanupPause(client *cos.Client, targetDir string) *cleanupPause {
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil {
+		return nil
+	}
+	return index.CleanupPause
+}
+
+// runResumeCleanup 解除清理暂停
+func runResumeCleanup(client *cos.Client, projects []*project, args []string) error {
+	fs := flag.NewFlagSet("resume-cleanup", flag.ExitOnError)
+	projectName := fs.String("project", "", "只恢复指定项目的清理，默认恢复全部项目")
+	fs.Parse(args)
+
+	found := false
+	for _, proj := range projects {
+		if *projectName != "" && proj.Name != *projectName {
+			continue
+		}
+		found = true
+
+		pause := getCleanupPause(client, proj.TargetDir)
+		if pause == nil {
+			fmt.Printf("清理未暂停%s\n", proj.logTag())
+			continue
+		}
+		err := updateCatalogIndex(client, proj.TargetDir, func(index *catalogIndex) {
+			index.CleanupPause = nil
+		})
+		if err != nil {
+			return fmt.Errorf("恢复清理失败: %v", err)
+		}
+		fmt.Printf("已恢复清理%s，暂停于 %s: %s\n", proj.logTag(), pause.Since, pause.Reason)
+	}
+	if !found {
+		return fmt.Errorf("未找到项目: %s", *projectName)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// rclone：通过rclone命令访问rclone支持的各种存储（Google Drive、OneDrive、Dropbox、B2等，STORAGE_PROVIDER=rclone）。
+// RCLONE_REMOTE指向的目录作为目录存储，由fsTransport提供COS接口，文件操作对应rclone的
+// lsjson、cat、rcat、moveto、deletefile、rmdir和mkdir命令。调度、归档和保留仍由vcpsave负责。
+
+// rcloneConfig rclone的配置
+type rcloneConfig struct {
+	binary string   // rclone可执行文件
+	remote string   // 存储目录，如 gdrive:backup
+	config string   // rclone配置文件，为空时使用rclone的默认位置
+	args   []string // 附加到每条命令的参数
+}
+
+// getRcloneConfig 读取rclone的配置
+func getRcloneConfig(getenv func(string) string) (*rcloneConfig, error) {
+	remote := getenv("RCLONE_REMOTE")
+	if remote == "" {
+		return nil, fmt.Errorf("rclone存储未配置，请设置RCLONE_REMOTE，例如 gdrive:backup")
+	}
+	if !strings.Contains(remote, ":") {
+		return nil, fmt.Errorf("RCLONE_REMOTE格式错误，应为 远程名称:路径，当前为: %s", remote)
+	}
+
+	cfg := &rcloneConfig{
+		binary: getenv("RCLONE_BINARY"),
+		remote: strings.TrimSuffix(remote, "/"),
+		config: getenv("RCLONE_CONFIG"),
+		args:   strings.Fields(getenv("RCLONE_ARGS")),
+	}
+	if cfg.binary == "" {
+		cfg.binary = "rclone"
+	}
+	if _, err := exec.LookPath(cfg.binary); err != nil {
+		return nil, fmt.Errorf("找不到rclone，请安装rclone或设置RCLONE_BINARY: %v", err)
+	}
+	return cfg, nil
+}
+
+// rcloneFS rclone远程存储上的目录
+type rcloneFS struct {
+	cfg *rcloneConfig
+}
+
+func (f *rcloneFS) String() string {
+	return f.cfg.remote
+}
+
+// target 返回文件在rclone中的路径
+func (f *rcloneFS) target(name string) string {
+	if name == "." || name == "" {
+		return f.cfg.remote
+	}
+	if strings.HasSuffix(f.cfg.remote, ":") {
+		return f.cfg.remote + name
+	}
+	return f.cfg.remote + "/" + name
+}
+
+// command 创建rclone命令，附加配置文件和RCLONE_ARGS中的参数
+func (f *rcloneFS) command(args ...string) *exec.Cmd {
+	var full []string
+	if f.cfg.config != "" {
+		full = append(full, "--config", f.cfg.config)
+	}
+	full = append(full, f.cfg.args...)
+	full = append(full, args...)
+	return exec.Command(f.cfg.binary, full...)
+}
+
+// rcloneError 把rclone的退出码转换为错误，目录或文件不存在（退出码3、4）返回fs.ErrNotExist
+func rcloneError(op, name string, err error, stderr []byte) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 3, 4:
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	message := strings.TrimSpace(string(stderr))
+	if i := strings.LastIndex(message, "\n"); i >= 0 {
+		message = message[i+1:]
+	}
+	return fmt.Errorf("rclone %s %s 失败: %v %s", op, name, err, message)
+}
+
+// run 执行rclone命令并返回标准输出
+func (f *rcloneFS) run(op, name string, args ...string) ([]byte, error) {
+	cmd := f.command(append([]string{op}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, rcloneError(op, name, err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// rcloneEntry lsjson输出的条目
+type rcloneEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+func (f *rcloneFS) Stat(name string) (fs.FileInfo, error) {
+	output, err := f.run("lsjson", name, "--stat", "--no-mimetype", f.target(name))
+	if err != nil {
+		return nil, err
+	}
+	var entry rcloneEntry
+	if err := json.Unmarshal(output, &entry); err != nil {
+		return nil, fmt.Errorf("解析rclone输出失败: %v", err)
+	}
+	if name == "." || name == "" {
+		entry.IsDir = true
+	}
+	return &rcloneFileInfo{name: path.Base(name), entry: entry}, nil
+}
+
+func (f *rcloneFS) Open(name string) (io.ReadCloser, error) {
+	// 先确认文件存在，cat对不存在的文件不一定返回错误
+	if _, err := f.Stat(name); err != nil {
+		return nil, err
+	}
+	cmd := f.command("cat", f.target(name))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, rcloneError("cat", name, err, nil)
+	}
+	return &rcloneReader{ReadCloser: stdout, cmd: cmd, name: name, stderr: stderr}, nil
+}
+
+func (f *rcloneFS) Create(name string) (io.WriteCloser, error) {
+	cmd := f.command("rcat", f.target(name))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, rcloneError("rcat", name, err, nil)
+	}
+	return &rcloneWriter{WriteCloser: stdin, cmd: cmd, name: name, stderr: stderr}, nil
+}
+
+func (f *rcloneFS) Rename(oldName, newName string) error {
+	_, err := f.run("moveto", oldName, f.target(oldName), f.target(newName))
+	return err
+}
+
+func (f *rcloneFS) Remove(name string) error {
+	info, err := f.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		// rmdir只删除空目录
+		_, err = f.run("rmdir", name, f.target(name))
+		return err
+	}
+	_, err = f.run("deletefile", name, f.target(name))
+	return err
+}
+
+func (f *rcloneFS) MkdirAll(name string) error {
+	if name == "." || name == "" {
+		return nil
+	}
+	// mkdir会创建上级目录，对象存储等没有目录的远程存储不做任何操作
+	_, err := f.run("mkdir", name, f.target(name))
+	return err
+}
+
+func (f *rcloneFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	output, err := f.run("lsjson", name, "--no-mimetype", f.target(name))
+	if err != nil {
+		return nil, err
+	}
+	var entries []rcloneEntry
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("解析rclone输出失败: %v", err)
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		infos = append(infos, &rcloneFileInfo{name: entry.Name, entry: entry})
+	}
+	return infos, nil
+}
+
+// rcloneReader 读取rclone cat的输出，关闭时等待命令结束
+type rcloneReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	name   string
+	stderr *bytes.Buffer
+}
+
+func (r *rcloneReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		// 没有读完就关闭时rclone会因管道关闭退出，不作为错误
+		if r.cmd.ProcessState != nil && !r.cmd.ProcessState.Exited() {
+			return nil
+		}
+		return rcloneError("cat", r.name, err, r.stderr.Bytes())
+	}
+	return nil
+}
+
+// rcloneWriter 写入rclone rcat的输入，关闭时等待上传完成
+type rcloneWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	name   string
+	stderr *bytes.Buffer
+}
+
+func (w *rcloneWriter) Close() error {
+	w.WriteCloser.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return rcloneError("rcat", w.name, err, w.stderr.Bytes())
+	}
+	return nil
+}
+
+// rcloneFileInfo rclone存储上的文件信息
+type rcloneFileInfo struct {
+	name  string
+	entry rcloneEntry
+}
+
+func (i *rcloneFileInfo) Name() string       { return i.name }
+func (i *rcloneFileInfo) Size() int64        { return i.entry.Size }
+func (i *rcloneFileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i *rcloneFileInfo) IsDir() bool        { return i.entry.IsDir }
+func (i *rcloneFileInfo) Sys() interface{}   { return nil }
+
+func (i *rcloneFileInfo) Mode() fs.FileMode {
+	if i.entry.IsDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 多地域存储桶：同一份配置部署在经常移动的边缘设备上时，可以列出多个地域的存储桶，
+// 启动时测量到每个存储桶的延迟，选择最快的一个作为本次运行的存储桶。
+
+// bucketCandidate 一个候选存储桶及其测得的延迟
+type bucketCandidate struct {
+	Bucket    string  `json:"bucket"`
+	Region    string  `json:"region"`
+	LatencyMS float64 `json:"latency_ms,omitempty"` // 不可达时为0
+	Error     string  `json:"error,omitempty"`
+}
+
+// bucketSelection 按延迟选择存储桶的结果，记录在运行报告中
+type bucketSelection struct {
+	Bucket     string            `json:"bucket"`
+	Region     string            `json:"region"`
+	SelectedAt string            `json:"selected_at"`
+	Candidates []bucketCandidate `json:"candidates"`
+}
+
+// selectedBucket 启动时按延迟选择的存储桶，未配置COS_BUCKET_CANDIDATES时为nil
+var selectedBucket *bucketSelection
+
+// bucketProbeCount 每个候选存储桶测量延迟的次数，取最小值以减少偶发抖动的影响
+const bucketProbeCount = 3
+
+// measureBucketLatency 测量访问存储桶的延迟（HEAD Bucket）
+func measureBucketLatency(client *cos.Client) (time.Duration, error) {
+	var best time.Duration
+	for i := 0; i < bucketProbeCount; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		start := time.Now()
+		_, err := client.Bucket.Head(ctx)
+		elapsed := time.Since(start)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		if best == 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	return best, nil
+}
+
+// selectLowestLatencyBucket 并发测量所有候选存储桶（COS_BUCKET_CANDIDATES，存储桶:地域）的延迟，返回延迟最低的一个
+func selectLowestLatencyBucket(candidates map[string]string, secretId, secretKey string) (*bucketSelection, error) {
+	selection := &bucketSelection{SelectedAt: time.Now().Format(time.RFC3339)}
+	latencies := make([]time.Duration, 0, len(candidates))
+	for _, bucket := range sortedKeys(candidates) {
+		selection.Candidates = append(selection.Candidates, bucketCandidate{Bucket: bucket, Region: candidates[bucket]})
+		latencies = append(latencies, 0)
+	}
+
+	var wg sync.WaitGroup
+	for i := range selection.Candidates {
+		wg.Add(1)
+		go func(c *bucketCandidate, latency *time.Duration) {
+			defer wg.Done()
+			d, err := measureBucketLatency(newCOSClient(c.Bucket, c.Region, secretId, secretKey))
+			if err != nil {
+				c.Error = err.Error()
+				return
+			}
+			*latency = d
+			c.LatencyMS = float64(d.Microseconds()) / 1000
+		}(&selection.Candidates[i], &latencies[i])
+	}
+	wg.Wait()
+
+	order := make([]int, 0, len(selection.Candidates))
+	for i, c := range selection.Candidates {
+		if c.Error != "" {
+			fmt.Printf("警告: 存储桶 %s (%s) 不可用: %s\n", c.Bucket, c.Region, c.Error)
+			continue
+		}
+		fmt.Printf("存储桶 %s (%s) 延迟: %.1f ms\n", c.Bucket, c.Region, c.LatencyMS)
+		order = append(order, i)
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("COS_BUCKET_CANDIDATES中的存储桶都不可用")
+	}
+	sort.SliceStable(order, func(a, b int) bool { return latencies[order[a]] < latencies[order[b]] })
+
+	best := selection.Candidates[order[0]]
+	selection.Bucket = best.Bucket
+	selection.Region = best.Region
+	return selection, nil
+}
+
+// applyBucketSelection 将选中的存储桶写回COS_BUCKET_NAME和COS_REGION，
+// 存储桶创建、策略检查、运行报告中的配置快照等都使用选中的存储桶
+func applyBucketSelection(selection *bucketSelection) {
+	os.Setenv("COS_BUCKET_NAME", selection.Bucket)
+	os.Setenv("COS_REGION", selection.Region)
+	selectedBucket = selection
+}
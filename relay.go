@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 中继模式：不能持有云端密钥或不能直接访问外网的设备（STORAGE_PROVIDER=relay）把COS请求原样发送给
+// vcpsave中继，中继校验令牌和可访问的前缀后，用自己的密钥签名并转发到存储桶。
+// 中继转发的是普通的COS API请求，备份、列出、清理等逻辑在设备上不需要区分是否经过中继。
+
+// relayListParams 限制前缀时允许的存储桶级请求参数（列出对象和未完成的分块上传）
+var relayListParams = map[string]bool{
+	"prefix": true, "marker": true, "max-keys": true, "delimiter": true, "encoding-type": true,
+	"uploads": true, "key-marker": true, "upload-id-marker": true,
+}
+
+// getRelayConfig 读取设备端的中继地址和令牌
+func getRelayConfig(getenv func(string) string) (*url.URL, string, error) {
+	relayURL := getenv("RELAY_URL")
+	if relayURL == "" {
+		return nil, "", fmt.Errorf("中继地址未配置，请设置RELAY_URL，例如 https://relay.example.com:8443")
+	}
+	u, err := url.Parse(relayURL)
+	if err != nil || u.Host == "" {
+		return nil, "", fmt.Errorf("RELAY_URL格式错误: %s", relayURL)
+	}
+	token := getenv("RELAY_TOKEN")
+	if token == "" {
+		return nil, "", fmt.Errorf("中继令牌未配置，请在中继上运行 vcpsave token create -scopes relay 创建令牌后设置RELAY_TOKEN")
+	}
+	if u.Scheme != "https" {
+		fmt.Printf("警告: RELAY_URL未使用HTTPS，令牌和备份数据将以明文传输\n")
+	}
+	return u, token, nil
+}
+
+// newRelayClient 创建通过中继访问存储桶的客户端
+func newRelayClient(relayURL *url.URL, token string) *cos.Client {
+	bu := &url.URL{Scheme: relayURL.Scheme, Host: relayURL.Host}
+	auth := &headerTransport{headers: http.Header{"Authorization": {"Bearer " + token}}, transport: http.DefaultTransport}
+	client := cos.NewClient(&cos.BaseURL{BucketURL: bu}, &http.Client{
+		Transport: &meteredTransport{transport: withRequestHeaders(auth)},
+	})
+	client.UserAgent = userAgent()
+	// 中继后面可能是S3兼容存储，不一定返回CRC64校验头
+	client.Conf.EnableCRC = false
+	client.Conf.RetryOpt.AutoSwitchHost = false
+	return client
+}
+
+// relayUpstream 返回中继转发的目标地址和签名用的transport，与中继本机直接访问存储时相同
+func relayUpstream(client *cos.Client) (*url.URL, http.RoundTripper, error) {
+	upstream := client.BaseURL.BucketURL
+	switch provider := getStorageProvider(); provider {
+	case "cos":
+		return upstream, withRequestHeaders(&cos.AuthorizationTransport{
+			SecretID:  os.Getenv("TENCENTCLOUD_SECRET_ID"),
+			SecretKey: os.Getenv("TENCENTCLOUD_SECRET_KEY"),
+		}), nil
+	case "s3", "oss", "kodo", "obs":
+		getConfig := getS3Config
+		switch provider {
+		case "oss":
+			getConfig = getOSSConfig
+		case "kodo":
+			getConfig = getKodoConfig
+		case "obs":
+			getConfig = getOBSConfig
+		}
+		cfg, err := getConfig(os.Getenv)
+		if err != nil {
+			return nil, nil, err
+		}
+		return upstream, withRequestHeaders(&s3Transport{cfg: cfg, transport: http.DefaultTransport}), nil
+	default:
+		return nil, nil, fmt.Errorf("中继不能转发到 %s，请为中继配置cos、s3、oss、kodo或obs存储", provider)
+	}
+}
+
+// relayAllPrefixes 允许令牌访问整个存储桶的前缀配置
+const relayAllPrefixes = "*"
+
+// getRelayPrefixes 读取RELAY_ALLOWED_PREFIXES（令牌名称:前缀），前缀统一为以"/"结尾，
+// 按完整的路径段匹配（edge1 不包括 edge10/）；前缀为 * 时可以访问整个存储桶
+func getRelayPrefixes() map[string]string {
+	prefixes := parseKeyValueList(os.Getenv("RELAY_ALLOWED_PREFIXES"))
+	for name, prefix := range prefixes {
+		if prefix == relayAllPrefixes {
+			continue
+		}
+		cleanPrefix := strings.Trim(prefix, "/")
+		if cleanPrefix == "" {
+			fmt.Printf("警告: 令牌 %s 的中继前缀为空，访问整个存储桶请配置为 *，该令牌的请求将被拒绝\n", name)
+			delete(prefixes, name)
+			continue
+		}
+		prefixes[name] = cleanPrefix + "/"
+	}
+	return prefixes
+}
+
+// relayAllowed 检查请求是否只访问allowedPrefix下的对象。allowedPrefix为getRelayPrefixes返回的前缀，
+// 为空（令牌未配置前缀）时拒绝，为 * 时不限制
+func relayAllowed(r *http.Request, allowedPrefix string) bool {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	if allowedPrefix == "" {
+		return false
+	}
+	if allowedPrefix == relayAllPrefixes {
+		return true
+	}
+
+	// 所有设备都需要读取全局的删除冻结标记
+	if key == getFreezeKey() && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return true
+	}
+
+	// 存储桶级请求只允许检查存储桶是否存在，以及在允许的前缀内列出
+	if key == "" {
+		if r.Method == http.MethodHead {
+			return true
+		}
+		query := r.URL.Query()
+		for name := range query {
+			if !relayListParams[name] {
+				return false
+			}
+		}
+		return r.Method == http.MethodGet && strings.HasPrefix(query.Get("prefix"), allowedPrefix)
+	}
+	if !strings.HasPrefix(key, allowedPrefix) {
+		return false
+	}
+
+	// 复制对象时源对象也必须在允许的前缀内
+	if source := r.Header.Get("x-cos-copy-source"); source != "" {
+		_, sourceKey, _ := strings.Cut(source, "/")
+		sourceKey, err := url.PathUnescape(sourceKey)
+		if err != nil || !strings.HasPrefix(sourceKey, allowedPrefix) || slices.Contains(strings.Split(sourceKey, "/"), "..") {
+			return false
+		}
+	}
+	return true
+}
+
+// runRelay 运行中继服务，设备使用 scope 为 relay 的API令牌访问
+func runRelay(client *cos.Client) error {
+	listen := os.Getenv("RELAY_LISTEN")
+	if listen == "" {
+		listen = ":8443"
+	}
+	upstream, transport, err := relayUpstream(client)
+	if err != nil {
+		return err
+	}
+	// 按令牌名称限制可访问的前缀（令牌名称:前缀），未配置前缀的令牌不能访问
+	prefixes := getRelayPrefixes()
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = upstream.Scheme
+			r.Out.URL.Host = upstream.Host
+			r.Out.Host = upstream.Host
+			r.Out.Header.Del("Authorization")
+			// 设备生成的复制源是中继的地址
+			if source := r.Out.Header.Get("x-cos-copy-source"); source != "" {
+				if _, sourceKey, ok := strings.Cut(source, "/"); ok {
+					r.Out.Header.Set("x-cos-copy-source", upstream.Host+"/"+sourceKey)
+				}
+			}
+		},
+		Transport: &meteredTransport{transport: transport},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := findAPIToken(requestToken(r))
+		if token == nil || !slices.Contains(token.Scopes, scopeRelay) {
+			http.Error(w, "令牌无效", http.StatusUnauthorized)
+			return
+		}
+		if !relayAllowed(r, prefixes[token.Name]) {
+			fmt.Printf("警告: 拒绝中继请求 (令牌: %s, 来源: %s): %s %s\n", token.Name, r.RemoteAddr, r.Method, r.URL.Path)
+			http.Error(w, "没有权限访问该对象", http.StatusForbidden)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+
+	certFile, keyFile := os.Getenv("RELAY_TLS_CERT"), os.Getenv("RELAY_TLS_KEY")
+	fmt.Printf("中继已启动: %s -> %s\n", listen, upstream.Host)
+	if certFile != "" && keyFile != "" {
+		return http.ListenAndServeTLS(listen, certFile, keyFile, handler)
+	}
+	fmt.Printf("警告: 未配置RELAY_TLS_CERT和RELAY_TLS_KEY，中继使用HTTP，请放在带HTTPS的反向代理之后\n")
+	return http.ListenAndServe(listen, handler)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// runRepair 执行 repair 命令：列出存储桶并重新解析文件名，重建远程索引和本地历史
+func runRepair(client *cos.Client, projects []*project, args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "只输出重建结果，不写入远程索引和本地历史")
+	projectName := fs.String("project", "", "要修复的项目名称，只有一个项目时可省略")
+	fs.Parse(args)
+
+	proj, err := findProject(projects, *projectName)
+	if err != nil {
+		return err
+	}
+	targetDir := proj.TargetDir
+
+	fmt.Printf("\n=== 开始修复索引%s ===\n", proj.logTag())
+
+	objects, err := listCOSObjects(client, targetDir)
+	if err != nil {
+		return err
+	}
+
+	dirPrefix := ""
+	if cleanDir := strings.Trim(targetDir, "/"); cleanDir != "" {
+		dirPrefix = cleanDir + "/"
+	}
+
+	// 从对象列表重建远程索引的备份记录，写入时保留清理记录和清理暂停
+	index := &catalogIndex{}
+	skipped := newSkipSummary()
+	splits := make(map[string]*catalogEntry) // 分卷备份：不带卷号的对象键 → 合并后的记录
+	metaKeys := make(map[string]bool)        // 元数据对象，用于判断备份是否有清单
+	for _, object := range objects {
+		fileName := strings.TrimPrefix(object.Key, dirPrefix)
+		if isMetaFile(fileName) {
+			metaKeys[object.Key] = true
+			continue
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		if strings.Contains(fileName, "/") {
+			skipped.add("子目录中的文件", fileName)
+			continue
+		}
+
+		prefix, timeStamp, isOurFormat := parseFileName(fileName)
+		if !isOurFormat {
+			skipped.add("非程序上传文件", fileName)
+			continue
+		}
+
+		// 同一备份的分卷合并为一条记录，大小为各卷之和
+		base := splitBaseName(fileName)
+		if base != fileName {
+			key := cosObjectKey(targetDir, base)
+			if split := splits[key]; split != nil {
+				split.Size += object.Size
+				split.Parts++
+				continue
+			}
+		}
+
+		entry := catalogEntry{
+			Key:       object.Key,
+			Prefix:    prefix,
+			TimeStamp: timeStamp,
+			Size:      object.Size,
+		}
+
+		// 加密备份的密钥ID记录在对象元数据中
+		if strings.HasSuffix(base, ".enc") {
+			resp, err := client.Object.Head(context.Background(), object.Key, nil)
+			if err != nil {
+				fmt.Printf("警告: 读取对象元数据失败: %s, 错误: %v\n", object.Key, err)
+			} else {
+				entry.KeyID = resp.Header.Get("x-cos-meta-vcpsave-key-id")
+			}
+		}
+
+		if base != fileName {
+			entry.Key = cosObjectKey(targetDir, base)
+			entry.Parts = 1
+			splits[entry.Key] = &entry
+			continue
+		}
+		index.addEntry(entry)
+	}
+	for _, key := range sortedKeys(splits) {
+		index.addEntry(*splits[key])
+	}
+	skipped.print()
+	fmt.Printf("从存储桶中识别到 %d 个备份\n", len(index.Backups))
+
+	// 有备份清单时从清单补充源路径和校验和，没有清单的备份只能从文件名重建
+	withoutManifest := 0
+	for i := range index.Backups {
+		entry := &index.Backups[i]
+		fileName := strings.TrimPrefix(entry.Key, dirPrefix)
+		if !metaKeys[getManifestKey(targetDir, fileName)] {
+			fmt.Printf("警告: %s 没有备份清单，重建的记录缺少源路径和校验和\n", entry.Key)
+			withoutManifest++
+			continue
+		}
+		manifest, err := loadManifest(client, targetDir, fileName)
+		if err != nil {
+			fmt.Printf("警告: %s: %v，重建的记录缺少源路径和校验和\n", entry.Key, err)
+			withoutManifest++
+			continue
+		}
+		entry.Source = manifest.Source
+		entry.SHA256 = manifest.SHA256
+		if entry.KeyID == "" {
+			entry.KeyID = manifest.KeyID
+		}
+	}
+	if withoutManifest > 0 {
+		fmt.Printf("%d 个备份没有可用的清单\n", withoutManifest)
+	}
+
+	// 合并本地历史：保留已有记录，补充本地缺失的备份
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Printf("警告: %v，将重新生成本地历史\n", err)
+		records = nil
+	}
+
+	known := make(map[string]bool)
+	for _, record := range records {
+		if record.Key != "" {
+			known[record.Key] = true
+		}
+	}
+
+	recoveredCount := 0
+	for _, entry := range index.Backups {
+		if known[entry.Key] {
+			continue
+		}
+
+		startTime := ""
+		if parsedTime, err := parseTimeStamp(entry.TimeStamp); err == nil {
+			startTime = parsedTime.Format(time.RFC3339)
+		}
+
+		records = append(records, historyRecord{
+			StartTime: startTime,
+			Project:   proj.Name,
+			Source:    entry.Source,
+			Prefix:    entry.Prefix,
+			Key:       entry.Key,
+			Size:      entry.Size,
+			KeyID:     entry.KeyID,
+			Status:    "success",
+			Recovered: true,
+		})
+		recoveredCount++
+	}
+	fmt.Printf("本地历史中缺失 %d 条备份记录\n", recoveredCount)
+
+	if *dryRun {
+		fmt.Printf("=== 试运行结束，未写入任何数据 ===\n")
+		return nil
+	}
+
+	if err := replaceCatalogBackups(client, targetDir, index.Backups); err != nil {
+		return err
+	}
+	fmt.Printf("远程索引已重建: %s\n", getIndexKey(targetDir))
+
+	if err := saveHistory(records); err != nil {
+		return err
+	}
+	fmt.Printf("本地历史已重建: %s\n", getHistoryPath())
+
+	fmt.Printf("=== 修复完成 ===\n")
+	return nil
+}
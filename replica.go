@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 多目标复制：REPLICA_DESTINATIONS=nas,dr 列出副本存储，每个备份上传到主存储后再上传到所有副本，对象键相同，
+// 清理删除主存储中的备份时同步删除副本。副本的存储配置使用 REPLICA_名称大写_ 作为前缀，
+// 例如 REPLICA_NAS_STORAGE_PROVIDER=local、REPLICA_NAS_LOCAL_STORAGE_PATH=/mnt/nas，
+// 未设置带前缀的配置时使用全局配置（如相同的密钥）。
+
+// replicaTarget 一个副本存储，连接失败时在下次复制时重试
+type replicaTarget struct {
+	Name      string
+	envPrefix string
+
+	mu     sync.Mutex
+	client *cos.Client
+}
+
+// replicaResult 备份复制到一个副本存储的结果，记录在运行报告中
+type replicaResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // success 或 failed
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	replicasOnce sync.Once
+	replicas     []*replicaTarget
+)
+
+// getReplicas 获取配置的副本存储
+func getReplicas() []*replicaTarget {
+	replicasOnce.Do(func() {
+		for _, name := range parseSourcePaths(os.Getenv("REPLICA_DESTINATIONS")) {
+			replicas = append(replicas, &replicaTarget{Name: name, envPrefix: "REPLICA_" + strings.ToUpper(name) + "_"})
+		}
+	})
+	return replicas
+}
+
+// isReplicaRequired 检查副本复制失败时是否使备份失败
+func isReplicaRequired(proj *project) bool {
+	return proj.Getenv("REPLICA_REQUIRED") == "true"
+}
+
+// Getenv 读取副本存储的配置，未单独设置时回退到全局配置
+func (r *replicaTarget) Getenv(key string) string {
+	if value, ok := os.LookupEnv(r.envPrefix + key); ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// connect 获取副本存储的客户端，未连接时创建
+func (r *replicaTarget) connect() (*cos.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != nil {
+		return r.client, nil
+	}
+
+	// 存储服务必须单独配置，避免副本回退到主存储的配置
+	provider, ok := os.LookupEnv(r.envPrefix + "STORAGE_PROVIDER")
+	if !ok || provider == "" {
+		return nil, fmt.Errorf("副本 %s 未配置 %sSTORAGE_PROVIDER", r.Name, r.envPrefix)
+	}
+	fmt.Printf("连接副本存储: %s\n", r.Name)
+	client, err := newStorageClient(provider, r.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	r.client = client
+	return client, nil
+}
+
+// initReplicas 启动时连接所有副本存储，连接失败时只输出警告，复制时重试
+func initReplicas() {
+	for _, r := range getReplicas() {
+		if _, err := r.connect(); err != nil {
+			fmt.Printf("警告: 连接副本存储 %s 失败: %v\n", r.Name, err)
+		}
+	}
+}
+
+// replicateBackup 将已上传到主存储的备份复制到所有副本存储。
+// 有本地文件（暂存文件或直接上传的源文件）时从本地上传，流式上传和超大文件分块上传的备份从主存储下载后上传
+func replicateBackup(ctx context.Context, primary *cos.Client, proj *project, key, localPath string, metadata map[string]string) []replicaResult {
+	targets := getReplicas()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	results := make([]replicaResult, len(targets))
+	var wg sync.WaitGroup
+	for i, r := range targets {
+		wg.Add(1)
+		go func(i int, r *replicaTarget) {
+			defer wg.Done()
+			results[i] = replicaResult{Name: r.Name, Status: "success"}
+			if err := r.put(ctx, primary, key, localPath, metadata); err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+				fmt.Printf("警告: 复制到副本 %s 失败: %v\n", r.Name, err)
+				return
+			}
+			fmt.Printf("已复制到副本 %s: %s\n", r.Name, key)
+		}(i, r)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, result := range results {
+		if result.Status != "success" {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
+	}
+	if len(failed) > 0 {
+		sendSourceAlert(proj, keySource(key), "副本复制失败", fmt.Sprintf("%s 未能复制到 %d 个副本存储: %s", key, len(failed), strings.Join(failed, "; ")))
+	}
+	return results
+}
+
+// put 上传备份到副本存储，并确认副本的大小与源一致
+func (r *replicaTarget) put(ctx context.Context, primary *cos.Client, key, localPath string, metadata map[string]string) error {
+	client, err := r.connect()
+	if err != nil {
+		return err
+	}
+	if err := checkStorageMount(client); err != nil {
+		return err
+	}
+	opt := &cos.ObjectPutOptions{
+		ACLHeaderOptions: uploadACLHeader(),
+		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+			XCosMetaXXX: metadataHeader(metadata),
+		},
+	}
+
+	var size int64
+	if localPath != "" {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+		if _, err := client.Object.PutFromFile(ctx, key, localPath, opt); err != nil {
+			return fmt.Errorf("上传失败: %v", err)
+		}
+	} else {
+		resp, err := primary.Object.Get(ctx, key, nil)
+		if err != nil {
+			return fmt.Errorf("从主存储下载失败: %v", err)
+		}
+		defer resp.Body.Close()
+		size = resp.ContentLength
+		opt.ObjectPutHeaderOptions.ContentLength = size
+		if _, err := client.Object.Put(ctx, key, resp.Body, opt); err != nil {
+			return fmt.Errorf("上传失败: %v", err)
+		}
+	}
+
+	resp, err := client.Object.Head(ctx, key, nil)
+	if err != nil {
+		return fmt.Errorf("验证副本失败: %v", err)
+	}
+	if resp.ContentLength != size {
+		return fmt.Errorf("副本大小不一致: 应为 %d bytes，实际 %d bytes", size, resp.ContentLength)
+	}
+	return nil
+}
+
+// ensureObject 副本存储中没有key时从本地文件上传，用于字典等多个备份共用的对象
+func (r *replicaTarget) ensureObject(primary *cos.Client, key, localPath string) error {
+	client, err := r.connect()
+	if err != nil {
+		return err
+	}
+	if _, err := client.Object.Head(context.Background(), key, nil); err == nil {
+		return nil
+	}
+	return r.put(context.Background(), primary, key, localPath, nil)
+}
+
+// deleteFromReplicas 从所有副本存储中删除已清理的备份
+func deleteFromReplicas(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	for _, r := range getReplicas() {
+		client, err := r.connect()
+		if err != nil {
+			fmt.Printf("警告: 副本 %s 不可用，%d 个已清理的备份未删除: %v\n", r.Name, len(keys), err)
+			continue
+		}
+		for _, key := range keys {
+			if _, err := client.Object.Delete(context.Background(), key); err != nil {
+				fmt.Printf("警告: 从副本 %s 删除失败: %s, 错误: %v\n", r.Name, key, err)
+			}
+		}
+	}
+}
+
+// replicaFailures 统计复制失败的副本数
+func replicaFailures(results []replicaResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Status != "success" {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 每次备份运行在 .vcpsave/reports 下写入运行报告，每个备份对象在 .vcpsave/manifests 下写入清单，
+// 两者都附带当时生效的配置快照（密钥等敏感配置已脱敏），便于事后审计某个备份是按什么策略产生的。
+
+// configKeys 写入配置快照的配置项
+var configKeys = []string{
+	"PROJECTS",
+	"STRICT_CONFIG",
+	"SOURCEFOLDER",
+	"SOURCE_DISABLED",
+	"NESTED_SOURCE_POLICY",
+	"BACKUP_INTERVAL",
+	"SOURCE_INTERVAL",
+	"OVERLAP_POLICY",
+	"MISSING_SOURCE_POLICY",
+	"SOURCE_MISSING_POLICY",
+	"MISSING_SOURCE_WAIT",
+	"WAIT_FOR_SOURCES",
+	"SOURCE_DATE_OFFSET",
+	"SOURCE_MAX_AGE",
+	"SOURCE_MIN_AGE",
+	"SOURCE_OFFLOAD",
+	"OFFLOAD_TRASH_DIR",
+	"COS_TARGET_DIR",
+	"COS_BUCKET_NAME",
+	"COS_BUCKET_CANDIDATES",
+	"COS_FALLBACK_BUCKET",
+	"HEALTH_CHECK_INTERVAL",
+	"HEALTH_CHECK_FAILURES",
+	"SENSITIVE_SCAN",
+	"SENSITIVE_PATTERNS",
+	"TRANSFORMS",
+	"FILENAME_SANITIZE",
+	"FILENAME_SANITIZE_CHARS",
+	"FILENAME_MAX_LENGTH",
+	"FILENAME_LEGACY_ENCODING",
+	"ICONV_PATH",
+	"STORAGE_PROVIDER",
+	"S3_ENDPOINT",
+	"S3_REGION",
+	"S3_BUCKET",
+	"S3_ACCESS_KEY_ID",
+	"S3_SECRET_ACCESS_KEY",
+	"S3_VIRTUAL_HOSTED",
+	"OSS_REGION",
+	"OSS_ENDPOINT",
+	"OSS_BUCKET",
+	"OSS_ACCESS_KEY_ID",
+	"OSS_ACCESS_KEY_SECRET",
+	"KODO_REGION",
+	"KODO_ENDPOINT",
+	"KODO_BUCKET",
+	"KODO_ACCESS_KEY",
+	"KODO_SECRET_KEY",
+	"OBS_REGION",
+	"OBS_ENDPOINT",
+	"OBS_BUCKET",
+	"OBS_ACCESS_KEY_ID",
+	"OBS_SECRET_ACCESS_KEY",
+	"RELAY_URL",
+	"RELAY_TOKEN",
+	"RELAY_LISTEN",
+	"RELAY_TLS_CERT",
+	"RELAY_TLS_KEY",
+	"RELAY_ALLOWED_PREFIXES",
+	"LOCAL_STORAGE_PATH",
+	"LOCAL_MOUNT_CHECK",
+	"LOCAL_MOUNT_TIMEOUT",
+	"SFTP_HOST",
+	"SFTP_PORT",
+	"SFTP_USER",
+	"SFTP_KEY_FILE",
+	"SFTP_PASSWORD",
+	"SFTP_PATH",
+	"SFTP_KNOWN_HOSTS",
+	"SFTP_SSH_PATH",
+	"FTP_HOST",
+	"FTP_PORT",
+	"FTP_USER",
+	"FTP_PASSWORD",
+	"FTP_PATH",
+	"FTP_TLS",
+	"FTP_TLS_SKIP_VERIFY",
+	"REPLICA_DESTINATIONS",
+	"REPLICA_REQUIRED",
+	"WEBDAV_URL",
+	"WEBDAV_USER",
+	"WEBDAV_PASSWORD",
+	"WEBDAV_CHUNKED",
+	"RCLONE_REMOTE",
+	"RCLONE_CONFIG",
+	"RCLONE_ARGS",
+	"RCLONE_BINARY",
+	"COS_REGION",
+	"CREATE_BUCKET_IF_MISSING",
+	"CREATE_BUCKET_ACL",
+	"ENFORCE_PRIVATE_ACL",
+	"PUBLIC_BUCKET_POLICY",
+	"TENCENTCLOUD_SECRET_ID",
+	"TENCENTCLOUD_SECRET_KEY",
+	"CLEANUP_ENABLED",
+	"CLEANUP_TIME",
+	"CLEANUP_DAYS",
+	"CLEANUP_MAX_AGE",
+	"CLEANUP_WHITELIST",
+	"CLEANUP_CONCURRENCY",
+	"CLEANUP_MAX_DELETE",
+	"CLEANUP_MAX_DELETE_PERCENT",
+	"CLEANUP_CONFIRM_MASS_DELETE",
+	"CLOCK_SKEW_MAX",
+	"SOURCE_PIPELINE",
+	"FILE_UPLOAD_SOURCES",
+	"DEFAULT_PIPELINE",
+	"ARCHIVE_PASSWORD",
+	"GZIP_LEVEL",
+	"ZIP64_POLICY",
+	"ARCHIVE_SPLIT_SIZE",
+	"GZIP_COMPRESSOR",
+	"PIGZ_PATH",
+	"COMPRESS_THREADS",
+	"MANIFEST_CHECKSUMS",
+	"RANSOMWARE_DETECTION",
+	"RANSOMWARE_CHANGE_THRESHOLD",
+	"RANSOMWARE_EXTENSIONS",
+	"RANSOMWARE_PAUSE_CLEANUP",
+	"BACKUP_FREEZE",
+	"BACKUP_FREEZE_REASON",
+	"ZSTD_PATH",
+	"ZSTD_LEVEL",
+	"ZSTD_DICT_SAMPLES",
+	"PRESERVE_ACLS",
+	"USE_VSS",
+	"ENCRYPTION_KEYS",
+	"SOURCE_ENCRYPTION_KEYS",
+	"STREAM_UPLOAD",
+	"STREAM_PART_SIZE_MB",
+	"LARGE_FILE_THRESHOLD_MB",
+	"LARGE_FILE_PART_SIZE_MB",
+	"STAGING_DIR",
+	"STAGING_MAX_SIZE_MB",
+	"LOAD_PAUSE_LOAD",
+	"LOAD_PAUSE_CPU_PRESSURE",
+	"LOAD_PAUSE_IO_PRESSURE",
+	"LOAD_CHECK_INTERVAL",
+	"LOAD_PAUSE_MAX",
+	"UPLOAD_PART_RETRIES",
+	"SEED_SOURCES",
+	"SEED_DIR",
+	"SEED_PART_SIZE_MB",
+	"SEED_WINDOW",
+	"SEED_BANDWIDTH_MB",
+	"SOURCE_SLA",
+	"SLA_CHECK_INTERVAL",
+	"POLICY_VERSIONING",
+	"POLICY_ENCRYPTION",
+	"POLICY_OBJECT_LOCK_DAYS",
+	"POLICY_ACL",
+	"POLICY_LIFECYCLE_RULES",
+	"POLICY_CHECK_INTERVAL",
+	"ALERT_WEBHOOK_URL",
+	"ALERT_ROUTES",
+	"PLUGIN_HOOKS",
+	"COMPLETION_HOOKS",
+	"PLUGIN_STORAGE",
+	"PLUGIN_TIMEOUT",
+	"NTFY_URL",
+	"NTFY_TOPIC",
+	"NTFY_TOKEN",
+	"NTFY_PRIORITY",
+	"GOTIFY_URL",
+	"GOTIFY_TOKEN",
+	"GOTIFY_PRIORITY",
+	"INCIDENT_FAILURE_THRESHOLD",
+	"PAGERDUTY_ROUTING_KEY",
+	"OPSGENIE_API_KEY",
+	"OPSGENIE_API_URL",
+	"MQTT_BROKER",
+	"MQTT_USERNAME",
+	"MQTT_PASSWORD",
+	"MQTT_CLIENT_ID",
+	"MQTT_TOPIC",
+	"GC_ENABLED",
+	"GC_POLICY",
+	"GC_GRACE_HOURS",
+	"CONSOLIDATE_AFTER_DAYS",
+	"DATA_DIR",
+	"STATUS_FILE",
+	"STATUS_STAMP",
+	"BACKUP_TIMEZONE",
+	"USER_AGENT_TAG",
+	"COS_REQUEST_HEADERS",
+	"ACCESS_LOG_BUCKET",
+	"ACCESS_LOG_REGION",
+	"ACCESS_LOG_PREFIX",
+	"PROBE_BEFORE_RUN",
+	"BACKUP_WINDOW",
+	"SOURCE_PRIORITY",
+	"PRIORITY_DEFER_BELOW",
+	"RUN_RESUME_MAX_AGE",
+	"WEB_LISTEN",
+	"WEB_TOKEN",
+	"WEB_VIEWER_TOKEN",
+}
+
+// secretConfigKeys 值需要整体脱敏的配置项（webhook地址中通常带有令牌）
+var secretConfigKeys = map[string]bool{
+	"ENCRYPTION_KEYS":       true,
+	"ALERT_WEBHOOK_URL":     true,
+	"PAGERDUTY_ROUTING_KEY": true,
+	"OPSGENIE_API_KEY":      true,
+}
+
+// isSecretConfig 检查配置项是否为敏感配置
+func isSecretConfig(key string) bool {
+	if secretConfigKeys[key] {
+		return true
+	}
+	for _, word := range []string{"SECRET", "PASSWORD", "TOKEN"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// configSnapshot 获取项目当前生效的配置，敏感配置只记录是否已设置
+func configSnapshot(proj *project) map[string]string {
+	snapshot := make(map[string]string)
+	for _, key := range configKeys {
+		value := proj.Getenv(key)
+		if key == "COS_TARGET_DIR" && proj != nil {
+			value = proj.TargetDir
+		}
+		if value == "" {
+			continue
+		}
+		if isSecretConfig(key) {
+			value = "******"
+		}
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// runReport 一次备份运行的报告
+type runReport struct {
+	Project   string            `json:"project,omitempty"`
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Skipped   int               `json:"skipped,omitempty"`
+	Disabled  int               `json:"disabled,omitempty"`
+	Results   []historyRecord   `json:"results"`
+	Resources *resourceUsage    `json:"resources,omitempty"` // 整次运行的资源使用
+	Config    map[string]string `json:"config"`
+
+	Target *bucketSelection `json:"target,omitempty"` // 按延迟选择的存储桶
+	Hooks  []hookResult     `json:"hooks,omitempty"`  // 完成钩子的执行结果
+}
+
+// backupManifest 单个备份对象的清单
+type backupManifest struct {
+	Key       string            `json:"key"`
+	Source    string            `json:"source"`
+	Prefix    string            `json:"prefix"`
+	TimeStamp string            `json:"timestamp"`
+	TimeZone  string            `json:"timezone"` // 时间戳所在的时区
+	Size      int64             `json:"size"`
+	KeyID     string            `json:"key_id,omitempty"`
+	SHA256    string            `json:"sha256,omitempty"`
+	Files     int               `json:"files"`    // 归档的源文件数
+	RawSize   int64             `json:"raw_size"` // 归档前源文件的总大小
+	CreatedAt string            `json:"created_at"`
+	Config    map[string]string `json:"config"`
+
+	// Checksums 每个源文件的SHA-256（相对路径 -> 十六进制），启用MANIFEST_CHECKSUMS时记录，供 verify 比较
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// Renamed 开启FILENAME_SANITIZE后改名的条目（归档中的名称 -> 原路径），extract -manifest 解压时恢复原名
+	Renamed map[string]string `json:"renamed,omitempty"`
+	// Sensitive 开启SENSITIVE_SCAN时文件名匹配敏感模式的文件（相对路径）
+	Sensitive []string `json:"sensitive_files,omitempty"`
+	// Parts 分卷数，各卷为 Key.001、Key.002……，未分卷时为0
+	Parts int `json:"parts,omitempty"`
+}
+
+// getReportKey 获取运行报告的对象键
+func getReportKey(targetDir string, startTime time.Time) string {
+	return cosObjectKey(targetDir, fmt.Sprintf("%s/reports/%s.json", metaDirName, startTime.In(getBackupLocation()).Format(timeStampLayout+".000")))
+}
+
+// getManifestKey 获取备份文件对应清单的对象键
+func getManifestKey(targetDir, fileName string) string {
+	return cosObjectKey(targetDir, fmt.Sprintf("%s/manifests/%s.json", metaDirName, fileName))
+}
+
+// putJSONObject 将数据序列化为JSON写入COS
+func putJSONObject(client *cos.Client, key string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化失败: %v", err)
+	}
+
+	_, err = client.Object.Put(context.Background(), key, bytes.NewReader(data), nil)
+	if err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", key, err)
+	}
+	return nil
+}
+
+// saveRunReport 写入运行报告
+func saveRunReport(client *cos.Client, targetDir string, startTime time.Time, report *runReport) error {
+	return putJSONObject(client, getReportKey(targetDir, startTime), report)
+}
+
+// saveManifest 写入备份清单
+func saveManifest(client *cos.Client, targetDir string, manifest *backupManifest) error {
+	fileName := strings.TrimPrefix(manifest.Key, strings.Trim(targetDir, "/")+"/")
+	return putJSONObject(client, getManifestKey(targetDir, fileName), manifest)
+}
+
+// loadManifest 读取备份文件对应的清单
+func loadManifest(client *cos.Client, targetDir, fileName string) (*backupManifest, error) {
+	resp, err := client.Object.Get(context.Background(), getManifestKey(targetDir, fileName), nil)
+	if err != nil {
+		return nil, fmt.Errorf("读取清单失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	manifest := &backupManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("解析清单失败: %v", err)
+	}
+	return manifest, nil
+}
+
+// deleteManifest 删除备份文件对应的清单，清单不存在时忽略
+func deleteManifest(client *cos.Client, targetDir, fileName string) error {
+	_, err := client.Object.Delete(context.Background(), getManifestKey(targetDir, fileName))
+	if err != nil && !cos.IsNotFoundError(err) {
+		return fmt.Errorf("删除清单失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每次运行和每个源的资源使用：CPU时间、内存峰值、临时磁盘和网络流量，
+// 写入运行报告并通过 /metrics 输出，用于确定主机规格和分析备份慢的原因。
+// 进程内同时运行多个任务（如清理与备份）时，CPU时间和内存峰值按进程统计，只能作为近似值；
+// 网络流量按请求所属的源统计。
+
+// resourceUsage 一段时间内的资源使用
+type resourceUsage struct {
+	CPUSeconds           float64 `json:"cpu_seconds"`       // 本进程和已结束的子进程（如zstd）的CPU时间
+	PeakMemoryBytes      uint64  `json:"peak_memory_bytes"` // Go运行时使用的内存峰值，不含子进程
+	TempDiskBytes        int64   `json:"temp_disk_bytes,omitempty"`
+	NetworkSentBytes     int64   `json:"network_sent_bytes"`
+	NetworkReceivedBytes int64   `json:"network_received_bytes"`
+}
+
+// String 返回用于日志的资源使用摘要
+func (u *resourceUsage) String() string {
+	return fmt.Sprintf("CPU %.1fs, 内存峰值 %.1f MB, 临时磁盘 %.1f MB, 网络 上传 %.1f MB / 下载 %.1f MB",
+		u.CPUSeconds, float64(u.PeakMemoryBytes)/1024/1024, float64(u.TempDiskBytes)/1024/1024,
+		float64(u.NetworkSentBytes)/1024/1024, float64(u.NetworkReceivedBytes)/1024/1024)
+}
+
+// resourceMeter 统计一次运行或一个源的资源使用，计数同时累加到上级（源 → 运行）
+type resourceMeter struct {
+	parent   *resourceMeter
+	cpuStart time.Duration
+	sent     atomic.Int64
+	received atomic.Int64
+	temp     atomic.Int64
+	peak     atomic.Uint64
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// memorySampleInterval 内存采样间隔
+const memorySampleInterval = time.Second
+
+// startResourceMeter 开始统计资源使用，parent不为nil时计数同时累加到parent
+func startResourceMeter(parent *resourceMeter) *resourceMeter {
+	m := &resourceMeter{parent: parent, cpuStart: processCPUTime(), done: make(chan struct{})}
+	m.sampleMemory()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sampleMemory()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// sampleMemory 记录当前Go运行时使用的内存
+func (m *resourceMeter) sampleMemory() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	m.observePeak(stats.HeapInuse + stats.StackInuse)
+}
+
+// observePeak 更新内存峰值
+func (m *resourceMeter) observePeak(bytes uint64) {
+	for ; m != nil; m = m.parent {
+		for {
+			peak := m.peak.Load()
+			if bytes <= peak || m.peak.CompareAndSwap(peak, bytes) {
+				break
+			}
+		}
+	}
+}
+
+// addNetwork 累加网络流量
+func (m *resourceMeter) addNetwork(sent, received int64) {
+	for ; m != nil; m = m.parent {
+		m.sent.Add(sent)
+		m.received.Add(received)
+	}
+}
+
+// addTempDisk 累加写入的临时文件大小
+func (m *resourceMeter) addTempDisk(bytes int64) {
+	for ; m != nil; m = m.parent {
+		m.temp.Add(bytes)
+	}
+}
+
+// stop 停止统计并返回资源使用
+func (m *resourceMeter) stop() *resourceUsage {
+	close(m.done)
+	m.wg.Wait()
+	m.sampleMemory()
+	return &resourceUsage{
+		CPUSeconds:           float64(int64((processCPUTime()-m.cpuStart).Seconds()*100)) / 100,
+		PeakMemoryBytes:      m.peak.Load(),
+		TempDiskBytes:        m.temp.Load(),
+		NetworkSentBytes:     m.sent.Load(),
+		NetworkReceivedBytes: m.received.Load(),
+	}
+}
+
+type resourceMeterKey struct{}
+
+// withResourceMeter 返回携带资源统计的context，使用该context的COS请求计入其网络流量
+func withResourceMeter(ctx context.Context, m *resourceMeter) context.Context {
+	return context.WithValue(ctx, resourceMeterKey{}, m)
+}
+
+// resourceMeterFrom 获取context携带的资源统计，没有时返回nil
+func resourceMeterFrom(ctx context.Context) *resourceMeter {
+	m, _ := ctx.Value(resourceMeterKey{}).(*resourceMeter)
+	return m
+}
+
+// meteredTransport 将请求和响应的字节数计入请求context携带的资源统计
+type meteredTransport struct {
+	transport http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m := resourceMeterFrom(req.Context())
+	if m == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &countingReadCloser{ReadCloser: req.Body, add: func(n int64) { m.addNetwork(n, 0) }}
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingReadCloser{ReadCloser: resp.Body, add: func(n int64) { m.addNetwork(0, n) }}
+	return resp, nil
+}
+
+// countingReadCloser 统计读取的字节数
+type countingReadCloser struct {
+	io.ReadCloser
+	add func(n int64)
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.add(int64(n))
+	}
+	return n, err
+}
+
+// lastRunUsage 每个项目最近一次运行的资源使用，供 /metrics 输出
+var (
+	lastRunUsageMu sync.Mutex
+	lastRunUsage   = make(map[string]*resourceUsage)
+)
+
+// recordRunUsage 记录项目最近一次运行的资源使用
+func recordRunUsage(proj *project, usage *resourceUsage) {
+	lastRunUsageMu.Lock()
+	defer lastRunUsageMu.Unlock()
+	lastRunUsage[statusProjectName(proj)] = usage
+}
+
+// getRunUsage 返回各项目最近一次运行的资源使用的副本
+func getRunUsage() map[string]resourceUsage {
+	lastRunUsageMu.Lock()
+	defer lastRunUsageMu.Unlock()
+	result := make(map[string]resourceUsage, len(lastRunUsage))
+	for name, usage := range lastRunUsage {
+		result[name] = *usage
+	}
+	return result
+}
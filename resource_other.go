@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime 返回本进程和已结束的子进程使用的用户态和内核态CPU时间
+func processCPUTime() time.Duration {
+	var total time.Duration
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var usage syscall.Rusage
+		if err := syscall.Getrusage(who, &usage); err != nil {
+			continue
+		}
+		total += time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	}
+	return total
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime 返回本进程使用的用户态和内核态CPU时间，Windows不统计子进程
+func processCPUTime() time.Duration {
+	handle, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	// Filetime 以100纳秒为单位
+	ticks := func(ft syscall.Filetime) int64 { return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime) }
+	return time.Duration((ticks(kernel) + ticks(user)) * 100)
+}
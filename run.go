@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// runOnce 立即执行一次备份、清理和垃圾回收后退出，供cron或任务计划程序调用。
+// 有源备份失败（包括按 fail 策略处理的缺失源）时返回false，调用方以退出码1结束
+func runOnce(client *cos.Client, projects []*project, args []string) (bool, error) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	projectName := fs.String("project", "", "只运行指定的项目，默认运行全部项目")
+	fs.Parse(args)
+
+	var selected []*project
+	for _, proj := range projects {
+		if *projectName == "" || proj.Name == *projectName {
+			selected = append(selected, proj)
+		}
+	}
+	if len(selected) == 0 {
+		return false, fmt.Errorf("未找到项目: %s", *projectName)
+	}
+
+	checkNestedSources(selected)
+	waitForSources(selected)
+
+	// 同一项目的清理和垃圾回收共用列表缓存
+	beginListCache()
+	defer endListCache()
+
+	ok := true
+	for _, proj := range selected {
+		if err := ensureCOSDirectory(client, proj.TargetDir); err != nil {
+			return false, fmt.Errorf("确保目录存在失败: %v", err)
+		}
+		if err := probeTarget(client, proj.TargetDir); err != nil {
+			return false, fmt.Errorf("项目 %s 权限检查失败: %v", proj, err)
+		}
+
+		// 上次运行被中断时只备份剩余的源
+		sourcePaths, state := resumeRun(proj, getProjectSources(proj))
+		if state == nil {
+			sourcePaths = getProjectSources(proj)
+			state = newRunState(proj, sourcePaths)
+		}
+		if !performBackupSources(client, proj, sourcePaths, state) {
+			ok = false
+		}
+		performCleanup(client, proj)
+		performConsolidation(client, proj)
+		performGC(client, proj)
+	}
+	return ok, nil
+}
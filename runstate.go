@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 运行进度：完整的备份运行（定时、run命令、Web手动触发）在处理每个源之前把已完成的源保存到
+// DATA_DIR/runs 中，正常结束后删除。程序崩溃或被重启后，未过期的进度在启动时继续：
+// 只备份还没有处理完的源，已经上传的大备份不会重做，剩余的源也不必等到第二天。
+
+// runState 一次备份运行的进度
+type runState struct {
+	Project   string   `json:"project"`
+	StartTime string   `json:"start_time"`
+	Sources   []string `json:"sources"` // 本次运行的全部源
+	Done      []string `json:"done"`    // 已处理完的源（成功、失败或跳过）
+
+	path     string
+	previous []string // 继续中断的运行时，之前已处理完的源
+}
+
+// getRunResumeMaxAge 获取中断的运行可以继续的时长，超过后下次运行重新备份全部源；0表示不记录进度
+func getRunResumeMaxAge(proj *project) time.Duration {
+	maxAge := 24 * time.Hour
+	if value := proj.Getenv("RUN_RESUME_MAX_AGE"); value != "" {
+		d, err := parseAge(value)
+		if err != nil {
+			fmt.Printf("警告: RUN_RESUME_MAX_AGE格式错误: %s，使用默认值 %v\n", value, maxAge)
+		} else {
+			maxAge = d
+		}
+	}
+	return maxAge
+}
+
+// getRunStatePath 获取项目运行进度的保存路径
+func getRunStatePath(proj *project) string {
+	sum := sha1.Sum([]byte(proj.Name))
+	return filepath.Join(getDataDir(), "runs", hex.EncodeToString(sum[:])+".json")
+}
+
+// newRunState 开始记录一次运行的进度，未启用时返回nil
+func newRunState(proj *project, sourcePaths []string) *runState {
+	if getRunResumeMaxAge(proj) == 0 {
+		return nil
+	}
+	return &runState{
+		Project:   proj.Name,
+		StartTime: time.Now().Format(time.RFC3339),
+		Sources:   sourcePaths,
+		path:      getRunStatePath(proj),
+	}
+}
+
+// loadInterruptedRun 读取项目上次中断的运行，没有、已过期或未启用时返回nil
+func loadInterruptedRun(proj *project) *runState {
+	maxAge := getRunResumeMaxAge(proj)
+	if maxAge == 0 {
+		return nil
+	}
+	path := getRunStatePath(proj)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("警告: 读取运行进度失败: %v\n", err)
+		}
+		return nil
+	}
+	state := &runState{path: path}
+	if err := json.Unmarshal(data, state); err != nil || state.Project != proj.Name {
+		fmt.Printf("警告: 运行进度文件无效，忽略: %s\n", path)
+		os.Remove(path)
+		return nil
+	}
+	started, err := time.Parse(time.RFC3339, state.StartTime)
+	if err != nil || time.Since(started) > maxAge {
+		fmt.Printf("上次中断的运行%s开始于 %s，已超过 %v，不再继续\n", proj.logTag(), state.StartTime, maxAge)
+		os.Remove(path)
+		return nil
+	}
+	state.previous = state.Done
+	return state
+}
+
+// remaining 返回中断的运行中还没有处理完、且仍在当前配置中的源
+func (s *runState) remaining(configured []string) []string {
+	done := make(map[string]bool, len(s.Done))
+	for _, sourcePath := range s.Done {
+		done[sourcePath] = true
+	}
+	var sources []string
+	for _, sourcePath := range s.Sources {
+		if !done[sourcePath] && containsString(configured, sourcePath) {
+			sources = append(sources, sourcePath)
+		}
+	}
+	return sources
+}
+
+// progress 保存本次已处理完的源，在开始处理下一个源之前调用
+func (s *runState) progress(done []string) {
+	if s == nil {
+		return
+	}
+	s.Done = append(append([]string(nil), s.previous...), done...)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	}
+	if err == nil {
+		tmpPath := s.path + ".tmp"
+		if err = os.WriteFile(tmpPath, data, 0644); err == nil {
+			err = os.Rename(tmpPath, s.path)
+		}
+	}
+	if err != nil {
+		fmt.Printf("警告: 保存运行进度失败: %v\n", err)
+	}
+}
+
+// finish 运行正常结束，删除进度
+func (s *runState) finish() {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("警告: 删除运行进度失败: %v\n", err)
+	}
+}
+
+// resumeRun 继续项目上次中断的运行，返回剩余的源和进度；没有中断的运行时返回nil
+func resumeRun(proj *project, configured []string) ([]string, *runState) {
+	state := loadInterruptedRun(proj)
+	if state == nil {
+		return nil, nil
+	}
+	remaining := state.remaining(configured)
+	if len(remaining) == 0 {
+		state.finish()
+		return nil, nil
+	}
+	fmt.Printf("发现中断的运行%s（开始于 %s），已完成 %d 个源，继续备份剩余的 %d 个源\n",
+		proj.logTag(), state.StartTime, len(state.Done), len(remaining))
+	return remaining, state
+}
+
+// resumeInterruptedRuns 程序启动时在后台继续各项目中断的运行，与定时备份共用runMu
+func resumeInterruptedRuns(client *cos.Client, projects []*project) {
+	type pending struct {
+		proj    *project
+		sources []string
+		state   *runState
+	}
+	var runs []pending
+	for _, proj := range projects {
+		if sources, state := resumeRun(proj, dailySources(proj)); state != nil {
+			runs = append(runs, pending{proj, sources, state})
+		}
+	}
+	if len(runs) == 0 {
+		return
+	}
+	go func() {
+		runMu.Lock()
+		defer runMu.Unlock()
+		for _, run := range runs {
+			performBackupSources(client, run.proj, run.sources, run.state)
+		}
+	}()
+}
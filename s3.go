@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// S3兼容存储：COS的API与S3基本一致，区别主要在请求头前缀（x-cos- / x-amz-）和签名算法。
+// 设置 STORAGE_PROVIDER=s3 后，仍使用COS SDK组装请求，由s3Transport改写为S3请求并使用SigV4签名，
+// 备份、列出、清理等逻辑不需要区分存储类型。
+
+// s3Config S3兼容存储的连接配置
+type s3Config struct {
+	endpoint      *url.URL // 例如 https://s3.us-east-1.amazonaws.com 或 http://minio:9000
+	region        string
+	bucket        string
+	accessKey     string
+	secretKey     string
+	virtualHosted bool // 使用 bucket.host 形式的地址，默认使用 host/bucket 路径形式
+}
+
+// getStorageProvider 获取存储服务：cos（默认）、s3、oss、kodo、obs、relay、local、sftp、ftp、webdav 或 rclone
+func getStorageProvider() string {
+	if provider := os.Getenv("STORAGE_PROVIDER"); provider != "" {
+		return provider
+	}
+	return "cos"
+}
+
+// getS3Config 读取S3兼容存储的配置
+func getS3Config(getenv func(string) string) (*s3Config, error) {
+	endpoint := getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		return nil, fmt.Errorf("S3地址未配置，请设置S3_ENDPOINT，例如 https://s3.us-east-1.amazonaws.com 或 http://minio:9000")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT格式错误: %s", endpoint)
+	}
+
+	cfg := &s3Config{
+		endpoint:      u,
+		region:        getenv("S3_REGION"),
+		bucket:        getenv("S3_BUCKET"),
+		accessKey:     getenv("S3_ACCESS_KEY_ID"),
+		secretKey:     getenv("S3_SECRET_ACCESS_KEY"),
+		virtualHosted: getenv("S3_VIRTUAL_HOSTED") == "true",
+	}
+	if cfg.region == "" {
+		cfg.region = "us-east-1"
+	}
+	if cfg.bucket == "" {
+		return nil, fmt.Errorf("存储桶名称未配置，请设置S3_BUCKET")
+	}
+	if cfg.accessKey == "" || cfg.secretKey == "" {
+		return nil, fmt.Errorf("S3密钥未配置，请设置S3_ACCESS_KEY_ID和S3_SECRET_ACCESS_KEY")
+	}
+	return cfg, nil
+}
+
+// newS3Client 创建访问S3兼容存储的客户端
+func newS3Client(cfg *s3Config) *cos.Client {
+	bu := &url.URL{Scheme: cfg.endpoint.Scheme, Host: cfg.endpoint.Host}
+	client := cos.NewClient(&cos.BaseURL{BucketURL: bu}, &http.Client{
+		Transport: &meteredTransport{transport: withRequestHeaders(&s3Transport{cfg: cfg, transport: http.DefaultTransport})},
+	})
+	client.UserAgent = userAgent()
+	// S3不返回COS的CRC64校验头，也没有COS的备用域名
+	client.Conf.EnableCRC = false
+	client.Conf.RetryOpt.AutoSwitchHost = false
+	return client
+}
+
+// s3Transport 将COS SDK生成的请求改写为S3请求并签名，响应中的 x-amz- 头改回 x-cos- 供SDK读取
+type s3Transport struct {
+	cfg       *s3Config
+	transport http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	// 地址：路径形式 host/bucket/key，虚拟主机形式 bucket.host/key
+	host := t.cfg.endpoint.Host
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	if t.cfg.virtualHosted {
+		host = t.cfg.bucket + "." + host
+	} else {
+		path = "/" + t.cfg.bucket + path
+	}
+	req.URL.Scheme = t.cfg.endpoint.Scheme
+	req.URL.Host = host
+	req.URL.Path = path
+	req.URL.RawPath = s3Escape(path, false)
+	req.Host = host
+
+	// 请求头前缀改为 x-amz-，复制源改为 /bucket/key 形式
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "x-cos-") {
+			continue
+		}
+		req.Header.Del(name)
+		if lower == "x-cos-sdk-retry" {
+			continue
+		}
+		amzName := "X-Amz-" + name[len("x-cos-"):]
+		switch lower {
+		case "x-cos-copy-source":
+			values = []string{s3CopySource(t.cfg.bucket, values[0])}
+		case "x-cos-metadata-directive":
+			// COS使用 Copy / Replaced，S3使用 COPY / REPLACE
+			values = []string{strings.TrimSuffix(strings.ToUpper(values[0]), "D")}
+		}
+		for _, value := range values {
+			req.Header.Add(amzName, value)
+		}
+	}
+	req.Header.Del("Authorization")
+	t.sign(req, time.Now().UTC())
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			resp.Header["X-Cos-"+name[len("x-amz-"):]] = values
+		}
+	}
+	return resp, nil
+}
+
+// s3CopySource 将COS格式的复制源（bucket-appid.cos.region.myqcloud.com/key）改为 /bucket/key
+func s3CopySource(bucket, source string) string {
+	if i := strings.Index(source, "/"); i >= 0 {
+		source = source[i+1:]
+	}
+	return "/" + bucket + "/" + strings.TrimPrefix(source, "/")
+}
+
+// s3Escape 按SigV4的规则编码，encodeSlash为false时保留路径中的 /
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign 使用AWS Signature Version 4签名请求，请求体不参与签名（UNSIGNED-PAYLOAD）
+func (t *s3Transport) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	// 规范请求头：host、content-type、content-md5 和全部 x-amz-
+	headers := map[string]string{"host": req.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// 规范查询字符串：按键排序，键和值都编码
+	query := req.URL.Query()
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+	req.URL.RawQuery = strings.Join(params, "&")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + t.cfg.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.cfg.secretKey), date)
+	key = hmacSHA256(key, t.cfg.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.cfg.accessKey, scope, signedHeaders, signature))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 文件名清理：有些存储服务或解压工具不接受反斜杠、控制字符或过长的文件名。
+// 开启 FILENAME_SANITIZE 后，归档条目名和对象键中的这些字符替换为下划线，过长的部分截断并加上哈希，
+// 改动过的条目名和原路径记录在备份清单中，extract -manifest 解压时恢复原来的文件名。
+
+// nameSanitizer 文件名清理规则，以及本次归档中改名的条目
+type nameSanitizer struct {
+	chars     string // 额外需要替换的字符
+	maxLength int    // 每一级名称的最大字节数
+
+	renamed map[string]string // 改名后的条目名 -> 原路径（斜杠分隔）
+	used    map[string]string // 已使用的条目名 -> 原路径，用于避免两个条目清理后重名
+}
+
+// getNameSanitizer 获取文件名清理规则，未开启FILENAME_SANITIZE时返回nil
+func getNameSanitizer() *nameSanitizer {
+	if os.Getenv("FILENAME_SANITIZE") != "true" {
+		return nil
+	}
+	s := &nameSanitizer{
+		chars:     os.Getenv("FILENAME_SANITIZE_CHARS"),
+		maxLength: 255,
+		renamed:   make(map[string]string),
+		used:      make(map[string]string),
+	}
+	if lengthStr := os.Getenv("FILENAME_MAX_LENGTH"); lengthStr != "" {
+		if n, err := strconv.Atoi(lengthStr); err == nil && n >= 32 {
+			s.maxLength = n
+		} else {
+			fmt.Printf("警告: FILENAME_MAX_LENGTH格式错误: %s，至少为32，使用默认值 %d\n", lengthStr, s.maxLength)
+		}
+	}
+	return s
+}
+
+// shortHash 返回名称SHA-256的前8位，用于截断或重名时区分
+func shortHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:4])
+}
+
+// component 清理一级名称：替换反斜杠、控制字符和额外配置的字符，超长时截断并加上原名称的哈希
+func (s *nameSanitizer) component(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '\\' || r < 0x20 || r == 0x7f || r == utf8.RuneError || strings.ContainsRune(s.chars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(cleaned) <= s.maxLength {
+		return cleaned
+	}
+
+	// 保留扩展名，在UTF-8字符边界截断
+	suffix := "~" + shortHash(name)
+	if i := strings.LastIndex(cleaned, "."); i > 0 && len(cleaned)-i <= 16 {
+		suffix += cleaned[i:]
+	}
+	cut := s.maxLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(cleaned[cut]) {
+		cut--
+	}
+	return cleaned[:cut] + suffix
+}
+
+// path 清理斜杠分隔的相对路径，改名时记录原路径；两个条目清理后重名时后一个加上哈希
+func (s *nameSanitizer) path(original string) string {
+	parts := strings.Split(original, "/")
+	for i, part := range parts {
+		parts[i] = s.component(part)
+	}
+	name := strings.Join(parts, "/")
+
+	if prev, ok := s.used[name]; ok && prev != original {
+		name += "~" + shortHash(original)
+	}
+	s.used[name] = original
+	if name != original {
+		s.renamed[name] = original
+	}
+	return name
+}
+
+// sanitizeObjectName 按文件名清理规则清理对象键中的名称，未开启时原样返回
+func sanitizeObjectName(name string) string {
+	if s := getNameSanitizer(); s != nil {
+		return s.component(name)
+	}
+	return name
+}
+
+// memberName 返回条目在归档中的名称（斜杠分隔），转换旧编码的文件名，开启文件名清理时按规则清理
+func (o *archiveOptions) memberName(relPath string) string {
+	name := filepath.ToSlash(relPath)
+	if o == nil {
+		return name
+	}
+	name = o.decoder.decode(name)
+	if !utf8.ValidString(name) && !o.warnedNonUTF8 {
+		fmt.Printf("警告: 文件名不是UTF-8，在其他系统上可能显示为乱码，可设置FILENAME_LEGACY_ENCODING转换: %q\n", name)
+		o.warnedNonUTF8 = true
+	}
+	if o.sanitizer == nil {
+		return name
+	}
+	return o.sanitizer.path(name)
+}
+
+// renamedMembers 返回改名的条目，没有改名时返回nil
+func (o *archiveOptions) renamedMembers() map[string]string {
+	if o == nil || o.sanitizer == nil || len(o.sanitizer.renamed) == 0 {
+		return nil
+	}
+	return o.sanitizer.renamed
+}
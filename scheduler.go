@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 同一个源的备份重叠（上一次仍在进行）时的处理结果
+const (
+	overlapNone      = ""          // 没有重叠
+	overlapSkipped   = "skipped"   // 跳过本次备份
+	overlapQueued    = "queued"    // 等上一次完成后再备份
+	overlapRestarted = "restarted" // 取消上一次备份并重新开始
+)
+
+// getOverlapPolicy 获取备份重叠时的策略：skip（默认）、queue、cancel
+func getOverlapPolicy(proj *project) string {
+	policy := proj.Getenv("OVERLAP_POLICY")
+	switch policy {
+	case "skip", "queue", "cancel":
+		return policy
+	case "":
+		return "skip"
+	default:
+		fmt.Printf("警告: OVERLAP_POLICY格式错误: %s，使用默认值 skip\n", policy)
+		return "skip"
+	}
+}
+
+// sourceRun 一个源的运行状态，防止同一个源的定时备份、间隔备份和手动触发重叠
+type sourceRun struct {
+	mu      sync.Mutex
+	running bool
+	queued  bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// sourceRuns 每个源的运行状态
+var sourceRuns sync.Map
+
+// getSourceRun 获取源对应的运行状态
+func getSourceRun(proj *project, sourcePath string) *sourceRun {
+	run, _ := sourceRuns.LoadOrStore(proj.Name+"\x00"+sourcePath, &sourceRun{})
+	return run.(*sourceRun)
+}
+
+// acquire 按策略开始一次备份，返回本次备份的ctx、结束时调用的release和重叠处理结果；
+// 结果为overlapSkipped时不应备份。queue策略最多排队一个，已有排队时跳过
+func (s *sourceRun) acquire(policy string) (context.Context, func(), string) {
+	outcome := overlapNone
+
+	s.mu.Lock()
+	for s.running {
+		done := s.done
+		switch policy {
+		case "queue":
+			if s.queued {
+				s.mu.Unlock()
+				return nil, nil, overlapSkipped
+			}
+			s.queued = true
+			s.mu.Unlock()
+			<-done
+			s.mu.Lock()
+			s.queued = false
+			outcome = overlapQueued
+		case "cancel":
+			s.cancel()
+			s.mu.Unlock()
+			<-done
+			s.mu.Lock()
+			outcome = overlapRestarted
+		default:
+			s.mu.Unlock()
+			return nil, nil, overlapSkipped
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.running = true
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		cancel()
+		s.running = false
+		close(s.done)
+	}
+	return ctx, release, outcome
+}
+
+// prefixLocks 每个目标目录下的备份前缀一把锁，备份上传和清理删除同一前缀时互斥，
+// 不同前缀之间互不等待
+var prefixLocks sync.Map
+
+// prefixLock 获取目标目录下前缀对应的锁
+func prefixLock(targetDir, prefix string) *sync.Mutex {
+	lock, _ := prefixLocks.LoadOrStore(targetDir+"\x00"+prefix, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+var (
+	overlapMu     sync.Mutex
+	overlapCounts = make(map[string]int) // 重叠处理结果 → 次数，用于监控指标
+)
+
+// recordOverlap 记录备份重叠的处理结果：输出日志、计数并发送通知
+func recordOverlap(proj *project, sourcePath, outcome string) {
+	if outcome == overlapNone {
+		return
+	}
+
+	overlapMu.Lock()
+	overlapCounts[outcome]++
+	overlapMu.Unlock()
+
+	var message string
+	switch outcome {
+	case overlapSkipped:
+		message = fmt.Sprintf("%s 的上一次备份仍在进行，已跳过本次备份", sourcePath)
+	case overlapQueued:
+		message = fmt.Sprintf("%s 的上一次备份仍在进行，已等待其完成后备份", sourcePath)
+	case overlapRestarted:
+		message = fmt.Sprintf("%s 的上一次备份仍在进行，已取消并重新开始备份", sourcePath)
+	default:
+		return
+	}
+	fmt.Println(message)
+	sendSourceAlert(proj, sourceName(sourcePath), "备份重叠", message)
+}
+
+// getOverlapCounts 返回各重叠处理结果的次数
+func getOverlapCounts() map[string]int {
+	overlapMu.Lock()
+	defer overlapMu.Unlock()
+	counts := make(map[string]int, len(overlapCounts))
+	for k, v := range overlapCounts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// getSourceInterval 获取源的备份间隔：SOURCE_INTERVAL（源名称:间隔）优先，否则使用BACKUP_INTERVAL。
+// 返回0表示只在每日CLEANUP_TIME时备份
+func getSourceInterval(proj *project, sourcePath string) time.Duration {
+	intervalStr := parseKeyValueList(proj.Getenv("SOURCE_INTERVAL"))[sourceName(sourcePath)]
+	if intervalStr == "" {
+		intervalStr = proj.Getenv("BACKUP_INTERVAL")
+	}
+	if intervalStr == "" {
+		return 0
+	}
+
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval < time.Minute {
+		fmt.Printf("警告: %s 的备份间隔格式错误或小于1分钟: %s，改为每日备份\n", sourcePath, intervalStr)
+		return 0
+	}
+	return interval
+}
+
+// startIntervalSchedulers 为配置了备份间隔的源启动独立的定时任务，启动后立即备份一次。
+// 每次到时都在单独的goroutine中备份，上一次仍在进行时由OVERLAP_POLICY决定跳过、排队或取消
+func startIntervalSchedulers(client *cos.Client, projects []*project) {
+	for _, proj := range projects {
+		for _, sourcePath := range getProjectSources(proj) {
+			interval := getSourceInterval(proj, sourcePath)
+			if interval == 0 {
+				continue
+			}
+
+			fmt.Printf("已启用间隔备份%s: %s, 每 %v\n", proj.logTag(), sourcePath, interval)
+			go func(proj *project, sourcePath string, interval time.Duration) {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					go performBackupSources(client, proj, []string{sourcePath}, nil)
+					recordSourceNextRun(proj, sourcePath, time.Now().Add(interval))
+					<-ticker.C
+				}
+			}(proj, sourcePath, interval)
+		}
+	}
+}
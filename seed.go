@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 初始备份（seed）模式：大数据集的第一次备份可能需要上传几天甚至一周。
+// SEED_SOURCES中的源在第一次备份成功之前，归档写入持久的初始备份目录（不使用会被淘汰的暂存区），
+// 再以较小的分块上传，可以限速并只在夜间等时间窗口内上传。归档、对象键和上传进度都保存在本地，
+// 程序重启、窗口结束暂停后，下次运行从已上传的分块继续。完成后该源恢复普通备份。
+
+// errUploadPaused 上传因时间窗口结束而暂停，进度已保存
+var errUploadPaused = errors.New("初始备份已暂停，下次在上传时间窗口内运行时继续")
+
+// seedState 初始备份的进度，归档完成后保存，上传完成后标记完成
+type seedState struct {
+	Project   string            `json:"project,omitempty"`
+	Source    string            `json:"source"`
+	Archive   string            `json:"archive"`
+	Key       string            `json:"key"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Started   string            `json:"started"`
+	Completed string            `json:"completed,omitempty"`
+}
+
+// isSeedSource 检查源是否配置了初始备份模式（SEED_SOURCES，逗号分隔的源名称）
+func isSeedSource(proj *project, sourcePath string) bool {
+	for _, name := range parseSourcePaths(proj.Getenv("SEED_SOURCES")) {
+		if name == sourceName(sourcePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// getSeedDir 获取保存初始备份归档的目录，需要能容纳整个源的归档
+func getSeedDir(proj *project) string {
+	if dir := proj.Getenv("SEED_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(getDataDir(), "seed")
+}
+
+// getSeedStatePath 获取源对应的初始备份进度文件路径
+func getSeedStatePath(proj *project, sourcePath string) string {
+	sum := sha1.Sum([]byte(proj.Name + "\x00" + sourcePath))
+	return filepath.Join(getDataDir(), "seed", hex.EncodeToString(sum[:])+".json")
+}
+
+// loadSeedState 读取初始备份进度，不存在时返回nil
+func loadSeedState(path string) (*seedState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	state := &seedState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveSeedState 保存初始备份进度
+func saveSeedState(path string, state *seedState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// needsSeed 检查本次备份是否使用初始备份模式：源已配置，初始备份未完成，本地历史中也没有成功的备份
+func needsSeed(proj *project, sourcePath string) bool {
+	if !isSeedSource(proj, sourcePath) {
+		return false
+	}
+
+	state, err := loadSeedState(getSeedStatePath(proj, sourcePath))
+	if err != nil {
+		fmt.Printf("警告: 读取初始备份进度失败: %v\n", err)
+	}
+	if state != nil {
+		return state.Completed == ""
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Printf("警告: %v\n", err)
+	}
+	for _, record := range records {
+		if record.Project == proj.Name && record.Source == sourcePath && record.Status == "success" {
+			return false
+		}
+	}
+	return true
+}
+
+// getSeedPartSize 获取初始备份的分块大小，默认16MB，分块越小中断后重传的数据越少
+func getSeedPartSize(proj *project) int64 {
+	partSizeMB := 16
+	if sizeStr := proj.Getenv("SEED_PART_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size >= 1 {
+			partSizeMB = size
+		} else {
+			fmt.Printf("警告: SEED_PART_SIZE_MB格式错误: %s，使用默认值 %d\n", sizeStr, partSizeMB)
+		}
+	}
+	return int64(partSizeMB) * 1024 * 1024
+}
+
+// getSeedWindow 获取初始备份的上传时间窗口（SEED_WINDOW，如 22:00-06:00），未配置时返回nil
+func getSeedWindow(proj *project) *timeWindow {
+	windowStr := proj.Getenv("SEED_WINDOW")
+	if windowStr == "" {
+		return nil
+	}
+	window, err := parseTimeWindow(windowStr)
+	if err != nil {
+		fmt.Printf("警告: SEED_WINDOW格式错误: %v，不限制上传时间\n", err)
+		return nil
+	}
+	return window
+}
+
+// getSeedRateLimiter 获取初始备份的上传限速（SEED_BANDWIDTH_MB，每秒MB），未配置时返回nil
+func getSeedRateLimiter(proj *project) *rateLimiter {
+	rateStr := proj.Getenv("SEED_BANDWIDTH_MB")
+	if rateStr == "" {
+		return nil
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate <= 0 {
+		fmt.Printf("警告: SEED_BANDWIDTH_MB格式错误: %s，不限速\n", rateStr)
+		return nil
+	}
+	return newRateLimiter(int64(rate * 1024 * 1024))
+}
+
+// seedUpload 以初始备份模式上传：首次运行时把流水线输出写入初始备份目录，
+// 之后的运行复用已有的归档和对象键，从已上传的分块继续
+func seedUpload(ctx context.Context, client *cos.Client, proj *project, p *pipeline, sourcePath, readPath, cosFileName, cosPath string) (*backupResult, error) {
+	statePath := getSeedStatePath(proj, sourcePath)
+	state, err := loadSeedState(statePath)
+	if err != nil {
+		fmt.Printf("警告: 读取初始备份进度失败: %v，重新开始\n", err)
+		state = nil
+	}
+	if state != nil {
+		if _, err := os.Stat(state.Archive); err != nil {
+			fmt.Printf("警告: 初始备份归档不存在: %s，重新开始\n", state.Archive)
+			state = nil
+		}
+	}
+
+	window := getSeedWindow(proj)
+	if state == nil {
+		archive := readPath
+		if !p.isPassthrough() {
+			// 归档不受时间窗口限制，窗口外也可以先在本地准备好
+			seedDir := getSeedDir(proj)
+			if err := os.MkdirAll(seedDir, 0700); err != nil {
+				return nil, fmt.Errorf("创建初始备份目录失败: %v", err)
+			}
+			if err := writeOutputMarker(seedDir); err != nil {
+				fmt.Printf("警告: 写入初始备份目录标记失败: %v\n", err)
+			}
+
+			archive = filepath.Join(seedDir, cosFileName)
+			fmt.Printf("开始初始备份归档: %s -> %s (流水线: %s)\n", sourcePath, archive, p)
+			if err := p.runToFile(ctx, readPath, archive+".partial"); err != nil {
+				removeTempFile(archive + ".partial")
+				return nil, fmt.Errorf("处理失败: %v", err)
+			}
+			if err := os.Rename(archive+".partial", archive); err != nil {
+				return nil, fmt.Errorf("保存初始备份归档失败: %v", err)
+			}
+		}
+
+		state = &seedState{
+			Project:  proj.Name,
+			Source:   sourcePath,
+			Archive:  archive,
+			Key:      cosPath,
+			Metadata: p.Metadata(),
+			Started:  time.Now().Format(time.RFC3339),
+		}
+		if err := saveSeedState(statePath, state); err != nil {
+			fmt.Printf("警告: 保存初始备份进度失败: %v，中断后将重新归档\n", err)
+		}
+	} else {
+		// 续传时本次没有重新归档，文件列表和校验和不完整，不写入清单
+		fmt.Printf("继续初始备份: %s (归档: %s, 开始于 %s)\n", state.Key, state.Archive, state.Started)
+		p.options.archived = nil
+		p.options.checksums = nil
+	}
+
+	if window != nil && !window.contains(time.Now()) {
+		fmt.Printf("不在上传时间窗口 %s 内，暂不上传: %s\n", window, state.Key)
+		return nil, errUploadPaused
+	}
+
+	opts := &largeUploadOptions{
+		partSize: getSeedPartSize(proj),
+		window:   window,
+		limiter:  getSeedRateLimiter(proj),
+	}
+	result, err := uploadLargeFileWith(ctx, client, state.Key, state.Archive, state.Metadata, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 校验通过后才删除本地归档
+	local, err := fileChecksum(state.Archive)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := verifyUploadChain(client, state.Key, local); err != nil {
+		if _, delErr := client.Object.Delete(context.Background(), state.Key); delErr != nil {
+			fmt.Printf("警告: 删除校验失败的对象失败: %v\n", delErr)
+		}
+		return nil, fmt.Errorf("上传校验失败，保留初始备份归档 %s: %v", state.Archive, err)
+	}
+	result.SHA256 = local.SHA256()
+	if !p.isPassthrough() {
+		removeTempFile(state.Archive)
+	}
+
+	state.Completed = time.Now().Format(time.RFC3339)
+	if err := saveSeedState(statePath, state); err != nil {
+		fmt.Printf("警告: 保存初始备份进度失败: %v\n", err)
+	}
+	fmt.Printf("初始备份完成: %s，之后恢复普通备份\n", state.Key)
+	return result, nil
+}
+
+// timeWindow 每天的时间窗口，按备份时区计算，结束时间早于开始时间表示跨过午夜
+type timeWindow struct {
+	start, end int // 距离0点的分钟数
+}
+
+// parseTimeWindow 解析 HH:MM-HH:MM 格式的时间窗口
+func parseTimeWindow(s string) (*timeWindow, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("应为HH:MM-HH:MM格式，当前为: %s", s)
+	}
+	start, err := parseClockMinutes(startStr)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClockMinutes(endStr)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("开始和结束时间相同: %s", s)
+	}
+	return &timeWindow{start: start, end: end}, nil
+}
+
+// parseClockMinutes 解析 HH:MM，返回距离0点的分钟数
+func parseClockMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误，应为HH:MM: %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains 检查时刻是否在窗口内
+func (w *timeWindow) contains(t time.Time) bool {
+	t = t.In(getBackupLocation())
+	minutes := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minutes >= w.start && minutes < w.end
+	}
+	return minutes >= w.start || minutes < w.end
+}
+
+func (w *timeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// rateLimiter 按平均速率限制上传，暂停超过1秒后重新计时，不会在恢复后突发上传
+type rateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	start       time.Time
+	sent        int64
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{bytesPerSec: bytesPerSec}
+}
+
+// wait 记录n字节，超过速率时等待，ctx取消时返回错误
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	expected := time.Duration(float64(l.sent) / float64(l.bytesPerSec) * float64(time.Second))
+	if l.start.IsZero() || now.Sub(l.start) > expected+time.Second {
+		l.start, l.sent = now, 0
+	}
+	l.sent += int64(n)
+	delay := time.Duration(float64(l.sent)/float64(l.bytesPerSec)*float64(time.Second)) - now.Sub(l.start)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader 返回限速读取r的Reader
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+// limitedReader 每次最多读取64KB，读取后按限速等待
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > 64*1024 {
+		p = p[:64*1024]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 敏感文件检查：开启SENSITIVE_SCAN后，归档完成时按文件名检查已归档的文件中是否有私钥、证书、
+// .env、钱包等可能包含密钥的文件，结果写入运行报告和备份清单；备份未加密时输出警告，
+// 避免密钥在不知情的情况下以明文上传到云存储。
+
+// defaultSensitivePatterns 默认的敏感文件名模式，不区分大小写
+var defaultSensitivePatterns = []string{
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.ppk", "*.jks", "*.keystore", "*.kdbx",
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	".env", ".env.*", ".htpasswd", ".netrc", ".pgpass", ".git-credentials",
+	"wallet.dat", "credentials",
+}
+
+// maxSensitiveLogged 日志中最多列出的敏感文件数，运行报告中记录全部
+const maxSensitiveLogged = 20
+
+// isSensitiveScanEnabled 检查是否开启敏感文件检查
+func isSensitiveScanEnabled(proj *project) bool {
+	return proj.Getenv("SENSITIVE_SCAN") == "true"
+}
+
+// getSensitivePatterns 获取敏感文件名模式：默认模式加上SENSITIVE_PATTERNS（逗号分隔），
+// 不含斜杠的模式匹配文件名，含斜杠的模式匹配源中的相对路径
+func getSensitivePatterns(proj *project) []string {
+	patterns := append([]string(nil), defaultSensitivePatterns...)
+	for _, pattern := range parseSourcePaths(proj.Getenv("SENSITIVE_PATTERNS")) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Printf("警告: SENSITIVE_PATTERNS格式错误: %s\n", pattern)
+			continue
+		}
+		patterns = append(patterns, strings.ToLower(pattern))
+	}
+	return patterns
+}
+
+// matchSensitive 检查相对路径（斜杠分隔）是否匹配敏感文件名模式
+func matchSensitive(patterns []string, relPath string) bool {
+	relPath = strings.ToLower(relPath)
+	name := path.Base(relPath)
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = relPath
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scanSensitiveFiles 检查已归档的文件，返回匹配敏感文件名模式的相对路径；文件源检查文件本身
+func scanSensitiveFiles(proj *project, sourcePath string, archived []archivedFile) []string {
+	patterns := getSensitivePatterns(proj)
+	var found []string
+	if len(archived) == 0 {
+		if name := filepath.Base(sourcePath); matchSensitive(patterns, name) {
+			found = append(found, name)
+		}
+		return found
+	}
+	for _, file := range archived {
+		relPath := filepath.ToSlash(file.RelPath)
+		if matchSensitive(patterns, relPath) {
+			found = append(found, relPath)
+		}
+	}
+	return found
+}
+
+// reportSensitiveFiles 输出敏感文件检查结果，未加密时作为警告
+func reportSensitiveFiles(sourcePath string, found []string, encrypted bool) {
+	if len(found) == 0 {
+		return
+	}
+	if encrypted {
+		fmt.Printf("备份中有 %d 个可能包含密钥的文件（已加密）: %s\n", len(found), sourcePath)
+	} else {
+		fmt.Printf("警告: 备份中有 %d 个可能包含密钥的文件，备份未加密: %s\n", len(found), sourcePath)
+	}
+	for i, relPath := range found {
+		if i == maxSensitiveLogged {
+			fmt.Printf("  ... 另有 %d 个，见运行报告\n", len(found)-maxSensitiveLogged)
+			break
+		}
+		fmt.Printf("  %s\n", relPath)
+	}
+	if !encrypted {
+		fmt.Printf("建议为该源配置加密（SOURCE_ENCRYPTION_KEYS），或为这些文件设置排除标记\n")
+	}
+}
@@ -0,0 +1,788 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SFTP：备份到普通的Linux服务器（STORAGE_PROVIDER=sftp）。与zstd相同调用系统的ssh程序，
+// 以 ssh -s <主机> sftp 启动SFTP子系统，在其标准输入输出上使用SFTP v3协议读写远程目录，
+// 密钥、known_hosts等沿用ssh自身的处理。远程目录作为目录存储，由fsTransport提供COS接口。
+
+// SFTP v3 数据包类型
+const (
+	sftpPacketInit     = 1
+	sftpPacketVersion  = 2
+	sftpPacketOpen     = 3
+	sftpPacketClose    = 4
+	sftpPacketRead     = 5
+	sftpPacketWrite    = 6
+	sftpPacketOpendir  = 11
+	sftpPacketReaddir  = 12
+	sftpPacketRemove   = 13
+	sftpPacketMkdir    = 14
+	sftpPacketRmdir    = 15
+	sftpPacketStat     = 17
+	sftpPacketRename   = 18
+	sftpPacketStatus   = 101
+	sftpPacketHandle   = 102
+	sftpPacketData     = 103
+	sftpPacketName     = 104
+	sftpPacketAttrs    = 105
+	sftpPacketExtended = 200
+)
+
+// SFTP v3 打开文件标志和属性标志
+const (
+	sftpOpenRead   = 0x01
+	sftpOpenWrite  = 0x02
+	sftpOpenCreate = 0x08
+	sftpOpenTrunc  = 0x10
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+)
+
+const (
+	sftpChunkSize   = 32 * 1024 // 每个读写请求的数据量，所有SFTP服务器都支持
+	sftpMaxInflight = 16        // 读写时同时等待响应的请求数
+	sftpMaxPacket   = 4 * 1024 * 1024
+)
+
+// sftpConfig SFTP服务器的连接配置
+type sftpConfig struct {
+	host       string
+	port       string
+	user       string
+	keyFile    string
+	password   string
+	path       string // 远程目录，为空时使用登录用户的主目录
+	knownHosts string
+	sshPath    string
+}
+
+// getSFTPConfig 读取SFTP服务器的配置
+func getSFTPConfig(getenv func(string) string) (*sftpConfig, error) {
+	cfg := &sftpConfig{
+		host:       getenv("SFTP_HOST"),
+		port:       getenv("SFTP_PORT"),
+		user:       getenv("SFTP_USER"),
+		keyFile:    getenv("SFTP_KEY_FILE"),
+		password:   getenv("SFTP_PASSWORD"),
+		path:       strings.TrimSuffix(getenv("SFTP_PATH"), "/"),
+		knownHosts: getenv("SFTP_KNOWN_HOSTS"),
+		sshPath:    getenv("SFTP_SSH_PATH"),
+	}
+	if cfg.host == "" {
+		return nil, fmt.Errorf("SFTP服务器未配置，请设置SFTP_HOST")
+	}
+	if cfg.user == "" {
+		return nil, fmt.Errorf("SFTP用户未配置，请设置SFTP_USER")
+	}
+	if cfg.port == "" {
+		cfg.port = "22"
+	}
+	if cfg.sshPath == "" {
+		cfg.sshPath = "ssh"
+	}
+	return cfg, nil
+}
+
+// sshArgs 启动SFTP子系统的ssh参数
+func (cfg *sftpConfig) sshArgs() []string {
+	args := []string{
+		"-p", cfg.port,
+		"-l", cfg.user,
+		"-o", "ConnectTimeout=15",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=4",
+		// 首次连接时记录主机密钥，之后密钥变化时拒绝连接
+		"-o", "StrictHostKeyChecking=accept-new",
+	}
+	if cfg.knownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+cfg.knownHosts)
+	}
+	if cfg.keyFile != "" {
+		args = append(args, "-i", cfg.keyFile, "-o", "IdentitiesOnly=yes")
+	}
+	if cfg.password == "" {
+		// 没有密码时不等待输入，认证失败立即退出
+		args = append(args, "-o", "BatchMode=yes")
+	} else {
+		args = append(args, "-o", "NumberOfPasswordPrompts=1")
+	}
+	return append(args, "-s", cfg.host, "sftp")
+}
+
+// runSSHAskpass ssh通过SSH_ASKPASS调用本程序获取密码时输出SFTP_PASSWORD，返回是否处理了该调用
+func runSSHAskpass() bool {
+	if os.Getenv("VCPSAVE_SSH_ASKPASS") != "1" {
+		return false
+	}
+	fmt.Println(os.Getenv("SFTP_PASSWORD"))
+	return true
+}
+
+// tailBuffer 保留ssh错误输出的最后一部分，用于连接失败时的错误信息
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 2048 {
+		b.buf = b.buf[len(b.buf)-2048:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
+
+// sftpConn 一个SFTP会话，请求可以并发发送，按请求ID分发响应
+type sftpConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan []byte
+	err     error // 会话断开的原因
+
+	extensions map[string]bool
+}
+
+// dialSFTP 启动ssh并完成SFTP版本协商
+func dialSFTP(cfg *sftpConfig) (*sftpConn, error) {
+	cmd := exec.Command(cfg.sshPath, cfg.sshArgs()...)
+	if cfg.password != "" {
+		// ssh向本程序获取密码（OpenSSH 8.4及以上）
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("获取程序路径失败: %v", err)
+		}
+		// 副本存储的密码可能与全局配置不同，通过环境变量传给子进程
+		cmd.Env = append(os.Environ(), "SSH_ASKPASS="+exe, "SSH_ASKPASS_REQUIRE=force", "VCPSAVE_SSH_ASKPASS=1", "SFTP_PASSWORD="+cfg.password)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c := &sftpConn{cmd: cmd, stdin: stdin, stderr: &tailBuffer{}, pending: make(map[uint32]chan []byte), extensions: make(map[string]bool)}
+	cmd.Stderr = c.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动ssh失败: %v，请确认已安装OpenSSH客户端或设置SFTP_SSH_PATH", err)
+	}
+
+	init := binary.BigEndian.AppendUint32([]byte{sftpPacketInit}, 3)
+	if _, err := stdin.Write(binary.BigEndian.AppendUint32(nil, uint32(len(init)))); err == nil {
+		_, err = stdin.Write(init)
+	}
+	pkt, err := readSFTPPacket(stdout)
+	if err != nil || len(pkt) < 5 || pkt[0] != sftpPacketVersion {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("连接SFTP服务器失败: %s", c.stderr.String())
+	}
+	r := &sftpReader{buf: pkt[5:]}
+	for len(r.buf) > 0 && !r.bad {
+		name := r.string()
+		r.string()
+		c.extensions[name] = true
+	}
+
+	go c.readLoop(stdout)
+	return c, nil
+}
+
+// readSFTPPacket 读取一个数据包（不含长度）
+func readSFTPPacket(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 || n > sftpMaxPacket {
+		return nil, fmt.Errorf("SFTP数据包长度错误: %d", n)
+	}
+	pkt := make([]byte, n)
+	if _, err := io.ReadFull(r, pkt); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// readLoop 读取响应并交给等待的请求
+func (c *sftpConn) readLoop(stdout io.Reader) {
+	for {
+		pkt, err := readSFTPPacket(stdout)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if len(pkt) < 5 {
+			continue
+		}
+		id := binary.BigEndian.Uint32(pkt[1:5])
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- pkt
+		}
+	}
+}
+
+// fail 会话断开，结束所有等待中的请求
+func (c *sftpConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if stderr := c.stderr.String(); stderr != "" {
+		err = fmt.Errorf("%v: %s", err, stderr)
+	}
+	c.err = fmt.Errorf("SFTP连接已断开: %v", err)
+	for _, ch := range c.pending {
+		close(ch)
+	}
+	c.pending = nil
+
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	go c.cmd.Wait()
+}
+
+// closed 返回会话断开的原因，未断开时返回nil
+func (c *sftpConn) closed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// send 发送请求，返回接收响应的channel
+func (c *sftpConn) send(packetType byte, payload []byte) (chan []byte, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan []byte, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	pkt := make([]byte, 0, 9+len(payload))
+	pkt = binary.BigEndian.AppendUint32(pkt, uint32(5+len(payload)))
+	pkt = append(pkt, packetType)
+	pkt = binary.BigEndian.AppendUint32(pkt, id)
+	pkt = append(pkt, payload...)
+
+	c.writeMu.Lock()
+	_, err := c.stdin.Write(pkt)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.fail(err)
+		return nil, c.closed()
+	}
+	return ch, nil
+}
+
+// wait 等待响应，返回响应类型和响应内容
+func (c *sftpConn) wait(ch chan []byte) (byte, *sftpReader, error) {
+	pkt, ok := <-ch
+	if !ok {
+		return 0, nil, c.closed()
+	}
+	return pkt[0], &sftpReader{buf: pkt[5:]}, nil
+}
+
+// request 发送请求并等待响应
+func (c *sftpConn) request(packetType byte, payload []byte) (byte, *sftpReader, error) {
+	ch, err := c.send(packetType, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return c.wait(ch)
+}
+
+// status 发送只返回状态的请求
+func (c *sftpConn) status(packetType byte, payload []byte, name string) error {
+	respType, r, err := c.request(packetType, payload)
+	if err != nil {
+		return err
+	}
+	return sftpResult(respType, r, sftpPacketStatus, name)
+}
+
+// handle 发送打开文件或目录的请求，返回句柄
+func (c *sftpConn) handle(packetType byte, payload []byte, name string) (string, error) {
+	respType, r, err := c.request(packetType, payload)
+	if err != nil {
+		return "", err
+	}
+	if err := sftpResult(respType, r, sftpPacketHandle, name); err != nil {
+		return "", err
+	}
+	return r.string(), nil
+}
+
+// sftpResult 检查响应类型，状态响应转换为错误，期望的响应类型返回nil
+func sftpResult(respType byte, r *sftpReader, want byte, name string) error {
+	if respType == sftpPacketStatus {
+		code := r.uint32()
+		message := r.string()
+		switch code {
+		case 0:
+			if want == sftpPacketStatus {
+				return nil
+			}
+		case 1:
+			return io.EOF
+		case 2:
+			return &fs.PathError{Op: "sftp", Path: name, Err: fs.ErrNotExist}
+		case 3:
+			return &fs.PathError{Op: "sftp", Path: name, Err: fs.ErrPermission}
+		}
+		return &fs.PathError{Op: "sftp", Path: name, Err: fmt.Errorf("错误 %d: %s", code, message)}
+	}
+	if respType != want {
+		return fmt.Errorf("SFTP响应类型错误: %d", respType)
+	}
+	return nil
+}
+
+// sftpPayload 组装请求内容
+type sftpPayload []byte
+
+func (p sftpPayload) uint32(v uint32) sftpPayload {
+	return binary.BigEndian.AppendUint32(p, v)
+}
+
+func (p sftpPayload) uint64(v uint64) sftpPayload {
+	return binary.BigEndian.AppendUint64(p, v)
+}
+
+func (p sftpPayload) string(s string) sftpPayload {
+	return append(p.uint32(uint32(len(s))), s...)
+}
+
+// sftpReader 解析响应内容，数据不足时bad为true
+type sftpReader struct {
+	buf []byte
+	bad bool
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if len(r.buf) < 4 {
+		r.bad, r.buf = true, nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *sftpReader) uint64() uint64 {
+	if len(r.buf) < 8 {
+		r.bad, r.buf = true, nil
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v
+}
+
+func (r *sftpReader) string() string {
+	n := r.uint32()
+	if uint32(len(r.buf)) < n {
+		r.bad, r.buf = true, nil
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// fileInfo 解析文件属性
+func (r *sftpReader) fileInfo(name string) *sftpFileInfo {
+	info := &sftpFileInfo{name: name}
+	flags := r.uint32()
+	if flags&sftpAttrSize != 0 {
+		info.size = int64(r.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		info.mode = r.uint32()
+	}
+	if flags&sftpAttrACModTime != 0 {
+		r.uint32()
+		info.mtime = int64(r.uint32())
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := r.uint32(); n > 0 && !r.bad; n-- {
+			r.string()
+			r.string()
+		}
+	}
+	return info
+}
+
+// sftpFileInfo 远程文件信息
+type sftpFileInfo struct {
+	name  string
+	size  int64
+	mode  uint32
+	mtime int64
+}
+
+func (i *sftpFileInfo) Name() string       { return i.name }
+func (i *sftpFileInfo) Size() int64        { return i.size }
+func (i *sftpFileInfo) ModTime() time.Time { return time.Unix(i.mtime, 0) }
+func (i *sftpFileInfo) IsDir() bool        { return i.mode&0170000 == 0040000 }
+func (i *sftpFileInfo) Sys() interface{}   { return nil }
+
+func (i *sftpFileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.mode & 0777)
+	if i.IsDir() {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
+
+// sftpFS SFTP服务器上的目录，会话断开后下次操作时重新连接
+type sftpFS struct {
+	cfg *sftpConfig
+
+	mu   sync.Mutex
+	conn *sftpConn
+	dirs sync.Map // 已确认存在的目录
+}
+
+// session 获取可用的SFTP会话
+func (f *sftpFS) session() (*sftpConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil && f.conn.closed() == nil {
+		return f.conn, nil
+	}
+	if f.conn != nil {
+		fmt.Printf("警告: %v，重新连接\n", f.conn.closed())
+	}
+	conn, err := dialSFTP(f.cfg)
+	if err != nil {
+		return nil, err
+	}
+	f.conn = conn
+	return conn, nil
+}
+
+// remotePath 远程路径
+func (f *sftpFS) remotePath(name string) string {
+	if p := path.Join(f.cfg.path, name); p != "" {
+		return p
+	}
+	return "."
+}
+
+func (f *sftpFS) String() string {
+	return fmt.Sprintf("sftp://%s@%s:%s/%s", f.cfg.user, f.cfg.host, f.cfg.port, strings.TrimPrefix(f.cfg.path, "/"))
+}
+
+func (f *sftpFS) Stat(name string) (fs.FileInfo, error) {
+	c, err := f.session()
+	if err != nil {
+		return nil, err
+	}
+	p := f.remotePath(name)
+	respType, r, err := c.request(sftpPacketStat, sftpPayload{}.string(p))
+	if err != nil {
+		return nil, err
+	}
+	if err := sftpResult(respType, r, sftpPacketAttrs, p); err != nil {
+		return nil, err
+	}
+	return r.fileInfo(path.Base(name)), nil
+}
+
+func (f *sftpFS) Open(name string) (io.ReadCloser, error) {
+	c, err := f.session()
+	if err != nil {
+		return nil, err
+	}
+	p := f.remotePath(name)
+	handle, err := c.handle(sftpPacketOpen, sftpPayload{}.string(p).uint32(sftpOpenRead).uint32(0), p)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpReadFile{c: c, handle: handle}, nil
+}
+
+func (f *sftpFS) Create(name string) (io.WriteCloser, error) {
+	c, err := f.session()
+	if err != nil {
+		return nil, err
+	}
+	p := f.remotePath(name)
+	payload := sftpPayload{}.string(p).uint32(sftpOpenWrite | sftpOpenCreate | sftpOpenTrunc).uint32(sftpAttrPermissions).uint32(0644)
+	handle, err := c.handle(sftpPacketOpen, payload, p)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpWriteFile{c: c, handle: handle}, nil
+}
+
+func (f *sftpFS) Rename(oldName, newName string) error {
+	c, err := f.session()
+	if err != nil {
+		return err
+	}
+	oldPath, newPath := f.remotePath(oldName), f.remotePath(newName)
+	// SFTP v3的RENAME不覆盖已有文件，OpenSSH提供覆盖的扩展
+	if c.extensions["posix-rename@openssh.com"] {
+		return c.status(sftpPacketExtended, sftpPayload{}.string("posix-rename@openssh.com").string(oldPath).string(newPath), newPath)
+	}
+	if err := c.status(sftpPacketRemove, sftpPayload{}.string(newPath), newPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.status(sftpPacketRename, sftpPayload{}.string(oldPath).string(newPath), newPath)
+}
+
+func (f *sftpFS) Remove(name string) error {
+	c, err := f.session()
+	if err != nil {
+		return err
+	}
+	p := f.remotePath(name)
+	err = c.status(sftpPacketRemove, sftpPayload{}.string(p), p)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	// 目录需要用RMDIR删除
+	if c.status(sftpPacketRmdir, sftpPayload{}.string(p), p) == nil {
+		f.dirs.Delete(name)
+		return nil
+	}
+	return err
+}
+
+func (f *sftpFS) MkdirAll(name string) error {
+	if name == "." || name == "" {
+		return nil
+	}
+	if _, ok := f.dirs.Load(name); ok {
+		return nil
+	}
+	if info, err := f.Stat(name); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s 不是目录", f.remotePath(name))
+		}
+		f.dirs.Store(name, true)
+		return nil
+	}
+	if err := f.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	c, err := f.session()
+	if err != nil {
+		return err
+	}
+	p := f.remotePath(name)
+	if err := c.status(sftpPacketMkdir, sftpPayload{}.string(p).uint32(0), p); err != nil {
+		// 可能已被并发的请求创建
+		if info, statErr := f.Stat(name); statErr != nil || !info.IsDir() {
+			return err
+		}
+	}
+	f.dirs.Store(name, true)
+	return nil
+}
+
+func (f *sftpFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	c, err := f.session()
+	if err != nil {
+		return nil, err
+	}
+	p := f.remotePath(name)
+	handle, err := c.handle(sftpPacketOpendir, sftpPayload{}.string(p), p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.status(sftpPacketClose, sftpPayload{}.string(handle), p)
+
+	var infos []fs.FileInfo
+	for {
+		respType, r, err := c.request(sftpPacketReaddir, sftpPayload{}.string(handle))
+		if err != nil {
+			return nil, err
+		}
+		if err := sftpResult(respType, r, sftpPacketName, p); err == io.EOF {
+			return infos, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for n := r.uint32(); n > 0 && !r.bad; n-- {
+			fileName := r.string()
+			r.string() // longname
+			info := r.fileInfo(fileName)
+			if fileName != "." && fileName != ".." {
+				infos = append(infos, info)
+			}
+		}
+		if r.bad {
+			return nil, fmt.Errorf("SFTP目录列表格式错误: %s", p)
+		}
+	}
+}
+
+// sftpReadRequest 已发送的读请求
+type sftpReadRequest struct {
+	offset uint64
+	ch     chan []byte
+}
+
+// sftpReadFile 顺序读取远程文件，同时保持多个读请求以减少往返延迟的影响
+type sftpReadFile struct {
+	c      *sftpConn
+	handle string
+	offset uint64 // 下一个读请求的位置
+	queue  []sftpReadRequest
+	buf    []byte
+	eof    bool
+}
+
+func (f *sftpReadFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.eof {
+			return 0, io.EOF
+		}
+		if err := f.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// fill 补足读请求，等待最早的一个
+func (f *sftpReadFile) fill() error {
+	for len(f.queue) < sftpMaxInflight {
+		ch, err := f.c.send(sftpPacketRead, sftpPayload{}.string(f.handle).uint64(f.offset).uint32(sftpChunkSize))
+		if err != nil {
+			return err
+		}
+		f.queue = append(f.queue, sftpReadRequest{offset: f.offset, ch: ch})
+		f.offset += sftpChunkSize
+	}
+	req := f.queue[0]
+	f.queue = f.queue[1:]
+	respType, r, err := f.c.wait(req.ch)
+	if err != nil {
+		return err
+	}
+	if err := sftpResult(respType, r, sftpPacketData, ""); err == io.EOF {
+		f.eof = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data := r.string()
+	if len(data) < sftpChunkSize {
+		// 读到的数据不足时，丢弃后续的请求，从实际读到的位置继续
+		f.queue = nil
+		f.offset = req.offset + uint64(len(data))
+	}
+	f.buf = []byte(data)
+	return nil
+}
+
+func (f *sftpReadFile) Close() error {
+	return f.c.status(sftpPacketClose, sftpPayload{}.string(f.handle), "")
+}
+
+// sftpWriteFile 顺序写入远程文件，同时保持多个写请求
+type sftpWriteFile struct {
+	c        *sftpConn
+	handle   string
+	offset   uint64
+	inflight []chan []byte
+	err      error
+}
+
+func (f *sftpWriteFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && f.err == nil {
+		n := min(len(p), sftpChunkSize)
+		ch, err := f.c.send(sftpPacketWrite, sftpPayload{}.string(f.handle).uint64(f.offset).string(string(p[:n])))
+		if err != nil {
+			f.err = err
+			break
+		}
+		f.inflight = append(f.inflight, ch)
+		f.offset += uint64(n)
+		p = p[n:]
+		written += n
+		if len(f.inflight) >= sftpMaxInflight {
+			f.waitOldest()
+		}
+	}
+	return written, f.err
+}
+
+// waitOldest 等待最早的写请求完成
+func (f *sftpWriteFile) waitOldest() {
+	ch := f.inflight[0]
+	f.inflight = f.inflight[1:]
+	respType, r, err := f.c.wait(ch)
+	if err == nil {
+		err = sftpResult(respType, r, sftpPacketStatus, "")
+	}
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+}
+
+// Close 等待所有写请求完成，服务器支持时确认数据落盘后关闭
+func (f *sftpWriteFile) Close() error {
+	for len(f.inflight) > 0 {
+		f.waitOldest()
+	}
+	if f.err == nil && f.c.extensions["fsync@openssh.com"] {
+		f.err = f.c.status(sftpPacketExtended, sftpPayload{}.string("fsync@openssh.com").string(f.handle), "")
+	}
+	if err := f.c.status(sftpPacketClose, sftpPayload{}.string(f.handle), ""); err != nil && f.err == nil {
+		f.err = err
+	}
+	return f.err
+}
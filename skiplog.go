@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// isDebugEnabled 检查是否启用调试日志
+func isDebugEnabled() bool {
+	return os.Getenv("LOG_LEVEL") == "debug"
+}
+
+// getSkipLogSamples 获取汇总时每种原因显示的示例数量
+func getSkipLogSamples() int {
+	samples := 5
+	if samplesStr := os.Getenv("SKIP_LOG_SAMPLES"); samplesStr != "" {
+		if n, err := strconv.Atoi(samplesStr); err == nil && n >= 0 {
+			samples = n
+		} else {
+			fmt.Printf("警告: SKIP_LOG_SAMPLES格式错误: %s，使用默认值 %d\n", samplesStr, samples)
+		}
+	}
+	return samples
+}
+
+// skipSummary 按原因汇总被跳过的文件，避免大目录逐条输出日志
+type skipSummary struct {
+	counts  map[string]int
+	samples map[string][]string
+	limit   int
+}
+
+func newSkipSummary() *skipSummary {
+	return &skipSummary{
+		counts:  make(map[string]int),
+		samples: make(map[string][]string),
+		limit:   getSkipLogSamples(),
+	}
+}
+
+// add 记录一个被跳过的文件，调试模式下同时输出明细
+func (s *skipSummary) add(reason, name string) {
+	s.counts[reason]++
+	if len(s.samples[reason]) < s.limit {
+		s.samples[reason] = append(s.samples[reason], name)
+	}
+	if isDebugEnabled() {
+		fmt.Printf("调试: %s: %s\n", reason, name)
+	}
+}
+
+// total 返回被跳过的文件总数
+func (s *skipSummary) total() int {
+	total := 0
+	for _, count := range s.counts {
+		total += count
+	}
+	return total
+}
+
+// print 输出各原因的数量和示例
+func (s *skipSummary) print() {
+	if len(s.counts) == 0 {
+		return
+	}
+
+	reasons := make([]string, 0, len(s.counts))
+	for reason := range s.counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	fmt.Printf("跳过 %d 个文件:\n", s.total())
+	for _, reason := range reasons {
+		count := s.counts[reason]
+		fmt.Printf("  %s: %d 个\n", reason, count)
+		for _, name := range s.samples[reason] {
+			fmt.Printf("    - %s\n", name)
+		}
+		if more := count - len(s.samples[reason]); more > 0 {
+			fmt.Printf("    ... 另有 %d 个（设置 LOG_LEVEL=debug 查看全部）\n", more)
+		}
+	}
+}
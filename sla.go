@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// getSourceSLAs 解析SOURCE_SLA，格式为 源名称:时长，例如 VCPToolBox:26h,Documents:50h
+func getSourceSLAs(proj *project) map[string]time.Duration {
+	slas := make(map[string]time.Duration)
+	for prefix, durationStr := range parseKeyValueList(proj.Getenv("SOURCE_SLA")) {
+		d, err := time.ParseDuration(durationStr)
+		if err != nil || d <= 0 {
+			fmt.Printf("警告: SOURCE_SLA中 %s 的时长格式错误: %s\n", prefix, durationStr)
+			continue
+		}
+		slas[prefix] = d
+	}
+	return slas
+}
+
+// getSLACheckInterval 获取新鲜度检查的间隔
+func getSLACheckInterval() time.Duration {
+	interval := time.Hour
+	if intervalStr := os.Getenv("SLA_CHECK_INTERVAL"); intervalStr != "" {
+		if d, err := time.ParseDuration(intervalStr); err == nil && d > 0 {
+			interval = d
+		} else {
+			fmt.Printf("警告: SLA_CHECK_INTERVAL格式错误: %s，使用默认值 %v\n", intervalStr, interval)
+		}
+	}
+	return interval
+}
+
+// latestBackupTimes 获取每个前缀最新备份的时间，优先使用远程索引，索引不可用时列出存储桶
+func latestBackupTimes(client *cos.Client, targetDir string) (map[string]time.Time, error) {
+	var entries []catalogEntry
+
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil || len(index.Backups) == 0 {
+		if err != nil {
+			fmt.Printf("警告: %v，改为列出存储桶\n", err)
+		}
+
+		fileNames, err := listCOSFiles(client, targetDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fileName := range fileNames {
+			if prefix, timeStamp, isOurFormat := parseFileName(fileName); isOurFormat && !isMetaFile(fileName) {
+				entries = append(entries, catalogEntry{Prefix: prefix, TimeStamp: timeStamp})
+			}
+		}
+	} else {
+		entries = index.Backups
+	}
+
+	latest := make(map[string]time.Time)
+	for _, entry := range entries {
+		backupTime, err := parseTimeStamp(entry.TimeStamp)
+		if err != nil {
+			continue
+		}
+		if backupTime.After(latest[entry.Prefix]) {
+			latest[entry.Prefix] = backupTime
+		}
+	}
+	return latest, nil
+}
+
+// slaViolation 一个源的新鲜度检查结果
+type slaViolation struct {
+	Prefix string
+	SLA    time.Duration
+	Latest time.Time // 零值表示没有任何备份
+}
+
+// String 返回违规描述
+func (v slaViolation) String() string {
+	if v.Latest.IsZero() {
+		return fmt.Sprintf("%s 没有任何备份 (要求 %v 内)", v.Prefix, v.SLA)
+	}
+	return fmt.Sprintf("%s 最新备份为 %s，已超过 %v (要求 %v 内)",
+		v.Prefix, v.Latest.Format("2006-01-02 15:04:05"), time.Since(v.Latest).Round(time.Minute), v.SLA)
+}
+
+// checkSLAs 根据每个前缀最新备份的时间检查所有配置了SLA的源，返回违规列表
+func checkSLAs(latest map[string]time.Time, slas map[string]time.Duration) []slaViolation {
+	var violations []slaViolation
+	for prefix, sla := range slas {
+		if t, ok := latest[prefix]; !ok || time.Since(t) > sla {
+			violations = append(violations, slaViolation{Prefix: prefix, SLA: sla, Latest: latest[prefix]})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Prefix < violations[j].Prefix
+	})
+	return violations
+}
+
+// startSLAChecker 启动后台新鲜度检查，源从未达标变为达标或反之时发送告警
+func startSLAChecker(client *cos.Client, proj *project) {
+	slas := getSourceSLAs(proj)
+	if len(slas) == 0 {
+		return
+	}
+
+	interval := getSLACheckInterval()
+	fmt.Printf("已启用备份新鲜度检查%s: %d 个源, 检查间隔 %v\n", proj.logTag(), len(slas), interval)
+
+	go func() {
+		violated := make(map[string]bool)
+		for {
+			latest, err := latestBackupTimes(client, proj.TargetDir)
+			if err != nil {
+				fmt.Printf("错误: 新鲜度检查失败: %v\n", err)
+			} else {
+				violations := checkSLAs(latest, slas)
+				publishFreshness(proj, slas, latest)
+				current := make(map[string]bool)
+				newViolations := make(map[string]string)
+				for _, v := range violations {
+					current[v.Prefix] = true
+					if !violated[v.Prefix] {
+						newViolations[v.Prefix] = v.String()
+					}
+				}
+				if len(newViolations) > 0 {
+					sendSourceAlerts(proj, "备份新鲜度不达标", newViolations)
+				}
+				for prefix := range violated {
+					if !current[prefix] {
+						fmt.Printf("备份新鲜度已恢复%s: %s\n", proj.logTag(), prefix)
+					}
+				}
+				violated = current
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+}
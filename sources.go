@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// pathTemplateVars 源路径模板支持的变量，日期变量按备份时区和SOURCE_DATE_OFFSET计算
+var pathTemplateVars = map[byte]func(t time.Time) string{
+	'Y': func(t time.Time) string { return t.Format("2006") },
+	'm': func(t time.Time) string { return t.Format("01") },
+	'd': func(t time.Time) string { return t.Format("02") },
+	'H': func(t time.Time) string { return t.Format("15") },
+	'M': func(t time.Time) string { return t.Format("04") },
+	'h': func(time.Time) string {
+		host, _ := os.Hostname()
+		return host
+	},
+	'%': func(time.Time) string { return "%" },
+}
+
+// isPathTemplate 检查路径是否包含模板变量
+func isPathTemplate(path string) bool {
+	for i := 0; i+1 < len(path); i++ {
+		if path[i] == '%' && pathTemplateVars[path[i+1]] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// getSourceDateOffset 获取路径模板日期的偏移，例如 -24h 表示使用昨天的日期
+func getSourceDateOffset(proj *project) time.Duration {
+	offsetStr := proj.Getenv("SOURCE_DATE_OFFSET")
+	if offsetStr == "" {
+		return 0
+	}
+	offset, err := time.ParseDuration(offsetStr)
+	if err != nil {
+		fmt.Printf("警告: SOURCE_DATE_OFFSET格式错误: %s\n", offsetStr)
+		return 0
+	}
+	return offset
+}
+
+// expandSourcePath 展开源路径中的模板变量，例如 /var/log/app/%Y-%m-%d 展开为当天的日志目录
+func expandSourcePath(proj *project, sourcePath string) string {
+	if !isPathTemplate(sourcePath) {
+		return sourcePath
+	}
+
+	t := time.Now().In(getBackupLocation()).Add(getSourceDateOffset(proj))
+	var b strings.Builder
+	for i := 0; i < len(sourcePath); i++ {
+		if sourcePath[i] == '%' && i+1 < len(sourcePath) {
+			if expand := pathTemplateVars[sourcePath[i+1]]; expand != nil {
+				b.WriteString(expand(t))
+				i++
+				continue
+			}
+		}
+		b.WriteByte(sourcePath[i])
+	}
+	return b.String()
+}
+
+// sourceName 获取源的名称，用作备份文件前缀和按源配置的键。
+// 路径模板使用最后一个不含变量的路径元素，使每次展开的备份前缀保持不变
+func sourceName(sourcePath string) string {
+	if !isPathTemplate(sourcePath) {
+		return filepath.Base(sourcePath)
+	}
+	elems := strings.FieldsFunc(sourcePath, func(r rune) bool { return r == '/' || r == '\\' })
+	for i := len(elems) - 1; i >= 0; i-- {
+		if !isPathTemplate(elems[i]) && !strings.HasSuffix(elems[i], ":") {
+			return elems[i]
+		}
+	}
+	return filepath.Base(sourcePath)
+}
+
+// sourceDisabledReason 获取源的禁用原因，SOURCE_DISABLED 格式为 源名称:原因，未禁用时返回空字符串。
+// 禁用的源保留在SOURCEFOLDER中，运行时记录为 disabled，不会被遗忘
+func sourceDisabledReason(proj *project, sourcePath string) string {
+	return parseKeyValueList(proj.Getenv("SOURCE_DISABLED"))[sourceName(sourcePath)]
+}
+
+// samePath 比较两个路径，Windows上不区分大小写
+func samePath(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// nestedPath 检查inner是否位于outer之内，返回相对路径（斜杠分隔），两者相同时返回"."
+func nestedPath(outer, inner string) (string, bool) {
+	outer, inner = filepath.Clean(outer), filepath.Clean(inner)
+	if runtime.GOOS == "windows" {
+		outer, inner = strings.ToLower(outer), strings.ToLower(inner)
+	}
+	rel, err := filepath.Rel(outer, inner)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// getNestedSourcePolicy 获取源路径嵌套时的处理方式：warn（默认）只在启动时警告，
+// exclude 外层源归档时跳过单独备份的内层源，避免每次备份重复归档同样的数据
+func getNestedSourcePolicy(proj *project) string {
+	policy := proj.Getenv("NESTED_SOURCE_POLICY")
+	switch policy {
+	case "warn", "exclude":
+		return policy
+	case "":
+		return "warn"
+	default:
+		fmt.Printf("警告: NESTED_SOURCE_POLICY格式错误: %s，使用默认值 warn\n", policy)
+		return "warn"
+	}
+}
+
+// nestedSources 返回项目中位于sourcePath之内的其他源，相对于sourcePath
+func nestedSources(proj *project, sourcePath string) []string {
+	outer := expandSourcePath(proj, sourcePath)
+	var nested []string
+	for _, other := range getProjectSources(proj) {
+		if other == sourcePath || sourceDisabledReason(proj, other) != "" {
+			continue
+		}
+		if rel, ok := nestedPath(outer, expandSourcePath(proj, other)); ok && rel != "." {
+			nested = append(nested, rel)
+		}
+	}
+	return nested
+}
+
+// checkNestedSources 启动时检查所有项目中重复或互相包含的源路径并警告，
+// 外层源的每次备份都会再次归档内层源的数据
+func checkNestedSources(projects []*project) {
+	type configuredSource struct {
+		proj *project
+		path string
+	}
+	var sources []configuredSource
+	for _, proj := range projects {
+		for _, sourcePath := range getProjectSources(proj) {
+			sources = append(sources, configuredSource{proj: proj, path: sourcePath})
+		}
+	}
+
+	for i, outer := range sources {
+		for j, inner := range sources {
+			if i == j {
+				continue
+			}
+			rel, ok := nestedPath(expandSourcePath(outer.proj, outer.path), expandSourcePath(inner.proj, inner.path))
+			if !ok {
+				continue
+			}
+			if rel == "." {
+				if i < j {
+					fmt.Printf("警告: 源路径重复: %s%s 与 %s%s\n", outer.path, outer.proj.logTag(), inner.path, inner.proj.logTag())
+				}
+				continue
+			}
+			if outer.proj == inner.proj && getNestedSourcePolicy(outer.proj) == "exclude" {
+				fmt.Printf("源 %s 位于 %s 之内%s，外层源归档时将跳过该目录\n", inner.path, outer.path, outer.proj.logTag())
+				continue
+			}
+			hint := ""
+			if outer.proj == inner.proj {
+				hint = "，可配置 NESTED_SOURCE_POLICY=exclude 跳过"
+			}
+			fmt.Printf("警告: 源 %s%s 位于 %s%s 之内，每次备份都会重复归档%s\n",
+				inner.path, inner.proj.logTag(), outer.path, outer.proj.logTag(), hint)
+		}
+	}
+}
+
+// missingSourcePollInterval 等待源路径出现时的检查间隔
+const missingSourcePollInterval = 10 * time.Second
+
+// getMissingSourcePolicy 获取源路径不存在时的处理方式：SOURCE_MISSING_POLICY（源名称:策略）优先，
+// 否则使用MISSING_SOURCE_POLICY。skip 告警并跳过（默认），fail 本次运行失败，wait 等待路径出现
+func getMissingSourcePolicy(proj *project, sourcePath string) string {
+	policy := parseKeyValueList(proj.Getenv("SOURCE_MISSING_POLICY"))[sourceName(sourcePath)]
+	if policy == "" {
+		policy = proj.Getenv("MISSING_SOURCE_POLICY")
+	}
+	switch policy {
+	case "skip", "fail", "wait":
+		return policy
+	case "":
+		return "skip"
+	default:
+		fmt.Printf("警告: %s 的缺失处理策略格式错误: %s，使用默认值 skip\n", sourcePath, policy)
+		return "skip"
+	}
+}
+
+// getMissingSourceWait 获取wait策略等待源路径出现的最长时间
+func getMissingSourceWait(proj *project) time.Duration {
+	wait := 5 * time.Minute
+	if waitStr := proj.Getenv("MISSING_SOURCE_WAIT"); waitStr != "" {
+		if d, err := time.ParseDuration(waitStr); err == nil && d > 0 {
+			wait = d
+		} else {
+			fmt.Printf("警告: MISSING_SOURCE_WAIT格式错误: %s，使用默认值 %v\n", waitStr, wait)
+		}
+	}
+	return wait
+}
+
+// sourceExists 检查源路径是否存在
+func sourceExists(sourcePath string) bool {
+	_, err := os.Stat(sourcePath)
+	return !os.IsNotExist(err)
+}
+
+// waitForPath 每隔一段时间检查路径，在timeout内出现时返回true
+func waitForPath(sourcePath string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !sourceExists(sourcePath) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(missingSourcePollInterval)
+	}
+	return true
+}
+
+// checkMissingSource 按策略处理不存在的源路径，返回最终的策略结果：
+// 空字符串表示路径存在（或等待后出现）可以备份，"skip" 跳过，"fail" 本次运行失败
+func checkMissingSource(proj *project, sourcePath string) string {
+	name := sourceName(sourcePath)
+	sourcePath = expandSourcePath(proj, sourcePath)
+	if sourceExists(sourcePath) {
+		return ""
+	}
+
+	switch getMissingSourcePolicy(proj, sourcePath) {
+	case "wait":
+		wait := getMissingSourceWait(proj)
+		fmt.Printf("路径不存在，最多等待 %v: %s\n", wait, sourcePath)
+		if waitForPath(sourcePath, wait) {
+			fmt.Printf("路径已出现: %s\n", sourcePath)
+			return ""
+		}
+		sendSourceAlert(proj, name, "源路径不存在", fmt.Sprintf("等待 %v 后 %s 仍不存在，本次运行失败", wait, sourcePath))
+		return "fail"
+	case "fail":
+		sendSourceAlert(proj, name, "源路径不存在", fmt.Sprintf("%s 不存在，本次运行失败", sourcePath))
+		return "fail"
+	default:
+		sendSourceAlert(proj, name, "源路径不存在", fmt.Sprintf("%s 不存在，已跳过", sourcePath))
+		return "skip"
+	}
+}
+
+// getWaitForSources 获取启动时等待源路径出现的最长时间，未配置时不等待
+func getWaitForSources() time.Duration {
+	waitStr := os.Getenv("WAIT_FOR_SOURCES")
+	if waitStr == "" {
+		return 0
+	}
+	wait, err := time.ParseDuration(waitStr)
+	if err != nil || wait < 0 {
+		fmt.Printf("警告: WAIT_FOR_SOURCES格式错误: %s\n", waitStr)
+		return 0
+	}
+	return wait
+}
+
+// waitForSources 首次运行前等待所有项目的源路径出现（网络挂载、启动较慢的容器），
+// 超时后继续运行，仍不存在的源按缺失处理策略处理
+func waitForSources(projects []*project) {
+	wait := getWaitForSources()
+	if wait == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(wait)
+	announced := false
+	for {
+		var missing []string
+		for _, proj := range projects {
+			for _, sourcePath := range getProjectSources(proj) {
+				if sourceDisabledReason(proj, sourcePath) != "" {
+					continue
+				}
+				if sourcePath = expandSourcePath(proj, sourcePath); !sourceExists(sourcePath) {
+					missing = append(missing, sourcePath)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			if announced {
+				fmt.Printf("所有源路径已就绪\n")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("警告: 等待 %v 后仍有 %d 个源路径不存在: %v\n", wait, len(missing), missing)
+			return
+		}
+		if !announced {
+			fmt.Printf("等待源路径出现（最多 %v）: %v\n", wait, missing)
+			announced = true
+		}
+		time.Sleep(missingSourcePollInterval)
+	}
+}
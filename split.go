@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 分卷归档：配置ARCHIVE_SPLIT_SIZE后，流水线输出按固定大小切分为 名称_时间戳.zip.001、.002……，
+// 每卷写满后单独上传和校验，暂存空间只需要一卷的大小。清单和索引记录不带卷号的名称和卷数；
+// 清理时同一备份的所有分卷作为一个备份判断和计数，一起删除。恢复时按卷号顺序拼接后按原格式解压。
+
+// splitPartPattern 分卷的文件名：备份文件名加三位以上的卷号
+var splitPartPattern = regexp.MustCompile(`^(.+)\.(\d{3,})$`)
+
+// getArchiveSplitSize 获取每卷的大小，支持 M、G 后缀，不带后缀时单位为MB，未配置时返回0表示不分卷
+func getArchiveSplitSize(proj *project) int64 {
+	sizeStr := strings.ToUpper(strings.TrimSpace(proj.Getenv("ARCHIVE_SPLIT_SIZE")))
+	if sizeStr == "" {
+		return 0
+	}
+	unit := int64(1024 * 1024)
+	number := strings.TrimSuffix(strings.TrimSuffix(sizeStr, "B"), "M")
+	if strings.HasSuffix(number, "G") {
+		unit *= 1024
+		number = strings.TrimSuffix(number, "G")
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || size <= 0 {
+		fmt.Printf("警告: ARCHIVE_SPLIT_SIZE格式错误: %s，不分卷\n", sizeStr)
+		return 0
+	}
+	return size * unit
+}
+
+// splitPartName 返回第n卷（从1开始）的名称
+func splitPartName(name string, n int) string {
+	return fmt.Sprintf("%s.%03d", name, n)
+}
+
+// splitBaseName 返回分卷所属备份的文件名，不是分卷时原样返回
+func splitBaseName(fileName string) string {
+	matches := splitPartPattern.FindStringSubmatch(fileName)
+	if matches == nil {
+		return fileName
+	}
+	if _, _, ok := parseFileName(matches[1]); !ok {
+		return fileName
+	}
+	return matches[1]
+}
+
+// uploadSplitArchive 运行流水线，输出按partSize切分后逐卷上传到 cosPath.001、cosPath.002……，
+// 任一卷失败时删除已上传的分卷
+func uploadSplitArchive(ctx context.Context, client *cos.Client, proj *project, p *pipeline, sourcePath, readPath, cosFileName, cosPath string, partSize int64) (*backupResult, error) {
+	area := getStagingArea()
+	if !area.fits(partSize) {
+		return nil, fmt.Errorf("ARCHIVE_SPLIT_SIZE超过暂存空间上限STAGING_MAX_SIZE_MB")
+	}
+	stagingPath, release, err := area.reserve(ctx, cosFileName, partSize)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	w := &splitWriter{
+		ctx:         ctx,
+		client:      client,
+		proj:        proj,
+		metadata:    p.Metadata(),
+		stagingPath: stagingPath,
+		cosPath:     cosPath,
+		partSize:    partSize,
+		hash:        sha256.New(),
+	}
+	fmt.Printf("开始分卷处理: %s -> %s.NNN (流水线: %s, 每卷 %d MB)\n", sourcePath, cosPath, p, partSize/1024/1024)
+	err = p.Run(readPath, &ctxWriter{ctx: ctx, w: loadThrottled(ctx, w)})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		w.abort()
+		return nil, fmt.Errorf("分卷上传失败: %v", err)
+	}
+	fmt.Printf("分卷上传完成: %s, %d 卷, 共 %d bytes\n", cosPath, len(w.parts), w.total)
+
+	return &backupResult{
+		Key:      cosPath,
+		Size:     w.total,
+		KeyID:    w.metadata["vcpsave-key-id"],
+		SHA256:   hex.EncodeToString(w.hash.Sum(nil)),
+		Parts:    len(w.parts),
+		Replicas: w.replicas,
+	}, nil
+}
+
+// splitWriter 将写入的数据按卷写入暂存文件，每卷写满后上传
+type splitWriter struct {
+	ctx         context.Context
+	client      *cos.Client
+	proj        *project
+	metadata    map[string]string
+	stagingPath string
+	cosPath     string
+	partSize    int64
+
+	file     *os.File
+	written  int64    // 当前卷已写入的大小
+	parts    []string // 已上传的分卷
+	total    int64
+	hash     hash.Hash // 整个归档（各卷拼接后）的SHA-256
+	replicas []replicaResult
+}
+
+func (s *splitWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if s.file == nil {
+			file, err := os.Create(s.stagingPath)
+			if err != nil {
+				return written, fmt.Errorf("创建暂存文件失败: %v", err)
+			}
+			s.file, s.written = file, 0
+		}
+		chunk := p
+		if remaining := s.partSize - s.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := s.file.Write(chunk)
+		s.hash.Write(chunk[:n])
+		s.written += int64(n)
+		s.total += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		if s.written == s.partSize {
+			if err := s.finishPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close 上传最后一卷
+func (s *splitWriter) Close() error {
+	if s.file == nil {
+		if len(s.parts) > 0 {
+			return nil
+		}
+		// 没有任何输出时也上传一个空的分卷，备份仍然存在
+		file, err := os.Create(s.stagingPath)
+		if err != nil {
+			return fmt.Errorf("创建暂存文件失败: %v", err)
+		}
+		s.file = file
+	}
+	return s.finishPart()
+}
+
+// finishPart 关闭当前卷的暂存文件，上传并校验后复制到存储插件和副本
+func (s *splitWriter) finishPart() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("写入暂存文件失败: %v", err)
+	}
+	s.file = nil
+	if info, err := os.Stat(s.stagingPath); err == nil {
+		resourceMeterFrom(s.ctx).addTempDisk(info.Size())
+	}
+
+	key := splitPartName(s.cosPath, len(s.parts)+1)
+	local, err := fileChecksum(s.stagingPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("开始上传分卷: %s (%d bytes)\n", key, local.size)
+	if local.size > getLargeFileThreshold() {
+		_, err = uploadLargeFile(s.ctx, s.client, key, s.stagingPath, s.metadata)
+	} else {
+		_, err = s.client.Object.PutFromFile(s.ctx, key, s.stagingPath, &cos.ObjectPutOptions{
+			ACLHeaderOptions: uploadACLHeader(),
+			ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+				XCosMetaXXX: metadataHeader(s.metadata),
+			},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("上传 %s 失败: %v", key, err)
+	}
+	s.parts = append(s.parts, key)
+	if _, err := verifyUploadChain(s.client, key, local); err != nil {
+		return fmt.Errorf("%s 上传校验失败: %v", key, err)
+	}
+
+	copyToStoragePlugins(s.proj, key, s.stagingPath)
+	s.mergeReplicas(replicateBackup(s.ctx, s.client, s.proj, key, s.stagingPath, s.metadata))
+	return nil
+}
+
+// mergeReplicas 合并每卷复制到副本存储的结果，任一卷失败时该副本记为失败
+func (s *splitWriter) mergeReplicas(results []replicaResult) {
+	if s.replicas == nil {
+		s.replicas = results
+		return
+	}
+	for i, result := range results {
+		if i < len(s.replicas) && s.replicas[i].Status == "success" && result.Status != "success" {
+			s.replicas[i] = result
+		}
+	}
+}
+
+// abort 删除已上传的分卷，不完整的分卷不能作为备份保留
+func (s *splitWriter) abort() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	for _, key := range s.parts {
+		if _, err := s.client.Object.Delete(context.Background(), key); err != nil {
+			fmt.Printf("警告: 删除未完成备份的分卷失败: %s, 错误: %v\n", key, err)
+		}
+	}
+	deleteFromStoragePlugins(s.proj, s.parts)
+	deleteFromReplicas(s.parts)
+}
+
+// backupUnits 将文件名按所属备份去重，同一备份的分卷只保留一个名称（不带卷号），保持原有顺序
+func backupUnits(fileNames []string) []string {
+	seen := make(map[string]bool, len(fileNames))
+	var units []string
+	for _, fileName := range fileNames {
+		unit := splitBaseName(fileName)
+		if !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+	return units
+}
+
+// backupNames 返回清单和索引中可能记录该文件的名称：文件名本身，是分卷时还有所属备份的名称。
+// 以数字结尾的普通文件（如 data_20251021_095449.tar.001）无法与分卷区分，两个名称都处理
+func backupNames(fileName string) []string {
+	if base := splitBaseName(fileName); base != fileName {
+		return []string{fileName, base}
+	}
+	return []string{fileName}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 暂存区：不使用流式上传时，流水线输出先写入暂存目录再上传。
+// 多个源同时备份（间隔备份、手动触发）时，暂存文件的总大小受STAGING_MAX_SIZE_MB限制：
+// 每个源按源数据大小预留空间，空间不足时先按最近修改时间淘汰未在使用的遗留暂存文件，
+// 仍不足时排队等待其他源上传完成释放空间。
+
+// stagingArea 暂存目录和空间预留
+type stagingArea struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	dir      string
+	maxSize  int64            // 0表示不限制
+	reserved map[string]int64 // 正在使用的暂存文件 → 预留大小
+}
+
+var (
+	stagingOnce sync.Once
+	staging     *stagingArea
+)
+
+// getStagingArea 获取暂存区，首次调用时读取配置
+func getStagingArea() *stagingArea {
+	stagingOnce.Do(func() {
+		dir := os.Getenv("STAGING_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "vcpsave-staging")
+		}
+
+		var maxSize int64
+		if sizeStr := os.Getenv("STAGING_MAX_SIZE_MB"); sizeStr != "" {
+			if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+				maxSize = int64(size) * 1024 * 1024
+			} else {
+				fmt.Printf("警告: STAGING_MAX_SIZE_MB格式错误: %s，不限制暂存空间\n", sizeStr)
+			}
+		}
+
+		staging = &stagingArea{dir: dir, maxSize: maxSize, reserved: make(map[string]int64)}
+		staging.cond = sync.NewCond(&staging.mu)
+	})
+	return staging
+}
+
+// path 返回暂存文件的路径
+func (s *stagingArea) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// fits 检查预留size是否可能满足，超过总上限的源永远无法暂存
+func (s *stagingArea) fits(size int64) bool {
+	return s.maxSize == 0 || size <= s.maxSize
+}
+
+// hasFreeSpace 检查暂存目录所在磁盘的剩余空间是否足够再写入size，其他源已预留的空间视为即将被占用。
+// 无法读取磁盘空间时（如Windows）按空间足够处理
+func (s *stagingArea) hasFreeSpace(size int64) bool {
+	// 暂存目录可能尚未创建，检查已存在的上级目录
+	dir := s.dir
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return true
+		}
+		dir = parent
+	}
+	st, err := statMount(dir)
+	if err != nil || st == nil {
+		return true
+	}
+	return int64(st.free) >= size+s.reservedBytes()
+}
+
+// estimateStagingSize 按源数据大小估计暂存文件大小，留出少量余量给归档头和加密开销
+func estimateStagingSize(sourcePath string) int64 {
+	var total int64
+	filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total + total/100 + 1024*1024
+}
+
+// idleFiles 返回暂存目录中未在使用的文件（之前运行遗留的），按修改时间从旧到新排序
+func (s *stagingArea) idleFiles() ([]os.FileInfo, int64) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, 0
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		path := s.path(entry.Name())
+		if _, inUse := s.reserved[path]; inUse || !entry.Type().IsRegular() || entry.Name() == outputMarker {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	return files, total
+}
+
+// usedLocked 当前占用的空间：预留空间加上遗留文件，调用时需持有锁
+func (s *stagingArea) usedLocked() int64 {
+	var used int64
+	for _, size := range s.reserved {
+		used += size
+	}
+	_, idle := s.idleFiles()
+	return used + idle
+}
+
+// evictLocked 淘汰最久未修改的遗留暂存文件，直到能容纳need，调用时需持有锁
+func (s *stagingArea) evictLocked(need int64) {
+	files, _ := s.idleFiles()
+	for _, info := range files {
+		if s.usedLocked()+need <= s.maxSize {
+			return
+		}
+		path := s.path(info.Name())
+		if err := os.Remove(path); err != nil {
+			fmt.Printf("警告: 淘汰暂存文件失败: %s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("已淘汰遗留的暂存文件: %s (%d bytes)\n", path, info.Size())
+	}
+}
+
+// reserve 为暂存文件预留空间，空间不足时等待其他源释放，ctx取消时返回错误。
+// 返回的release删除暂存文件并释放预留
+func (s *stagingArea) reserve(ctx context.Context, name string, size int64) (string, func(), error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", nil, fmt.Errorf("创建暂存目录失败: %v", err)
+	}
+	if err := writeOutputMarker(s.dir); err != nil {
+		fmt.Printf("警告: 写入暂存目录标记失败: %v\n", err)
+	}
+	path := s.path(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 {
+		stop := context.AfterFunc(ctx, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		defer stop()
+
+		waiting := false
+		for {
+			if s.usedLocked()+size > s.maxSize {
+				s.evictLocked(size)
+			}
+			if s.usedLocked()+size <= s.maxSize {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return "", nil, err
+			}
+			if !waiting {
+				fmt.Printf("暂存空间不足（需要 %d MB），等待其他备份释放: %s\n", size/1024/1024, name)
+				waiting = true
+			}
+			s.cond.Wait()
+		}
+	}
+	s.reserved[path] = size
+
+	release := func() {
+		removeTempFile(path)
+		s.mu.Lock()
+		delete(s.reserved, path)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+	return path, release, nil
+}
+
+// reservedBytes 返回当前预留的暂存空间，用于监控指标
+func (s *stagingArea) reservedBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	for _, size := range s.reserved {
+		total += size
+	}
+	return total
+}
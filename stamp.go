@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 状态标记：每个源备份成功后覆盖写入目标目录下的 status/<前缀>.json，记录最近一次成功的时间和对象键，
+// 外部监控只需一次GET即可检查新鲜度，不需要列出存储桶或读取远程索引。
+
+// statusDirName 状态标记所在的目录（相对目标目录）
+const statusDirName = "status"
+
+// statusStamp 一个源最近一次成功备份的状态标记
+type statusStamp struct {
+	Project     string `json:"project,omitempty"`
+	Prefix      string `json:"prefix"`
+	Source      string `json:"source"`
+	LastSuccess string `json:"last_success"` // 备份完成时间，RFC3339
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	Host        string `json:"host,omitempty"`
+}
+
+// isStatusStampEnabled 检查是否写入状态标记，默认启用，STATUS_STAMP=false 关闭
+func isStatusStampEnabled(proj *project) bool {
+	return proj.Getenv("STATUS_STAMP") != "false"
+}
+
+// getStatusStampKey 获取源的状态标记的对象键
+func getStatusStampKey(targetDir, prefix string) string {
+	return cosObjectKey(targetDir, fmt.Sprintf("%s/%s.json", statusDirName, prefix))
+}
+
+// isStatusStampKey 检查文件名（相对目标目录）是否为状态标记
+func isStatusStampKey(fileName string) bool {
+	return strings.HasPrefix(fileName, statusDirName+"/")
+}
+
+// saveStatusStamp 写入源的状态标记，失败时只输出警告，不影响备份结果
+func saveStatusStamp(client *cos.Client, proj *project, prefix, sourcePath string, result *backupResult) {
+	if !isStatusStampEnabled(proj) {
+		return
+	}
+	hostname, _ := os.Hostname()
+	stamp := &statusStamp{
+		Project:     proj.Name,
+		Prefix:      prefix,
+		Source:      sourcePath,
+		LastSuccess: time.Now().Format(time.RFC3339),
+		Key:         result.Key,
+		Size:        result.Size,
+		Host:        hostname,
+	}
+	if err := putJSONObject(client, getStatusStampKey(proj.TargetDir, prefix), stamp); err != nil {
+		fmt.Printf("警告: 写入状态标记失败: %v\n", err)
+	}
+}
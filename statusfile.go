@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 本地状态文件：每次运行后整体替换一个JSON文件，记录每个项目和源的最近结果和下次运行时间，
+// Zabbix、Nagios等监控脚本只需读取这一个文件即可判断备份是否健康。
+
+// statusFile 状态文件内容
+type statusFile struct {
+	UpdatedAt string                    `json:"updated_at"`
+	NextRun   string                    `json:"next_run,omitempty"` // 下次每日备份时间，单次运行模式下为空
+	Projects  map[string]*projectStatus `json:"projects"`
+}
+
+// projectStatus 一个项目的状态
+type projectStatus struct {
+	LastRun *runStatus               `json:"last_run,omitempty"`
+	Sources map[string]*sourceStatus `json:"sources"`
+}
+
+// runStatus 最近一次运行的结果
+type runStatus struct {
+	Status    string `json:"status"` // success 或 failed
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Skipped   int    `json:"skipped"`
+	Disabled  int    `json:"disabled,omitempty"`
+}
+
+// sourceStatus 一个源的状态
+type sourceStatus struct {
+	Status      string `json:"status"` // 最近一次的状态，disabled 时Error为禁用原因
+	LastRun     string `json:"last_run"`
+	LastSuccess string `json:"last_success,omitempty"`
+	LastKey     string `json:"last_key,omitempty"`
+	Error       string `json:"error,omitempty"`
+	NextRun     string `json:"next_run,omitempty"` // 按间隔备份的源的下次运行时间
+}
+
+// statusFileMu 保护状态文件的读-改-写
+var statusFileMu sync.Mutex
+
+// getStatusFilePath 获取状态文件路径，默认 DATA_DIR/status.json
+func getStatusFilePath() string {
+	if path := os.Getenv("STATUS_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(getDataDir(), "status.json")
+}
+
+// statusProjectName 状态文件中项目的名称，默认项目为 default
+func statusProjectName(proj *project) string {
+	if proj.Name == "" {
+		return "default"
+	}
+	return proj.Name
+}
+
+// updateStatusFile 读取状态文件，修改后先写临时文件再替换，监控脚本不会读到写了一半的文件
+func updateStatusFile(update func(status *statusFile)) {
+	statusFileMu.Lock()
+	defer statusFileMu.Unlock()
+
+	path := getStatusFilePath()
+	status := &statusFile{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, status); err != nil {
+			fmt.Printf("警告: 解析状态文件失败，将重新生成: %v\n", err)
+			status = &statusFile{}
+		}
+	}
+	if status.Projects == nil {
+		status.Projects = make(map[string]*projectStatus)
+	}
+
+	update(status)
+	status.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		fmt.Printf("警告: 序列化状态文件失败: %v\n", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Printf("警告: 创建状态文件目录失败: %v\n", err)
+		return
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		fmt.Printf("警告: 写入状态文件失败: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		fmt.Printf("警告: 替换状态文件失败: %v\n", err)
+	}
+}
+
+// getProjectStatus 获取状态文件中的项目状态，不存在时创建
+func (status *statusFile) getProjectStatus(proj *project) *projectStatus {
+	name := statusProjectName(proj)
+	ps := status.Projects[name]
+	if ps == nil {
+		ps = &projectStatus{}
+		status.Projects[name] = ps
+	}
+	if ps.Sources == nil {
+		ps.Sources = make(map[string]*sourceStatus)
+	}
+	return ps
+}
+
+// recordRunStatus 将一次备份运行的结果写入状态文件
+func recordRunStatus(proj *project, report *runReport) {
+	updateStatusFile(func(status *statusFile) {
+		ps := status.getProjectStatus(proj)
+		ps.LastRun = &runStatus{
+			Status:    "success",
+			StartTime: report.StartTime,
+			EndTime:   report.EndTime,
+			Succeeded: report.Succeeded,
+			Failed:    report.Failed,
+			Skipped:   report.Skipped,
+			Disabled:  report.Disabled,
+		}
+		if report.Failed > 0 {
+			ps.LastRun.Status = "failed"
+		}
+
+		for _, r := range report.Results {
+			ss := ps.Sources[r.Prefix]
+			if ss == nil {
+				ss = &sourceStatus{}
+				ps.Sources[r.Prefix] = ss
+			}
+			ss.Status = r.Status
+			ss.LastRun = r.StartTime
+			ss.Error = r.Error
+			if r.Status == "success" {
+				ss.LastSuccess = r.StartTime
+				ss.LastKey = r.Key
+			}
+		}
+	})
+}
+
+// recordNextRun 将下次每日备份时间写入状态文件
+func recordNextRun(next time.Time) {
+	updateStatusFile(func(status *statusFile) {
+		status.NextRun = next.Format(time.RFC3339)
+	})
+}
+
+// recordSourceNextRun 将按间隔备份的源的下次运行时间写入状态文件
+func recordSourceNextRun(proj *project, sourcePath string, next time.Time) {
+	updateStatusFile(func(status *statusFile) {
+		ps := status.getProjectStatus(proj)
+		ss := ps.Sources[sourceName(sourcePath)]
+		if ss == nil {
+			ss = &sourceStatus{}
+			ps.Sources[sourceName(sourcePath)] = ss
+		}
+		ss.NextRun = next.Format(time.RFC3339)
+	})
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+
+	"vcpsave/pkg/storage"
+)
+
+// 注册的存储：实现 vcpsave/pkg/storage 的 Storage 接口并调用 storage.Register 注册的存储，
+// 与目录存储相同由COS SDK组装请求，storageTransport 转换为对 Storage 的调用。
+// 存储接口没有分块上传，分块先保存在本地临时目录，合并时一次上传。
+
+// newBackendClient 创建读写注册的存储的客户端
+func newBackendClient(name string, store storage.Storage) *cos.Client {
+	// SDK只接受http(s)地址，请求由storageTransport处理，不会发送到网络
+	bu := &url.URL{Scheme: "http", Host: "localhost"}
+	client := cos.NewClient(&cos.BaseURL{BucketURL: bu}, &http.Client{
+		Transport: &meteredTransport{transport: &storageTransport{name: name, store: store, uploads: make(map[string]*backendUpload)}},
+	})
+	client.UserAgent = userAgent()
+	client.Conf.EnableCRC = false
+	client.Conf.RetryOpt.AutoSwitchHost = false
+	return client
+}
+
+// storageTransport 在注册的存储上执行COS SDK生成的请求
+type storageTransport struct {
+	name  string
+	store storage.Storage
+
+	mu      sync.Mutex
+	uploads map[string]*backendUpload
+}
+
+// backendUpload 未完成的分块上传，分块保存在本地临时目录
+type backendUpload struct {
+	key       string
+	initiated time.Time
+	meta      fsMeta
+	dir       string
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *storageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	query := req.URL.Query()
+	if key == "" {
+		switch {
+		case req.Method == http.MethodHead:
+			return fsResponse(req, http.StatusOK, nil, nil)
+		case req.Method == http.MethodGet && query.Has("uploads"):
+			return t.listUploads(req, query)
+		case req.Method == http.MethodGet && !hasSubresource(query):
+			return t.listObjects(req, query)
+		}
+		return fsError(req, http.StatusNotImplemented, "NotImplemented", t.name+" 不支持存储桶配置")
+	}
+
+	switch {
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		return t.initiateUpload(req, key)
+	case req.Method == http.MethodPut && query.Has("uploadId"):
+		return t.uploadPart(req, query.Get("uploadId"), query.Get("partNumber"))
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		return t.completeUpload(req, key, query.Get("uploadId"))
+	case req.Method == http.MethodDelete && query.Has("uploadId"):
+		t.removeUpload(query.Get("uploadId"))
+		return fsResponse(req, http.StatusNoContent, nil, nil)
+	case req.Method == http.MethodGet && query.Has("uploadId"):
+		return t.listParts(req, key, query.Get("uploadId"))
+	case hasSubresource(query):
+		return fsError(req, http.StatusNotImplemented, "NotImplemented", t.name+" 不支持对象配置")
+	case req.Method == http.MethodPut && req.Header.Get("x-cos-copy-source") != "":
+		return t.copyObject(req, key)
+	case req.Method == http.MethodPut:
+		return t.putObject(req, key)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		return t.getObject(req, key)
+	case req.Method == http.MethodDelete:
+		if err := t.store.Delete(req.Context(), key); err != nil {
+			return t.storeError(req, err)
+		}
+		return fsResponse(req, http.StatusNoContent, nil, nil)
+	}
+	return fsError(req, http.StatusMethodNotAllowed, "MethodNotAllowed", req.Method)
+}
+
+// storeError 将存储返回的错误转换为COS格式的错误
+func (t *storageTransport) storeError(req *http.Request, err error) (*http.Response, error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fsError(req, http.StatusNotFound, "NoSuchKey", err.Error())
+	}
+	return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+}
+
+// storeMeta 将请求头中的元数据转换为存储的元数据（去掉 x-cos-meta- 前缀）
+func storeMeta(meta fsMeta) map[string]string {
+	metadata := make(map[string]string, len(meta))
+	for name, value := range meta {
+		metadata[strings.TrimPrefix(name, "x-cos-meta-")] = value
+	}
+	return metadata
+}
+
+// put 上传对象并返回内容的MD5，长度未知时先写入临时文件
+func (t *storageTransport) put(ctx context.Context, key string, r io.Reader, size int64, meta fsMeta) (string, error) {
+	if size < 0 {
+		tmpFile, err := os.CreateTemp("", "vcpsave-backend-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+		if size, err = io.Copy(tmpFile, r); err != nil {
+			return "", err
+		}
+		if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		r = tmpFile
+	}
+
+	hash := md5.New()
+	if err := t.store.Put(ctx, key, io.TeeReader(r, hash), size, storeMeta(meta)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// putObject 上传对象
+func (t *storageTransport) putObject(req *http.Request, key string) (*http.Response, error) {
+	// 与net/http相同，有请求体时ContentLength为0表示长度未知
+	body := io.Reader(http.NoBody)
+	size := int64(0)
+	if req.Body != nil && req.Body != http.NoBody {
+		body = req.Body
+		size = req.ContentLength
+		if size == 0 {
+			size = -1
+		}
+	}
+	etag, err := t.put(req.Context(), key, body, size, requestMeta(req.Header))
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("写入 %s 失败: %v", key, err))
+	}
+	return fsResponse(req, http.StatusOK, http.Header{"Etag": {`"` + etag + `"`}}, nil)
+}
+
+// getObject 下载对象或获取对象信息
+func (t *storageTransport) getObject(req *http.Request, key string) (*http.Response, error) {
+	object, err := t.store.Head(req.Context(), key)
+	if err != nil {
+		return t.storeError(req, err)
+	}
+
+	header := http.Header{
+		"Last-Modified":  {object.LastModified.UTC().Format(http.TimeFormat)},
+		"Content-Type":   {"application/octet-stream"},
+		"Content-Length": {strconv.FormatInt(object.Size, 10)},
+	}
+	for name, value := range object.Metadata {
+		header.Set("x-cos-meta-"+name, value)
+	}
+	resp, _ := fsResponse(req, http.StatusOK, header, nil)
+	resp.ContentLength = object.Size
+	if req.Method == http.MethodGet {
+		body, err := t.store.Get(req.Context(), key)
+		if err != nil {
+			return t.storeError(req, err)
+		}
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// copyObject 复制对象，存储接口没有复制操作，下载后重新上传
+func (t *storageTransport) copyObject(req *http.Request, key string) (*http.Response, error) {
+	_, sourceKey, _ := strings.Cut(req.Header.Get("x-cos-copy-source"), "/")
+	sourceKey, err := url.PathUnescape(sourceKey)
+	if err != nil || sourceKey == "" {
+		return fsError(req, http.StatusBadRequest, "InvalidArgument", "复制源格式错误")
+	}
+
+	source, err := t.store.Head(req.Context(), sourceKey)
+	if err != nil {
+		return t.storeError(req, err)
+	}
+	meta := make(fsMeta)
+	for name, value := range source.Metadata {
+		meta["x-cos-meta-"+name] = value
+	}
+	if strings.EqualFold(req.Header.Get("x-cos-metadata-directive"), "Replaced") {
+		meta = requestMeta(req.Header)
+	}
+
+	body, err := t.store.Get(req.Context(), sourceKey)
+	if err != nil {
+		return t.storeError(req, err)
+	}
+	defer body.Close()
+	if _, err := t.put(req.Context(), key, body, source.Size, meta); err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("复制 %s 失败: %v", sourceKey, err))
+	}
+	return fsXML(req, &cos.ObjectCopyResult{LastModified: time.Now().UTC().Format(time.RFC3339)})
+}
+
+// listObjects 按前缀列出对象
+func (t *storageTransport) listObjects(req *http.Request, query url.Values) (*http.Response, error) {
+	list, err := t.store.List(req.Context(), query.Get("prefix"))
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("列出文件失败: %v", err))
+	}
+	objects := make([]cos.Object, 0, len(list))
+	for _, object := range list {
+		objects = append(objects, cos.Object{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified.UTC().Format(time.RFC3339),
+			StorageClass: "STANDARD",
+		})
+	}
+	return fsXML(req, listObjectsPage(t.name, objects, query))
+}
+
+// getUpload 查找未完成的分块上传
+func (t *storageTransport) getUpload(uploadID string) *backendUpload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.uploads[uploadID]
+}
+
+// initiateUpload 初始化分块上传，创建保存分块的临时目录
+func (t *storageTransport) initiateUpload(req *http.Request, key string) (*http.Response, error) {
+	dir, err := os.MkdirTemp("", "vcpsave-parts-*")
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("初始化分块上传失败: %v", err))
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	uploadID := hex.EncodeToString(id)
+
+	t.mu.Lock()
+	t.uploads[uploadID] = &backendUpload{key: key, initiated: time.Now(), meta: requestMeta(req.Header), dir: dir}
+	t.mu.Unlock()
+	return fsXML(req, &cos.InitiateMultipartUploadResult{Bucket: t.name, Key: key, UploadID: uploadID})
+}
+
+// removeUpload 删除分块上传及其临时目录
+func (t *storageTransport) removeUpload(uploadID string) {
+	t.mu.Lock()
+	upload := t.uploads[uploadID]
+	delete(t.uploads, uploadID)
+	t.mu.Unlock()
+	if upload != nil {
+		os.RemoveAll(upload.dir)
+	}
+}
+
+// uploadPart 将一个分块保存到临时目录
+func (t *storageTransport) uploadPart(req *http.Request, uploadID, partNumber string) (*http.Response, error) {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 {
+		return fsError(req, http.StatusBadRequest, "InvalidArgument", "分块编号格式错误: "+partNumber)
+	}
+	upload := t.getUpload(uploadID)
+	if upload == nil {
+		return fsError(req, http.StatusNotFound, "NoSuchUpload", "分块上传不存在: "+uploadID)
+	}
+
+	file, err := os.Create(filepath.Join(upload.dir, strconv.Itoa(n)))
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("写入分块失败: %v", err))
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), req.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("写入分块失败: %v", err))
+	}
+	return fsResponse(req, http.StatusOK, http.Header{"Etag": {`"` + hex.EncodeToString(hash.Sum(nil)) + `"`}}, nil)
+}
+
+// listParts 列出已上传的分块
+func (t *storageTransport) listParts(req *http.Request, key, uploadID string) (*http.Response, error) {
+	upload := t.getUpload(uploadID)
+	if upload == nil {
+		return fsError(req, http.StatusNotFound, "NoSuchUpload", "分块上传不存在: "+uploadID)
+	}
+	entries, err := os.ReadDir(upload.dir)
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	result := &cos.ObjectListPartsResult{Bucket: t.name, Key: key, UploadID: uploadID}
+	for _, entry := range entries {
+		n, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		result.Parts = append(result.Parts, cos.Object{
+			PartNumber:   n,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Sort(cos.ObjectList(result.Parts))
+	return fsXML(req, result)
+}
+
+// completeUpload 按请求中的分块顺序合并后上传到存储
+func (t *storageTransport) completeUpload(req *http.Request, key, uploadID string) (*http.Response, error) {
+	upload := t.getUpload(uploadID)
+	if upload == nil {
+		return fsError(req, http.StatusNotFound, "NoSuchUpload", "分块上传不存在: "+uploadID)
+	}
+	var opt cos.CompleteMultipartUploadOptions
+	if err := xml.NewDecoder(req.Body).Decode(&opt); err != nil || len(opt.Parts) == 0 {
+		return fsError(req, http.StatusBadRequest, "MalformedXML", "分块列表格式错误")
+	}
+	var size int64
+	for _, part := range opt.Parts {
+		info, err := os.Stat(filepath.Join(upload.dir, strconv.Itoa(part.PartNumber)))
+		if err != nil {
+			return fsError(req, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("分块 %d 不存在", part.PartNumber))
+		}
+		size += info.Size()
+	}
+
+	// 逐个打开分块，同一时间只保持一个文件打开
+	pr, pw := io.Pipe()
+	go func() {
+		for _, part := range opt.Parts {
+			r, err := os.Open(filepath.Join(upload.dir, strconv.Itoa(part.PartNumber)))
+			if err == nil {
+				_, err = io.Copy(pw, r)
+				r.Close()
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	etag, err := t.put(req.Context(), key, pr, size, upload.meta)
+	pr.Close()
+	if err != nil {
+		return fsError(req, http.StatusInternalServerError, "InternalError", fmt.Sprintf("合并分块失败: %v", err))
+	}
+	t.removeUpload(uploadID)
+	return fsXML(req, &cos.CompleteMultipartUploadResult{Bucket: t.name, Key: key, ETag: `"` + etag + `"`})
+}
+
+// listUploads 列出未完成的分块上传
+func (t *storageTransport) listUploads(req *http.Request, query url.Values) (*http.Response, error) {
+	result := &cos.ObjectListUploadsResult{Bucket: t.name, Prefix: query.Get("prefix")}
+	t.mu.Lock()
+	for uploadID, upload := range t.uploads {
+		if strings.HasPrefix(upload.key, result.Prefix) {
+			result.Upload = append(result.Upload, cos.ListUploadsResultUpload{
+				Key:       upload.key,
+				UploadID:  uploadID,
+				Initiated: upload.initiated.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(result.Upload, func(i, j int) bool { return result.Upload[i].Key < result.Upload[j].Key })
+	return fsXML(req, result)
+}
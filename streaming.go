@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// isStreamUploadEnabled 检查是否启用流式上传（归档直接上传，不写本地临时文件）
+func isStreamUploadEnabled() bool {
+	return os.Getenv("STREAM_UPLOAD") == "true"
+}
+
+// getStreamPartSize 获取流式上传的分块大小，分块在内存中缓冲
+func getStreamPartSize() int64 {
+	partSizeMB := 16 // 默认16MB，最多10000个分块，即单个备份最大约160GB
+	if sizeStr := os.Getenv("STREAM_PART_SIZE_MB"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size >= 1 {
+			partSizeMB = size
+		} else {
+			fmt.Printf("警告: STREAM_PART_SIZE_MB格式错误: %s，使用默认值 %d\n", sizeStr, partSizeMB)
+		}
+	}
+	return int64(partSizeMB) * 1024 * 1024
+}
+
+// checksumReader 在读取数据的同时计算SHA-256、CRC64和MD5
+type checksumReader struct {
+	r      io.Reader
+	sha256 hash.Hash
+	crc64  hash.Hash64
+	md5    hash.Hash
+	size   int64
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{
+		r:      r,
+		sha256: sha256.New(),
+		crc64:  crc64.New(crc64.MakeTable(crc64.ECMA)),
+		md5:    md5.New(),
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.sha256.Write(p[:n])
+		c.crc64.Write(p[:n])
+		c.md5.Write(p[:n])
+		c.size += int64(n)
+	}
+	return n, err
+}
+
+// MD5 返回已读取数据的MD5（hex），与单次上传的ETag格式一致
+func (c *checksumReader) MD5() string {
+	return hex.EncodeToString(c.md5.Sum(nil))
+}
+
+// SHA256 返回已读取数据的SHA-256（hex）
+func (c *checksumReader) SHA256() string {
+	return hex.EncodeToString(c.sha256.Sum(nil))
+}
+
+// CRC64 返回已读取数据的CRC64-ECMA，与COS的x-cos-hash-crc64ecma格式一致
+func (c *checksumReader) CRC64() string {
+	return strconv.FormatUint(c.crc64.Sum64(), 10)
+}
+
+// streamPipelineToCOS 运行流水线并通过分块上传直接写入COS，不产生本地临时文件
+// 上传过程中计算校验和，完成后与COS计算的CRC64比对，并将SHA-256写入对象元数据
+func streamPipelineToCOS(ctx context.Context, client *cos.Client, cosPath string, p *pipeline, sourcePath string, metadata map[string]string) (*backupResult, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.Run(sourcePath, &ctxWriter{ctx: ctx, w: loadThrottled(ctx, pw)}))
+	}()
+
+	checksum := newChecksumReader(pr)
+	if err := multipartUploadStream(ctx, client, cosPath, checksum, metadata); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+
+	sha := checksum.SHA256()
+	fmt.Printf("流式上传完成: %s (大小: %d bytes, SHA-256: %s)\n", cosPath, checksum.size, sha)
+
+	// 端到端校验：COS对完整对象计算的CRC64应与本地流计算结果一致
+	resp, err := client.Object.Head(context.Background(), cosPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("验证上传文件失败: %v", err)
+	}
+	var verifyErr error
+	if remoteCRC := resp.Header.Get("x-cos-hash-crc64ecma"); remoteCRC != "" && remoteCRC != checksum.CRC64() {
+		verifyErr = fmt.Errorf("校验失败: 本地CRC64 %s, COS CRC64 %s", checksum.CRC64(), remoteCRC)
+	} else if resp.ContentLength != checksum.size {
+		verifyErr = fmt.Errorf("校验失败: 本地大小 %d, COS大小 %d", checksum.size, resp.ContentLength)
+	}
+	if verifyErr != nil {
+		// 删除校验失败的对象，避免被当作有效备份
+		if _, err := client.Object.Delete(context.Background(), cosPath); err != nil {
+			fmt.Printf("警告: 删除校验失败的对象失败: %v\n", err)
+		}
+		return nil, verifyErr
+	}
+	fmt.Printf("文件验证成功，CRC64: %s\n", checksum.CRC64())
+
+	// 分块上传在开始时就要确定元数据，SHA-256只能在完成后通过复制自身写入
+	metadata["vcpsave-sha256"] = sha
+	if err := replaceObjectMetadata(client, cosPath, metadata); err != nil {
+		fmt.Printf("警告: 写入校验和元数据失败: %v\n", err)
+	}
+
+	return &backupResult{
+		Key:    cosPath,
+		Size:   checksum.size,
+		KeyID:  metadata["vcpsave-key-id"],
+		SHA256: sha,
+	}, nil
+}
+
+// multipartUploadStream 将r按分块上传到COS，失败或ctx取消时终止分块上传
+func multipartUploadStream(ctx context.Context, client *cos.Client, cosPath string, r io.Reader, metadata map[string]string) error {
+	initOpt := &cos.InitiateMultipartUploadOptions{
+		ACLHeaderOptions: uploadACLHeader(),
+		ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{
+			XCosMetaXXX: metadataHeader(metadata),
+		},
+	}
+	v, _, err := client.Object.InitiateMultipartUpload(context.Background(), cosPath, initOpt)
+	if err != nil {
+		return fmt.Errorf("初始化分块上传失败: %v", err)
+	}
+	uploadID := v.UploadID
+
+	uploads.start(cosPath, 0, 0, 0, 0)
+	defer uploads.finish(cosPath)
+
+	abort := func(cause error) error {
+		if _, err := client.Object.AbortMultipartUpload(context.Background(), cosPath, uploadID); err != nil {
+			fmt.Printf("警告: 终止分块上传失败: %v\n", err)
+		}
+		return cause
+	}
+
+	partSize := getStreamPartSize()
+	buf := make([]byte, partSize)
+	opt := &cos.CompleteMultipartUploadOptions{}
+
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("读取归档数据失败: %v", readErr))
+		}
+
+		// 至少上传一个分块，空归档也需要完成上传
+		if n > 0 || partNumber == 1 {
+			resp, err := client.Object.UploadPart(ctx, cosPath, uploadID, partNumber,
+				bytes.NewReader(buf[:n]), &cos.ObjectUploadPartOptions{ContentLength: int64(n)})
+			if err != nil {
+				return abort(fmt.Errorf("上传分块 %d 失败: %v", partNumber, err))
+			}
+			opt.Parts = append(opt.Parts, cos.Object{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+			uploads.partDone(cosPath, int64(n))
+			fmt.Printf("分块 %d 上传成功 (%d bytes)\n", partNumber, n)
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	if _, _, err := client.Object.CompleteMultipartUpload(context.Background(), cosPath, uploadID, opt); err != nil {
+		return abort(fmt.Errorf("完成分块上传失败: %v", err))
+	}
+	return nil
+}
+
+// replaceObjectMetadata 通过复制对象自身替换其元数据
+func replaceObjectMetadata(client *cos.Client, cosPath string, metadata map[string]string) error {
+	sourceURL := fmt.Sprintf("%s/%s", client.BaseURL.BucketURL.Host, cosPath)
+	opt := &cos.ObjectCopyOptions{
+		ObjectCopyHeaderOptions: &cos.ObjectCopyHeaderOptions{
+			XCosMetadataDirective: "Replaced",
+			XCosMetaXXX:           metadataHeader(metadata),
+		},
+		ACLHeaderOptions: uploadACLHeader(),
+	}
+	_, _, err := client.Object.Copy(context.Background(), cosPath, sourceURL, opt)
+	return err
+}
+
+// metadataHeader 将元数据转换为x-cos-meta-*请求头
+func metadataHeader(metadata map[string]string) *http.Header {
+	header := &http.Header{}
+	for k, v := range metadata {
+		header.Add("x-cos-meta-"+k, v)
+	}
+	return header
+}
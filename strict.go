@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 严格配置模式：STRICT_CONFIG=true 时启动前检查配置，SOURCEFOLDER为空、CLEANUP_TIME无法解析
+// 或配置文件中有未知的配置项（通常是拼写错误）时立即以非零退出码退出，
+// 避免程序带着警告启动后长时间空转而没有人发现。
+
+// strictExtraKeys 不写入配置快照但程序会读取的配置项
+var strictExtraKeys = []string{
+	"LOG_LEVEL",
+	"SKIP_LOG_SAMPLES",
+	"VCPSAVE_ENV_FILE",
+}
+
+// isStrictConfig 检查是否启用严格配置模式
+func isStrictConfig() bool {
+	return os.Getenv("STRICT_CONFIG") == "true"
+}
+
+// checkStrictConfig 检查配置，返回所有发现的问题。CLEANUP_TIME只在后台运行时必需
+func checkStrictConfig(projects []*project, daemon bool) error {
+	var problems []string
+
+	for _, proj := range projects {
+		if len(parseSourcePaths(proj.Getenv("SOURCEFOLDER"))) == 0 {
+			problems = append(problems, fmt.Sprintf("项目 %s 的SOURCEFOLDER未配置", proj))
+		}
+	}
+
+	if _, err := getNextCleanupTime(); err != nil && (daemon || os.Getenv("CLEANUP_TIME") != "") {
+		problems = append(problems, err.Error())
+	}
+
+	known := make(map[string]bool)
+	for _, key := range append(append([]string{}, configKeys...), strictExtraKeys...) {
+		known[key] = true
+	}
+	for _, proj := range projects {
+		for _, key := range transformRuleKeys(proj) {
+			known[key] = true
+		}
+		for _, key := range completionHookKeys(proj) {
+			known[key] = true
+		}
+	}
+	var prefixes []string
+	for _, proj := range projects {
+		if proj.envPrefix != "" {
+			prefixes = append(prefixes, proj.envPrefix)
+		}
+	}
+	for _, r := range getReplicas() {
+		prefixes = append(prefixes, r.envPrefix)
+	}
+	for _, proj := range projects {
+		for _, rule := range getAlertRoutes(proj) {
+			for _, route := range rule.routes {
+				if target := alertRouteProject(proj, route); target != proj {
+					prefixes = append(prefixes, target.envPrefix)
+				}
+			}
+		}
+	}
+
+	var unknown []string
+	for key := range envFileKeys {
+		if !isKnownConfigKey(key, known, prefixes) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problem := fmt.Sprintf("未知的配置项: %s（%s）", key, envFileKeys[key])
+		if suggestion := suggestConfigKey(key, known, prefixes); suggestion != "" {
+			problem += fmt.Sprintf("，是否应为 %s？", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("严格配置模式检查失败:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// isKnownConfigKey 检查配置项是否为已知的全局配置，或带项目、副本前缀的已知配置
+func isKnownConfigKey(key string, known map[string]bool, prefixes []string) bool {
+	if known[key] {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if rest, ok := strings.CutPrefix(key, prefix); ok && known[rest] {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestConfigKey 为未知的配置项查找拼写最接近的已知配置项，找不到时返回空
+func suggestConfigKey(key string, known map[string]bool, prefixes []string) string {
+	prefix, name := "", key
+	for _, p := range prefixes {
+		if rest, ok := strings.CutPrefix(key, p); ok {
+			prefix, name = p, rest
+			break
+		}
+	}
+
+	best, bestDistance := "", 3
+	for candidate := range known {
+		distance := editDistance(name, candidate)
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return prefix + best
+}
+
+// editDistance 计算两个字符串的编辑距离
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
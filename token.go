@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HTTP API 的权限范围
+const (
+	scopeReadStatus    = "read-status"    // 查看备份、历史和状态
+	scopeRestore       = "restore"        // 下载备份
+	scopeTriggerBackup = "trigger-backup" // 手动触发备份
+	scopeFreeze        = "freeze"         // 冻结和解除冻结删除
+	scopeRelay         = "relay"          // 通过中继访问存储桶
+)
+
+// allScopes 所有权限范围
+var allScopes = []string{scopeReadStatus, scopeRestore, scopeTriggerBackup, scopeFreeze, scopeRelay}
+
+// apiToken 保存在本地的API令牌，只保存令牌的SHA-256，不保存明文
+type apiToken struct {
+	Name      string   `json:"name"`
+	Hash      string   `json:"hash"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt string   `json:"created_at"`
+	ExpiresAt string   `json:"expires_at,omitempty"`
+}
+
+// expired 检查令牌是否已过期
+func (t apiToken) expired() bool {
+	if t.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, t.ExpiresAt)
+	return err != nil || time.Now().After(expiresAt)
+}
+
+// getTokensPath 获取令牌文件路径
+func getTokensPath() string {
+	return filepath.Join(getDataDir(), "tokens.json")
+}
+
+// hashToken 计算令牌的SHA-256
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAPITokens 读取令牌列表，文件不存在时返回空列表
+func loadAPITokens() ([]apiToken, error) {
+	data, err := os.ReadFile(getTokensPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取令牌文件失败: %v", err)
+	}
+
+	var tokens []apiToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("解析令牌文件失败: %v", err)
+	}
+	return tokens, nil
+}
+
+// saveAPITokens 写入令牌列表，文件权限只允许当前用户读写
+func saveAPITokens(tokens []apiToken) error {
+	if err := os.MkdirAll(getDataDir(), 0755); err != nil {
+		return fmt.Errorf("创建数据目录失败: %v", err)
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化令牌失败: %v", err)
+	}
+
+	tmpPath := getTokensPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入令牌文件失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, getTokensPath()); err != nil {
+		return fmt.Errorf("替换令牌文件失败: %v", err)
+	}
+	return nil
+}
+
+// lookupAPIToken 查找令牌对应的权限范围，每次请求都重新读取文件，撤销后立即生效
+func lookupAPIToken(token string) []string {
+	if t := findAPIToken(token); t != nil {
+		return t.Scopes
+	}
+	return nil
+}
+
+// findAPIToken 查找有效的令牌，不存在或已过期时返回nil
+func findAPIToken(token string) *apiToken {
+	if token == "" {
+		return nil
+	}
+
+	tokens, err := loadAPITokens()
+	if err != nil {
+		fmt.Printf("警告: %v\n", err)
+		return nil
+	}
+
+	hash := hashToken(token)
+	for i, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) == 1 && !t.expired() {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// parseScopes 解析并校验逗号分隔的权限范围
+func parseScopes(value string) ([]string, error) {
+	valid := make(map[string]bool)
+	for _, scope := range allScopes {
+		valid[scope] = true
+	}
+
+	var scopes []string
+	for _, scope := range parseSourcePaths(value) {
+		if !valid[scope] {
+			return nil, fmt.Errorf("未知的权限范围: %s，可选值: %s", scope, strings.Join(allScopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("至少需要一个权限范围，可选值: %s", strings.Join(allScopes, ", "))
+	}
+	return scopes, nil
+}
+
+// runToken 管理HTTP API令牌：vcpsave token create|list|revoke
+func runToken(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: vcpsave token create -name <名称> -scopes <权限范围> [-expires 720h] | list | revoke <名称>")
+	}
+
+	tokens, err := loadAPITokens()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ExitOnError)
+		name := fs.String("name", "", "令牌名称，用于撤销")
+		scopesStr := fs.String("scopes", scopeReadStatus, "权限范围，逗号分隔: "+strings.Join(allScopes, ", "))
+		expires := fs.Duration("expires", 0, "有效期，例如 720h，默认不过期")
+		fs.Parse(args[1:])
+
+		if *name == "" {
+			return fmt.Errorf("必须使用 -name 指定令牌名称")
+		}
+		for _, t := range tokens {
+			if t.Name == *name {
+				return fmt.Errorf("令牌已存在: %s，请先撤销", *name)
+			}
+		}
+		scopes, err := parseScopes(*scopesStr)
+		if err != nil {
+			return err
+		}
+
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return fmt.Errorf("生成令牌失败: %v", err)
+		}
+		token := "vcp_" + hex.EncodeToString(raw)
+
+		t := apiToken{
+			Name:      *name,
+			Hash:      hashToken(token),
+			Scopes:    scopes,
+			CreatedAt: time.Now().Format(time.RFC3339),
+		}
+		if *expires > 0 {
+			t.ExpiresAt = time.Now().Add(*expires).Format(time.RFC3339)
+		}
+		if err := saveAPITokens(append(tokens, t)); err != nil {
+			return err
+		}
+
+		fmt.Printf("已创建令牌 %s (权限: %s)\n", t.Name, strings.Join(t.Scopes, ", "))
+		fmt.Printf("%s\n", token)
+		fmt.Println("令牌只显示这一次，请妥善保存")
+
+	case "list":
+		if len(tokens) == 0 {
+			fmt.Println("没有令牌")
+		}
+		for _, t := range tokens {
+			status := ""
+			if t.expired() {
+				status = " (已过期)"
+			}
+			expiresAt := t.ExpiresAt
+			if expiresAt == "" {
+				expiresAt = "永不"
+			}
+			fmt.Printf("%s: 权限 %s, 创建于 %s, 过期 %s%s\n",
+				t.Name, strings.Join(t.Scopes, ", "), t.CreatedAt, expiresAt, status)
+		}
+
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("用法: vcpsave token revoke <名称>")
+		}
+		var kept []apiToken
+		for _, t := range tokens {
+			if t.Name != args[1] {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == len(tokens) {
+			return fmt.Errorf("未找到令牌: %s", args[1])
+		}
+		if err := saveAPITokens(kept); err != nil {
+			return err
+		}
+		fmt.Printf("已撤销令牌: %s\n", args[1])
+
+	default:
+		return fmt.Errorf("未知的令牌命令: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 文件转换：TRANSFORMS配置的规则在文件写入归档前改写匹配的文件，例如去掉配置文件中的密码。
+// 规则可以使用正则替换，也可以调用外部命令（从标准输入读取原内容，标准输出作为归档中的内容）。
+// 源文件本身不会被修改；清单中的校验和仍按原文件计算，归档后删除源文件时保留被转换的文件。
+
+// maxTransformSize 转换的文件的最大大小，转换在内存中进行
+const maxTransformSize = 64 << 20
+
+// transformNamePattern 规则名称只能包含字母、数字和下划线，用于组成配置项名称
+var transformNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// transformRule 一条文件转换规则
+type transformRule struct {
+	name    string
+	sources []string       // 适用的源名称，为空时适用于所有源
+	files   []string       // 文件名模式，不区分大小写；不含斜杠的匹配文件名，含斜杠的匹配源中的相对路径
+	regex   *regexp.Regexp // 正则替换，与command二选一
+	replace string         // 替换内容，可以用 ${1} 引用分组
+	command []string       // 外部命令
+}
+
+// transformRuleKeys 返回规则的配置项名称，供严格配置模式识别
+func transformRuleKeys(proj *project) []string {
+	var keys []string
+	for _, name := range parseSourcePaths(proj.Getenv("TRANSFORMS")) {
+		prefix := "TRANSFORM_" + strings.ToUpper(name) + "_"
+		keys = append(keys, prefix+"SOURCES", prefix+"FILES", prefix+"REGEX", prefix+"REPLACE", prefix+"COMMAND")
+	}
+	return keys
+}
+
+// getTransformRules 获取适用于源的转换规则，TRANSFORMS为规则名称列表（逗号分隔），每条规则的配置：
+// TRANSFORM_<名称>_SOURCES 适用的源名称（可选），TRANSFORM_<名称>_FILES 文件名模式，
+// TRANSFORM_<名称>_REGEX 和 TRANSFORM_<名称>_REPLACE 正则替换，或 TRANSFORM_<名称>_COMMAND 外部命令。
+// 规则配置错误时返回错误，避免本应去掉的内容被原样备份
+func getTransformRules(proj *project, srcName string) ([]*transformRule, error) {
+	var rules []*transformRule
+	for _, name := range parseSourcePaths(proj.Getenv("TRANSFORMS")) {
+		if !transformNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("转换规则名称只能包含字母、数字和下划线: %s", name)
+		}
+		prefix := "TRANSFORM_" + strings.ToUpper(name) + "_"
+
+		rule := &transformRule{name: name, sources: parseSourcePaths(proj.Getenv(prefix + "SOURCES"))}
+		if len(rule.sources) > 0 && !containsString(rule.sources, srcName) {
+			continue
+		}
+
+		for _, pattern := range parseSourcePaths(proj.Getenv(prefix + "FILES")) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%sFILES格式错误: %s", prefix, pattern)
+			}
+			rule.files = append(rule.files, strings.ToLower(pattern))
+		}
+		if len(rule.files) == 0 {
+			return nil, fmt.Errorf("转换规则 %s 未配置%sFILES", name, prefix)
+		}
+
+		expr, command := proj.Getenv(prefix+"REGEX"), proj.Getenv(prefix+"COMMAND")
+		switch {
+		case expr != "" && command != "":
+			return nil, fmt.Errorf("转换规则 %s 不能同时配置%sREGEX和%sCOMMAND", name, prefix, prefix)
+		case expr != "":
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%sREGEX格式错误: %v", prefix, err)
+			}
+			rule.regex = re
+			rule.replace = proj.Getenv(prefix + "REPLACE")
+		case command != "":
+			// 与插件相同：命令是存在的文件时整体作为路径，否则按空格拆分参数
+			rule.command = []string{command}
+			if _, err := os.Stat(command); err != nil {
+				rule.command = strings.Fields(command)
+			}
+		default:
+			return nil, fmt.Errorf("转换规则 %s 未配置%sREGEX或%sCOMMAND", name, prefix, prefix)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// containsString 检查列表中是否有指定的字符串
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// apply 对文件内容执行转换
+func (r *transformRule) apply(content []byte, filePath, relPath string) ([]byte, error) {
+	if r.regex != nil {
+		return r.regex.ReplaceAll(content, []byte(r.replace)), nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(r.command[0], r.command[1:]...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "VCPSAVE_FILE="+filePath, "VCPSAVE_RELPATH="+relPath)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("转换规则 %s 的命令执行失败: %v %s", r.name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// transformFile 对匹配转换规则的文件执行转换，返回归档中使用的内容；没有匹配的规则时返回false。
+// 启用了校验和时记录原文件的SHA-256
+func (o *archiveOptions) transformFile(filePath, relPath string) ([]byte, bool, error) {
+	if o == nil || len(o.transforms) == 0 {
+		return nil, false, nil
+	}
+	slashPath := filepath.ToSlash(relPath)
+	var matched []*transformRule
+	for _, rule := range o.transforms {
+		if matchSensitive(rule.files, slashPath) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, false, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("打开文件失败: %v", err)
+	}
+	content, err := io.ReadAll(io.LimitReader(file, maxTransformSize+1))
+	file.Close()
+	if err != nil {
+		return nil, false, fmt.Errorf("读取文件失败: %v", err)
+	}
+	if len(content) > maxTransformSize {
+		return nil, false, fmt.Errorf("需要转换的文件超过 %d MB: %s", maxTransformSize>>20, filePath)
+	}
+	if o.checksums != nil {
+		sum := sha256.Sum256(content)
+		o.checksums[slashPath] = hex.EncodeToString(sum[:])
+	}
+
+	names := make([]string, 0, len(matched))
+	for _, rule := range matched {
+		if content, err = rule.apply(content, filePath, slashPath); err != nil {
+			return nil, false, fmt.Errorf("转换 %s 失败: %v", filePath, err)
+		}
+		names = append(names, rule.name)
+	}
+	fmt.Printf("已转换: %s (规则 %s)\n", slashPath, strings.Join(names, ", "))
+	return content, true, nil
+}
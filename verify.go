@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 校验模式：不归档也不上传，只遍历源路径计算每个文件的SHA-256，与最新备份清单中的校验和比较，
+// 报告自上次备份以来新增、删除和修改的文件，用于发现意外的修改或勒索软件式的大量修改。
+
+// isManifestChecksumsEnabled 是否在备份清单中记录每个源文件的SHA-256
+func isManifestChecksumsEnabled(proj *project) bool {
+	return proj.Getenv("MANIFEST_CHECKSUMS") == "true"
+}
+
+// sourceDrift 源当前内容与最新备份的差异
+type sourceDrift struct {
+	added     []string
+	removed   []string
+	modified  []string
+	unchanged int
+}
+
+// changed 返回有变化的文件数
+func (d *sourceDrift) changed() int {
+	return len(d.added) + len(d.removed) + len(d.modified)
+}
+
+// compareChecksums 比较备份时和当前的校验和
+func compareChecksums(backup, current map[string]string) *sourceDrift {
+	drift := &sourceDrift{}
+	for path, sum := range current {
+		backupSum, ok := backup[path]
+		switch {
+		case !ok:
+			drift.added = append(drift.added, path)
+		case backupSum != sum:
+			drift.modified = append(drift.modified, path)
+		default:
+			drift.unchanged++
+		}
+	}
+	for path := range backup {
+		if _, ok := current[path]; !ok {
+			drift.removed = append(drift.removed, path)
+		}
+	}
+	sort.Strings(drift.added)
+	sort.Strings(drift.removed)
+	sort.Strings(drift.modified)
+	return drift
+}
+
+// computeSourceChecksums 按归档时相同的筛选条件遍历源路径，计算每个文件的SHA-256
+func computeSourceChecksums(sourcePath string, opts *archiveOptions) (map[string]string, error) {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string)
+	if !info.IsDir() {
+		sum, err := fileSHA256(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		checksums[filepath.Base(sourcePath)] = sum
+		return checksums, nil
+	}
+
+	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		if skip, _ := opts.matchEntry(path, relPath, info); skip {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		checksums[filepath.ToSlash(relPath)] = sum
+		return nil
+	})
+	return checksums, err
+}
+
+// latestManifest 从远程索引中找到前缀最新的备份并读取其清单
+func latestManifest(client *cos.Client, targetDir, prefix string) (*backupManifest, error) {
+	index, err := loadCatalogIndex(client, targetDir)
+	if err != nil {
+		return nil, err
+	}
+	var latest *catalogEntry
+	for i, entry := range index.Backups {
+		if entry.Prefix == prefix && (latest == nil || entry.TimeStamp > latest.TimeStamp) {
+			latest = &index.Backups[i]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("远程索引中没有 %s 的备份", prefix)
+	}
+	return loadManifest(client, targetDir, filepath.Base(latest.Key))
+}
+
+// printDriftFiles 输出一类变化的文件，最多limit个
+func printDriftFiles(label string, files []string, limit int) {
+	for i, path := range files {
+		if limit > 0 && i >= limit {
+			fmt.Printf("  ... 另有 %d 个%s的文件\n", len(files)-limit, label)
+			return
+		}
+		fmt.Printf("  %s: %s\n", label, path)
+	}
+}
+
+// runVerify 计算源路径的校验和并与最新备份清单比较，有变化时返回true
+func runVerify(client *cos.Client, projects []*project, args []string) (bool, error) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	projectName := fs.String("project", "", "只校验指定的项目，默认校验全部项目")
+	sourceNames := fs.String("source", "", "只校验指定的源（逗号分隔），默认校验SOURCEFOLDER中的全部源")
+	limit := fs.Int("list", 20, "每类变化最多列出的文件数，0表示全部列出")
+	fs.Parse(args)
+
+	selectedSources := make(map[string]bool)
+	for _, name := range strings.Split(*sourceNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			selectedSources[name] = true
+		}
+	}
+
+	found := false
+	drifted := false
+	for _, proj := range projects {
+		if *projectName != "" && proj.Name != *projectName {
+			continue
+		}
+		found = true
+
+		for _, sourcePath := range getProjectSources(proj) {
+			name := sourceName(sourcePath)
+			if len(selectedSources) > 0 && !selectedSources[name] {
+				continue
+			}
+			fmt.Printf("\n--- 校验%s: %s ---\n", proj.logTag(), sourcePath)
+
+			manifest, err := latestManifest(client, proj.TargetDir, sanitizeObjectName(name))
+			if err != nil {
+				return drifted, err
+			}
+			if manifest.Checksums == nil {
+				return drifted, fmt.Errorf("%s 的清单中没有文件校验和，请设置 MANIFEST_CHECKSUMS=true 并完成一次备份", manifest.Key)
+			}
+
+			readPath := expandSourcePath(proj, sourcePath)
+			isDir, err := isDirectory(readPath)
+			if err != nil {
+				return drifted, fmt.Errorf("检查路径类型失败: %v", err)
+			}
+			p, err := buildPipeline(proj, sourcePath, isDir)
+			if err != nil {
+				return drifted, err
+			}
+			if isDir && getNestedSourcePolicy(proj) == "exclude" {
+				p.options.nested = nestedSources(proj, sourcePath)
+			}
+
+			current, err := computeSourceChecksums(readPath, p.options)
+			if err != nil {
+				return drifted, err
+			}
+
+			drift := compareChecksums(manifest.Checksums, current)
+			fmt.Printf("最新备份: %s (%s)\n", manifest.Key, manifest.CreatedAt)
+			fmt.Printf("未变化 %d 个, 新增 %d 个, 删除 %d 个, 修改 %d 个", drift.unchanged, len(drift.added), len(drift.removed), len(drift.modified))
+			if total := len(manifest.Checksums); total > 0 {
+				fmt.Printf(" (备份中 %.1f%% 的文件被修改或删除)", float64(len(drift.modified)+len(drift.removed))*100/float64(total))
+			}
+			fmt.Println()
+			printDriftFiles("新增", drift.added, *limit)
+			printDriftFiles("删除", drift.removed, *limit)
+			printDriftFiles("修改", drift.modified, *limit)
+			if drift.changed() > 0 {
+				drifted = true
+			}
+		}
+	}
+	if !found {
+		return false, fmt.Errorf("未找到项目: %s", *projectName)
+	}
+	return drifted, nil
+}
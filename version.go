@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// version 程序版本，构建时通过 -ldflags "-X main.version=v1.2.3" 设置
+var version = "dev"
+
+// userAgent 返回所有COS请求使用的User-Agent，便于在访问日志和账单中识别vcpsave的流量
+// 配置了USER_AGENT_TAG时追加在末尾，例如区分不同主机或环境
+func userAgent() string {
+	ua := fmt.Sprintf("vcpsave/%s %s", version, cos.UserAgent)
+	if tag := strings.TrimSpace(os.Getenv("USER_AGENT_TAG")); tag != "" {
+		ua += " " + tag
+	}
+	return ua
+}
+
+// headerTransport 为每个请求添加固定的请求头
+type headerTransport struct {
+	headers   http.Header
+	transport http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// withRequestHeaders 根据COS_REQUEST_HEADERS（格式为 名称:值）包装transport，未配置时原样返回
+func withRequestHeaders(transport http.RoundTripper) http.RoundTripper {
+	headers := http.Header{}
+	for key, value := range parseKeyValueList(os.Getenv("COS_REQUEST_HEADERS")) {
+		headers.Add(key, value)
+	}
+	if len(headers) == 0 {
+		return transport
+	}
+	return &headerTransport{headers: headers, transport: transport}
+}
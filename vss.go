@@ -0,0 +1,6 @@
+package main
+
+// isVSSEnabled 检查是否通过卷影副本（VSS）读取源路径，只在Windows上生效
+func isVSSEnabled(proj *project) bool {
+	return proj.Getenv("USE_VSS") == "true"
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// snapshotSource 卷影副本只在Windows上可用
+func snapshotSource(string) (string, func(), error) {
+	return "", nil, fmt.Errorf("卷影副本（USE_VSS）只支持Windows")
+}
@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runPowerShell 执行PowerShell脚本并返回输出的各行
+func runPowerShell(script string) ([]string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// createShadowCopy 为卷创建卷影副本，返回副本ID和设备路径（如 \\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1）
+func createShadowCopy(volume string) (string, string, error) {
+	script := fmt.Sprintf(`$ErrorActionPreference = 'Stop'
+$r = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume='%s'; Context='ClientAccessible'}
+if ($r.ReturnValue -ne 0) { throw "Win32_ShadowCopy.Create 返回 $($r.ReturnValue)" }
+$s = Get-CimInstance Win32_ShadowCopy -Filter "ID='$($r.ShadowID)'"
+$s.ID
+$s.DeviceObject`, volume)
+
+	lines, err := runPowerShell(script)
+	if err != nil {
+		return "", "", fmt.Errorf("创建卷影副本失败（需要以管理员身份运行）: %v", err)
+	}
+	if len(lines) != 2 {
+		return "", "", fmt.Errorf("创建卷影副本失败: 无法解析输出: %s", strings.Join(lines, " "))
+	}
+	return lines[0], lines[1], nil
+}
+
+// deleteShadowCopy 删除卷影副本
+func deleteShadowCopy(id string) error {
+	script := fmt.Sprintf(`$ErrorActionPreference = 'Stop'
+Get-CimInstance Win32_ShadowCopy -Filter "ID='%s'" | Remove-CimInstance`, id)
+	if _, err := runPowerShell(script); err != nil {
+		return fmt.Errorf("删除卷影副本失败: %s: %v", id, err)
+	}
+	return nil
+}
+
+// snapshotSource 为源路径所在的卷创建卷影副本，返回副本中对应的路径和删除副本的函数。
+// 从副本读取可以备份被其他程序占用的文件（如数据库文件、PST），且所有文件处于同一时间点
+func snapshotSource(sourcePath string) (string, func(), error) {
+	absPath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("获取绝对路径失败: %v", err)
+	}
+	volume := filepath.VolumeName(absPath)
+	if len(volume) != 2 || volume[1] != ':' {
+		return "", nil, fmt.Errorf("卷影副本只支持本地磁盘路径: %s", sourcePath)
+	}
+
+	id, device, err := createShadowCopy(volume + `\`)
+	if err != nil {
+		return "", nil, err
+	}
+	fmt.Printf("已创建卷影副本: %s (%s)\n", id, volume)
+
+	release := func() {
+		if err := deleteShadowCopy(id); err != nil {
+			fmt.Printf("警告: %v\n", err)
+		}
+	}
+	return device + absPath[len(volume):], release, nil
+}
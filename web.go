@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// 可选的内置Web界面：浏览项目、运行历史、备份列表和大小变化，并下载备份用于恢复。
+// 静态资源嵌入到程序中，只需一个可执行文件。
+
+//go:embed web
+var webAssets embed.FS
+
+// 内置角色对应的权限范围
+var (
+	viewerScopes   = []string{scopeReadStatus}
+	operatorScopes = allScopes
+)
+
+// runMu 保证同一时间只有一次备份运行，定时任务和手动触发共用
+var runMu sync.Mutex
+
+// webServer Web界面和HTTP API
+type webServer struct {
+	client   *cos.Client
+	projects []*project
+	tokens   map[string][]string // 配置文件中的角色令牌
+}
+
+// startWebServer 配置了WEB_LISTEN时在后台启动Web界面
+// WEB_TOKEN 为操作员令牌，WEB_VIEWER_TOKEN 为只读令牌，便于只读共享看板；
+// 集成使用的令牌通过 vcpsave token 命令管理，可以按权限范围授权和单独撤销
+func startWebServer(client *cos.Client, projects []*project) error {
+	listen := os.Getenv("WEB_LISTEN")
+	if listen == "" {
+		return nil
+	}
+
+	tokens := make(map[string][]string)
+	if token := os.Getenv("WEB_VIEWER_TOKEN"); token != "" {
+		tokens[token] = viewerScopes
+	}
+	if token := os.Getenv("WEB_TOKEN"); token != "" {
+		tokens[token] = operatorScopes
+	}
+	if len(tokens) == 0 {
+		if apiTokens, _ := loadAPITokens(); len(apiTokens) == 0 {
+			return fmt.Errorf("启用Web界面时必须配置WEB_TOKEN、WEB_VIEWER_TOKEN或使用 vcpsave token create 创建令牌")
+		}
+	}
+
+	s := &webServer{client: client, projects: projects, tokens: tokens}
+	handler, err := s.handler()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		fmt.Printf("Web界面已启动: %s\n", listen)
+		if err := http.ListenAndServe(listen, handler); err != nil {
+			fmt.Printf("错误: Web界面停止: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// handler 注册所有路由
+func (s *webServer) handler() (http.Handler, error) {
+	static, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		return nil, fmt.Errorf("加载Web资源失败: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/api/whoami", s.authorized("", s.handleWhoami))
+	mux.HandleFunc("/api/projects", s.authorized(scopeReadStatus, s.handleProjects))
+	mux.HandleFunc("/api/history", s.authorized(scopeReadStatus, s.handleHistory))
+	mux.HandleFunc("/api/backups", s.authorized(scopeReadStatus, s.handleBackups))
+	mux.HandleFunc("/api/download", s.authorized(scopeRestore, s.handleDownload))
+	mux.HandleFunc("/api/trigger", s.authorized(scopeTriggerBackup, s.handleTrigger))
+	mux.HandleFunc("/api/status", s.authorized(scopeReadStatus, s.handleStatus))
+	mux.HandleFunc("/api/freeze", s.authorized(scopeFreeze, s.handleFreeze))
+	mux.HandleFunc("/metrics", s.authorized(scopeReadStatus, s.handleMetrics))
+	return mux, nil
+}
+
+// requestToken 从Authorization头或token查询参数（用于浏览器直接下载）中读取令牌
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requestScopes 根据令牌确定请求的权限范围，令牌无效时返回nil
+func (s *webServer) requestScopes(r *http.Request) []string {
+	token := requestToken(r)
+	if token == "" {
+		return nil
+	}
+	for candidate, scopes := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			return scopes
+		}
+	}
+	return lookupAPIToken(token)
+}
+
+// authorized 校验令牌和权限范围后再调用处理函数，scope为空时只要求令牌有效
+func (s *webServer) authorized(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes := s.requestScopes(r)
+		if len(scopes) == 0 {
+			writeJSONError(w, http.StatusUnauthorized, "令牌无效")
+			return
+		}
+		if scope != "" && !slices.Contains(scopes, scope) {
+			writeJSONError(w, http.StatusForbidden, "没有权限执行此操作，需要: "+scope)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON 输出JSON响应
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("警告: 输出响应失败: %v\n", err)
+	}
+}
+
+// writeJSONError 输出JSON错误响应
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// requestProject 根据project查询参数查找项目
+func (s *webServer) requestProject(r *http.Request) (*project, error) {
+	return findProject(s.projects, r.URL.Query().Get("project"))
+}
+
+// handleWhoami 返回当前令牌的权限范围，界面据此隐藏无权限的操作
+func (s *webServer) handleWhoami(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string][]string{"scopes": s.requestScopes(r)})
+}
+
+// handleProjects 返回项目列表
+func (s *webServer) handleProjects(w http.ResponseWriter, r *http.Request) {
+	type projectInfo struct {
+		Name      string `json:"name"`
+		TargetDir string `json:"target_dir"`
+	}
+
+	var projects []projectInfo
+	for _, proj := range s.projects {
+		projects = append(projects, projectInfo{Name: proj.Name, TargetDir: proj.TargetDir})
+	}
+	writeJSON(w, projects)
+}
+
+// handleHistory 返回项目的本地运行历史
+func (s *webServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	proj, err := s.requestProject(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := []historyRecord{}
+	for _, record := range records {
+		if record.Project == proj.Name {
+			result = append(result, record)
+		}
+	}
+	writeJSON(w, result)
+}
+
+// handleBackups 返回项目远程索引中的备份
+func (s *webServer) handleBackups(w http.ResponseWriter, r *http.Request) {
+	proj, err := s.requestProject(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	index, err := loadCatalogIndex(s.client, proj.TargetDir)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if index.Backups == nil {
+		index.Backups = []catalogEntry{}
+	}
+	writeJSON(w, index.Backups)
+}
+
+// handleDownload 通过服务端转发下载备份对象，不向浏览器暴露COS密钥
+func (s *webServer) handleDownload(w http.ResponseWriter, r *http.Request) {
+	proj, err := s.requestProject(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 只允许下载项目目标目录下的备份文件
+	key := r.URL.Query().Get("key")
+	fileName := strings.TrimPrefix(key, strings.Trim(proj.TargetDir, "/")+"/")
+	if _, _, isOurFormat := parseFileName(fileName); !isOurFormat || cosObjectKey(proj.TargetDir, fileName) != key {
+		writeJSONError(w, http.StatusBadRequest, "不是该项目的备份文件")
+		return
+	}
+
+	resp, err := s.client.Object.Get(context.Background(), key, nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			writeJSONError(w, http.StatusNotFound, "备份文件不存在")
+			return
+		}
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("Web界面下载备份: %s (来源: %s)\n", key, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(key)))
+	if resp.ContentLength > 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(resp.ContentLength))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		fmt.Printf("警告: 下载中断: %s, 错误: %v\n", key, err)
+	}
+}
+
+// handleTrigger 手动触发项目备份，已有备份在运行时返回冲突
+func (s *webServer) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "请使用POST")
+		return
+	}
+
+	proj, err := s.requestProject(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !runMu.TryLock() {
+		writeJSONError(w, http.StatusConflict, "已有备份正在运行")
+		return
+	}
+
+	fmt.Printf("Web界面手动触发备份%s (来源: %s)\n", proj.logTag(), r.RemoteAddr)
+	go func() {
+		defer runMu.Unlock()
+		sourcePaths := getProjectSources(proj)
+		performBackupSources(s.client, proj, sourcePaths, newRunState(proj, sourcePaths))
+	}()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, map[string]string{"status": "started"})
+}
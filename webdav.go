@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebDAV：备份到Nextcloud、坚果云等WebDAV网盘（STORAGE_PROVIDER=webdav）。
+// WebDAV_URL指向的目录作为目录存储，由fsTransport提供COS接口，文件操作对应WebDAV的
+// PROPFIND、GET、PUT、MOVE、DELETE和MKCOL请求。
+
+// webdavConfig WebDAV服务器的配置
+type webdavConfig struct {
+	baseURL  *url.URL // 存储目录的地址，路径以斜杠结尾
+	user     string
+	password string
+	chunked  bool // 使用分块传输编码直接上传，不先写入临时文件
+}
+
+// getWebDAVConfig 读取WebDAV服务器的配置
+func getWebDAVConfig(getenv func(string) string) (*webdavConfig, error) {
+	rawURL := getenv("WEBDAV_URL")
+	if rawURL == "" {
+		return nil, fmt.Errorf("WebDAV地址未配置，请设置WEBDAV_URL，例如 https://dav.jianguoyun.com/dav/backup")
+	}
+	baseURL, err := url.Parse(rawURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("WEBDAV_URL格式错误: %s", rawURL)
+	}
+	if !strings.HasSuffix(baseURL.Path, "/") {
+		baseURL.Path += "/"
+	}
+	baseURL.RawPath = ""
+
+	cfg := &webdavConfig{
+		baseURL:  baseURL,
+		user:     getenv("WEBDAV_USER"),
+		password: getenv("WEBDAV_PASSWORD"),
+		chunked:  getenv("WEBDAV_CHUNKED") == "true",
+	}
+	if cfg.user == "" {
+		return nil, fmt.Errorf("WebDAV用户未配置，请设置WEBDAV_USER")
+	}
+	return cfg, nil
+}
+
+// webdavFS WebDAV服务器上的目录
+type webdavFS struct {
+	cfg    *webdavConfig
+	client *http.Client
+	dirs   sync.Map // 已确认存在的目录
+}
+
+// newWebDAVFS 创建WebDAV目录存储
+func newWebDAVFS(cfg *webdavConfig) *webdavFS {
+	return &webdavFS{
+		cfg: cfg,
+		client: &http.Client{
+			Transport: http.DefaultTransport,
+			// 重定向会把PROPFIND等请求改为GET，直接作为错误返回
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (f *webdavFS) String() string {
+	return f.cfg.baseURL.String()
+}
+
+// url 返回文件的地址，目录以斜杠结尾
+func (f *webdavFS) url(name string, dir bool) string {
+	u := *f.cfg.baseURL
+	if name != "." && name != "" {
+		u.Path = path.Join(u.Path, name)
+		if dir {
+			u.Path += "/"
+		}
+	}
+	return u.String()
+}
+
+// do 发送请求，状态码不在expected中时返回错误，404返回fs.ErrNotExist
+func (f *webdavFS) do(method, name, target string, header http.Header, body io.Reader, size int64, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil && size >= 0 {
+		req.ContentLength = size
+	}
+	req.SetBasicAuth(f.cfg.user, f.cfg.password)
+	req.Header.Set("User-Agent", userAgent())
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, &fs.PathError{Op: method, Path: name, Err: fs.ErrNotExist}
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("WebDAV认证失败，请检查WEBDAV_USER和WEBDAV_PASSWORD（坚果云、Nextcloud需要使用应用密码）")
+	case http.StatusInsufficientStorage:
+		return nil, fmt.Errorf("WebDAV %s %s 失败: 网盘空间不足", method, name)
+	}
+	return nil, fmt.Errorf("WebDAV %s %s 失败: %s %s", method, name, resp.Status, strings.TrimSpace(string(message)))
+}
+
+// webdavPropfindBody PROPFIND请求的内容，只获取用到的属性
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// webdavMultistatus PROPFIND的响应
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind 获取文件或目录的信息，depth为1时同时返回目录中的条目（第一个为目录本身）
+func (f *webdavFS) propfind(name string, depth int) ([]*webdavFileInfo, error) {
+	header := http.Header{
+		"Depth":        {strconv.Itoa(depth)},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	body := []byte(webdavPropfindBody)
+	resp, err := f.do("PROPFIND", name, f.url(name, depth > 0), header, bytes.NewReader(body), int64(len(body)), http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析WebDAV目录列表失败: %v", err)
+	}
+
+	self := strings.TrimSuffix(path.Join(f.cfg.baseURL.Path, name), "/")
+	var infos []*webdavFileInfo
+	found := false
+	for _, r := range result.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		hrefPath := strings.TrimSuffix(href.Path, "/")
+		info := &webdavFileInfo{name: path.Base(hrefPath)}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			info.dir = ps.Prop.ResourceType.Collection != nil
+			info.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			info.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+		}
+		// 目录本身放在第一个，depth为0时只有一个结果
+		if !found && (hrefPath == self || depth == 0) {
+			info.name = path.Base(name)
+			infos = append([]*webdavFileInfo{info}, infos...)
+			found = true
+		} else {
+			infos = append(infos, info)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("WebDAV目录列表中缺少 %s", name)
+	}
+	return infos, nil
+}
+
+func (f *webdavFS) Stat(name string) (fs.FileInfo, error) {
+	infos, err := f.propfind(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	return infos[0], nil
+}
+
+func (f *webdavFS) Open(name string) (io.ReadCloser, error) {
+	resp, err := f.do(http.MethodGet, name, f.url(name, false), nil, nil, -1, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f *webdavFS) Create(name string) (io.WriteCloser, error) {
+	if f.cfg.chunked {
+		pr, pw := io.Pipe()
+		w := &webdavPipeWriter{pw: pw, done: make(chan error, 1)}
+		go func() {
+			resp, err := f.do(http.MethodPut, name, f.url(name, false), nil, pr, -1, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+			if err == nil {
+				resp.Body.Close()
+			}
+			pr.CloseWithError(err)
+			w.done <- err
+		}()
+		return w, nil
+	}
+
+	// 多数服务器要求PUT请求带有Content-Length，先写入临时文件
+	tmp, err := os.CreateTemp("", "vcpsave-webdav-*")
+	if err != nil {
+		return nil, err
+	}
+	return &webdavSpoolWriter{fsys: f, name: name, file: tmp}, nil
+}
+
+func (f *webdavFS) Rename(oldName, newName string) error {
+	header := http.Header{
+		"Destination": {f.url(newName, false)},
+		"Overwrite":   {"T"},
+	}
+	resp, err := f.do("MOVE", oldName, f.url(oldName, false), header, nil, -1, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (f *webdavFS) Remove(name string) error {
+	info, err := f.Stat(name)
+	if err != nil {
+		return err
+	}
+	target := f.url(name, false)
+	if info.IsDir() {
+		// WebDAV删除目录时会删除其中的所有内容，只删除空目录
+		infos, err := f.propfind(name, 1)
+		if err != nil {
+			return err
+		}
+		if len(infos) > 1 {
+			return fmt.Errorf("目录不为空: %s", name)
+		}
+		target = f.url(name, true)
+		f.dirs.Delete(name)
+	}
+	resp, err := f.do(http.MethodDelete, name, target, nil, nil, -1, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (f *webdavFS) MkdirAll(name string) error {
+	if name == "." || name == "" {
+		return nil
+	}
+	if _, ok := f.dirs.Load(name); ok {
+		return nil
+	}
+	if info, err := f.Stat(name); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s 不是目录", name)
+		}
+		f.dirs.Store(name, true)
+		return nil
+	}
+	if err := f.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	resp, err := f.do("MKCOL", name, f.url(name, true), nil, nil, -1, http.StatusCreated)
+	if err != nil {
+		// 可能已被并发的请求创建
+		if info, statErr := f.Stat(name); statErr != nil || !info.IsDir() {
+			return err
+		}
+	} else {
+		resp.Body.Close()
+	}
+	f.dirs.Store(name, true)
+	return nil
+}
+
+func (f *webdavFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	infos, err := f.propfind(name, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !infos[0].dir {
+		return nil, fmt.Errorf("%s 不是目录", name)
+	}
+	entries := make([]fs.FileInfo, 0, len(infos)-1)
+	for _, info := range infos[1:] {
+		entries = append(entries, info)
+	}
+	return entries, nil
+}
+
+// webdavFileInfo WebDAV上的文件信息
+type webdavFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *webdavFileInfo) Name() string       { return i.name }
+func (i *webdavFileInfo) Size() int64        { return i.size }
+func (i *webdavFileInfo) ModTime() time.Time { return i.modTime }
+func (i *webdavFileInfo) IsDir() bool        { return i.dir }
+func (i *webdavFileInfo) Sys() interface{}   { return nil }
+
+func (i *webdavFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// webdavSpoolWriter 写入临时文件，关闭时上传
+type webdavSpoolWriter struct {
+	fsys *webdavFS
+	name string
+	file *os.File
+}
+
+func (w *webdavSpoolWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *webdavSpoolWriter) Close() error {
+	defer os.Remove(w.file.Name())
+	defer w.file.Close()
+
+	size, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := w.fsys.do(http.MethodPut, w.name, w.fsys.url(w.name, false), nil, io.NopCloser(w.file), size, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// webdavPipeWriter 边写入边上传，关闭时等待上传完成
+type webdavPipeWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *webdavPipeWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *webdavPipeWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// ZIP密码保护：设置ARCHIVE_PASSWORD后，zip归档器按WinZip AES-256（AE-2）格式加密每个条目的内容，
+// 7-Zip、WinRAR、macOS的Archive Utility和 vcpsave extract 输入密码即可解压。
+// 条目名和目录结构不加密，需要同时隐藏文件名时使用encrypt阶段。
+
+const (
+	zipMethodAES     = 99     // WinZip AES的压缩方法编号，实际压缩方法记录在扩展字段中
+	zipAESExtraID    = 0x9901 // WinZip AES扩展字段
+	zipAESSaltLen    = 16     // AES-256的盐长度
+	zipAESKeyLen     = 32
+	zipAESMACLen     = 10 // 认证码为HMAC-SHA1的前10字节
+	zipAESIterations = 1000
+	zipFlagEncrypted = 0x1
+	zipFlagDataDesc  = 0x8
+	zipFlagUTF8      = 0x800
+	zipVersionAES    = 51 // AES加密需要的解压版本5.1
+)
+
+// getArchivePassword 获取zip归档的密码，未设置时不加密
+func getArchivePassword(proj *project) string {
+	return proj.Getenv("ARCHIVE_PASSWORD")
+}
+
+// pbkdf2SHA1 按PBKDF2-HMAC-SHA1派生密钥
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var dk, u []byte
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
+
+// zipAESKeys 从密码和盐派生AES密钥、HMAC密钥和2字节的密码校验值
+func zipAESKeys(password string, salt []byte) (aesKey, macKey, verifier []byte) {
+	dk := pbkdf2SHA1([]byte(password), salt, zipAESIterations, 2*zipAESKeyLen+2)
+	return dk[:zipAESKeyLen], dk[zipAESKeyLen : 2*zipAESKeyLen], dk[2*zipAESKeyLen:]
+}
+
+// zipAESStream WinZip AES使用的CTR模式：计数器从1开始，按小端序递增
+type zipAESStream struct {
+	block   cipher.Block
+	counter uint64
+	buf     [aes.BlockSize]byte
+	pos     int
+}
+
+func newZipAESStream(key []byte) (*zipAESStream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESStream{block: block, pos: aes.BlockSize}, nil
+}
+
+func (s *zipAESStream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.pos == aes.BlockSize {
+			s.counter++
+			var ctr [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(ctr[:], s.counter)
+			s.block.Encrypt(s.buf[:], ctr[:])
+			s.pos = 0
+		}
+		dst[i] = src[i] ^ s.buf[s.pos]
+		s.pos++
+	}
+}
+
+// zipAESExtra 返回WinZip AES扩展字段：AE-2、AES-256和实际的压缩方法
+func zipAESExtra(method uint16) []byte {
+	extra := binary.LittleEndian.AppendUint16(nil, zipAESExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, 2) // AE-2，不记录CRC
+	extra = append(extra, 'A', 'E', 3)                 // 3表示AES-256
+	return binary.LittleEndian.AppendUint16(extra, method)
+}
+
+// zipAESMethod 从扩展字段中读取实际的压缩方法，不是WinZip AES条目时返回false
+func zipAESMethod(extra []byte) (uint16, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == zipAESExtraID && size == 7 && extra[8] == 3 {
+			return binary.LittleEndian.Uint16(extra[9:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, false
+}
+
+// createAESEntry 在ZIP中创建加密的条目，返回写入原始内容的写入器，关闭时写入认证码并更新条目的大小。
+// 使用CreateRaw写入，标准库为普通条目设置的UTF-8标志和修改时间在这里设置
+func createAESEntry(zw *zip.Writer, header *zip.FileHeader, password string) (io.WriteCloser, error) {
+	method := header.Method
+	if method != zip.Store {
+		method = zip.Deflate
+	}
+	if !header.NonUTF8 && utf8.ValidString(header.Name) {
+		for _, r := range header.Name {
+			if r >= utf8.RuneSelf {
+				header.Flags |= zipFlagUTF8
+				break
+			}
+		}
+	}
+	header.Flags |= zipFlagEncrypted | zipFlagDataDesc
+	header.Method = zipMethodAES
+	header.CreatorVersion = header.CreatorVersion&0xff00 | zipVersionAES
+	header.ReaderVersion = zipVersionAES
+	header.ModifiedDate, header.ModifiedTime = msDosTime(header.Modified)
+	if !header.Modified.IsZero() {
+		// 与标准库相同，同时写入扩展时间戳（Info-ZIP格式）
+		header.Extra = binary.LittleEndian.AppendUint16(header.Extra, 0x5455)
+		header.Extra = binary.LittleEndian.AppendUint16(header.Extra, 5)
+		header.Extra = append(header.Extra, 1)
+		header.Extra = binary.LittleEndian.AppendUint32(header.Extra, uint32(header.Modified.Unix()))
+	}
+	header.Extra = append(header.Extra, zipAESExtra(method)...)
+	header.CRC32 = 0
+
+	raw, err := zw.CreateRaw(header)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, zipAESSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("生成盐失败: %v", err)
+	}
+	aesKey, macKey, verifier := zipAESKeys(password, salt)
+	stream, err := newZipAESStream(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := raw.Write(append(salt, verifier...)); err != nil {
+		return nil, err
+	}
+
+	w := &zipAESWriter{header: header, raw: raw, stream: stream, mac: hmac.New(sha1.New, macKey)}
+	w.compressed = int64(len(salt) + len(verifier))
+	w.body = &zipAESEncrypter{w: w}
+	if method == zip.Deflate {
+		if w.flate, err = flate.NewWriter(w.body, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// msDosTime 将修改时间转换为ZIP文件头中的MS-DOS日期和时间
+func msDosTime(t time.Time) (uint16, uint16) {
+	if t.IsZero() {
+		return 0, 0
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// zipAESWriter 加密条目的写入器：原始内容 → deflate → AES-CTR → HMAC → ZIP
+type zipAESWriter struct {
+	header       *zip.FileHeader
+	raw          io.Writer
+	stream       *zipAESStream
+	mac          hash.Hash
+	flate        *flate.Writer
+	body         io.Writer
+	uncompressed int64
+	compressed   int64
+}
+
+func (w *zipAESWriter) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if w.flate != nil {
+		n, err = w.flate.Write(p)
+	} else {
+		n, err = w.body.Write(p)
+	}
+	w.uncompressed += int64(n)
+	return n, err
+}
+
+func (w *zipAESWriter) Close() error {
+	if w.flate != nil {
+		if err := w.flate.Close(); err != nil {
+			return err
+		}
+	}
+	n, err := w.raw.Write(w.mac.Sum(nil)[:zipAESMACLen])
+	w.compressed += int64(n)
+	if err != nil {
+		return err
+	}
+	// 使用了数据描述符，大小在下一个条目开始或归档关闭时写入
+	w.header.CompressedSize64 = uint64(w.compressed)
+	w.header.UncompressedSize64 = uint64(w.uncompressed)
+	w.header.CompressedSize = uint32(min(w.header.CompressedSize64, 0xffffffff))
+	w.header.UncompressedSize = uint32(min(w.header.UncompressedSize64, 0xffffffff))
+	return nil
+}
+
+// zipAESEncrypter 加密压缩后的数据并计算认证码
+type zipAESEncrypter struct {
+	w *zipAESWriter
+}
+
+func (e *zipAESEncrypter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	e.w.stream.XORKeyStream(buf, p)
+	e.w.mac.Write(buf)
+	n, err := e.w.raw.Write(buf)
+	e.w.compressed += int64(n)
+	return n, err
+}
+
+// errZipPassword 密码错误
+var errZipPassword = errors.New("ZIP密码错误")
+
+// openAESEntry 打开加密的ZIP条目，读完时校验认证码，AE-1条目同时校验CRC
+func openAESEntry(f *zip.File, password string) (io.ReadCloser, error) {
+	method, ok := zipAESMethod(f.Extra)
+	if !ok {
+		return nil, fmt.Errorf("不支持的加密方式: %s", f.Name)
+	}
+	if password == "" {
+		return nil, fmt.Errorf("%s 已加密，请设置ARCHIVE_PASSWORD", f.Name)
+	}
+	overhead := uint64(zipAESSaltLen + 2 + zipAESMACLen)
+	if f.CompressedSize64 < overhead {
+		return nil, fmt.Errorf("加密条目不完整: %s", f.Name)
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, zipAESSaltLen+2)
+	if _, err := io.ReadFull(raw, head); err != nil {
+		return nil, err
+	}
+	aesKey, macKey, verifier := zipAESKeys(password, head[:zipAESSaltLen])
+	if subtle.ConstantTimeCompare(verifier, head[zipAESSaltLen:]) != 1 {
+		return nil, errZipPassword
+	}
+	stream, err := newZipAESStream(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &zipAESDecrypter{
+		r:      io.LimitReader(raw, int64(f.CompressedSize64-overhead)),
+		trail:  raw,
+		stream: stream,
+		mac:    hmac.New(sha1.New, macKey),
+	}
+	var body io.ReadCloser = io.NopCloser(d)
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		body = flate.NewReader(d)
+	default:
+		return nil, fmt.Errorf("不支持的压缩方法 %d: %s", method, f.Name)
+	}
+	return &zipAESReader{ReadCloser: body, body: d, f: f, crc: crc32.NewIEEE()}, nil
+}
+
+// zipAESDecrypter 校验认证码并解密
+type zipAESDecrypter struct {
+	r      io.Reader
+	trail  io.Reader
+	stream *zipAESStream
+	mac    hash.Hash
+}
+
+func (d *zipAESDecrypter) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.mac.Write(p[:n])
+	d.stream.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF {
+		code := make([]byte, zipAESMACLen)
+		if _, readErr := io.ReadFull(d.trail, code); readErr != nil {
+			return n, readErr
+		}
+		if !hmac.Equal(code, d.mac.Sum(nil)[:zipAESMACLen]) {
+			return n, fmt.Errorf("认证码校验失败，数据已损坏或被篡改")
+		}
+	}
+	return n, err
+}
+
+// zipAESReader 检查解密后的大小，AE-1条目校验CRC
+type zipAESReader struct {
+	io.ReadCloser
+	body *zipAESDecrypter
+	f    *zip.File
+	crc  hash.Hash32
+	read uint64
+}
+
+func (r *zipAESReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.crc.Write(p[:n])
+	r.read += uint64(n)
+	if err == io.EOF {
+		if r.read != r.f.UncompressedSize64 {
+			return n, io.ErrUnexpectedEOF
+		}
+		// deflate数据读完时加密数据可能还没有读到结尾，读完剩余部分以校验认证码
+		if _, drainErr := io.Copy(io.Discard, r.body); drainErr != nil {
+			return n, drainErr
+		}
+		if r.f.CRC32 != 0 && r.crc.Sum32() != r.f.CRC32 {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
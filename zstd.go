@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// zstd阶段调用系统的zstd程序压缩。大量相似的小备份（配置导出等）可以使用按历史备份训练的字典，
+// 每次备份的未压缩数据保存为训练样本，样本足够时训练新版本的字典。
+// 字典保存在 DATA_DIR/zstd/<项目>/<源名称>/ 中，使用的字典版本记录在对象元数据中，
+// 并上传到目标目录的 .vcpsave/dicts/<源名称>/<版本>.dict，恢复时需要对应版本的字典。
+
+const (
+	zstdSampleMaxSize = 1024 * 1024 // 每个训练样本最多保存的字节数
+	zstdDictMaxSize   = 112640      // 字典大小上限（zstd默认值）
+)
+
+// getZstdPath 获取zstd程序路径
+func getZstdPath() string {
+	if path := os.Getenv("ZSTD_PATH"); path != "" {
+		return path
+	}
+	return "zstd"
+}
+
+// getZstdLevel 获取zstd压缩级别，默认3，20以上为ultra级别
+func getZstdLevel(proj *project) int {
+	level := 3
+	if levelStr := proj.Getenv("ZSTD_LEVEL"); levelStr != "" {
+		if n, err := strconv.Atoi(levelStr); err == nil && n >= 1 && n <= 22 {
+			level = n
+		} else {
+			fmt.Printf("警告: ZSTD_LEVEL格式错误: %s，使用默认值 %d\n", levelStr, level)
+		}
+	}
+	return level
+}
+
+// getZstdDictSamples 获取训练字典所需的样本数，0表示不使用字典
+func getZstdDictSamples(proj *project) int {
+	samplesStr := proj.Getenv("ZSTD_DICT_SAMPLES")
+	if samplesStr == "" {
+		return 0
+	}
+	samples, err := strconv.Atoi(samplesStr)
+	if err != nil || samples < 0 {
+		fmt.Printf("警告: ZSTD_DICT_SAMPLES格式错误: %s，不使用字典\n", samplesStr)
+		return 0
+	}
+	return samples
+}
+
+// zstdDictDir 源的字典目录
+func zstdDictDir(proj *project, sourceName string) string {
+	return filepath.Join(getDataDir(), "zstd", proj.Name, sourceName)
+}
+
+// latestZstdDict 返回源最新的字典版本和路径，没有字典时返回0
+func latestZstdDict(dir string) (int, string) {
+	entries, err := os.ReadDir(filepath.Join(dir, "dicts"))
+	if err != nil {
+		return 0, ""
+	}
+	latest := 0
+	for _, entry := range entries {
+		version, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".dict"))
+		if err == nil && version > latest {
+			latest = version
+		}
+	}
+	if latest == 0 {
+		return 0, ""
+	}
+	return latest, filepath.Join(dir, "dicts", strconv.Itoa(latest)+".dict")
+}
+
+// zstdStage 使用zstd压缩，可选使用字典
+type zstdStage struct {
+	dir         string
+	level       int
+	threads     int
+	samples     int // 训练所需的样本数，0表示不使用字典
+	dictVersion int
+	dictPath    string
+}
+
+func newZstdStage(proj *project, sourceName string) (pipelineStage, error) {
+	if _, err := exec.LookPath(getZstdPath()); err != nil {
+		return nil, fmt.Errorf("未找到zstd程序，请安装zstd或配置ZSTD_PATH: %v", err)
+	}
+
+	s := &zstdStage{
+		dir:     zstdDictDir(proj, sourceName),
+		level:   getZstdLevel(proj),
+		threads: getCompressThreads(proj),
+		samples: getZstdDictSamples(proj),
+	}
+	if s.samples > 0 {
+		s.dictVersion, s.dictPath = latestZstdDict(s.dir)
+	}
+	return s, nil
+}
+
+func (s *zstdStage) Ext() string { return ".zst" }
+
+func (s *zstdStage) Metadata() map[string]string {
+	if s.dictVersion == 0 {
+		return nil
+	}
+	return map[string]string{"vcpsave-zstd-dict": strconv.Itoa(s.dictVersion)}
+}
+
+func (s *zstdStage) Wrap(w io.Writer) (io.WriteCloser, error) {
+	args := []string{"-q", "-c", "-" + strconv.Itoa(s.level), "-T" + strconv.Itoa(s.threads)}
+	if s.level > 19 {
+		// 20以上的级别需要--ultra，解压时占用的内存也更多
+		args = append(args, "--ultra")
+	}
+	if s.dictPath != "" {
+		args = append(args, "-D", s.dictPath)
+	}
+	cmd := exec.Command(getZstdPath(), args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动zstd失败: %v", err)
+	}
+
+	zw := &zstdWriter{stage: s, cmd: cmd, stdin: stdin}
+	if s.samples > 0 {
+		sampleDir := filepath.Join(s.dir, "samples")
+		if err := os.MkdirAll(sampleDir, 0700); err == nil {
+			zw.sample, _ = os.Create(filepath.Join(sampleDir, time.Now().Format("20060102_150405.000000000")+".sample"))
+		}
+	}
+	return zw, nil
+}
+
+// zstdWriter 将数据写入zstd进程，同时保存训练样本
+type zstdWriter struct {
+	stage       *zstdStage
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	sample      *os.File
+	sampleBytes int64
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	if z.sample != nil && z.sampleBytes < zstdSampleMaxSize {
+		n := int64(len(p))
+		if z.sampleBytes+n > zstdSampleMaxSize {
+			n = zstdSampleMaxSize - z.sampleBytes
+		}
+		z.sample.Write(p[:n])
+		z.sampleBytes += n
+	}
+	return z.stdin.Write(p)
+}
+
+// Abort 结束zstd进程并删除不完整的训练样本
+func (z *zstdWriter) Abort() {
+	z.stdin.Close()
+	z.cmd.Process.Kill()
+	z.cmd.Wait()
+	if z.sample != nil {
+		z.sample.Close()
+		os.Remove(z.sample.Name())
+	}
+}
+
+func (z *zstdWriter) Close() error {
+	z.stdin.Close()
+	err := z.cmd.Wait()
+	if z.sample != nil {
+		z.sample.Close()
+		if err != nil {
+			os.Remove(z.sample.Name())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("zstd压缩失败: %v", err)
+	}
+
+	if z.sample != nil {
+		z.stage.trainIfReady()
+	}
+	return nil
+}
+
+// trainIfReady 样本数足够时训练新版本的字典，训练后清空样本，下次备份开始使用
+func (s *zstdStage) trainIfReady() {
+	sampleDir := filepath.Join(s.dir, "samples")
+	entries, err := os.ReadDir(sampleDir)
+	if err != nil || len(entries) < s.samples {
+		return
+	}
+
+	var samples []string
+	for _, entry := range entries {
+		samples = append(samples, filepath.Join(sampleDir, entry.Name()))
+	}
+	sort.Strings(samples)
+
+	dictDir := filepath.Join(s.dir, "dicts")
+	if err := os.MkdirAll(dictDir, 0700); err != nil {
+		fmt.Printf("警告: 创建字典目录失败: %v\n", err)
+		return
+	}
+	version, _ := latestZstdDict(s.dir)
+	dictPath := filepath.Join(dictDir, strconv.Itoa(version+1)+".dict")
+
+	args := append([]string{"-q", "--train"}, samples...)
+	args = append(args, "-o", dictPath, "--maxdict="+strconv.Itoa(zstdDictMaxSize))
+	if output, err := exec.Command(getZstdPath(), args...).CombinedOutput(); err != nil {
+		fmt.Printf("警告: 训练zstd字典失败: %v: %s\n", err, strings.TrimSpace(string(output)))
+		os.Remove(dictPath)
+		return
+	}
+
+	fmt.Printf("已训练zstd字典版本 %d (%d 个样本): %s\n", version+1, len(samples), dictPath)
+	for _, sample := range samples {
+		os.Remove(sample)
+	}
+}
+
+// getDictKey 获取字典在COS中的对象键
+func getDictKey(targetDir, sourceName string, version int) string {
+	return cosObjectKey(targetDir, fmt.Sprintf("%s/dicts/%s/%d.dict", metaDirName, sourceName, version))
+}
+
+// uploadZstdDict 确保备份使用的字典已上传到目标目录和副本存储，恢复时需要对应版本的字典。
+// 在上传备份之前调用，字典上传失败时备份失败，避免留下无法解压的备份
+func uploadZstdDict(client *cos.Client, proj *project, sourceName string, metadata map[string]string) error {
+	versionStr := metadata["vcpsave-zstd-dict"]
+	if versionStr == "" {
+		return nil
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return fmt.Errorf("字典版本格式错误: %s", versionStr)
+	}
+
+	key := getDictKey(proj.TargetDir, sourceName, version)
+	dictPath := filepath.Join(zstdDictDir(proj, sourceName), "dicts", versionStr+".dict")
+	if _, err := client.Object.Head(context.Background(), key, nil); err != nil {
+		opt := &cos.ObjectPutOptions{ACLHeaderOptions: uploadACLHeader()}
+		if _, err := client.Object.PutFromFile(context.Background(), key, dictPath, opt); err != nil {
+			return fmt.Errorf("上传zstd字典失败: %v", err)
+		}
+		fmt.Printf("已上传zstd字典: %s\n", key)
+	}
+
+	// 副本存储中的备份同样需要字典才能恢复
+	for _, r := range getReplicas() {
+		if err := r.ensureObject(client, key, dictPath); err != nil {
+			if isReplicaRequired(proj) {
+				return fmt.Errorf("复制zstd字典到副本 %s 失败: %v", r.Name, err)
+			}
+			fmt.Printf("警告: 复制zstd字典到副本 %s 失败: %v\n", r.Name, err)
+		}
+	}
+	return nil
+}